@@ -1,3 +1,3 @@
 #!/usr/bin/env bash
 
-GOOS=linux GOARCH=amd64 go build -o target/godis-linux ./src/cmd
\ No newline at end of file
+GOOS=linux GOARCH=amd64 go build -o target/godis-linux ./src/cmdGOOS=linux GOARCH=amd64 go build -o target/godis-check-aof-linux ./src/cmd/godis-check-aof
@@ -2,3 +2,4 @@
 
 
 go build -i -o target/godis-darwin ./src/cmd
+go build -i -o target/godis-check-aof-darwin ./src/cmd/godis-check-aof
@@ -1,3 +1,5 @@
 module my-godis
 
 go 1.20
+
+require github.com/yuin/gopher-lua v1.1.1
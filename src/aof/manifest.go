@@ -0,0 +1,276 @@
+package aof
+
+/*
+ * Manifest describes files of multi part aof in appenddirname directory, like redis 7:
+ *   file appendonly.aof.1.base.aof seq 1 type b
+ *   file appendonly.aof.1.incr.aof seq 1 type i
+ *   file appendonly.aof.2.incr.aof seq 2 type i
+ * dataset is rebuilt by loading base file followed by incremental files in order.
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// types of aof file
+const (
+	TypeBase = "b"
+	TypeIncr = "i"
+)
+
+const (
+	baseSuffix     = ".base.aof"
+	incrSuffix     = ".incr.aof"
+	manifestSuffix = ".manifest"
+)
+
+// FileInfo is an aof file recorded in manifest
+type FileInfo struct {
+	Name string
+	Seq  int64
+	Type string
+}
+
+type Manifest struct {
+	Dir string
+	// appendfilename, prefix of file names
+	Filename string
+	// nil if dataset was empty when aof started
+	Base  *FileInfo
+	Incrs []*FileInfo
+	// max seq used by base and incremental files
+	baseSeq int64
+	incrSeq int64
+}
+
+// MakeManifest returns an empty manifest which has not been saved
+func MakeManifest(dir string, filename string) *Manifest {
+	return &Manifest{
+		Dir:      dir,
+		Filename: filename,
+	}
+}
+
+// ManifestPath returns path of manifest file
+func ManifestPath(dir string, filename string) string {
+	return filepath.Join(dir, filename+manifestSuffix)
+}
+
+// LoadManifest reads manifest of dir, returns error satisfies os.IsNotExist if manifest doesn't exist
+func LoadManifest(dir string, filename string) (*Manifest, error) {
+	content, err := os.ReadFile(ManifestPath(dir, filename))
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", ManifestPath(dir, filename), err)
+	}
+	m.Dir = dir
+	m.Filename = filename
+	return m, nil
+}
+
+// ParseManifest parses content of manifest file
+func ParseManifest(content []byte) (*Manifest, error) {
+	m := &Manifest{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("line %d: invalid format", lineNo)
+		}
+		file := &FileInfo{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				file.Name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil || seq <= 0 {
+					return nil, fmt.Errorf("line %d: invalid seq", lineNo)
+				}
+				file.Seq = seq
+			case "type":
+				file.Type = fields[i+1]
+			}
+			// unknown keys are ignored for compatibility
+		}
+		if file.Name == "" || file.Seq == 0 || strings.ContainsAny(file.Name, "/\\") {
+			return nil, fmt.Errorf("line %d: invalid file", lineNo)
+		}
+		switch file.Type {
+		case TypeBase:
+			if m.Base != nil {
+				return nil, fmt.Errorf("line %d: duplicated base file", lineNo)
+			}
+			m.Base = file
+			m.baseSeq = file.Seq
+		case TypeIncr:
+			if file.Seq <= m.incrSeq {
+				return nil, fmt.Errorf("line %d: incremental files are out of order", lineNo)
+			}
+			m.Incrs = append(m.Incrs, file)
+			m.incrSeq = file.Seq
+		default:
+			// history files are not needed for loading
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Path returns path of file in aof directory
+func (m *Manifest) Path(file *FileInfo) string {
+	return filepath.Join(m.Dir, file.Name)
+}
+
+// Files returns files in loading order
+func (m *Manifest) Files() []*FileInfo {
+	files := make([]*FileInfo, 0, len(m.Incrs)+1)
+	if m.Base != nil {
+		files = append(files, m.Base)
+	}
+	return append(files, m.Incrs...)
+}
+
+// LastIncr returns incremental file being appended, nil if there is none
+func (m *Manifest) LastIncr() *FileInfo {
+	if len(m.Incrs) == 0 {
+		return nil
+	}
+	return m.Incrs[len(m.Incrs)-1]
+}
+
+// NextBase returns info of next base file, manifest is not modified
+func (m *Manifest) NextBase() *FileInfo {
+	seq := m.baseSeq + 1
+	return &FileInfo{
+		Name: m.Filename + "." + strconv.FormatInt(seq, 10) + baseSuffix,
+		Seq:  seq,
+		Type: TypeBase,
+	}
+}
+
+// NextIncr returns info of next incremental file, manifest is not modified
+func (m *Manifest) NextIncr() *FileInfo {
+	seq := m.incrSeq + 1
+	return &FileInfo{
+		Name: m.Filename + "." + strconv.FormatInt(seq, 10) + incrSuffix,
+		Seq:  seq,
+		Type: TypeIncr,
+	}
+}
+
+// SetBase replaces base file
+func (m *Manifest) SetBase(base *FileInfo) {
+	m.Base = base
+	if base != nil && base.Seq > m.baseSeq {
+		m.baseSeq = base.Seq
+	}
+}
+
+// AddIncr appends an incremental file
+func (m *Manifest) AddIncr(incr *FileInfo) {
+	m.Incrs = append(m.Incrs, incr)
+	if incr.Seq > m.incrSeq {
+		m.incrSeq = incr.Seq
+	}
+}
+
+// TrimIncrs removes incremental files before seq, returns removed files
+func (m *Manifest) TrimIncrs(seq int64) []*FileInfo {
+	i := 0
+	for i < len(m.Incrs) && m.Incrs[i].Seq < seq {
+		i++
+	}
+	removed := m.Incrs[:i:i]
+	m.Incrs = m.Incrs[i:]
+	return removed
+}
+
+// DropIncrsAfter removes incremental files after seq, returns removed files
+func (m *Manifest) DropIncrsAfter(seq int64) []*FileInfo {
+	i := len(m.Incrs)
+	for i > 0 && m.Incrs[i-1].Seq > seq {
+		i--
+	}
+	removed := append([]*FileInfo(nil), m.Incrs[i:]...)
+	m.Incrs = m.Incrs[:i]
+	return removed
+}
+
+// Clone returns a copy of manifest for rollback
+func (m *Manifest) Clone() *Manifest {
+	clone := *m
+	clone.Incrs = append([]*FileInfo(nil), m.Incrs...)
+	return &clone
+}
+
+func (m *Manifest) encode() []byte {
+	var buf bytes.Buffer
+	for _, file := range m.Files() {
+		buf.WriteString(fmt.Sprintf("file %s seq %d type %s\n", file.Name, file.Seq, file.Type))
+	}
+	return buf.Bytes()
+}
+
+// Save writes manifest atomically, the old manifest stays intact if saving failed
+func (m *Manifest) Save() error {
+	if strings.ContainsAny(m.Filename, " \t\r\n/\\") {
+		return errors.New("appendfilename should not contain spaces or path separators")
+	}
+	err := os.MkdirAll(m.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	path := ManifestPath(m.Dir, m.Filename)
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(m.encode())
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return SyncDir(m.Dir)
+}
+
+// SyncDir fsyncs directory to make renaming durable
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// some platforms don't support fsync on directory, its error is ignored
+	_ = d.Sync()
+	return nil
+}
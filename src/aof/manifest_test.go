@@ -0,0 +1,71 @@
+package aof
+
+import (
+	"os"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadManifest(dir, "appendonly.aof"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, actually %v", err)
+	}
+	m := MakeManifest(dir, "appendonly.aof")
+	m.SetBase(m.NextBase())
+	m.AddIncr(m.NextIncr())
+	m.AddIncr(m.NextIncr())
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadManifest(dir, "appendonly.aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := loaded.Files()
+	expected := []string{"appendonly.aof.1.base.aof", "appendonly.aof.1.incr.aof", "appendonly.aof.2.incr.aof"}
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files, actually %d", len(expected), len(files))
+	}
+	for i, file := range files {
+		if file.Name != expected[i] {
+			t.Errorf("expected %s, actually %s", expected[i], file.Name)
+		}
+	}
+	if loaded.NextBase().Name != "appendonly.aof.2.base.aof" || loaded.NextIncr().Name != "appendonly.aof.3.incr.aof" {
+		t.Error("unexpected next file name")
+	}
+
+	removed := loaded.TrimIncrs(2)
+	if len(removed) != 1 || removed[0].Seq != 1 || len(loaded.Incrs) != 1 || loaded.LastIncr().Seq != 2 {
+		t.Error("unexpected result of TrimIncrs")
+	}
+
+	m.AddIncr(m.NextIncr())
+	removed = m.DropIncrsAfter(1)
+	if len(removed) != 2 || removed[0].Seq != 2 || len(m.Incrs) != 1 || m.LastIncr().Seq != 1 {
+		t.Error("unexpected result of DropIncrsAfter")
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	content := "file a.1.base.aof seq 1 type b\nfile a.1.incr.aof seq 1 type h\nfile a.2.incr.aof seq 2 type i\n"
+	m, err := ParseManifest([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Base == nil || len(m.Incrs) != 1 || m.Incrs[0].Seq != 2 {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+	invalid := []string{
+		"file a.1.incr.aof seq x type i\n",
+		"file a.2.incr.aof seq 2 type i\nfile a.1.incr.aof seq 1 type i\n",
+		"file ../a seq 1 type b\n",
+		"file a.1.base.aof seq 1 type\n",
+	}
+	for _, content := range invalid {
+		if _, err := ParseManifest([]byte(content)); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package aof
+
+/*
+ * snapshot preamble of aof base file, rewritten dataset is loaded without replaying commands:
+ *   magic "GODIS0001"
+ *   records: [opExpire unix-ms(8 bytes)] type key value
+ *   opEOF crc64(8 bytes) of all bytes before it
+ * commands in RESP format may follow the preamble.
+ * type and value are encoded by payload package, key is encoded as string of payload,
+ * expire time in little endian.
+ */
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+	"io"
+	"my-godis/src/lib/marshal/payload"
+	"time"
+)
+
+const preambleMagic = "GODIS0001"
+
+// opcodes of records besides value types of payload
+const (
+	opExpire byte = 0xfc
+	opEOF    byte = 0xff
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// HasPreamble tells whether content begins with snapshot preamble
+func HasPreamble(header []byte) bool {
+	return len(header) >= len(preambleMagic) && string(header[:len(preambleMagic)]) == preambleMagic
+}
+
+// EncodeEntry encodes key and value as a record of preamble, returns nil for unsupported value
+func EncodeEntry(key string, val interface{}) []byte {
+	typ, ok := payload.ValueType(val)
+	if !ok {
+		return nil
+	}
+	buf := payload.AppendString([]byte{typ}, key)
+	return payload.AppendValue(buf, val)
+}
+
+// PreambleWriter writes records of preamble, the first error is kept and returned by Close
+type PreambleWriter struct {
+	writer io.Writer
+	hash   hash.Hash64
+	err    error
+}
+
+// NewPreambleWriter writes magic and returns writer for records
+func NewPreambleWriter(writer io.Writer) *PreambleWriter {
+	w := &PreambleWriter{
+		writer: writer,
+		hash:   crc64.New(crcTable),
+	}
+	w.write([]byte(preambleMagic))
+	return w
+}
+
+func (w *PreambleWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.writer.Write(b)
+	_, _ = w.hash.Write(b)
+}
+
+// WriteExpire sets expire time of the next entry
+func (w *PreambleWriter) WriteExpire(expireAt time.Time) {
+	buf := make([]byte, 1, 9)
+	buf[0] = opExpire
+	w.write(binary.LittleEndian.AppendUint64(buf, uint64(expireAt.UnixNano()/int64(time.Millisecond))))
+}
+
+// WriteEntry writes record made by EncodeEntry
+func (w *PreambleWriter) WriteEntry(entry []byte) {
+	w.write(entry)
+}
+
+// Close ends preamble with checksum, RESP commands could be written after it
+func (w *PreambleWriter) Close() error {
+	w.write([]byte{opEOF})
+	w.write(binary.LittleEndian.AppendUint64(nil, w.hash.Sum64()))
+	return w.err
+}
+
+// preambleReader reads records and calculates checksum of bytes read
+type preambleReader struct {
+	reader *bufio.Reader
+	hash   hash.Hash64
+	read   int64
+}
+
+func (r *preambleReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	_, _ = r.hash.Write([]byte{b})
+	r.read++
+	return b, nil
+}
+
+func (r *preambleReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	_, _ = r.hash.Write(p[:n])
+	r.read += int64(n)
+	return n, err
+}
+
+func (r *preambleReader) readUint64() (uint64, error) {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// ReadPreamble loads preamble at the beginning of aof if exists, it must be called before Next
+// consumer receives zero expireAt for keys without ttl
+func (r *Reader) ReadPreamble(consumer func(key string, val interface{}, expireAt time.Time)) (bool, error) {
+	header, _ := r.reader.Peek(len(preambleMagic))
+	if r.offset != 0 || !HasPreamble(header) {
+		return false, nil
+	}
+	pr := &preambleReader{
+		reader: r.reader,
+		hash:   crc64.New(crcTable),
+	}
+	fail := func(msg string) (bool, error) {
+		// base file is renamed after written completely, incomplete preamble means corruption and cannot be trimmed
+		return true, &FormatError{
+			Offset:   0,
+			Preamble: true,
+			Msg:      msg,
+		}
+	}
+	_, _ = io.ReadFull(pr, make([]byte, len(preambleMagic)))
+	var expireAt time.Time
+	for {
+		op, err := pr.ReadByte()
+		if err != nil {
+			return fail("unexpected end of preamble")
+		}
+		if op == opEOF {
+			sum := pr.hash.Sum64()
+			expected, err := pr.readUint64()
+			if err != nil {
+				return fail("unexpected end of preamble")
+			}
+			if sum != expected {
+				return fail("preamble checksum mismatch")
+			}
+			r.offset = pr.read
+			return true, nil
+		}
+		if op == opExpire {
+			ms, err := pr.readUint64()
+			if err != nil {
+				return fail("unexpected end of preamble")
+			}
+			expireAt = time.Unix(0, int64(ms)*int64(time.Millisecond))
+			continue
+		}
+		key, err := payload.ReadString(pr)
+		if err != nil {
+			return fail("invalid key in preamble")
+		}
+		val, err := payload.ReadValue(pr, op)
+		if err != nil {
+			return fail("invalid value of " + string(key) + " in preamble")
+		}
+		if consumer != nil {
+			consumer(string(key), val, expireAt)
+		}
+		expireAt = time.Time{}
+	}
+}
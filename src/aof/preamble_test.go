@@ -0,0 +1,115 @@
+package aof
+
+import (
+	"bytes"
+	"my-godis/src/datastruct/dict"
+	List "my-godis/src/datastruct/list"
+	"my-godis/src/datastruct/set"
+	SortedSet "my-godis/src/datastruct/sortedset"
+	"testing"
+	"time"
+)
+
+func makePreamble(t *testing.T, expireAt time.Time) []byte {
+	hash := dict.MakeSimple()
+	hash.Put("f", []byte("v"))
+	zset := SortedSet.Make()
+	zset.Add("a", 1.5)
+	zset.Add("b", -2)
+	values := map[string]interface{}{
+		"str":  []byte("hello"),
+		"list": List.MakeQuickList([]byte("1"), []byte("2"), []byte("3")),
+		"set":  set.MakeFromVals("x", "y"),
+		"hash": hash,
+		"zset": zset,
+	}
+	buf := &bytes.Buffer{}
+	w := NewPreambleWriter(buf)
+	for _, key := range []string{"str", "list", "set", "hash", "zset"} {
+		if key == "str" {
+			w.WriteExpire(expireAt)
+		}
+		w.WriteEntry(EncodeEntry(key, values[key]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPreamble(t *testing.T) {
+	expireAt := time.Unix(1700000000, 123*int64(time.Millisecond))
+	content := makePreamble(t, expireAt)
+	if !HasPreamble(content) {
+		t.Fatal("expected preamble magic")
+	}
+	preambleLen := len(content)
+	content = append(content, validAof...)
+
+	r := NewReader(bytes.NewReader(content))
+	loaded := make(map[string]interface{})
+	ok, err := r.ReadPreamble(func(key string, val interface{}, at time.Time) {
+		loaded[key] = val
+		if key == "str" && !at.Equal(expireAt) {
+			t.Errorf("expected expire time %v, actually %v", expireAt, at)
+		}
+		if key != "str" && !at.IsZero() {
+			t.Errorf("unexpected expire time of %s", key)
+		}
+	})
+	if !ok || err != nil {
+		t.Fatalf("expected preamble loaded, actually %v %v", ok, err)
+	}
+	if r.Offset() != int64(preambleLen) {
+		t.Errorf("expected offset %d, actually %d", preambleLen, r.Offset())
+	}
+	if string(loaded["str"].([]byte)) != "hello" {
+		t.Error("unexpected string")
+	}
+	list := loaded["list"].(*List.QuickList)
+	if list.Len() != 3 || string(list.Get(2)) != "3" {
+		t.Error("unexpected list")
+	}
+	if s := loaded["set"].(*set.Set); s.Len() != 2 || !s.Has("y") {
+		t.Error("unexpected set")
+	}
+	if v, _ := loaded["hash"].(dict.Dict).Get("f"); string(v.([]byte)) != "v" {
+		t.Error("unexpected hash")
+	}
+	if e, ok := loaded["zset"].(*SortedSet.SortedSet).Get("b"); !ok || e.Score != -2 {
+		t.Error("unexpected zset")
+	}
+
+	// commands after preamble
+	entry, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Args[0]) != "SET" || entry.Offset != int64(preambleLen) {
+		t.Errorf("unexpected entry %q at %d", entry.Args, entry.Offset)
+	}
+	count, err := Check(bytes.NewReader(content))
+	if err != nil || count != 7 {
+		t.Errorf("unexpected check result %d %v", count, err)
+	}
+}
+
+func TestPreambleCorrupted(t *testing.T) {
+	content := makePreamble(t, time.Now())
+	corrupted := append([]byte{}, content...)
+	corrupted[len(preambleMagic)+3] ^= 0xff
+	truncated := content[:len(content)-3]
+	for _, c := range [][]byte{corrupted, truncated} {
+		_, err := Check(bytes.NewReader(c))
+		formatErr, ok := err.(*FormatError)
+		if !ok || !formatErr.Preamble || formatErr.Offset != 0 {
+			t.Errorf("expected preamble error, actually %v", err)
+		}
+	}
+
+	// aof without preamble
+	ok, err := NewReader(bytes.NewReader([]byte(validAof))).ReadPreamble(nil)
+	if ok || err != nil {
+		t.Errorf("unexpected result %v %v", ok, err)
+	}
+}
@@ -0,0 +1,213 @@
+package aof
+
+/*
+ * Reader parses commands from append only file in RESP multi bulk format
+ * lines beginning with '#' are annotations, like `#TS:1700000000\r\n` written before commands of that second
+ */
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// max count of arguments or length of an argument accepted while reading
+const (
+	maxArgCount = 1 << 20
+	maxArgLen   = 512 << 20
+)
+
+// FormatError reports the first bad entry of aof
+type FormatError struct {
+	// offset of the beginning of bad entry, data before it is valid
+	Offset int64
+	// file ends in the middle of the entry, usually caused by a crash while writing
+	Truncated bool
+	// snapshot preamble is corrupted, aof cannot be fixed by truncating
+	Preamble bool
+	Msg      string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("bad entry at offset %d: %s", e.Offset, e.Msg)
+}
+
+// Entry is a command or a timestamp annotation read from aof
+type Entry struct {
+	// nil for timestamp annotation
+	Args [][]byte
+	// unix time in seconds of timestamp annotation
+	Timestamp int64
+	// offset of the beginning of entry
+	Offset int64
+}
+
+const timestampPrefix = "#TS:"
+
+// MakeTimestamp returns timestamp annotation of t
+func MakeTimestamp(t time.Time) []byte {
+	return []byte(timestampPrefix + strconv.FormatInt(t.Unix(), 10) + "\r\n")
+}
+
+type Reader struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: bufio.NewReader(reader),
+	}
+}
+
+// Offset returns count of bytes consumed by valid entries
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Next returns next entry, returns io.EOF at the end of aof or *FormatError if entry is invalid
+func (r *Reader) Next() (*Entry, error) {
+	start := r.offset
+	read := int64(0)
+	fail := func(err error, msg string) (*Entry, error) {
+		return nil, &FormatError{
+			Offset:    start,
+			Truncated: err == io.EOF || err == io.ErrUnexpectedEOF,
+			Msg:       msg,
+		}
+	}
+
+	line, err := r.reader.ReadBytes('\n')
+	read += int64(len(line))
+	if err == io.EOF && len(line) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return fail(err, "unexpected end of file")
+	}
+	if line[0] == '#' {
+		return r.readAnnotation(line, start, fail)
+	}
+	if line[0] != '*' {
+		return fail(nil, "command should start with '*'")
+	}
+	argCount, err := parseLength(line[1:], maxArgCount)
+	if err != nil || argCount == 0 {
+		return fail(nil, "invalid multibulk length")
+	}
+
+	args := make([][]byte, argCount)
+	for i := range args {
+		line, err = r.reader.ReadBytes('\n')
+		read += int64(len(line))
+		if err != nil {
+			return fail(err, "unexpected end of file")
+		}
+		if line[0] != '$' {
+			return fail(nil, "argument should start with '$'")
+		}
+		argLen, err := parseLength(line[1:], maxArgLen)
+		if err != nil {
+			return fail(nil, "invalid bulk length")
+		}
+		arg := make([]byte, argLen+2)
+		n, err := io.ReadFull(r.reader, arg)
+		read += int64(n)
+		if err != nil {
+			return fail(err, "unexpected end of file")
+		}
+		if arg[argLen] != '\r' || arg[argLen+1] != '\n' {
+			return fail(nil, "argument should end with \\r\\n")
+		}
+		args[i] = arg[:argLen]
+	}
+	r.offset += read
+	return &Entry{
+		Args:   args,
+		Offset: start,
+	}, nil
+}
+
+// readAnnotation returns timestamp annotation, other annotations are skipped
+func (r *Reader) readAnnotation(line []byte, start int64, fail func(error, string) (*Entry, error)) (*Entry, error) {
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return fail(nil, "annotation should end with \\r\\n")
+	}
+	content := string(line[:len(line)-2])
+	if !strings.HasPrefix(content, timestampPrefix) {
+		r.offset += int64(len(line))
+		return r.Next()
+	}
+	timestamp, err := strconv.ParseInt(content[len(timestampPrefix):], 10, 64)
+	if err != nil {
+		return fail(nil, "invalid timestamp annotation")
+	}
+	r.offset += int64(len(line))
+	return &Entry{
+		Timestamp: timestamp,
+		Offset:    start,
+	}, nil
+}
+
+// parseLength parses `123\r\n`
+func parseLength(line []byte, max int) (int, error) {
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return 0, errors.New("line should end with \\r\\n")
+	}
+	n, err := strconv.Atoi(string(line[:len(line)-2]))
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > max {
+		return 0, errors.New("length out of range")
+	}
+	return n, nil
+}
+
+// Check reads preamble and all entries of aof, returns count of valid entries and the first *FormatError
+func Check(reader io.Reader) (int, error) {
+	r := NewReader(reader)
+	count := 0
+	if _, err := r.ReadPreamble(func(key string, val interface{}, expireAt time.Time) {
+		count++
+	}); err != nil {
+		return count, err
+	}
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if entry.Args != nil {
+			count++
+		}
+	}
+}
+
+// ListTimestamps returns timestamp annotations of aof
+func ListTimestamps(reader io.Reader) ([]*Entry, error) {
+	r := NewReader(reader)
+	if _, err := r.ReadPreamble(nil); err != nil {
+		return nil, err
+	}
+	var timestamps []*Entry
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return timestamps, nil
+		}
+		if err != nil {
+			return timestamps, err
+		}
+		if entry.Args == nil {
+			timestamps = append(timestamps, entry)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package aof
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validAof = "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n" + "*2\r\n$3\r\nDEL\r\n$0\r\n\r\n"
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader(validAof))
+	entry, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Args) != 3 || string(entry.Args[0]) != "SET" || entry.Offset != 0 {
+		t.Errorf("unexpected entry %q at %d", entry.Args, entry.Offset)
+	}
+	entry, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Args) != 2 || !bytes.Equal(entry.Args[1], []byte{}) || entry.Offset != 27 {
+		t.Errorf("unexpected entry %q at %d", entry.Args, entry.Offset)
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Errorf("expected EOF, actually %v", err)
+	}
+	if r.Offset() != int64(len(validAof)) {
+		t.Errorf("expected offset %d, actually %d", len(validAof), r.Offset())
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	for i := 1; i < len(validAof)-27; i++ {
+		count, err := Check(strings.NewReader(validAof[:27+i]))
+		formatErr, ok := err.(*FormatError)
+		if !ok {
+			t.Fatalf("expected format error while truncated at %d, actually %v", 27+i, err)
+		}
+		if !formatErr.Truncated || formatErr.Offset != 27 || count != 1 {
+			t.Errorf("unexpected result %d %+v", count, formatErr)
+		}
+	}
+}
+
+func TestReaderCorrupted(t *testing.T) {
+	corrupted := validAof[:27] + "+OK\r\n" + validAof[27:]
+	count, err := Check(strings.NewReader(corrupted))
+	formatErr, ok := err.(*FormatError)
+	if !ok {
+		t.Fatalf("expected format error, actually %v", err)
+	}
+	if formatErr.Truncated || formatErr.Offset != 27 || count != 1 {
+		t.Errorf("unexpected result %d %+v", count, formatErr)
+	}
+
+	corrupted = "*1\r\n$3\r\nabcd\r\n"
+	_, err = Check(strings.NewReader(corrupted))
+	if formatErr, ok = err.(*FormatError); !ok || formatErr.Truncated {
+		t.Errorf("expected corrupted error, actually %v", err)
+	}
+}
+
+func TestReaderTimestamps(t *testing.T) {
+	ts := string(MakeTimestamp(time.Unix(1700000000, 0)))
+	content := ts + validAof[:27] + "#comment\r\n" + "#TS:1700000002\r\n" + validAof[27:]
+	timestamps, err := ListTimestamps(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timestamps) != 2 || timestamps[0].Timestamp != 1700000000 || timestamps[0].Offset != 0 ||
+		timestamps[1].Timestamp != 1700000002 || timestamps[1].Offset != int64(len(ts)+27+10) {
+		t.Errorf("unexpected timestamps %+v", timestamps)
+	}
+	count, err := Check(strings.NewReader(content))
+	if count != 2 || err != nil {
+		t.Errorf("unexpected check result %d %v", count, err)
+	}
+
+	_, err = Check(strings.NewReader(validAof[:27] + "#TS:abc\r\n"))
+	if formatErr, ok := err.(*FormatError); !ok || formatErr.Offset != 27 {
+		t.Errorf("expected format error, actually %v", err)
+	}
+	_, err = Check(strings.NewReader(validAof[:27] + "#TS:17"))
+	if formatErr, ok := err.(*FormatError); !ok || !formatErr.Truncated || formatErr.Offset != 27 {
+		t.Errorf("expected truncated error, actually %v", err)
+	}
+}
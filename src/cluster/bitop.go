@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"my-godis/src/db"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"strconv"
+)
+
+// BITOP operation destkey key [key ...]
+// keys on different nodes are locked by transaction, source values are collected in prepare
+// and the result is sent to the node of destkey in commit
+func BitOp(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 4 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'bitop' command")
+	}
+	keys := make([]string, len(args)-2)
+	for i := 2; i < len(args); i++ {
+		keys[i-2] = string(args[i])
+	}
+	groupMap := cluster.groupBy(keys)
+	if len(groupMap) == 1 { // do fast
+		for peer := range groupMap { // only one group
+			return cluster.Relay(peer, c, args)
+		}
+	}
+	// check operation before locking keys
+	if _, errReply := db.BitOpValues(string(args[1]), make([][]byte, len(keys)-1)); errReply != nil {
+		return errReply
+	}
+
+	// prepare
+	dest := keys[0]
+	txId := strconv.FormatInt(cluster.idGenerator.NextId(), 10)
+	valueMap := make(map[string][]byte)
+	var errReply redis.Reply
+	for peer, group := range groupMap {
+		prepareArgs := makeArgs("PrepareBitOp", append([]string{txId, dest}, group...)...)
+		var ret redis.Reply
+		if peer == cluster.self {
+			ret = PrepareBitOp(cluster, c, prepareArgs)
+		} else {
+			ret = cluster.Relay(peer, c, prepareArgs)
+		}
+		if reply.IsErrorReply(ret) {
+			errReply = ret
+			break
+		}
+		values, _ := ret.(*reply.MultiBulkReply)
+		for i, key := range group {
+			valueMap[key] = values.Args[i]
+		}
+	}
+	if errReply != nil {
+		cluster.rollbackPeers(c, groupMap, txId)
+		return errReply
+	}
+
+	values := make([][]byte, len(keys)-1)
+	for i, key := range keys[1:] {
+		values[i] = valueMap[key]
+	}
+	result, _ := db.BitOpValues(string(args[1]), values)
+
+	// commit, result is sent to the node of dest
+	destPeer := cluster.peerPicker.Get(dest)
+	for peer := range groupMap {
+		commitArgs := makeArgs("commit", txId)
+		if peer == destPeer {
+			commitArgs = append(commitArgs, result)
+		}
+		var ret redis.Reply
+		if peer == cluster.self {
+			ret = Commit(cluster, c, commitArgs)
+		} else {
+			ret = cluster.Relay(peer, c, commitArgs)
+		}
+		if reply.IsErrorReply(ret) {
+			errReply = ret
+			break
+		}
+	}
+	if errReply != nil {
+		cluster.rollbackPeers(c, groupMap, txId)
+		return errReply
+	}
+	return reply.MakeIntReply(int64(len(result)))
+}
+
+// args: PrepareBitOp id destkey keys...
+// values of keys are replied in order, destkey is written in commit if it belongs to this node
+// and then leads keys
+func PrepareBitOp(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 4 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'preparebitop' command")
+	}
+	txId := string(args[1])
+	dest := string(args[2])
+	keys := make([]string, 0, len(args)-3)
+	for i := 3; i < len(args); i++ {
+		keys = append(keys, string(args[i]))
+	}
+	tx := NewTransaction(cluster, c, txId, makeArgs("BITOP", dest), keys)
+	cluster.transactions.Put(txId, tx)
+	err := tx.prepare()
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	// destkey comes first in keys, it is overwritten regardless of its type
+	// and only checked if it is a source key as well
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		if i == 0 && key == dest {
+			continue
+		}
+		entity, ok := cluster.db.Get(key)
+		if !ok {
+			continue
+		}
+		bytes, ok := entity.Data.([]byte)
+		if !ok {
+			return &reply.WrongTypeErrReply{}
+		}
+		values[i] = bytes
+	}
+	return reply.MakeMultiBulkReply(values)
+}
+
+// invoker should provide lock, tx.args is BITOP destkey [result] where result is appended by commit
+func CommitBitOp(cluster *Cluster, c redis.Connection, tx *Transaction) redis.Reply {
+	if len(tx.args) < 3 {
+		// destkey is on another node
+		return &reply.OkReply{}
+	}
+	dest := string(tx.args[1])
+	result := tx.args[2]
+	if len(result) == 0 {
+		if cluster.db.Removes(dest) > 0 {
+			cluster.db.AddAof(reply.MakeMultiBulkReply(makeArgs("DEL", dest)))
+		}
+		return &reply.OkReply{}
+	}
+	cluster.db.Put(dest, &db.DataEntity{Data: result})
+	cluster.db.Persist(dest)
+	cluster.db.AddAof(reply.MakeMultiBulkReply([][]byte{[]byte("SET"), tx.args[1], result}))
+	return &reply.OkReply{}
+}
+
+// rollbackPeers sends rollback to all nodes of transaction
+func (cluster *Cluster) rollbackPeers(c redis.Connection, groupMap map[string][]string, txId string) {
+	for peer := range groupMap {
+		rollbackArgs := makeArgs("rollback", txId)
+		if peer == cluster.self {
+			Rollback(cluster, c, rollbackArgs)
+		} else {
+			cluster.Relay(peer, c, rollbackArgs)
+		}
+	}
+}
@@ -9,11 +9,14 @@ import (
 	"my-godis/src/interface/redis"
 	"my-godis/src/lib/consistenthash"
 	"my-godis/src/lib/logger"
+	"my-godis/src/monitor"
 	"my-godis/src/redis/client"
 	"my-godis/src/redis/reply"
+	"my-godis/src/stats"
 
 	"runtime/debug"
 	"strings"
+	"time"
 )
 
 type Cluster struct {
@@ -21,6 +24,8 @@ type Cluster struct {
 
 	peerPicker *consistenthash.Map
 	peers      map[string]*client.Client
+	// all nodes including self
+	nodes []string
 
 	db           *db.DB
 	transactions *dict.SimpleDict // id -> Transaction
@@ -34,28 +39,31 @@ const (
 )
 
 func MakeCluster() *Cluster {
+	properties := config.Properties()
 	cluster := &Cluster{
-		self: config.Properties.Self,
+		self: properties.Self,
 
 		db:           db.MakeDB(),
 		transactions: dict.MakeSimple(),
 		peerPicker:   consistenthash.New(replicas, nil),
 		peers:        make(map[string]*client.Client),
 
-		idGenerator: idgenerator.MakeGenerator("godis", config.Properties.Self),
+		idGenerator: idgenerator.MakeGenerator("godis", properties.Self),
 	}
-	if config.Properties.Peers != nil && len(config.Properties.Peers) > 0 && config.Properties.Self != "" {
+	cluster.db.Mode = db.ModeCluster
+	if properties.Peers != nil && len(properties.Peers) > 0 && properties.Self != "" {
 		contains := make(map[string]bool)
-		peers := make([]string, 0, len(config.Properties.Peers)+1)
-		for _, peer := range config.Properties.Peers {
+		peers := make([]string, 0, len(properties.Peers)+1)
+		for _, peer := range properties.Peers {
 			if _, ok := contains[peer]; ok {
 				continue
 			}
 			contains[peer] = true
 			peers = append(peers, peer)
 		}
-		peers = append(peers, config.Properties.Self)
+		peers = append(peers, properties.Self)
 		cluster.peerPicker.Add(peers...)
+		cluster.nodes = peers
 	}
 	return cluster
 }
@@ -78,16 +86,22 @@ func (cluster *Cluster) Exec(c redis.Connection, args [][]byte) (result redis.Re
 	}()
 
 	cmd := strings.ToLower(string(args[0]))
+	stats.Incr(&stats.TotalCommandsProcessed)
 	cmdFunc, ok := router[cmd]
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmd + "', or not supported in cluster mode")
 	}
+	if cmd != "monitor" {
+		monitor.Default.Feed(c, args)
+	}
+	start := time.Now()
 	result = cmdFunc(cluster, c, args)
+	db.RecordCommand(c, cmd, args, start, time.Since(start))
 	return
 }
 
 func (cluster *Cluster) AfterClientClose(c redis.Connection) {
-
+	cluster.db.AfterClientClose(c)
 }
 
 func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
@@ -119,8 +133,8 @@ func Ping(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 // cannot call Prepare, Commit, Rollback of self node
 func (cluster *Cluster) Relay(peer string, c redis.Connection, args [][]byte) redis.Reply {
 	if peer == cluster.self {
-		// to self db
-		return cluster.db.Exec(c, args)
+		// to self db, command has been recorded by cluster.Exec
+		return cluster.db.Dispatch(c, args)
 	} else {
 		peerClient, err := cluster.getPeerClient(peer)
 		if err != nil {
@@ -148,8 +162,9 @@ func Rollback(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 	return reply.MakeIntReply(1)
 }
 
+// args: commit id [arg ...], args computed by the coordinator after prepare are appended to tx.args
 func Commit(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'commit' command")
 	}
 	txId := string(args[1])
@@ -158,6 +173,7 @@ func Commit(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 		return reply.MakeIntReply(0)
 	}
 	tx, _ := raw.(*Transaction)
+	tx.args = append(tx.args, args[2:]...)
 
 	// finish transaction
 	defer func() {
@@ -169,6 +185,8 @@ func Commit(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
 	var result redis.Reply
 	if cmd == "del" {
 		result = CommitDel(cluster, c, tx)
+	} else if cmd == "bitop" {
+		result = CommitBitOp(cluster, c, tx)
 	}
 
 	if reply.IsErrorReply(result) {
@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"strings"
+)
+
+// SCRIPT LOAD and FLUSH are sent to all nodes, so EVALSHA could be relayed to any node
+func Script(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return broadcastSubCommands(cluster, c, args, "load", "flush")
+}
+
+// function libraries are changed on all nodes, so FCALL could be relayed to any node
+func Function(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return broadcastSubCommands(cluster, c, args, "load", "delete", "flush", "restore")
+}
+
+// broadcastSubCommands sends the given sub commands to all nodes, others run on self node
+func broadcastSubCommands(cluster *Cluster, c redis.Connection, args [][]byte, subCmds ...string) redis.Reply {
+	broadcast := false
+	if len(args) >= 2 {
+		subCmd := strings.ToLower(string(args[1]))
+		for _, name := range subCmds {
+			broadcast = broadcast || subCmd == name
+		}
+	}
+	result := execSelf(cluster, c, args)
+	if !broadcast || reply.IsErrorReply(result) {
+		return result
+	}
+	for _, peer := range cluster.nodes {
+		if peer == cluster.self {
+			continue
+		}
+		resp := cluster.Relay(peer, c, args)
+		if reply.IsErrorReply(resp) {
+			return resp
+		}
+	}
+	return result
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"my-godis/src/db"
 	"my-godis/src/interface/redis"
-	"my-godis/src/lib/marshal/gob"
+	"my-godis/src/lib/marshal/payload"
 	"time"
 )
 
@@ -59,7 +59,7 @@ func (tx *Transaction) prepare() error {
 	for _, key := range tx.keys {
 		entity, ok := tx.cluster.db.Get(key)
 		if ok {
-			blob, err := gob.Marshal(entity)
+			blob, err := payload.Dump(entity.Data)
 			if err != nil {
 				return err
 			}
@@ -75,12 +75,11 @@ func (tx *Transaction) prepare() error {
 func (tx *Transaction) rollback() error {
 	for key, blob := range tx.undoLog {
 		if len(blob) > 0 {
-			entity := &db.DataEntity{}
-			err := gob.UnMarshal(blob, entity)
+			val, err := payload.Load(blob)
 			if err != nil {
 				return err
 			}
-			tx.cluster.db.Put(key, entity)
+			tx.cluster.db.Put(key, &db.DataEntity{Data: val})
 		} else {
 			tx.cluster.db.Remove(key)
 		}
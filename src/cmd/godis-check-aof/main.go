@@ -0,0 +1,153 @@
+package main
+
+/*
+ * godis-check-aof validates an append only file and reports offset of the first bad entry
+ * usage: godis-check-aof [--fix] <file.aof|file.manifest|appenddirname>
+ *        godis-check-aof --list-timestamps <file.aof|file.manifest|appenddirname>
+ *        godis-check-aof --truncate-to-timestamp <unix-time> <file.aof|file.manifest|appenddirname>
+ * files of multi part aof are checked in manifest order, only the last one could be fixed
+ */
+
+import (
+	"flag"
+	"fmt"
+	"my-godis/src/aof"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file at the first bad entry")
+	list := flag.Bool("list-timestamps", false, "list timestamp annotations")
+	until := flag.Int64("truncate-to-timestamp", 0, "remove commands after the unix time")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: godis-check-aof [--fix] <file.aof|file.manifest|appenddirname>")
+		fmt.Fprintln(os.Stderr, "       godis-check-aof --list-timestamps <file.aof|file.manifest|appenddirname>")
+		fmt.Fprintln(os.Stderr, "       godis-check-aof --truncate-to-timestamp <unix-time> <file.aof|file.manifest|appenddirname>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	manifest, err := openManifest(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *list {
+		os.Exit(listTimestamps(manifest, filename))
+	}
+	if *until > 0 {
+		os.Exit(truncateToTimestamp(manifest, filename, *until))
+	}
+	if manifest == nil {
+		os.Exit(check(filename, *fix))
+	}
+	os.Exit(checkManifest(manifest, *fix))
+}
+
+// openManifest loads manifest if path is a manifest or a directory containing one, returns nil for a single aof file
+func openManifest(path string) (*aof.Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(path, "*.manifest"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) != 1 {
+			return nil, fmt.Errorf("expected one manifest in %s, found %d", path, len(matches))
+		}
+		path = matches[0]
+	} else if !strings.HasSuffix(path, ".manifest") {
+		return nil, nil
+	}
+	filename := strings.TrimSuffix(filepath.Base(path), ".manifest")
+	return aof.LoadManifest(filepath.Dir(path), filename)
+}
+
+func checkManifest(manifest *aof.Manifest, fix bool) int {
+	files := manifest.Files()
+	if len(files) == 0 {
+		fmt.Println("AOF is valid")
+		return 0
+	}
+	for i, file := range files {
+		last := i == len(files)-1
+		filename := manifest.Path(file)
+		if _, err := os.Stat(filename); os.IsNotExist(err) && last && file.Type == aof.TypeIncr {
+			// incremental file not created yet
+			continue
+		}
+		code := check(filename, fix && last)
+		if code != 0 {
+			if fix && !last {
+				fmt.Println("Only the last file of multi part AOF could be fixed, data after the bad entry would be lost.")
+			}
+			return code
+		}
+	}
+	return 0
+}
+
+func check(filename string, fix bool) int {
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	count, err := aof.Check(file)
+	_ = file.Close()
+
+	size := info.Size()
+	okUpTo := size
+	formatErr, ok := err.(*aof.FormatError)
+	if err != nil && !ok {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if ok {
+		okUpTo = formatErr.Offset
+	}
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, commands=%d, diff=%d\n",
+		filename, size, okUpTo, count, size-okUpTo)
+	if formatErr == nil {
+		fmt.Println("AOF is valid")
+		return 0
+	}
+	if formatErr.Truncated {
+		fmt.Printf("AOF is truncated at offset %d: %s\n", formatErr.Offset, formatErr.Msg)
+	} else {
+		fmt.Printf("AOF is corrupted at offset %d: %s\n", formatErr.Offset, formatErr.Msg)
+	}
+	if formatErr.Preamble {
+		fmt.Println("RDB preamble of AOF is corrupted and cannot be fixed.")
+		return 1
+	}
+	if !fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		return 1
+	}
+	if !formatErr.Truncated {
+		fmt.Printf("WARNING: all %d bytes after offset %d will be discarded\n", size-okUpTo, okUpTo)
+	}
+	err = os.Truncate(filename, okUpTo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to truncate AOF:", err)
+		return 1
+	}
+	fmt.Println("Successfully truncated AOF")
+	return 0
+}
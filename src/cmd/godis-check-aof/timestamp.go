@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"my-godis/src/aof"
+	"os"
+	"time"
+)
+
+// timestamp annotation in aof files
+type marker struct {
+	*aof.Entry
+	file *aof.FileInfo
+	path string
+	// offset in files of manifest order, used by aof-load-until-offset
+	totalOffset int64
+}
+
+// readMarkers reads timestamp annotations of a single aof file or files of manifest
+func readMarkers(manifest *aof.Manifest, filename string) ([]*marker, error) {
+	files := []*aof.FileInfo{nil}
+	if manifest != nil {
+		files = manifest.Files()
+	}
+	var markers []*marker
+	var loaded int64
+	for _, file := range files {
+		path := filename
+		if file != nil {
+			path = manifest.Path(file)
+		}
+		f, err := os.Open(path)
+		if os.IsNotExist(err) && file != nil && file.Type == aof.TypeIncr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		timestamps, err := aof.ListTimestamps(f)
+		info, statErr := f.Stat()
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if statErr != nil {
+			return nil, statErr
+		}
+		for _, entry := range timestamps {
+			markers = append(markers, &marker{
+				Entry:       entry,
+				file:        file,
+				path:        path,
+				totalOffset: loaded + entry.Offset,
+			})
+		}
+		loaded += info.Size()
+	}
+	return markers, nil
+}
+
+func listTimestamps(manifest *aof.Manifest, filename string) int {
+	markers, err := readMarkers(manifest, filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, m := range markers {
+		fmt.Printf("timestamp=%d time=%s file=%s offset=%d total_offset=%d\n",
+			m.Timestamp, time.Unix(m.Timestamp, 0).Format(time.RFC3339), m.path, m.Offset, m.totalOffset)
+	}
+	fmt.Printf("%d timestamps found\n", len(markers))
+	return 0
+}
+
+// truncateToTimestamp truncates at the first annotation after until, incremental files after it are removed
+func truncateToTimestamp(manifest *aof.Manifest, filename string, until int64) int {
+	markers, err := readMarkers(manifest, filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var target *marker
+	for _, m := range markers {
+		if m.Timestamp > until {
+			target = m
+			break
+		}
+	}
+	if target == nil {
+		fmt.Println("No commands after the timestamp, AOF is not changed")
+		return 0
+	}
+	if target.file != nil && target.file.Type == aof.TypeBase {
+		fmt.Fprintf(os.Stderr, "The timestamp is earlier than base file %s, AOF cannot be truncated to it\n", target.path)
+		return 1
+	}
+	if manifest != nil {
+		next := manifest.Clone()
+		dropped := next.DropIncrsAfter(target.file.Seq)
+		if len(dropped) > 0 {
+			if err := next.Save(); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to save manifest:", err)
+				return 1
+			}
+			for _, file := range dropped {
+				if err := os.Remove(next.Path(file)); err != nil && !os.IsNotExist(err) {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				fmt.Printf("Removed %s\n", next.Path(file))
+			}
+		}
+	}
+	if err := os.Truncate(target.path, target.Offset); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to truncate AOF:", err)
+		return 1
+	}
+	fmt.Printf("Successfully truncated %s at offset %d to timestamp %d\n", target.path, target.Offset, until)
+	return 0
+}
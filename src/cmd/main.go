@@ -2,14 +2,38 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"my-godis/src/config"
 	"my-godis/src/lib/logger"
+	"my-godis/src/lib/metrics"
 	RedisServer "my-godis/src/redis/server"
 	"my-godis/src/tcp"
+	"os"
 )
 
+const defaultConfigFilename = "redis.conf"
+
 func main() {
-	config.SetupConfig("redis.conf")
+	configFilename, overrides, err := config.ParseArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if configFilename == "" {
+		// default config file is optional
+		configFilename = defaultConfigFilename
+		if _, err := os.Stat(configFilename); os.IsNotExist(err) {
+			configFilename = ""
+		}
+	}
+	if configFilename != "" {
+		if err := config.SetupConfig(configFilename); err != nil {
+			log.Fatal("invalid config:\n", err)
+		}
+	}
+	if err := config.ApplyOverrides(overrides); err != nil {
+		log.Fatal("invalid config:\n", err)
+	}
+
 	logger.Setup(&logger.Settings{
 		Path:       "logs",
 		Name:       "godis",
@@ -17,7 +41,12 @@ func main() {
 		TimeFormat: "2006-01-02",
 	})
 
+	properties := config.Properties()
+	if properties.MetricsPort > 0 {
+		go metrics.ListenAndServe(fmt.Sprintf("%s:%d", properties.Bind, properties.MetricsPort))
+	}
+
 	tcp.ListenAndServe(&tcp.Config{
-		Address: fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port),
+		Address: fmt.Sprintf("%s:%d", properties.Bind, properties.Port),
 	}, RedisServer.MakeHandler())
 }
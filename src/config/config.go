@@ -1,100 +1,677 @@
 package config
 
 import (
-	"bufio"
-	"log"
-	"my-godis/src/lib/logger"
+	"errors"
+	"fmt"
+	"my-godis/src/lib/wildcard"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+/*
+ * fields tagged with `mutable:"yes"` could be changed by CONFIG SET at runtime
+ * time.Duration fields are tagged with unit of bare numbers, like `unit:"s"`, and accept `5s`, `100ms` as well
+ * int64 fields tagged with `unit:"memory"` accept memory units, like `100mb`
+ * string fields tagged with `enum:"a,b"` only accept listed values
+ */
 type PropertyHolder struct {
-	Bind           string   `cfg:"bind"`
-	Port           int      `cfg:"port"`
-	AppendOnly     bool     `cfg:"appendOnly"`
-	AppendFilename string   `cfg:"appendFilename"`
-	MaxClients     int      `cfg:"maxclients"`
-	Peers          []string `cfg:"peers"`
-	Self           string   `cfg:"self"`
+	Bind        string   `cfg:"bind"`
+	Port        int      `cfg:"port"`
+	MaxClients  int      `cfg:"maxclients" mutable:"yes"`
+	Peers       []string `cfg:"peers"`
+	Self        string   `cfg:"self"`
+	MetricsPort int      `cfg:"metrics-port"`
+
+	AppendOnly     bool   `cfg:"appendOnly" mutable:"yes"`
+	AppendFilename string `cfg:"appendFilename"`
+	// directory of base, incremental files and manifest of aof
+	AppendDirname string `cfg:"appenddirname"`
+	AppendFsync   string `cfg:"appendfsync" enum:"always,everysec,no" mutable:"yes"`
+	// trim incomplete last command of aof while loading instead of refusing to start
+	AofLoadTruncated bool `cfg:"aof-load-truncated" mutable:"yes"`
+	// rewrite aof automatically when it grows by the percentage since last rewrite and is larger than min size
+	AutoAofRewritePercentage int   `cfg:"auto-aof-rewrite-percentage" mutable:"yes"`
+	AutoAofRewriteMinSize    int64 `cfg:"auto-aof-rewrite-min-size" unit:"memory" mutable:"yes"`
+	// rewrite base file as binary snapshot which is loaded much faster than commands
+	AofUseRdbPreamble bool `cfg:"aof-use-rdb-preamble" mutable:"yes"`
+	// annotate aof with `#TS:` every second for point-in-time restore
+	AofTimestampEnabled bool `cfg:"aof-timestamp-enabled" mutable:"yes"`
+	// load aof only until the unix time or the offset in files of manifest order, 0 means no limit
+	AofLoadUntilTimestamp int64 `cfg:"aof-load-until-timestamp"`
+	AofLoadUntilOffset    int64 `cfg:"aof-load-until-offset"`
+
+	// close connection after client is idle, 0 to disable
+	Timeout time.Duration `cfg:"timeout" unit:"s" mutable:"yes"`
+	// in bytes, 0 means no limit
+	MaxMemory int64 `cfg:"maxmemory" unit:"memory" mutable:"yes"`
+
+	// negative value disables slowlog
+	SlowlogLogSlowerThan time.Duration `cfg:"slowlog-log-slower-than" unit:"us" mutable:"yes"`
+	SlowlogMaxLen        int           `cfg:"slowlog-max-len" mutable:"yes"`
+	// 0 disables latency monitor
+	LatencyMonitorThreshold time.Duration `cfg:"latency-monitor-threshold" unit:"ms" mutable:"yes"`
+	// scripts running longer than it could be killed by SCRIPT KILL, other commands get BUSY meanwhile, 0 to disable
+	LuaTimeLimit time.Duration `cfg:"lua-time-limit" unit:"ms" mutable:"yes"`
 }
 
-var Properties *PropertyHolder
+// values of appendfsync
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+/*
+ * current config is published as an immutable copy, requests read it without lock.
+ * changes are made on a copy which replaces current config as a whole,
+ * so readers never see a half-written value
+ */
+var current atomic.Pointer[PropertyHolder]
+
+// path of loaded config file, used by CONFIG REWRITE
+var ConfigFilename string
+
+// serialize changes of config and CONFIG REWRITE
+var mu sync.Mutex
+
+// Properties returns current config, it must not be modified, use Set or Update instead
+func Properties() *PropertyHolder {
+	return current.Load()
+}
+
+// Update applies modify on a copy of current config and publishes the copy
+func Update(modify func(properties *PropertyHolder)) {
+	mu.Lock()
+	defer mu.Unlock()
+	properties := *current.Load()
+	modify(&properties)
+	current.Store(&properties)
+}
+
+func makeDefaultProperties() *PropertyHolder {
+	return &PropertyHolder{
+		Bind:           "127.0.0.1",
+		Port:           6379,
+		AppendOnly:     false,
+		AppendFilename: "appendonly.aof",
+		AppendDirname:  "appendonlydir",
+		AppendFsync:    FsyncEverySec,
+
+		AofLoadTruncated:         true,
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 << 20,
+		AofUseRdbPreamble:        true,
+		AofTimestampEnabled:      true,
+
+		SlowlogLogSlowerThan: 10 * time.Millisecond,
+		SlowlogMaxLen:        128,
+
+		LuaTimeLimit: 5 * time.Second,
+	}
+}
 
 func init() {
 	// default config
-	Properties = &PropertyHolder{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+	current.Store(makeDefaultProperties())
+}
+
+// fieldKey returns lower case config key of struct field
+func fieldKey(field reflect.StructField) string {
+	key, ok := field.Tag.Lookup("cfg")
+	if !ok {
+		key = field.Name
+	}
+	return strings.ToLower(key)
+}
+
+// lookupField finds field of holder by config key
+func lookupField(holder reflect.Value, key string) (reflect.StructField, reflect.Value, bool) {
+	t := holder.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if fieldKey(t.Field(i)) == key {
+			return t.Field(i), holder.Field(i), true
+		}
 	}
+	return reflect.StructField{}, reflect.Value{}, false
+}
+
+/* ---- units ---- */
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+var durationUnits = map[string]time.Duration{
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// durationUnit returns unit of bare numbers for duration field, seconds by default
+func durationUnit(field reflect.StructField) time.Duration {
+	unit, ok := durationUnits[field.Tag.Get("unit")]
+	if !ok {
+		return time.Second
+	}
+	return unit
+}
+
+// suffixes of formatted durations, longer unit first
+var durationSuffixes = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"h", time.Hour}, {"m", time.Minute}, {"s", time.Second},
+	{"ms", time.Millisecond}, {"us", time.Microsecond}, {"ns", time.Nanosecond},
 }
 
-func LoadConfig(configFilename string) *PropertyHolder {
-	config := Properties
-	file, err := os.Open(configFilename)
+// formatDuration formats d as bare number of field unit, or with the longest suffix dividing it if truncated
+func formatDuration(field reflect.StructField, d time.Duration) string {
+	unit := durationUnit(field)
+	if d%unit == 0 {
+		return strconv.FormatInt(int64(d/unit), 10)
+	}
+	for _, item := range durationSuffixes {
+		if d%item.unit == 0 {
+			return strconv.FormatInt(int64(d/item.unit), 10) + item.suffix
+		}
+	}
+	return d.String()
+}
+
+func parseDuration(field reflect.StructField, value string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(n) * durationUnit(field), nil
+	}
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		log.Print(err)
-		return config
+		return 0, errors.New("argument couldn't be parsed into a duration")
 	}
-	defer file.Close()
+	return d, nil
+}
 
-	// read config file
-	rawMap := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 0 && line[0] == '#' {
-			continue
+// longer suffix must be matched first, k means 1000 and kb means 1024 like redis
+var memoryUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	factor := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			factor = unit.factor
+			break
 		}
-		pivot := strings.IndexAny(line, " ")
-		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
-			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		logger.Fatal(err)
-	}
-
-	// parse format
-	t := reflect.TypeOf(config)
-	v := reflect.ValueOf(config)
-	n := t.Elem().NumField()
-	for i := 0; i < n; i++ {
-		field := t.Elem().Field(i)
-		fieldVal := v.Elem().Field(i)
-		key, ok := field.Tag.Lookup("cfg")
-		if !ok {
-			key = field.Name
-		}
-		value, ok := rawMap[strings.ToLower(key)]
-		if ok {
-			// fill config
-			switch field.Type.Kind() {
-			case reflect.String:
-				fieldVal.SetString(value)
-			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * factor, nil
+}
+
+/* ---- field values ---- */
+
+// setField parses values and fills them into fieldVal
+func setField(field reflect.StructField, fieldVal reflect.Value, values []string) error {
+	if len(values) == 0 {
+		return errors.New("missing argument")
+	}
+	if field.Type.Kind() != reflect.Slice && len(values) != 1 {
+		return errors.New("wrong number of arguments")
+	}
+	value := values[0]
+	switch field.Type.Kind() {
+	case reflect.String:
+		if enum, ok := field.Tag.Lookup("enum"); ok {
+			value = strings.ToLower(value)
+			valid := false
+			for _, item := range strings.Split(enum, ",") {
+				valid = valid || item == value
+			}
+			if !valid {
+				return errors.New("argument must be one of " + enum)
+			}
+		}
+		fieldVal.SetString(value)
+	case reflect.Int, reflect.Int64:
+		var intValue int64
+		var err error
+		if field.Type == durationType {
+			var d time.Duration
+			d, err = parseDuration(field, value)
+			intValue = int64(d)
+		} else if field.Tag.Get("unit") == "memory" {
+			intValue, err = parseMemory(value)
+		} else if intValue, err = strconv.ParseInt(value, 10, 64); err != nil {
+			err = errors.New("argument couldn't be parsed into an integer")
+		}
+		if err != nil {
+			return err
+		}
+		fieldVal.SetInt(intValue)
+	case reflect.Float64:
+		floatValue, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into a float")
+		}
+		fieldVal.SetFloat(floatValue)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes":
+			fieldVal.SetBool(true)
+		case "no":
+			fieldVal.SetBool(false)
+		default:
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+	case reflect.Slice:
+		if field.Type.Elem().Kind() == reflect.String {
+			// both `peers a,b` and `peers a b` are accepted
+			slice := make([]string, 0, len(values))
+			for _, v := range values {
+				for _, item := range strings.Split(v, ",") {
+					if item != "" {
+						slice = append(slice, item)
+					}
+				}
+			}
+			fieldVal.Set(reflect.ValueOf(slice))
+		}
+	}
+	return nil
+}
+
+// formatField formats field value in config file format
+func formatField(field reflect.StructField, fieldVal reflect.Value) string {
+	switch fieldVal.Kind() {
+	case reflect.String:
+		return fieldVal.String()
+	case reflect.Int, reflect.Int64:
+		if field.Type == durationType {
+			return formatDuration(field, time.Duration(fieldVal.Int()))
+		}
+		return strconv.FormatInt(fieldVal.Int(), 10)
+	case reflect.Float64:
+		return strconv.FormatFloat(fieldVal.Float(), 'f', -1, 64)
+	case reflect.Bool:
+		if fieldVal.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		if slice, ok := fieldVal.Interface().([]string); ok {
+			return strings.Join(slice, ",")
+		}
+	}
+	return ""
+}
+
+// quoteValue quotes value for config file if necessary
+func quoteValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"'\\\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+/* ---- config file ---- */
+
+// ParseError reports an invalid line of config file or an invalid command line argument
+type ParseError struct {
+	Filename string
+	Line     int
+	Msg      string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return e.Filename + ": " + e.Msg
+	}
+	return e.Filename + ":" + strconv.Itoa(e.Line) + ": " + e.Msg
+}
+
+// max depth of nested include, prevents include loop
+const maxIncludeDepth = 16
+
+/*
+ * splitArgs splits a config line into arguments like redis does,
+ * supports "double quoted" string with escapes and 'single quoted' string
+ */
+func splitArgs(line string) ([]string, error) {
+	args := make([]string, 0)
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var current strings.Builder
+		var quote byte // 0, '"' or '\''
+		if line[i] == '"' || line[i] == '\'' {
+			quote = line[i]
+			i++
+		}
+		closed := quote == 0
+		for ; i < len(line); i++ {
+			c := line[i]
+			if quote == 0 {
+				if c == ' ' || c == '\t' {
+					break
 				}
-			case reflect.Bool:
-				boolValue := "yes" == value
-				fieldVal.SetBool(boolValue)
-			case reflect.Slice:
-				if field.Type.Elem().Kind() == reflect.String {
-					slice := strings.Split(value, ",")
-					fieldVal.Set(reflect.ValueOf(slice))
+				current.WriteByte(c)
+				continue
+			}
+			if c == '\\' && i+1 < len(line) && (quote == '"' || line[i+1] == '\'') {
+				i++
+				switch line[i] {
+				case 'n':
+					current.WriteByte('\n')
+				case 'r':
+					current.WriteByte('\r')
+				case 't':
+					current.WriteByte('\t')
+				default:
+					current.WriteByte(line[i])
+				}
+				continue
+			}
+			if c == quote {
+				// closing quote must be followed by a space or nothing
+				if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+					return nil, errors.New("closing quote must be followed by a space")
 				}
+				i++
+				closed = true
+				break
 			}
+			current.WriteByte(c)
+		}
+		if !closed {
+			return nil, errors.New("unbalanced quotes")
+		}
+		args = append(args, current.String())
+	}
+}
+
+// applyConfig sets config of key into holder
+func applyConfig(holder reflect.Value, key string, values []string) error {
+	field, fieldVal, ok := lookupField(holder, strings.ToLower(key))
+	if !ok {
+		return fmt.Errorf("unknown config '%s'", key)
+	}
+	err := setField(field, fieldVal, values)
+	if err != nil {
+		return fmt.Errorf("invalid value for '%s': %s", key, err.Error())
+	}
+	return nil
+}
+
+// parseFile fills config file into holder, returns errors of all invalid lines
+func parseFile(holder reflect.Value, filename string, depth int) []error {
+	if depth > maxIncludeDepth {
+		return []error{&ParseError{Filename: filename, Msg: "too many nested includes"}}
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			errs = append(errs, &ParseError{Filename: filename, Line: i + 1, Msg: err.Error()})
+			continue
+		}
+		if strings.ToLower(args[0]) == "include" {
+			if len(args) != 2 {
+				errs = append(errs, &ParseError{Filename: filename, Line: i + 1, Msg: "include requires exactly one file"})
+				continue
+			}
+			// relative path is resolved against the including file
+			path := args[1]
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(filename), path)
+			}
+			errs = append(errs, parseFile(holder, path, depth+1)...)
+			continue
+		}
+		err = applyConfig(holder, args[0], args[1:])
+		if err != nil {
+			errs = append(errs, &ParseError{Filename: filename, Line: i + 1, Msg: err.Error()})
 		}
 	}
-	return config
+	return errs
 }
 
-func SetupConfig(configFilename string) {
-	Properties = LoadConfig(configFilename)
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "\n"))
+}
+
+// LoadConfig reads config file into a copy of current config, returns errors of all invalid lines
+func LoadConfig(configFilename string) (*PropertyHolder, error) {
+	config := *Properties()
+	errs := parseFile(reflect.ValueOf(&config).Elem(), configFilename, 0)
+	return &config, joinErrors(errs)
+}
+
+func SetupConfig(configFilename string) error {
+	ConfigFilename = configFilename
+	config, err := LoadConfig(configFilename)
+	current.Store(config)
+	return err
+}
+
+/* ---- command line ---- */
+
+/*
+ * ParseArgs parses command line like `[config-file] [--config config-file] [--key value ...]`
+ * returns path of config file and overrides as [key, values...] in order
+ */
+func ParseArgs(args []string) (string, [][]string, error) {
+	configFilename := ""
+	var overrides [][]string
+	i := 0
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		configFilename = args[0]
+		i++
+	}
+	for i < len(args) {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			return "", nil, fmt.Errorf("invalid argument '%s'", arg)
+		}
+		override := []string{strings.ToLower(arg[2:])}
+		for i++; i < len(args) && !strings.HasPrefix(args[i], "--"); i++ {
+			override = append(override, args[i])
+		}
+		if override[0] == "config" {
+			if len(override) != 2 {
+				return "", nil, errors.New("--config requires exactly one file")
+			}
+			configFilename = override[1]
+			continue
+		}
+		overrides = append(overrides, override)
+	}
+	return configFilename, overrides, nil
+}
+
+// ApplyOverrides applies configs from command line, they take precedence over config file
+func ApplyOverrides(overrides [][]string) error {
+	var errs []error
+	Update(func(properties *PropertyHolder) {
+		holder := reflect.ValueOf(properties).Elem()
+		for _, override := range overrides {
+			err := applyConfig(holder, override[0], override[1:])
+			if err != nil {
+				errs = append(errs, &ParseError{Filename: "command line", Msg: err.Error()})
+			}
+		}
+	})
+	return joinErrors(errs)
+}
+
+/* ---- runtime config ---- */
+
+// Get returns key-value pairs of config matching pattern, sorted by key
+func Get(pattern string) [][2]string {
+	matcher := wildcard.CompilePattern(strings.ToLower(pattern))
+	result := make([][2]string, 0)
+	properties := Properties()
+	t := reflect.TypeOf(properties).Elem()
+	v := reflect.ValueOf(properties).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		key := fieldKey(t.Field(i))
+		if matcher.IsMatch(key) {
+			result = append(result, [2]string{key, formatField(t.Field(i), v.Field(i))})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i][0] < result[j][0]
+	})
+	return result
+}
+
+// Set changes mutable config at runtime
+func Set(key string, value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	properties := *current.Load()
+	field, fieldVal, ok := lookupField(reflect.ValueOf(&properties).Elem(), strings.ToLower(key))
+	if !ok {
+		return errors.New("unsupported CONFIG parameter")
+	}
+	if field.Tag.Get("mutable") != "yes" {
+		return errors.New("can't set immutable config")
+	}
+	err := setField(field, fieldVal, []string{value})
+	if err != nil {
+		return err
+	}
+	current.Store(&properties)
+	return nil
+}
+
+// RemoveFromFile removes lines of keys from config file, other lines and runtime config are not changed
+func RemoveFromFile(keys ...string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if ConfigFilename == "" {
+		return errors.New("the server is running without a config file")
+	}
+	content, err := os.ReadFile(ConfigFilename)
+	if err != nil {
+		return err
+	}
+	removing := make(map[string]bool)
+	for _, key := range keys {
+		removing[strings.ToLower(key)] = true
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && removing[strings.ToLower(fields[0])] {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	tmpFilename := ConfigFilename + ".tmp"
+	err = os.WriteFile(tmpFilename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, ConfigFilename)
+}
+
+// Rewrite writes current config into config file, keeps comments and unknown lines of original file
+func Rewrite() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if ConfigFilename == "" {
+		return errors.New("the server is running without a config file")
+	}
+
+	properties := current.Load()
+	t := reflect.TypeOf(properties).Elem()
+	currentVal := reflect.ValueOf(properties).Elem()
+	defaults := reflect.ValueOf(makeDefaultProperties()).Elem()
+	values := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		values[fieldKey(t.Field(i))] = quoteValue(formatField(t.Field(i), currentVal.Field(i)))
+	}
+
+	var lines []string
+	content, err := os.ReadFile(ConfigFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	written := make(map[string]bool)
+	if len(content) > 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || trimmed[0] == '#' {
+				lines = append(lines, line)
+				continue
+			}
+			key := strings.ToLower(strings.Fields(trimmed)[0])
+			value, known := values[key]
+			if !known {
+				// include directives and unknown lines are kept as is
+				lines = append(lines, line)
+				continue
+			}
+			if written[key] {
+				// drop duplicated config line
+				continue
+			}
+			lines = append(lines, strings.Fields(trimmed)[0]+" "+value)
+			written[key] = true
+		}
+	}
+	// append changed configs which not exist in original file
+	for i := 0; i < t.NumField(); i++ {
+		key := fieldKey(t.Field(i))
+		if written[key] {
+			continue
+		}
+		if reflect.DeepEqual(currentVal.Field(i).Interface(), defaults.Field(i).Interface()) {
+			continue
+		}
+		lines = append(lines, key+" "+values[key])
+	}
+
+	tmpFilename := ConfigFilename + ".tmp"
+	err = os.WriteFile(tmpFilename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, ConfigFilename)
 }
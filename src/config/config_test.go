@@ -0,0 +1,228 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetGet(t *testing.T) {
+	current.Store(makeDefaultProperties())
+	err := Set("maxclients", "100")
+	if err != nil {
+		t.Error(err)
+	}
+	if Properties().MaxClients != 100 {
+		t.Errorf("expected 100, actually %d", Properties().MaxClients)
+	}
+	if Set("port", "7000") == nil {
+		t.Error("expected error while setting immutable config")
+	}
+	if Set("appendonly", "maybe") == nil {
+		t.Error("expected error while setting invalid bool")
+	}
+	pairs := Get("max*")
+	if len(pairs) != 2 || pairs[0][0] != "maxclients" || pairs[0][1] != "100" || pairs[1][0] != "maxmemory" {
+		t.Errorf("unexpected result %v", pairs)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	content := "# comment\nbind 0.0.0.0\nport 6399\n\nmaxclients 10\n"
+	err := os.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(makeDefaultProperties())
+	_ = SetupConfig(filename)
+	_ = Set("maxclients", "20")
+	_ = Set("timeout", "500ms")
+	err = Rewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, _ := os.ReadFile(filename)
+	expected := "# comment\nbind 0.0.0.0\nport 6399\n\nmaxclients 20\ntimeout 500ms\n"
+	if string(actual) != expected {
+		t.Errorf("expected %q, actually %q", expected, actual)
+	}
+	current.Store(makeDefaultProperties())
+	ConfigFilename = ""
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`requirepass "a b\"c" 'd e' f`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"requirepass", `a b"c`, "d e", "f"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, actually %q", expected, args)
+	}
+	if _, err := splitArgs(`bind "127.0.0.1`); err == nil {
+		t.Error("expected error for unbalanced quotes")
+	}
+	if _, err := splitArgs(`bind "127.0.0.1"x`); err == nil {
+		t.Error("expected error for closing quote followed by text")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	included := "maxmemory 100mb\ntimeout 5m\n"
+	err := os.WriteFile(filepath.Join(dir, "extra.conf"), []byte(included), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "# comment\nbind \"0.0.0.0\"\ninclude extra.conf\nslowlog-log-slower-than 5ms\n" +
+		"latency-monitor-threshold 100\npeers a:1 b:2,c:3\n"
+	filename := filepath.Join(dir, "redis.conf")
+	err = os.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(makeDefaultProperties())
+	defer func() {
+		current.Store(makeDefaultProperties())
+		ConfigFilename = ""
+	}()
+	err = SetupConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Properties().Bind != "0.0.0.0" {
+		t.Errorf("unexpected bind %s", Properties().Bind)
+	}
+	if Properties().MaxMemory != 100*1024*1024 {
+		t.Errorf("unexpected maxmemory %d", Properties().MaxMemory)
+	}
+	if Properties().Timeout != 5*time.Minute {
+		t.Errorf("unexpected timeout %s", Properties().Timeout)
+	}
+	if Properties().SlowlogLogSlowerThan != 5*time.Millisecond {
+		t.Errorf("unexpected slowlog-log-slower-than %s", Properties().SlowlogLogSlowerThan)
+	}
+	if Properties().LatencyMonitorThreshold != 100*time.Millisecond {
+		t.Errorf("unexpected latency-monitor-threshold %s", Properties().LatencyMonitorThreshold)
+	}
+	if !reflect.DeepEqual(Properties().Peers, []string{"a:1", "b:2", "c:3"}) {
+		t.Errorf("unexpected peers %v", Properties().Peers)
+	}
+	pairs := Get("timeout")
+	if len(pairs) != 1 || pairs[0][1] != "300" {
+		t.Errorf("unexpected result %v", pairs)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	current.Store(makeDefaultProperties())
+	defer current.Store(makeDefaultProperties())
+	cases := map[string]string{
+		"30":     "30",
+		"2m":     "120",
+		"500ms":  "500ms",
+		"1500ms": "1500ms",
+		"90s":    "90",
+		"1500us": "1500us",
+	}
+	for value, expected := range cases {
+		if err := Set("timeout", value); err != nil {
+			t.Fatal(err)
+		}
+		pairs := Get("timeout")
+		if pairs[0][1] != expected {
+			t.Errorf("expected %s for %s, actually %s", expected, value, pairs[0][1])
+		}
+		// formatted value is parsed back into the same duration
+		before := Properties().Timeout
+		if err := Set("timeout", pairs[0][1]); err != nil || Properties().Timeout != before {
+			t.Errorf("%s is not parsed back into %s", pairs[0][1], before)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	content := "port 6380\nno-such-key 1\n\nappendonly maybe\nmaxmemory 1x\n"
+	err := os.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(makeDefaultProperties())
+	defer func() {
+		current.Store(makeDefaultProperties())
+		ConfigFilename = ""
+	}()
+	err = SetupConfig(filename)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, expected := range []string{filename + ":2:", filename + ":4:", filename + ":5:"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error at %s, actually %s", expected, err.Error())
+		}
+	}
+	if Properties().Port != 6380 {
+		t.Errorf("valid lines should be loaded, actually port %d", Properties().Port)
+	}
+}
+
+func TestFloatField(t *testing.T) {
+	holder := &struct {
+		Ratio float64 `cfg:"ratio"`
+	}{}
+	v := reflect.ValueOf(holder).Elem()
+	err := applyConfig(v, "ratio", []string{"0.75"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder.Ratio != 0.75 {
+		t.Errorf("expected 0.75, actually %f", holder.Ratio)
+	}
+	if formatField(v.Type().Field(0), v.Field(0)) != "0.75" {
+		t.Errorf("unexpected format %s", formatField(v.Type().Field(0), v.Field(0)))
+	}
+	if applyConfig(v, "ratio", []string{"abc"}) == nil {
+		t.Error("expected error for invalid float")
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	filename, overrides, err := ParseArgs([]string{"--port", "7000", "--config", "a.conf", "--appendonly", "yes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != "a.conf" {
+		t.Errorf("unexpected config file %s", filename)
+	}
+	expected := [][]string{{"port", "7000"}, {"appendonly", "yes"}}
+	if !reflect.DeepEqual(overrides, expected) {
+		t.Errorf("expected %v, actually %v", expected, overrides)
+	}
+	filename, _, err = ParseArgs([]string{"b.conf", "--port", "7001"})
+	if err != nil || filename != "b.conf" {
+		t.Errorf("unexpected result %s %v", filename, err)
+	}
+	if _, _, err = ParseArgs([]string{"a.conf", "port", "1"}); err == nil {
+		t.Error("expected error for argument without --")
+	}
+
+	current.Store(makeDefaultProperties())
+	defer func() {
+		current.Store(makeDefaultProperties())
+	}()
+	err = ApplyOverrides(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Properties().Port != 7000 || !Properties().AppendOnly {
+		t.Errorf("overrides not applied: %+v", Properties())
+	}
+	if ApplyOverrides([][]string{{"no-such-key", "1"}}) == nil {
+		t.Error("expected error for unknown key")
+	}
+}
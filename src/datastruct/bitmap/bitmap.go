@@ -0,0 +1,111 @@
+package bitmap
+
+import "math/bits"
+
+// BitMap is a string viewed as bits in redis order, offset 0 is the most significant bit of the first byte
+type BitMap []byte
+
+// FromBytes returns a bitmap holding a copy of bytes, so it could be modified without touching bytes
+func FromBytes(bytes []byte) *BitMap {
+	bm := make(BitMap, len(bytes))
+	copy(bm, bytes)
+	return &bm
+}
+
+func (b *BitMap) ToBytes() []byte {
+	return *b
+}
+
+// BitSize returns count of bits including zero padding of the last byte
+func (b *BitMap) BitSize() int64 {
+	return int64(len(*b)) * 8
+}
+
+// grow pads zero bytes until offset is inside bitmap
+func (b *BitMap) grow(offset int64) {
+	byteSize := offset/8 + 1
+	if byteSize <= int64(len(*b)) {
+		return
+	}
+	gap := make([]byte, byteSize-int64(len(*b)))
+	*b = append(*b, gap...)
+}
+
+// GetBit returns 0 for offset beyond bitmap
+func (b *BitMap) GetBit(offset int64) byte {
+	index := offset / 8
+	if index >= int64(len(*b)) {
+		return 0
+	}
+	return ((*b)[index] >> (7 - offset%8)) & 1
+}
+
+// SetBit grows bitmap if necessary and returns the old bit
+func (b *BitMap) SetBit(offset int64, val byte) byte {
+	b.grow(offset)
+	index := offset / 8
+	shift := 7 - offset%8
+	old := ((*b)[index] >> shift) & 1
+	if val == 0 {
+		(*b)[index] &^= 1 << shift
+	} else {
+		(*b)[index] |= 1 << shift
+	}
+	return old
+}
+
+// Count returns count of set bits within [start, end], the range should be inside bitmap
+func (b *BitMap) Count(start, end int64) int64 {
+	var count int64
+	for start <= end && start%8 != 0 {
+		count += int64(b.GetBit(start))
+		start++
+	}
+	for start+7 <= end {
+		count += int64(bits.OnesCount8((*b)[start/8]))
+		start += 8
+	}
+	for start <= end {
+		count += int64(b.GetBit(start))
+		start++
+	}
+	return count
+}
+
+// Pos returns offset of the first bit equal to val within [start, end], or -1 if not found
+func (b *BitMap) Pos(val byte, start, end int64) int64 {
+	// bytes having no bit equal to val are skipped
+	var skip byte
+	if val == 0 {
+		skip = 0xff
+	}
+	for start <= end {
+		if start%8 == 0 && start+7 <= end && (*b)[start/8] == skip {
+			start += 8
+			continue
+		}
+		if b.GetBit(start) == val {
+			return start
+		}
+		start++
+	}
+	return -1
+}
+
+// GetField reads width bits at offset as unsigned integer, bits beyond bitmap are zero
+func (b *BitMap) GetField(offset int64, width uint) uint64 {
+	var value uint64
+	for i := int64(0); i < int64(width); i++ {
+		value = value<<1 | uint64(b.GetBit(offset+i))
+	}
+	return value
+}
+
+// SetField writes the lowest width bits of value at offset, bitmap grows if necessary
+func (b *BitMap) SetField(offset int64, width uint, value uint64) {
+	b.grow(offset + int64(width) - 1)
+	for i := int64(0); i < int64(width); i++ {
+		bit := byte(value>>(int64(width)-1-i)) & 1
+		b.SetBit(offset+i, bit)
+	}
+}
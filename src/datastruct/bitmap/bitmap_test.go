@@ -0,0 +1,94 @@
+package bitmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSetBit(t *testing.T) {
+	bm := FromBytes(nil)
+	offsets := []int64{0, 7, 8, 100, 1023}
+	for _, offset := range offsets {
+		if old := bm.SetBit(offset, 1); old != 0 {
+			t.Errorf("expected old bit 0 at %d, actually %d", offset, old)
+		}
+	}
+	if len(bm.ToBytes()) != 128 {
+		t.Errorf("expected 128 bytes, actually %d", len(bm.ToBytes()))
+	}
+	// redis order: offset 0 is the highest bit
+	if bm.ToBytes()[0] != 0x81 || bm.ToBytes()[1] != 0x80 {
+		t.Errorf("unexpected bytes %x", bm.ToBytes()[:2])
+	}
+	for _, offset := range offsets {
+		if bm.GetBit(offset) != 1 {
+			t.Errorf("expected bit 1 at %d", offset)
+		}
+	}
+	if bm.GetBit(1) != 0 || bm.GetBit(1<<20) != 0 {
+		t.Error("expected bit 0")
+	}
+	if old := bm.SetBit(100, 0); old != 1 || bm.GetBit(100) != 0 {
+		t.Error("expected bit 100 cleared")
+	}
+
+	// bitmap doesn't share memory with source
+	src := []byte{0}
+	FromBytes(src).SetBit(0, 1)
+	if src[0] != 0 {
+		t.Error("expected source bytes unchanged")
+	}
+}
+
+func TestCountAndPos(t *testing.T) {
+	bm := FromBytes(make([]byte, 64))
+	set := make(map[int64]bool)
+	for i := 0; i < 100; i++ {
+		offset := rand.Int63n(bm.BitSize())
+		bm.SetBit(offset, 1)
+		set[offset] = true
+	}
+	for i := 0; i < 100; i++ {
+		start := rand.Int63n(bm.BitSize())
+		end := start + rand.Int63n(bm.BitSize()-start)
+		var count int64
+		first, firstZero := int64(-1), int64(-1)
+		for offset := start; offset <= end; offset++ {
+			if set[offset] {
+				count++
+				if first < 0 {
+					first = offset
+				}
+			} else if firstZero < 0 {
+				firstZero = offset
+			}
+		}
+		if actual := bm.Count(start, end); actual != count {
+			t.Errorf("expected count %d in [%d, %d], actually %d", count, start, end, actual)
+		}
+		if actual := bm.Pos(1, start, end); actual != first {
+			t.Errorf("expected pos %d in [%d, %d], actually %d", first, start, end, actual)
+		}
+		if actual := bm.Pos(0, start, end); actual != firstZero {
+			t.Errorf("expected pos of 0 %d in [%d, %d], actually %d", firstZero, start, end, actual)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	bm := FromBytes(nil)
+	bm.SetField(3, 12, 0xabc)
+	if actual := bm.GetField(3, 12); actual != 0xabc {
+		t.Errorf("expected 0xabc, actually %x", actual)
+	}
+	if len(bm.ToBytes()) != 2 {
+		t.Errorf("expected 2 bytes, actually %d", len(bm.ToBytes()))
+	}
+	bm.SetField(0, 64, 1<<63|1)
+	if actual := bm.GetField(0, 64); actual != 1<<63|1 {
+		t.Errorf("unexpected field %x", actual)
+	}
+	if actual := bm.GetField(1000, 8); actual != 0 {
+		t.Errorf("expected 0 beyond bitmap, actually %d", actual)
+	}
+}
@@ -10,11 +10,17 @@ import (
 type ConcurrentDict struct {
 	table []*Shard
 	count int32
+
+	// current snapshot, protected by locks of all shards
+	snapshot     *Snapshot
+	snapshotting int32
 }
 
 type Shard struct {
 	m     map[string]interface{}
 	mutex sync.RWMutex
+	// old values of keys modified after snapshot taken, nil if no snapshot
+	frozen map[string]*frozenEntry
 }
 
 func computeCapacity(param int) (size int) {
@@ -105,6 +111,7 @@ func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
 	shard := dict.getShard(index)
 	shard.mutex.Lock()
 	defer shard.mutex.Unlock()
+	dict.preserve(shard, key)
 
 	if _, ok := shard.m[key]; ok {
 		shard.m[key] = val
@@ -126,6 +133,7 @@ func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int
 	shard := dict.getShard(index)
 	shard.mutex.Lock()
 	defer shard.mutex.Unlock()
+	dict.preserve(shard, key)
 
 	if _, ok := shard.m[key]; ok {
 		return 0
@@ -146,6 +154,7 @@ func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int
 	shard := dict.getShard(index)
 	shard.mutex.Lock()
 	defer shard.mutex.Unlock()
+	dict.preserve(shard, key)
 
 	if _, ok := shard.m[key]; ok {
 		shard.m[key] = val
@@ -165,9 +174,11 @@ func (dict *ConcurrentDict) Remove(key string) (result int) {
 	shard := dict.getShard(index)
 	shard.mutex.Lock()
 	defer shard.mutex.Unlock()
+	dict.preserve(shard, key)
 
 	if _, ok := shard.m[key]; ok {
 		delete(shard.m, key)
+		dict.decreaseCount()
 		return 1
 	} else {
 		return 0
@@ -178,6 +189,10 @@ func (dict *ConcurrentDict) addCount() int32 {
 	return atomic.AddInt32(&dict.count, 1)
 }
 
+func (dict *ConcurrentDict) decreaseCount() int32 {
+	return atomic.AddInt32(&dict.count, -1)
+}
+
 /*
  * may not contains new entry inserted during traversal
  */
@@ -213,7 +228,8 @@ func (dict *ConcurrentDict) Keys() []string {
 	return keys
 }
 
-func (shard *Shard) RandomKey() string {
+// RandomKey returns a key of shard, ok is false if shard is empty
+func (shard *Shard) RandomKey() (string, bool) {
 	if shard == nil {
 		panic("shard is nil")
 	}
@@ -221,15 +237,15 @@ func (shard *Shard) RandomKey() string {
 	defer shard.mutex.RUnlock()
 
 	for key := range shard.m {
-		return key
+		return key, true
 	}
-	return ""
+	return "", false
 }
 
+// RandomKeys returns limit keys which may repeat
 func (dict *ConcurrentDict) RandomKeys(limit int) []string {
-	size := dict.Len()
-	if limit >= size {
-		return dict.Keys()
+	if dict.Len() == 0 {
+		return nil
 	}
 	shardCount := len(dict.table)
 
@@ -239,8 +255,8 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 		if shard == nil {
 			continue
 		}
-		key := shard.RandomKey()
-		if key != "" {
+		key, ok := shard.RandomKey()
+		if ok {
 			result[i] = key
 			i++
 		}
@@ -262,8 +278,8 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 		if shard == nil {
 			continue
 		}
-		key := shard.RandomKey()
-		if key != "" {
+		key, ok := shard.RandomKey()
+		if ok {
 			result[key] = true
 		}
 	}
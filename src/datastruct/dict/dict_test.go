@@ -242,3 +242,21 @@ func TestForEach(t *testing.T) {
 		t.Error("remove test failed: expected " + strconv.Itoa(size) + ", actual: " + strconv.Itoa(i))
 	}
 }
+
+func TestRandomKeysEmptyString(t *testing.T) {
+	d := MakeConcurrent(0)
+	d.Put("", 1)
+	keys := d.RandomKeys(3)
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, actual %d", len(keys))
+	}
+	for _, key := range keys {
+		if key != "" {
+			t.Errorf("expected empty key, actual %s", key)
+		}
+	}
+	keys = d.RandomDistinctKeys(1)
+	if len(keys) != 1 || keys[0] != "" {
+		t.Errorf("expected [\"\"], actual %v", keys)
+	}
+}
@@ -79,7 +79,11 @@ func (dict *SimpleDict) ForEach(consumer Consumer) {
 	}
 }
 
+// RandomKeys returns limit keys which may repeat
 func (dict *SimpleDict) RandomKeys(limit int) []string {
+	if len(dict.m) == 0 {
+		return nil
+	}
 	result := make([]string, limit)
 	for i := 0; i < limit; i++ {
 		for k := range dict.m {
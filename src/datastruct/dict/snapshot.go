@@ -0,0 +1,164 @@
+package dict
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+/*
+ * Snapshot is a copy-on-write view of ConcurrentDict at the moment it was taken.
+ * Before a key is modified for the first time after the snapshot is taken, its value is copied by Copier
+ * and kept in the shard, so the snapshot still sees the old value while the dict goes on serving writes.
+ * Only one snapshot could exist at a time.
+ */
+type Snapshot struct {
+	dict   *ConcurrentDict
+	copier Copier
+}
+
+// Copier makes an immutable copy of value, it is called while the shard of key is locked
+type Copier func(key string, val interface{}) interface{}
+
+// frozenEntry keeps value of key at the moment snapshot taken
+type frozenEntry struct {
+	val     interface{}
+	exists  bool
+	visited bool
+}
+
+// Snapshot takes a copy-on-write snapshot of dict, Release must be called after using it
+func (dict *ConcurrentDict) Snapshot(copier Copier) (*Snapshot, error) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	// lock all shards to make sure every shard is frozen at the same moment
+	for _, shard := range dict.table {
+		shard.mutex.Lock()
+	}
+	defer func() {
+		for _, shard := range dict.table {
+			shard.mutex.Unlock()
+		}
+	}()
+	if dict.snapshot != nil {
+		return nil, errors.New("another snapshot exists")
+	}
+	snapshot := &Snapshot{
+		dict:   dict,
+		copier: copier,
+	}
+	for _, shard := range dict.table {
+		shard.frozen = make(map[string]*frozenEntry)
+	}
+	dict.snapshot = snapshot
+	atomic.StoreInt32(&dict.snapshotting, 1)
+	return snapshot, nil
+}
+
+// preserve copies value of key into snapshot before it is modified, caller must hold lock of shard
+func (dict *ConcurrentDict) preserve(shard *Shard, key string) {
+	if shard.frozen == nil {
+		return
+	}
+	if _, ok := shard.frozen[key]; ok {
+		return
+	}
+	val, exists := shard.m[key]
+	entry := &frozenEntry{exists: exists}
+	if exists {
+		entry.val = dict.snapshot.copier(key, val)
+	}
+	shard.frozen[key] = entry
+}
+
+// Preserve must be called before value of keys are modified in place while snapshot exists
+func (dict *ConcurrentDict) Preserve(keys ...string) {
+	if atomic.LoadInt32(&dict.snapshotting) == 0 {
+		return
+	}
+	for _, key := range keys {
+		shard := dict.getShard(dict.spread(fnv32(key)))
+		shard.mutex.Lock()
+		dict.preserve(shard, key)
+		shard.mutex.Unlock()
+	}
+}
+
+/*
+ * ForEachKey traverses keys existed at the moment snapshot taken, consumer should read value by Visit.
+ * Shards traversed are released, modifications on them no longer need to be copied.
+ */
+func (s *Snapshot) ForEachKey(consumer func(key string) bool) {
+	for _, shard := range s.dict.table {
+		shard.mutex.RLock()
+		if shard.frozen == nil {
+			// released
+			shard.mutex.RUnlock()
+			continue
+		}
+		keys := make([]string, 0, len(shard.m))
+		for key := range shard.m {
+			if entry, ok := shard.frozen[key]; ok && !entry.exists {
+				// created after snapshot taken
+				continue
+			}
+			keys = append(keys, key)
+		}
+		for key, entry := range shard.frozen {
+			if _, ok := shard.m[key]; !ok && entry.exists {
+				// removed after snapshot taken
+				keys = append(keys, key)
+			}
+		}
+		shard.mutex.RUnlock()
+
+		for _, key := range keys {
+			if !consumer(key) {
+				return
+			}
+		}
+
+		shard.mutex.Lock()
+		shard.frozen = nil
+		shard.mutex.Unlock()
+	}
+}
+
+// Visit returns copy of value at the moment snapshot taken, each key should be visited once only
+func (s *Snapshot) Visit(key string) (val interface{}, exists bool) {
+	shard := s.dict.getShard(s.dict.spread(fnv32(key)))
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.frozen == nil {
+		return nil, false
+	}
+	entry, ok := shard.frozen[key]
+	if ok {
+		if entry.visited {
+			return nil, false
+		}
+		val, exists = entry.val, entry.exists
+	} else {
+		val, exists = shard.m[key]
+		if exists {
+			val = s.copier(key, val)
+		}
+	}
+	// later modifications of visited key need not to be copied
+	shard.frozen[key] = &frozenEntry{visited: true}
+	return val, exists
+}
+
+// Release stops copy-on-write
+func (s *Snapshot) Release() {
+	dict := s.dict
+	for _, shard := range dict.table {
+		shard.mutex.Lock()
+	}
+	for _, shard := range dict.table {
+		shard.frozen = nil
+		shard.mutex.Unlock()
+	}
+	dict.snapshot = nil
+	atomic.StoreInt32(&dict.snapshotting, 0)
+}
@@ -0,0 +1,63 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	d := MakeConcurrent(0)
+	for i := 0; i < 100; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	copies := 0
+	snapshot, err := d.Snapshot(func(key string, val interface{}) interface{} {
+		copies++
+		return val
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Snapshot(nil); err == nil {
+		t.Error("expected error while another snapshot exists")
+	}
+
+	// modifications after snapshot taken
+	d.Put("k0", -1)
+	d.Remove("k1")
+	d.Put("new", 1)
+	d.PutIfExists("k2", -1)
+
+	seen := make(map[string]int)
+	snapshot.ForEachKey(func(key string) bool {
+		val, exists := snapshot.Visit(key)
+		if !exists {
+			t.Errorf("key %s should exist", key)
+			return true
+		}
+		seen[key] = val.(int)
+		// modifications after key visited are not copied
+		d.Put(key, -2)
+		return true
+	})
+	snapshot.Release()
+
+	if len(seen) != 100 {
+		t.Errorf("expected 100 keys, actually %d", len(seen))
+	}
+	for i := 0; i < 100; i++ {
+		key := "k" + strconv.Itoa(i)
+		if seen[key] != i {
+			t.Errorf("expected %s=%d, actually %d", key, i, seen[key])
+		}
+	}
+	if _, ok := seen["new"]; ok {
+		t.Error("key created after snapshot should not be seen")
+	}
+	if copies != 100 {
+		t.Errorf("expected 100 copies, actually %d", copies)
+	}
+	if d.snapshot != nil || d.snapshotting != 0 {
+		t.Error("snapshot should be released")
+	}
+}
@@ -0,0 +1,429 @@
+package hyperloglog
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+/*
+ * HyperLogLog in the string encoding of redis, so values could be exchanged with redis by GET/SET/DUMP.
+ *
+ * header: "HYLL" | encoding (1 byte) | unused (3 bytes) | cached cardinality (8 bytes, little endian)
+ * the highest bit of cached cardinality is set when the cache is invalid.
+ *
+ * dense encoding: 16384 registers of 6 bits, the least significant bits come first.
+ * sparse encoding: opcodes of register runs
+ *   ZERO  00xxxxxx          xxxxxx+1 registers are 0
+ *   XZERO 01xxxxxx yyyyyyyy xxxxxxyyyyyyyy+1 registers are 0
+ *   VAL   1vvvvvxx          xx+1 registers are vvvvv+1
+ */
+
+const (
+	precision     = 14
+	registerCount = 1 << precision
+	registerMask  = registerCount - 1
+	registerBits  = 6
+	registerMax   = 1<<registerBits - 1
+	// bits of hash left for counting pattern length
+	hashBits = 64 - precision
+
+	headerSize = 16
+	denseSize  = headerSize + (registerCount*registerBits+7)/8
+
+	encodingDense  = 0
+	encodingSparse = 1
+
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+	sparseValMaxValue = 32
+	sparseValMaxLen   = 4
+	// sparse encoding is converted to dense if it grows beyond this size, same as hll-sparse-max-bytes of redis
+	sparseMaxBytes = 3000
+
+	hashSeed = 0xadc83b19
+	// 0.5/ln(2)
+	alphaInf = 0.721347520444481703680
+)
+
+var magic = []byte("HYLL")
+
+var (
+	// ErrInvalid is returned for strings not in hyperloglog encoding
+	ErrInvalid = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	// ErrCorrupted is returned for sparse encoding not describing all registers
+	ErrCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+type HyperLogLog struct {
+	bytes []byte
+}
+
+// Make returns an empty hyperloglog in sparse encoding
+func Make() *HyperLogLog {
+	bytes := makeHeader(encodingSparse)
+	// a single XZERO covers all registers
+	bytes = append(bytes, 0x40|byte((registerCount-1)>>8), byte((registerCount-1)&0xff))
+	return &HyperLogLog{bytes: bytes}
+}
+
+// FromBytes checks header of bytes, the hyperloglog shares memory with bytes and Add, Merge modify it
+func FromBytes(bytes []byte) (*HyperLogLog, error) {
+	if len(bytes) < headerSize || string(bytes[:4]) != string(magic) {
+		return nil, ErrInvalid
+	}
+	switch bytes[4] {
+	case encodingDense:
+		if len(bytes) != denseSize {
+			return nil, ErrInvalid
+		}
+	case encodingSparse:
+	default:
+		return nil, ErrInvalid
+	}
+	return &HyperLogLog{bytes: bytes}, nil
+}
+
+func (h *HyperLogLog) ToBytes() []byte {
+	return h.bytes
+}
+
+func (h *HyperLogLog) IsDense() bool {
+	return h.bytes[4] == encodingDense
+}
+
+func makeHeader(encoding byte) []byte {
+	header := make([]byte, headerSize, denseSize)
+	copy(header, magic)
+	header[4] = encoding
+	return header
+}
+
+func (h *HyperLogLog) invalidateCache() {
+	h.bytes[headerSize-1] |= 1 << 7
+}
+
+/* ---- hash ---- */
+
+// murmurHash64A is the 64 bit murmur hash used by redis
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(key)) * m)
+	tail := len(key) - len(key)%8
+	for i := 0; i < tail; i += 8 {
+		k := binary.LittleEndian.Uint64(key[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if rest := key[tail:]; len(rest) > 0 {
+		for i := len(rest) - 1; i >= 0; i-- {
+			h ^= uint64(rest[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// patternLen returns register index of element and length of its 000..1 pattern
+func patternLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, hashSeed)
+	index := int(hash & registerMask)
+	hash >>= precision
+	// make sure the loop terminates
+	hash |= 1 << hashBits
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+/* ---- dense encoding ---- */
+
+func denseGet(registers []byte, index int) uint8 {
+	pos := index * registerBits
+	b := pos / 8
+	shift := uint(pos % 8)
+	value := uint(registers[b]) >> shift
+	if b+1 < len(registers) {
+		value |= uint(registers[b+1]) << (8 - shift)
+	}
+	return uint8(value & registerMax)
+}
+
+func denseSet(registers []byte, index int, value uint8) {
+	pos := index * registerBits
+	b := pos / 8
+	shift := uint(pos % 8)
+	registers[b] &^= byte(registerMax << shift)
+	registers[b] |= value << shift
+	if b+1 < len(registers) {
+		registers[b+1] &^= byte(registerMax >> (8 - shift))
+		registers[b+1] |= value >> (8 - shift)
+	}
+}
+
+func encodeDense(values []uint8) []byte {
+	bytes := makeHeader(encodingDense)
+	bytes = bytes[:denseSize]
+	registers := bytes[headerSize:]
+	for i, value := range values {
+		if value != 0 {
+			denseSet(registers, i, value)
+		}
+	}
+	return bytes
+}
+
+/* ---- sparse encoding ---- */
+
+func decodeSparse(opcodes []byte, values []uint8) error {
+	index := 0
+	for i := 0; i < len(opcodes); i++ {
+		op := opcodes[i]
+		var runLen int
+		var value uint8
+		switch {
+		case op&0xc0 == 0: // ZERO
+			runLen = int(op&0x3f) + 1
+		case op&0xc0 == 0x40: // XZERO
+			if i+1 >= len(opcodes) {
+				return ErrCorrupted
+			}
+			runLen = (int(op&0x3f)<<8 | int(opcodes[i+1])) + 1
+			i++
+		default: // VAL
+			runLen = int(op&0x3) + 1
+			value = (op>>2)&0x1f + 1
+		}
+		if index+runLen > registerCount {
+			return ErrCorrupted
+		}
+		for j := index; j < index+runLen; j++ {
+			values[j] = value
+		}
+		index += runLen
+	}
+	if index != registerCount {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// encodeSparse returns nil if some register is too big for sparse encoding
+func encodeSparse(values []uint8) []byte {
+	bytes := makeHeader(encodingSparse)
+	for i := 0; i < len(values); {
+		value := values[i]
+		if value > sparseValMaxValue {
+			return nil
+		}
+		runLen := 1
+		for i+runLen < len(values) && values[i+runLen] == value {
+			runLen++
+		}
+		i += runLen
+		if value == 0 {
+			for runLen > 0 {
+				if runLen <= sparseZeroMaxLen {
+					bytes = append(bytes, byte(runLen-1))
+					break
+				}
+				n := runLen
+				if n > sparseXZeroMaxLen {
+					n = sparseXZeroMaxLen
+				}
+				bytes = append(bytes, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+				runLen -= n
+			}
+			continue
+		}
+		for runLen > 0 {
+			n := runLen
+			if n > sparseValMaxLen {
+				n = sparseValMaxLen
+			}
+			bytes = append(bytes, 0x80|(value-1)<<2|byte(n-1))
+			runLen -= n
+		}
+	}
+	return bytes
+}
+
+/* ---- operations ---- */
+
+// registers returns values of all registers
+func (h *HyperLogLog) registers() ([]uint8, error) {
+	values := make([]uint8, registerCount)
+	if h.IsDense() {
+		registers := h.bytes[headerSize:]
+		for i := range values {
+			values[i] = denseGet(registers, i)
+		}
+		return values, nil
+	}
+	if err := decodeSparse(h.bytes[headerSize:], values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// store encodes values in sparse encoding if possible unless dense is required
+func (h *HyperLogLog) store(values []uint8, dense bool) {
+	var bytes []byte
+	if !dense {
+		bytes = encodeSparse(values)
+	}
+	if bytes == nil || len(bytes) > sparseMaxBytes {
+		bytes = encodeDense(values)
+	}
+	h.bytes = bytes
+	h.invalidateCache()
+}
+
+// Add returns true if some register is changed
+func (h *HyperLogLog) Add(elements ...[]byte) (bool, error) {
+	if h.IsDense() {
+		// dense registers are updated in place
+		registers := h.bytes[headerSize:]
+		changed := false
+		for _, element := range elements {
+			index, count := patternLen(element)
+			if denseGet(registers, index) < count {
+				denseSet(registers, index, count)
+				changed = true
+			}
+		}
+		if changed {
+			h.invalidateCache()
+		}
+		return changed, nil
+	}
+
+	values, err := h.registers()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, element := range elements {
+		index, count := patternLen(element)
+		if values[index] < count {
+			values[index] = count
+			changed = true
+		}
+	}
+	if changed {
+		h.store(values, false)
+	}
+	return changed, nil
+}
+
+// Merge sets registers to the max of h and others, result is dense if any of them is dense
+func (h *HyperLogLog) Merge(others ...*HyperLogLog) error {
+	values, err := h.registers()
+	if err != nil {
+		return err
+	}
+	dense := h.IsDense()
+	for _, other := range others {
+		otherValues, err := other.registers()
+		if err != nil {
+			return err
+		}
+		for i, value := range otherValues {
+			if value > values[i] {
+				values[i] = value
+			}
+		}
+		dense = dense || other.IsDense()
+	}
+	h.store(values, dense)
+	return nil
+}
+
+// Count returns the cached cardinality if it is valid, or estimates it from registers
+func (h *HyperLogLog) Count() (uint64, error) {
+	card := binary.LittleEndian.Uint64(h.bytes[8:headerSize])
+	if card&(1<<63) == 0 {
+		return card, nil
+	}
+	values, err := h.registers()
+	if err != nil {
+		return 0, err
+	}
+	return estimate(values), nil
+}
+
+// CountUnion estimates cardinality of union of hlls
+func CountUnion(hlls ...*HyperLogLog) (uint64, error) {
+	union := make([]uint8, registerCount)
+	for _, h := range hlls {
+		values, err := h.registers()
+		if err != nil {
+			return 0, err
+		}
+		for i, value := range values {
+			if value > union[i] {
+				union[i] = value
+			}
+		}
+	}
+	return estimate(union), nil
+}
+
+// estimate is the improved estimator of Otmar Ertl, same as redis
+func estimate(values []uint8) uint64 {
+	var histogram [hashBits + 2]int
+	for _, value := range values {
+		histogram[value]++
+	}
+	m := float64(registerCount)
+	z := m * tau((m-float64(histogram[hashBits+1]))/m)
+	for j := hashBits; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histogram[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
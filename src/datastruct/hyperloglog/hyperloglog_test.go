@@ -0,0 +1,138 @@
+package hyperloglog
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// standard error of hyperloglog with 16384 registers is 1.04/sqrt(16384) = 0.81%
+const maxError = 4 * 0.0081
+
+func assertEstimate(t *testing.T, actual uint64, expected int) {
+	t.Helper()
+	if math.Abs(float64(actual)-float64(expected)) > float64(expected)*maxError+1 {
+		t.Errorf("expected about %d, actually %d", expected, actual)
+	}
+}
+
+func TestErrorBound(t *testing.T) {
+	h := Make()
+	added := 0
+	for _, n := range []int{10, 100, 1000, 10000, 100000, 1000000} {
+		for ; added < n; added++ {
+			if _, err := h.Add([]byte("element:" + strconv.Itoa(added))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		count, err := h.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEstimate(t, count, n)
+	}
+	if !h.IsDense() {
+		t.Error("expected dense encoding")
+	}
+}
+
+func TestSparse(t *testing.T) {
+	h := Make()
+	if string(h.ToBytes()) != "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff" {
+		t.Errorf("unexpected empty hyperloglog %q", h.ToBytes())
+	}
+	if count, _ := h.Count(); count != 0 {
+		t.Errorf("expected 0, actually %d", count)
+	}
+
+	changed, _ := h.Add([]byte("a"), []byte("b"), []byte("c"))
+	if !changed {
+		t.Error("expected changed")
+	}
+	if changed, _ = h.Add([]byte("a")); changed {
+		t.Error("expected unchanged")
+	}
+	if h.IsDense() {
+		t.Error("expected sparse encoding")
+	}
+	count, _ := h.Count()
+	if count != 3 {
+		t.Errorf("expected 3, actually %d", count)
+	}
+
+	// sparse and dense encodings of the same registers are equivalent
+	values, err := h.registers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dense, err := FromBytes(encodeDense(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	denseValues, _ := dense.registers()
+	for i := range values {
+		if values[i] != denseValues[i] {
+			t.Fatalf("register %d: sparse %d, dense %d", i, values[i], denseValues[i])
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	h1 := Make()
+	h2 := Make()
+	for i := 0; i < 20000; i++ {
+		h1.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 10000; i < 30000; i++ {
+		h2.Add([]byte(strconv.Itoa(i)))
+	}
+	count, err := CountUnion(h1, h2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEstimate(t, count, 30000)
+
+	merged := Make()
+	if err := merged.Merge(h1, h2); err != nil {
+		t.Fatal(err)
+	}
+	if !merged.IsDense() {
+		t.Error("expected dense encoding merged from dense")
+	}
+	mergedCount, _ := merged.Count()
+	if mergedCount != count {
+		t.Errorf("expected %d, actually %d", count, mergedCount)
+	}
+
+	// small sets are merged into sparse encoding
+	s1 := Make()
+	s1.Add([]byte("a"))
+	s2 := Make()
+	s2.Add([]byte("b"))
+	s1.Merge(s2)
+	if s1.IsDense() {
+		t.Error("expected sparse encoding")
+	}
+	if count, _ := s1.Count(); count != 2 {
+		t.Errorf("expected 2, actually %d", count)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, bytes := range []string{"", "hello world, this is not hll", "HYLL\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", "HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"} {
+		if _, err := FromBytes([]byte(bytes)); err != ErrInvalid {
+			t.Errorf("expected invalid for %q", bytes)
+		}
+	}
+	// registers of sparse encoding don't add up
+	h, err := FromBytes([]byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Count(); err != ErrCorrupted {
+		t.Error("expected corrupted")
+	}
+	if _, err := h.Add([]byte("a")); err != ErrCorrupted {
+		t.Error("expected corrupted")
+	}
+}
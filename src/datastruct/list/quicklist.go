@@ -0,0 +1,505 @@
+package list
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+/*
+ * QuickList is a list of byte slices stored in chunks of packed entries:
+ *   uvarint length | entry bytes | uvarint length | entry bytes ...
+ * an entry costs a few bytes of overhead instead of a node with pointers and an interface,
+ * and looking up an index skips whole chunks by their counts.
+ */
+
+const (
+	// chunk is split after it grows beyond chunkMaxBytes
+	chunkMaxBytes = 8 * 1024
+	// adjacent chunks are merged if they fit in chunkMergeBytes together
+	chunkMergeBytes = chunkMaxBytes / 2
+)
+
+type chunk struct {
+	data  []byte
+	count int
+}
+
+type QuickList struct {
+	chunks []*chunk
+	size   int
+}
+
+func appendEntry(buf []byte, val []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(val)))
+	return append(buf, val...)
+}
+
+func entrySize(val []byte) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(len(val))) + len(val)
+}
+
+// entryAt decodes the entry starting at offset, returned slice shares memory with chunk
+func (c *chunk) entryAt(offset int) (val []byte, next int) {
+	n, w := binary.Uvarint(c.data[offset:])
+	start := offset + w
+	end := start + int(n)
+	return c.data[start:end:end], end
+}
+
+// offset returns the position of index-th entry, or len(data) if index == count
+func (c *chunk) offset(index int) int {
+	offset := 0
+	for i := 0; i < index; i++ {
+		_, offset = c.entryAt(offset)
+	}
+	return offset
+}
+
+// offsets returns positions of all entries and the end of data
+func (c *chunk) offsets() []int {
+	offsets := make([]int, c.count+1)
+	for i := 0; i < c.count; i++ {
+		_, offsets[i+1] = c.entryAt(offsets[i])
+	}
+	return offsets
+}
+
+func (c *chunk) insert(index int, val []byte) {
+	offset := c.offset(index)
+	if offset == len(c.data) {
+		c.data = appendEntry(c.data, val)
+	} else {
+		tail := append([]byte(nil), c.data[offset:]...)
+		c.data = append(appendEntry(c.data[:offset], val), tail...)
+	}
+	c.count++
+}
+
+func (c *chunk) set(index int, val []byte) {
+	offset := c.offset(index)
+	_, end := c.entryAt(offset)
+	tail := append([]byte(nil), c.data[end:]...)
+	c.data = append(appendEntry(c.data[:offset], val), tail...)
+}
+
+func (c *chunk) remove(index int) []byte {
+	offset := c.offset(index)
+	val, end := c.entryAt(offset)
+	removed := make([]byte, len(val))
+	copy(removed, val)
+	c.data = append(c.data[:offset], c.data[end:]...)
+	c.count--
+	return removed
+}
+
+// trimFront removes the first n entries
+func (c *chunk) trimFront(n int) {
+	offset := c.offset(n)
+	c.data = append([]byte(nil), c.data[offset:]...)
+	c.count -= n
+}
+
+// trimBack keeps the first n entries
+func (c *chunk) trimBack(n int) {
+	offset := c.offset(n)
+	c.data = append([]byte(nil), c.data[:offset]...)
+	c.count = n
+}
+
+// MakeQuickList creates a QuickList with vals, vals are copied
+func MakeQuickList(vals ...[]byte) *QuickList {
+	list := &QuickList{}
+	for _, v := range vals {
+		list.Add(v)
+	}
+	return list
+}
+
+// locate returns the chunk holding index-th entry and the position of the entry in chunk
+func (list *QuickList) locate(index int) (int, int) {
+	if index < list.size/2 {
+		for i, c := range list.chunks {
+			if index < c.count {
+				return i, index
+			}
+			index -= c.count
+		}
+	} else {
+		index = list.size - 1 - index
+		for i := len(list.chunks) - 1; i >= 0; i-- {
+			c := list.chunks[i]
+			if index < c.count {
+				return i, c.count - 1 - index
+			}
+			index -= c.count
+		}
+	}
+	panic("index out of bound")
+}
+
+func (list *QuickList) insertChunk(i int, c *chunk) {
+	list.chunks = append(list.chunks, nil)
+	copy(list.chunks[i+1:], list.chunks[i:])
+	list.chunks[i] = c
+}
+
+func (list *QuickList) removeChunk(i int) {
+	copy(list.chunks[i:], list.chunks[i+1:])
+	list.chunks[len(list.chunks)-1] = nil
+	list.chunks = list.chunks[:len(list.chunks)-1]
+}
+
+// split splits i-th chunk into halves if it is too large
+func (list *QuickList) split(i int) {
+	c := list.chunks[i]
+	if len(c.data) <= chunkMaxBytes || c.count < 2 {
+		return
+	}
+	half := c.count / 2
+	offset := c.offset(half)
+	right := &chunk{
+		data:  append([]byte(nil), c.data[offset:]...),
+		count: c.count - half,
+	}
+	c.data = append([]byte(nil), c.data[:offset]...)
+	c.count = half
+	list.insertChunk(i+1, right)
+}
+
+// shrink removes i-th chunk if it is empty, or merges it with a neighbour if both are small
+func (list *QuickList) shrink(i int) {
+	c := list.chunks[i]
+	if c.count == 0 {
+		list.removeChunk(i)
+		return
+	}
+	if i+1 < len(list.chunks) {
+		next := list.chunks[i+1]
+		if len(c.data)+len(next.data) <= chunkMergeBytes {
+			c.data = append(c.data, next.data...)
+			c.count += next.count
+			list.removeChunk(i + 1)
+		}
+	}
+	if i > 0 {
+		prev := list.chunks[i-1]
+		if len(prev.data)+len(c.data) <= chunkMergeBytes {
+			prev.data = append(prev.data, c.data...)
+			prev.count += c.count
+			list.removeChunk(i)
+		}
+	}
+}
+
+// Add appends val to the tail of list, val is copied
+func (list *QuickList) Add(val []byte) {
+	if list == nil {
+		panic("list is nil")
+	}
+	n := len(list.chunks)
+	if n == 0 || len(list.chunks[n-1].data)+entrySize(val) > chunkMaxBytes {
+		list.chunks = append(list.chunks, &chunk{})
+		n++
+	}
+	c := list.chunks[n-1]
+	c.data = appendEntry(c.data, val)
+	c.count++
+	list.size++
+}
+
+// Get returns a copy of index-th entry
+func (list *QuickList) Get(index int) []byte {
+	if list == nil {
+		panic("list is nil")
+	}
+	if index < 0 || index >= list.size {
+		panic("index out of bound")
+	}
+	i, j := list.locate(index)
+	c := list.chunks[i]
+	val, _ := c.entryAt(c.offset(j))
+	return append([]byte(nil), val...)
+}
+
+func (list *QuickList) Set(index int, val []byte) {
+	if list == nil {
+		panic("list is nil")
+	}
+	if index < 0 || index >= list.size {
+		panic("index out of bound")
+	}
+	i, j := list.locate(index)
+	list.chunks[i].set(j, val)
+	list.split(i)
+}
+
+// Insert inserts val before index-th entry, index == Len() appends it
+func (list *QuickList) Insert(index int, val []byte) {
+	if list == nil {
+		panic("list is nil")
+	}
+	if index < 0 || index > list.size {
+		panic("index out of bound")
+	}
+	if index == list.size {
+		list.Add(val)
+		return
+	}
+	i, j := list.locate(index)
+	c := list.chunks[i]
+	if j == 0 && len(c.data)+entrySize(val) > chunkMaxBytes {
+		// pushing to the head of a full chunk starts a new one instead of splitting
+		list.insertChunk(i, &chunk{data: appendEntry(nil, val), count: 1})
+		list.size++
+		return
+	}
+	c.insert(j, val)
+	list.size++
+	list.split(i)
+}
+
+// Remove removes index-th entry and returns it
+func (list *QuickList) Remove(index int) []byte {
+	if list == nil {
+		panic("list is nil")
+	}
+	if index < 0 || index >= list.size {
+		panic("index out of bound")
+	}
+	i, j := list.locate(index)
+	val := list.chunks[i].remove(j)
+	list.size--
+	list.shrink(i)
+	return val
+}
+
+func (list *QuickList) RemoveLast() []byte {
+	if list == nil {
+		panic("list is nil")
+	}
+	if list.size == 0 {
+		return nil
+	}
+	return list.Remove(list.size - 1)
+}
+
+// removeByVal removes at most count entries equal to val, count <= 0 means removing all
+func (list *QuickList) removeByVal(val []byte, count int, reverse bool) int {
+	removed := 0
+	for k := 0; k < len(list.chunks); k++ {
+		i := k
+		if reverse {
+			i = len(list.chunks) - 1 - k
+		}
+		c := list.chunks[i]
+		offsets := c.offsets()
+		matched := make([]bool, c.count)
+		found := false
+		for m := 0; m < c.count; m++ {
+			if count > 0 && removed == count {
+				break
+			}
+			j := m
+			if reverse {
+				j = c.count - 1 - m
+			}
+			if entry, _ := c.entryAt(offsets[j]); bytes.Equal(entry, val) {
+				matched[j] = true
+				found = true
+				removed++
+			}
+		}
+		if found {
+			data := make([]byte, 0, len(c.data))
+			kept := 0
+			for j := 0; j < c.count; j++ {
+				if !matched[j] {
+					data = append(data, c.data[offsets[j]:offsets[j+1]]...)
+					kept++
+				}
+			}
+			c.data = data
+			c.count = kept
+		}
+		if count > 0 && removed == count {
+			break
+		}
+	}
+	list.size -= removed
+	list.compact()
+	return removed
+}
+
+// compact removes empty chunks and merges small neighbours
+func (list *QuickList) compact() {
+	chunks := list.chunks[:0]
+	for _, c := range list.chunks {
+		if c.count == 0 {
+			continue
+		}
+		if n := len(chunks); n > 0 && len(chunks[n-1].data)+len(c.data) <= chunkMergeBytes {
+			chunks[n-1].data = append(chunks[n-1].data, c.data...)
+			chunks[n-1].count += c.count
+			continue
+		}
+		chunks = append(chunks, c)
+	}
+	for i := len(chunks); i < len(list.chunks); i++ {
+		list.chunks[i] = nil
+	}
+	list.chunks = chunks
+}
+
+func (list *QuickList) RemoveAllByVal(val []byte) int {
+	if list == nil {
+		panic("list is nil")
+	}
+	return list.removeByVal(val, 0, false)
+}
+
+// RemoveByVal removes at most count entries equal to val from head to tail
+func (list *QuickList) RemoveByVal(val []byte, count int) int {
+	if list == nil {
+		panic("list is nil")
+	}
+	return list.removeByVal(val, count, false)
+}
+
+// ReverseRemoveByVal removes at most count entries equal to val from tail to head
+func (list *QuickList) ReverseRemoveByVal(val []byte, count int) int {
+	if list == nil {
+		panic("list is nil")
+	}
+	return list.removeByVal(val, count, true)
+}
+
+func (list *QuickList) Len() int {
+	if list == nil {
+		panic("list is nil")
+	}
+	return list.size
+}
+
+// ForEach visits entries from head to tail until consumer returns false,
+// slices passed to consumer share memory with list, copy them before retaining
+func (list *QuickList) ForEach(consumer func(int, []byte) bool) {
+	if list == nil {
+		panic("list is nil")
+	}
+	index := 0
+	for _, c := range list.chunks {
+		offset := 0
+		for j := 0; j < c.count; j++ {
+			var val []byte
+			val, offset = c.entryAt(offset)
+			if !consumer(index, val) {
+				return
+			}
+			index++
+		}
+	}
+}
+
+// ReverseForEach visits entries from tail to head until consumer returns false,
+// slices passed to consumer share memory with list, copy them before retaining
+func (list *QuickList) ReverseForEach(consumer func(int, []byte) bool) {
+	if list == nil {
+		panic("list is nil")
+	}
+	index := list.size - 1
+	for i := len(list.chunks) - 1; i >= 0; i-- {
+		c := list.chunks[i]
+		offsets := c.offsets()
+		for j := c.count - 1; j >= 0; j-- {
+			val, _ := c.entryAt(offsets[j])
+			if !consumer(index, val) {
+				return
+			}
+			index--
+		}
+	}
+}
+
+func (list *QuickList) Contains(val []byte) bool {
+	contains := false
+	list.ForEach(func(i int, actual []byte) bool {
+		if bytes.Equal(actual, val) {
+			contains = true
+			return false
+		}
+		return true
+	})
+	return contains
+}
+
+// Range returns copies of entries in [start, stop)
+func (list *QuickList) Range(start int, stop int) [][]byte {
+	if list == nil {
+		panic("list is nil")
+	}
+	if start < 0 || start >= list.size {
+		panic("`start` out of range")
+	}
+	if stop < start || stop > list.size {
+		panic("`stop` out of range")
+	}
+	result := make([][]byte, 0, stop-start)
+	i, j := list.locate(start)
+	for ; i < len(list.chunks) && len(result) < cap(result); i++ {
+		c := list.chunks[i]
+		offset := c.offset(j)
+		for ; j < c.count && len(result) < cap(result); j++ {
+			var val []byte
+			val, offset = c.entryAt(offset)
+			result = append(result, append([]byte(nil), val...))
+		}
+		j = 0
+	}
+	return result
+}
+
+// Trim keeps only entries in [start, stop)
+func (list *QuickList) Trim(start int, stop int) {
+	if list == nil {
+		panic("list is nil")
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop > list.size {
+		stop = list.size
+	}
+	if start >= stop {
+		list.chunks = nil
+		list.size = 0
+		return
+	}
+	// drop entries after stop
+	tail := list.size - stop
+	for tail > 0 {
+		i := len(list.chunks) - 1
+		c := list.chunks[i]
+		if c.count <= tail {
+			tail -= c.count
+			list.removeChunk(i)
+		} else {
+			c.trimBack(c.count - tail)
+			tail = 0
+		}
+	}
+	// drop entries before start
+	head := start
+	for head > 0 {
+		c := list.chunks[0]
+		if c.count <= head {
+			head -= c.count
+			list.removeChunk(0)
+		} else {
+			c.trimFront(head)
+			head = 0
+		}
+	}
+	list.size = stop - start
+	list.compact()
+}
@@ -0,0 +1,141 @@
+package list
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// assertQuickList compares list with expected slice through all accessors
+func assertQuickList(t *testing.T, list *QuickList, expected [][]byte) {
+	t.Helper()
+	if list.Len() != len(expected) {
+		t.Fatalf("expected len %d, actually %d", len(expected), list.Len())
+	}
+	for i, v := range expected {
+		if actual := list.Get(i); !bytes.Equal(actual, v) {
+			t.Fatalf("expected %s at %d, actually %s", v, i, actual)
+		}
+	}
+	list.ForEach(func(i int, v []byte) bool {
+		if !bytes.Equal(v, expected[i]) {
+			t.Fatalf("ForEach: expected %s at %d, actually %s", expected[i], i, v)
+		}
+		return true
+	})
+	list.ReverseForEach(func(i int, v []byte) bool {
+		if !bytes.Equal(v, expected[i]) {
+			t.Fatalf("ReverseForEach: expected %s at %d, actually %s", expected[i], i, v)
+		}
+		return true
+	})
+	size := 0
+	for _, c := range list.chunks {
+		if c.count == 0 {
+			t.Fatal("empty chunk")
+		}
+		size += c.count
+	}
+	if size != list.size {
+		t.Fatalf("chunks hold %d entries, size is %d", size, list.size)
+	}
+}
+
+func randomValue() []byte {
+	// large values make chunks split and merge often
+	return bytes.Repeat([]byte(strconv.Itoa(rand.Intn(5))), 1+rand.Intn(1000))
+}
+
+func TestQuickListRandom(t *testing.T) {
+	list := MakeQuickList()
+	var expected [][]byte
+	for round := 0; round < 5000; round++ {
+		switch op := rand.Intn(10); {
+		case op < 3:
+			v := randomValue()
+			list.Add(v)
+			expected = append(expected, v)
+		case op < 6:
+			v := randomValue()
+			index := rand.Intn(len(expected) + 1)
+			list.Insert(index, v)
+			expected = append(expected[:index], append([][]byte{v}, expected[index:]...)...)
+		case op < 7 && len(expected) > 0:
+			v := randomValue()
+			index := rand.Intn(len(expected))
+			list.Set(index, v)
+			expected[index] = v
+		case op < 9 && len(expected) > 0:
+			index := rand.Intn(len(expected))
+			if removed := list.Remove(index); !bytes.Equal(removed, expected[index]) {
+				t.Fatalf("expected to remove %s, actually %s", expected[index], removed)
+			}
+			expected = append(expected[:index], expected[index+1:]...)
+		case len(expected) > 0:
+			v := expected[rand.Intn(len(expected))]
+			count := rand.Intn(3)
+			var kept [][]byte
+			removed := 0
+			for _, e := range expected {
+				if bytes.Equal(e, v) && (count == 0 || removed < count) {
+					removed++
+					continue
+				}
+				kept = append(kept, e)
+			}
+			if actual := list.RemoveByVal(v, count); actual != removed {
+				t.Fatalf("expected %d removed, actually %d", removed, actual)
+			}
+			expected = kept
+		}
+		if round%100 == 0 {
+			assertQuickList(t, list, expected)
+		}
+	}
+	assertQuickList(t, list, expected)
+}
+
+func TestQuickListReverseRemoveByVal(t *testing.T) {
+	list := MakeQuickList([]byte("a"), []byte("b"), []byte("a"), []byte("c"), []byte("a"))
+	if removed := list.ReverseRemoveByVal([]byte("a"), 2); removed != 2 {
+		t.Errorf("expected 2 removed, actually %d", removed)
+	}
+	assertQuickList(t, list, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if removed := list.RemoveAllByVal([]byte("a")); removed != 1 {
+		t.Errorf("expected 1 removed, actually %d", removed)
+	}
+	assertQuickList(t, list, [][]byte{[]byte("b"), []byte("c")})
+}
+
+func TestQuickListRangeAndTrim(t *testing.T) {
+	var expected [][]byte
+	list := MakeQuickList()
+	for i := 0; i < 2000; i++ {
+		v := []byte(strconv.Itoa(i) + string(bytes.Repeat([]byte("x"), 20)))
+		list.Add(v)
+		expected = append(expected, v)
+	}
+	if len(list.chunks) < 2 {
+		t.Fatal("expected multiple chunks")
+	}
+	result := list.Range(100, 1500)
+	for i, v := range result {
+		if !bytes.Equal(v, expected[100+i]) {
+			t.Fatalf("expected %s, actually %s", expected[100+i], v)
+		}
+	}
+	if len(result) != 1400 {
+		t.Fatalf("expected 1400 entries, actually %d", len(result))
+	}
+	// returned entries are copies
+	result[0][0] = '#'
+	if list.Get(100)[0] == '#' {
+		t.Error("Range should return copies")
+	}
+
+	list.Trim(300, 1700)
+	assertQuickList(t, list, expected[300:1700])
+	list.Trim(5, 5)
+	assertQuickList(t, list, nil)
+}
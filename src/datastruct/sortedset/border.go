@@ -2,9 +2,20 @@ package sortedset
 
 import (
 	"errors"
+	"math"
 	"strconv"
 )
 
+// Border is the `min` or `max` of a range of elements, ordered by score or by member
+type Border interface {
+	// if max.greater(element) then the element is within the upper border
+	greater(element *Element) bool
+	// if min.less(element) then the element is within the lower border
+	less(element *Element) bool
+	// isIntersected tells whether there could be elements between min and max, called on min
+	isIntersected(max Border) bool
+}
+
 /*
  * ScoreBorder is a struct represents `min` `max` parameter of redis command `ZRANGEBYSCORE`
  * can accept:
@@ -26,7 +37,8 @@ type ScoreBorder struct {
 
 // if max.greater(score) then the score is within the upper border
 // do not use min.greater()
-func (border *ScoreBorder) greater(value float64) bool {
+func (border *ScoreBorder) greater(element *Element) bool {
+	value := element.Score
 	if border.Inf == negativeInf {
 		return false
 	} else if border.Inf == positiveInf {
@@ -39,7 +51,8 @@ func (border *ScoreBorder) greater(value float64) bool {
 	}
 }
 
-func (border *ScoreBorder) less(value float64) bool {
+func (border *ScoreBorder) less(element *Element) bool {
+	value := element.Score
 	if border.Inf == negativeInf {
 		return true
 	} else if border.Inf == positiveInf {
@@ -52,6 +65,22 @@ func (border *ScoreBorder) less(value float64) bool {
 	}
 }
 
+func (border *ScoreBorder) value() float64 {
+	if border.Inf == negativeInf {
+		return math.Inf(-1)
+	} else if border.Inf == positiveInf {
+		return math.Inf(1)
+	}
+	return border.Value
+}
+
+func (border *ScoreBorder) isIntersected(max Border) bool {
+	maxBorder := max.(*ScoreBorder)
+	minValue := border.value()
+	maxValue := maxBorder.value()
+	return minValue < maxValue || (minValue == maxValue && !border.Exclude && !maxBorder.Exclude)
+}
+
 var positiveInfBorder = &ScoreBorder{
 	Inf: positiveInf,
 }
@@ -67,9 +96,9 @@ func ParseScoreBorder(s string) (*ScoreBorder, error) {
 	if s == "-inf" {
 		return negativeInfBorder, nil
 	}
-	if s[0] == '(' {
+	if len(s) > 0 && s[0] == '(' {
 		value, err := strconv.ParseFloat(s[1:], 64)
-		if err != nil {
+		if err != nil || math.IsNaN(value) {
 			return nil, errors.New("ERR min or max is not a float")
 		}
 		return &ScoreBorder{
@@ -79,7 +108,7 @@ func ParseScoreBorder(s string) (*ScoreBorder, error) {
 		}, nil
 	} else {
 		value, err := strconv.ParseFloat(s, 64)
-		if err != nil {
+		if err != nil || math.IsNaN(value) {
 			return nil, errors.New("ERR min or max is not a float")
 		}
 		return &ScoreBorder{
@@ -89,3 +118,87 @@ func ParseScoreBorder(s string) (*ScoreBorder, error) {
 		}, nil
 	}
 }
+
+/*
+ * LexBorder is a struct represents `min` `max` parameter of redis command `ZRANGEBYLEX`
+ * members are compared byte by byte, which makes sense only if all elements have the same score
+ * can accept:
+ *   inclusive member: [a
+ *   exclusive member: (a
+ *   infinity: +, -
+ */
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (border *LexBorder) greater(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return false
+	} else if border.Inf == positiveInf {
+		return true
+	}
+	if border.Exclude {
+		return border.Value > value
+	} else {
+		return border.Value >= value
+	}
+}
+
+func (border *LexBorder) less(element *Element) bool {
+	value := element.Member
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < value
+	} else {
+		return border.Value <= value
+	}
+}
+
+func (border *LexBorder) isIntersected(max Border) bool {
+	maxBorder := max.(*LexBorder)
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return false
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return true
+	}
+	return border.Value < maxBorder.Value ||
+		(border.Value == maxBorder.Value && !border.Exclude && !maxBorder.Exclude)
+}
+
+var positiveInfLexBorder = &LexBorder{
+	Inf: positiveInf,
+}
+
+var negativeInfLexBorder = &LexBorder{
+	Inf: negativeInf,
+}
+
+func ParseLexBorder(s string) (*LexBorder, error) {
+	if s == "+" {
+		return positiveInfLexBorder, nil
+	}
+	if s == "-" {
+		return negativeInfLexBorder, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: true,
+		}, nil
+	}
+	if len(s) > 0 && s[0] == '[' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: false,
+		}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
@@ -27,7 +27,7 @@ func (sortedSet *SortedSet) Add(member string, score float64) bool {
 	}
 	if ok {
 		if score != element.Score {
-			sortedSet.skiplist.remove(member, score)
+			sortedSet.skiplist.remove(member, element.Score)
 			sortedSet.skiplist.insert(member, score)
 		}
 		return false
@@ -132,33 +132,31 @@ func (sortedSet *SortedSet) Range(start int64, stop int64, desc bool) []*Element
 }
 
 func (sortedSet *SortedSet) Count(min *ScoreBorder, max *ScoreBorder) int64 {
-	var i int64 = 0
-	// ascending order
-	sortedSet.ForEach(0, sortedSet.Len(), false, func(element *Element) bool {
-		gtMin := min.less(element.Score) // greater than min
-		if !gtMin {
-			// has not into range, continue foreach
-			return true
-		}
-		ltMax := max.greater(element.Score) // less than max
-		if !ltMax {
-			// break through score border, break foreach
-			return false
-		}
-		// gtMin && ltMax
-		i++
-		return true
-	})
-	return i
+	return sortedSet.CountByBorder(min, max)
+}
+
+// CountByBorder returns count of elements in range, computed by ranks of the first and the last one
+func (sortedSet *SortedSet) CountByBorder(min Border, max Border) int64 {
+	first := sortedSet.skiplist.getFirstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := sortedSet.skiplist.getLastInRange(min, max)
+	return sortedSet.skiplist.getRank(last.Member, last.Score) - sortedSet.skiplist.getRank(first.Member, first.Score) + 1
 }
 
 func (sortedSet *SortedSet) ForEachByScore(min *ScoreBorder, max *ScoreBorder, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	sortedSet.ForEachByBorder(min, max, offset, limit, desc, consumer)
+}
+
+// ForEachByBorder traverses elements in range of score or member
+func (sortedSet *SortedSet) ForEachByBorder(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
 	// find start node
 	var node *Node
 	if desc {
-		node = sortedSet.skiplist.getLastInScoreRange(min, max)
+		node = sortedSet.skiplist.getLastInRange(min, max)
 	} else {
-		node = sortedSet.skiplist.getFirstInScoreRange(min, max)
+		node = sortedSet.skiplist.getFirstInRange(min, max)
 	}
 
 	for node != nil && offset > 0 {
@@ -172,6 +170,11 @@ func (sortedSet *SortedSet) ForEachByScore(min *ScoreBorder, max *ScoreBorder, o
 
 	// A negative limit returns all elements from the offset
 	for i := 0; (i < int(limit) || limit < 0) && node != nil; i++ {
+		gtMin := min.less(&node.Element) // greater than min
+		ltMax := max.greater(&node.Element)
+		if !gtMin || !ltMax {
+			break // break through border
+		}
 		if !consumer(&node.Element) {
 			break
 		}
@@ -180,14 +183,6 @@ func (sortedSet *SortedSet) ForEachByScore(min *ScoreBorder, max *ScoreBorder, o
 		} else {
 			node = node.level[0].forward
 		}
-		if node == nil {
-			break
-		}
-		gtMin := min.less(node.Element.Score) // greater than min
-		ltMax := max.greater(node.Element.Score)
-		if !gtMin || !ltMax {
-			break // break through score border
-		}
 	}
 }
 
@@ -195,11 +190,18 @@ func (sortedSet *SortedSet) ForEachByScore(min *ScoreBorder, max *ScoreBorder, o
  * param limit: <0 means no limit
  */
 func (sortedSet *SortedSet) RangeByScore(min *ScoreBorder, max *ScoreBorder, offset int64, limit int64, desc bool) []*Element {
+	return sortedSet.RangeByBorder(min, max, offset, limit, desc)
+}
+
+/*
+ * param limit: <0 means no limit
+ */
+func (sortedSet *SortedSet) RangeByBorder(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
 	if limit == 0 || offset < 0 {
 		return make([]*Element, 0)
 	}
 	slice := make([]*Element, 0)
-	sortedSet.ForEachByScore(min, max, offset, limit, desc, func(element *Element) bool {
+	sortedSet.ForEachByBorder(min, max, offset, limit, desc, func(element *Element) bool {
 		slice = append(slice, element)
 		return true
 	})
@@ -207,7 +209,11 @@ func (sortedSet *SortedSet) RangeByScore(min *ScoreBorder, max *ScoreBorder, off
 }
 
 func (sortedSet *SortedSet) RemoveByScore(min *ScoreBorder, max *ScoreBorder) int64 {
-	removed := sortedSet.skiplist.RemoveRangeByScore(min, max)
+	return sortedSet.RemoveByBorder(min, max)
+}
+
+func (sortedSet *SortedSet) RemoveByBorder(min Border, max Border) int64 {
+	removed := sortedSet.skiplist.RemoveRange(min, max)
 	for _, element := range removed {
 		delete(sortedSet.dict, element.Member)
 	}
@@ -224,3 +230,33 @@ func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
 	}
 	return int64(len(removed))
 }
+
+// PopMin removes and returns at most count elements with the lowest scores, in ascending order
+func (sortedSet *SortedSet) PopMin(count int64) []*Element {
+	removed := sortedSet.skiplist.RemoveRangeByRank(1, count+1)
+	for _, element := range removed {
+		delete(sortedSet.dict, element.Member)
+	}
+	return removed
+}
+
+// PopMax removes and returns at most count elements with the highest scores, in descending order
+func (sortedSet *SortedSet) PopMax(count int64) []*Element {
+	start := sortedSet.skiplist.length - count + 1
+	if start < 1 {
+		start = 1
+	}
+	removed := sortedSet.skiplist.RemoveRangeByRank(start, sortedSet.skiplist.length+1)
+	for i, j := 0, len(removed)-1; i < j; i, j = i+1, j-1 {
+		removed[i], removed[j] = removed[j], removed[i]
+	}
+	for _, element := range removed {
+		delete(sortedSet.dict, element.Member)
+	}
+	return removed
+}
+
+// GetByRank returns element of 0-based rank in ascending order, rank must be in [0, size)
+func (sortedSet *SortedSet) GetByRank(rank int64) *Element {
+	return &sortedSet.skiplist.getByRank(rank + 1).Element
+}
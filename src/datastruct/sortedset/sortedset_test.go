@@ -0,0 +1,86 @@
+package sortedset
+
+import (
+	"strconv"
+	"testing"
+)
+
+func members(elements []*Element) string {
+	s := ""
+	for _, element := range elements {
+		s += element.Member
+	}
+	return s
+}
+
+func TestUpdateScore(t *testing.T) {
+	set := Make()
+	set.Add("a", 1)
+	set.Add("b", 2)
+	set.Add("a", 3)
+	if got := members(set.Range(0, set.Len(), false)); got != "ba" {
+		t.Errorf("expected ba, actually %s", got)
+	}
+	if set.skiplist.length != 2 {
+		t.Errorf("expected 2 nodes, actually %d", set.skiplist.length)
+	}
+}
+
+func TestRangeByBorder(t *testing.T) {
+	set := Make()
+	for i := 0; i < 10; i++ {
+		set.Add(strconv.Itoa(i), float64(i))
+	}
+	min, _ := ParseScoreBorder("(2")
+	max, _ := ParseScoreBorder("5")
+	if got := members(set.RangeByScore(min, max, 0, -1, false)); got != "345" {
+		t.Errorf("expected 345, actually %s", got)
+	}
+	if got := members(set.RangeByScore(min, max, 1, 5, true)); got != "43" {
+		t.Errorf("expected 43, actually %s", got)
+	}
+	if got := members(set.RangeByScore(min, max, 5, -1, false)); got != "" {
+		t.Errorf("expected empty, actually %s", got)
+	}
+	if count := set.Count(min, max); count != 3 {
+		t.Errorf("expected 3, actually %d", count)
+	}
+	if count := set.Count(negativeInfBorder, positiveInfBorder); count != 10 {
+		t.Errorf("expected 10, actually %d", count)
+	}
+	empty, _ := ParseScoreBorder("(5")
+	if count := set.Count(empty, max); count != 0 {
+		t.Errorf("expected 0, actually %d", count)
+	}
+
+	lexMin, _ := ParseLexBorder("[3")
+	lexMax, _ := ParseLexBorder("(6")
+	if got := members(set.RangeByBorder(lexMin, lexMax, 0, -1, false)); got != "345" {
+		t.Errorf("expected 345, actually %s", got)
+	}
+	if count := set.CountByBorder(negativeInfLexBorder, lexMax); count != 6 {
+		t.Errorf("expected 6, actually %d", count)
+	}
+	if removed := set.RemoveByBorder(lexMin, lexMax); removed != 3 || set.Len() != 7 {
+		t.Errorf("expected 3 removed, actually %d", removed)
+	}
+	if _, err := ParseLexBorder("a"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestPop(t *testing.T) {
+	set := Make()
+	for i := 0; i < 5; i++ {
+		set.Add(strconv.Itoa(i), float64(i))
+	}
+	if got := members(set.PopMin(2)); got != "01" {
+		t.Errorf("expected 01, actually %s", got)
+	}
+	if got := members(set.PopMax(2)); got != "43" {
+		t.Errorf("expected 43, actually %s", got)
+	}
+	if got := members(set.PopMax(5)); got != "2" || set.Len() != 0 {
+		t.Errorf("expected 2, actually %s", got)
+	}
+}
@@ -1,21 +1,25 @@
 package db
 
 import (
-	"bufio"
+	"fmt"
+	"my-godis/src/aof"
 	"my-godis/src/config"
 	"my-godis/src/datastruct/dict"
 	List "my-godis/src/datastruct/list"
-	"my-godis/src/datastruct/lock"
 	"my-godis/src/datastruct/set"
 	SortedSet "my-godis/src/datastruct/sortedset"
+	"my-godis/src/interface/redis"
+	"my-godis/src/latency"
 	"my-godis/src/lib/logger"
 	"my-godis/src/redis/reply"
 
 	"io"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,6 +33,17 @@ func makeExpireCmd(key string, expireAt time.Time) *reply.MultiBulkReply {
 	return reply.MakeMultiBulkReply(args)
 }
 
+var hPExpireAtCmd = []byte("HPEXPIREAT")
+var fieldsArg = []byte("FIELDS")
+
+func makeFieldExpireCmd(key string, expireAt time.Time, fields [][]byte) *reply.MultiBulkReply {
+	args := make([][]byte, 0, 5+len(fields))
+	args = append(args, hPExpireAtCmd, []byte(key), []byte(strconv.FormatInt(expireAt.UnixNano()/1e6, 10)),
+		fieldsArg, []byte(strconv.Itoa(len(fields))))
+	args = append(args, fields...)
+	return reply.MakeMultiBulkReply(args)
+}
+
 func makeAofCmd(cmd string, args [][]byte) *reply.MultiBulkReply {
 	params := make([][]byte, len(args)+1)
 	copy(params[1:], args)
@@ -36,198 +51,660 @@ func makeAofCmd(cmd string, args [][]byte) *reply.MultiBulkReply {
 	return reply.MakeMultiBulkReply(params)
 }
 
+/* ---- rewrite commands for aof ----- */
+
+// the command is appended only if it changes dataset: reply is positive integer, not null and not empty
+func changedOnly(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	switch result := result.(type) {
+	case *reply.IntReply:
+		if result.Code == 0 {
+			return nil
+		}
+	case *reply.MultiBulkReply:
+		// like LPOP key 0
+		if len(result.Args) == 0 {
+			return nil
+		}
+	case *reply.NullBulkReply, *reply.EmptyMultiBulkReply, *reply.NullMultiBulkReply:
+		return nil
+	}
+	return []*reply.MultiBulkReply{reply.MakeMultiBulkReply(args)}
+}
+
+// effect of scripts is appended by commands they call
+func noPropagate(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	return nil
+}
+
+// relative expire time is converted into PEXPIREAT
+func rewriteExpire(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	if intReply, ok := result.(*reply.IntReply); ok && intReply.Code == 0 {
+		return nil
+	}
+	key := string(args[1])
+	if _, exists := db.Get(key); !exists {
+		// expire time in the past removes key
+		return []*reply.MultiBulkReply{makeAofCmd("del", args[1:2])}
+	}
+	return makeTTLCmds(db, key, nil)
+}
+
+// commands setting string with relative ttl or computed value, such as SET EX and INCRBYFLOAT,
+// are converted into SET with the value in dataset and PEXPIREAT
+func rewriteAsSet(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	key := string(args[1])
+	value, errReply := db.getAsString(key)
+	if errReply != nil {
+		return nil
+	}
+	if value == nil {
+		// expire time in the past removes key
+		return []*reply.MultiBulkReply{makeAofCmd("del", args[1:2])}
+	}
+	return makeTTLCmds(db, key, persistString(key, value))
+}
+
+// makeTTLCmds returns cmd followed by PEXPIREAT if key has ttl
+func makeTTLCmds(db *DB, key string, cmd *reply.MultiBulkReply) []*reply.MultiBulkReply {
+	cmds := make([]*reply.MultiBulkReply, 0, 2)
+	if cmd != nil {
+		cmds = append(cmds, cmd)
+	}
+	if raw, ok := db.TTLMap.Get(key); ok {
+		expireTime, _ := raw.(time.Time)
+		cmds = append(cmds, makeExpireCmd(key, expireTime))
+	}
+	return cmds
+}
+
 // send command to aof
 func (db *DB) AddAof(args *reply.MultiBulkReply) {
-	// aofChan == nil when loadAof
-	if config.Properties.AppendOnly && db.aofChan != nil {
+	db.aofMu.RLock()
+	defer db.aofMu.RUnlock()
+	// aofChan == nil when loadAof or aof stopped
+	if config.Properties().AppendOnly && db.aofChan != nil {
+		db.aofSeqMu.Lock()
 		db.aofChan <- args
+		db.aofSeq++
+		db.aofSeqMu.Unlock()
 	}
 }
 
-// listen aof channel and write into file
-func (db *DB) handleAof() {
-	for cmd := range db.aofChan {
-		db.pausingAof.RLock() // prevent other goroutines from pausing aof
-		if db.aofRewriteChan != nil {
-			// replica during rewrite
-			db.aofRewriteChan <- cmd
-		}
-		_, err := db.aofFile.Write(cmd.ToBytes())
-		if err != nil {
-			logger.Warn(err)
+// propagate appends effect of write command to aof, failed commands are not appended
+func (db *DB) propagate(cmd *command, args [][]byte, result redis.Reply) {
+	if !config.Properties().AppendOnly || cmd.flags&flagWrite == 0 || reply.IsErrorReply(result) {
+		return
+	}
+	if cmd.rewrite == nil {
+		db.AddAof(reply.MakeMultiBulkReply(args))
+		return
+	}
+	for _, line := range cmd.rewrite(db, args, result) {
+		db.AddAof(line)
+	}
+}
+
+// waitAofSync blocks until all commands sent to aof are flushed, returns the last write error
+func (db *DB) waitAofSync() error {
+	db.aofSeqMu.Lock()
+	seq := db.aofSeq
+	db.aofSeqMu.Unlock()
+
+	db.aofSyncMu.Lock()
+	defer db.aofSyncMu.Unlock()
+	for db.aofSynced < seq {
+		db.aofSyncCond.Wait()
+	}
+	return db.aofErr
+}
+
+// aofError returns the last error of writing aof, write commands are refused until aof is writable again
+func (db *DB) aofError() error {
+	if !config.Properties().AppendOnly {
+		return nil
+	}
+	db.aofSyncMu.Lock()
+	defer db.aofSyncMu.Unlock()
+	return db.aofErr
+}
+
+// markAofSynced is called by aof goroutine after flushing n commands
+func (db *DB) markAofSynced(n int, err error) {
+	db.aofSyncMu.Lock()
+	if err != nil && db.aofErr == nil {
+		logger.Warn("aof write failed, refusing write commands: " + err.Error())
+	} else if err == nil && db.aofErr != nil {
+		logger.Info("aof is writable again")
+	}
+	db.aofSynced += uint64(n)
+	db.aofErr = err
+	db.aofSyncMu.Unlock()
+	db.aofSyncCond.Broadcast()
+}
+
+func makeMisconfErrReply(err error) *reply.StandardErrReply {
+	return reply.MakeErrReply("MISCONF Errors writing to the AOF file: " + err.Error())
+}
+
+// open the last incremental file of manifest and start aof goroutine
+func (db *DB) startAof() error {
+	db.pausingAof.Lock()
+	manifest := db.aofManifest
+	incr := manifest.LastIncr()
+	if incr == nil {
+		// start a new incremental file after loading
+		manifest = manifest.Clone()
+		incr = manifest.NextIncr()
+		manifest.AddIncr(incr)
+		if err := manifest.Save(); err != nil {
+			db.pausingAof.Unlock()
+			return err
 		}
-		db.pausingAof.RUnlock()
+		db.aofManifest = manifest
+	}
+	aofFile, err := os.OpenFile(manifest.Path(incr), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		db.pausingAof.Unlock()
+		return err
 	}
+	db.aofFile = aofFile
+	db.aofFilename = manifest.Path(incr)
+	db.updateAofSize()
+	db.pausingAof.Unlock()
+
+	db.aofMu.Lock()
+	defer db.aofMu.Unlock()
+	db.aofChan = make(chan *reply.MultiBulkReply, aofQueueSize)
+	db.aofFinished = make(chan struct{})
+	go db.handleAof(db.aofChan, db.aofFinished)
+	return nil
 }
 
-func trim(msg []byte) string {
-	trimed := ""
-	for i := len(msg) - 1; i >= 0; i-- {
-		if msg[i] == '\r' || msg[i] == '\n' {
+// updateAofSize stats size of aof files, caller must hold pausingAof
+func (db *DB) updateAofSize() {
+	var base, total int64
+	for _, file := range db.aofManifest.Files() {
+		info, err := os.Stat(db.aofManifest.Path(file))
+		if err != nil {
 			continue
 		}
-		return string(msg[:i+1])
+		if file.Type == aof.TypeBase {
+			base = info.Size()
+		}
+		total += info.Size()
 	}
-	return trimed
+	atomic.StoreInt64(&db.aofBaseSize, base)
+	atomic.StoreInt64(&db.aofCurrentSize, total)
 }
 
-// read aof file
-func (db *DB) loadAof(maxBytes int) {
-	file, err := os.Open(db.aofFilename)
+// stop aof goroutine after it flushed queued commands, then close aof file
+func (db *DB) stopAof() {
+	db.aofMu.Lock()
+	if db.aofChan == nil {
+		db.aofMu.Unlock()
+		return
+	}
+	close(db.aofChan)
+	db.aofChan = nil
+	finished := db.aofFinished
+	db.aofMu.Unlock()
+	<-finished
+
+	db.aofSyncMu.Lock()
+	db.aofErr = nil
+	db.aofSyncMu.Unlock()
+}
+
+// enable aof at runtime, commands are appended to a new incremental file since dataset snapshotted,
+// then the snapshot is written into a new base file which replaces all files before
+func (db *DB) enableAof() error {
+	// a rewrite started before aof stopped is discarded by finishRewrite, wait for it to exit
+	for !atomic.CompareAndSwapInt32(&db.aofRewriting, 0, 1) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer atomic.StoreInt32(&db.aofRewriting, 0)
+
+	manifest, err := openAofManifest()
 	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return
+		return err
+	}
+	// the new incremental file is saved into manifest with the new base
+	manifest = manifest.Clone()
+	incr := manifest.NextIncr()
+	manifest.AddIncr(incr)
+
+	// no write command is running until aof started, so none is missed by both snapshot and aof
+	db.snapshotMu.Lock()
+	snapshot, err := db.takeSnapshot(config.Properties().AofUseRdbPreamble)
+	if err == nil {
+		db.pausingAof.Lock()
+		db.aofManifest = manifest
+		db.pausingAof.Unlock()
+		err = db.startAof()
+		if err != nil {
+			snapshot.release()
 		}
-		logger.Warn(err)
-		return
 	}
-	defer file.Close()
+	db.snapshotMu.Unlock()
+	if err != nil {
+		return err
+	}
 
-	reader := bufio.NewReader(file)
-	var fixedLen int64 = 0
-	var expectedArgsCount uint32
-	var receivedCount uint32
-	var args [][]byte
-	processing := false
-	var msg []byte
-	readBytes := 0
-	for {
-		if maxBytes != 0 && readBytes >= maxBytes {
-			break
+	err = db.writeBase(snapshot, incr)
+	if err != nil {
+		db.stopAof()
+		return err
+	}
+	return nil
+}
+
+// saveDataset writes current dataset into a new base file of manifest, incremental files before keepSeq are removed
+func (db *DB) saveDataset(manifest *aof.Manifest, keepSeq int64) (*aof.Manifest, error) {
+	tmpFilename, err := writeTempBase(manifest.Dir, func(w io.Writer) {
+		now := time.Now()
+		writeDataset(w, db.Data, db.TTLMap, db.FieldTTLMap)
+		writeFunctions(w, db.functions.codes())
+		writeBaseTimestamp(w, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replaceBase(manifest, tmpFilename, keepSeq)
+}
+
+// max count of commands written by one write call
+const aofBatchSize = 1024
+
+// listen aof channel and write into file, fsync according to appendfsync
+func (db *DB) handleAof(aofChan chan *reply.MultiBulkReply, finished chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	// commands failed to write are kept in buffer and retried later
+	var buf []byte
+	dirty := false
+	// unix time of the latest timestamp annotation
+	var lastTimestamp int64
+	defer func() {
+		ticker.Stop()
+		db.pausingAof.Lock()
+		_, err := db.writeAof(buf)
+		if err == nil && dirty && config.Properties().AppendFsync != config.FsyncNo {
+			err = db.aofFile.Sync()
+		}
+		if err != nil {
+			logger.Warn(err)
+		}
+		err = db.aofFile.Close()
+		if err != nil {
+			logger.Warn(err)
 		}
-		if fixedLen == 0 {
-			msg, err = reader.ReadBytes('\n')
-			if err == io.EOF {
+		db.aofFile = nil
+		db.pausingAof.Unlock()
+		close(finished)
+	}()
+	for {
+		select {
+		case cmd, ok := <-aofChan:
+			if !ok {
 				return
 			}
-			if len(msg) == 0 {
-				logger.Warn("invalid format: line should end with \\r\\n")
-				return
+			db.pausingAof.RLock() // prevent other goroutines from pausing aof
+			// group commands arrived together into one write and one fsync
+			count := 0
+			closed := false
+			if config.Properties().AofTimestampEnabled {
+				if now := time.Now(); now.Unix() != lastTimestamp {
+					buf = append(buf, aof.MakeTimestamp(now)...)
+					lastTimestamp = now.Unix()
+				}
 			}
-			readBytes += len(msg)
-		} else {
-			msg = make([]byte, fixedLen+2)
-			n, err := io.ReadFull(reader, msg)
-			if err == io.EOF {
-				return
+		batch:
+			for {
+				buf = append(buf, cmd.ToBytes()...)
+				count++
+				if count >= aofBatchSize {
+					break
+				}
+				select {
+				case cmd, ok = <-aofChan:
+					if !ok {
+						closed = true
+						break batch
+					}
+				default:
+					break batch
+				}
+			}
+			start := time.Now()
+			var err error
+			buf, err = db.writeAof(buf)
+			if err == nil {
+				dirty = true
+				if config.Properties().AppendFsync == config.FsyncAlways {
+					err = db.aofFile.Sync()
+					dirty = err != nil
+				}
 			}
-			if len(msg) == 0 {
-				logger.Warn("invalid multibulk length")
+			latency.Default.Record(latency.EventAofWrite, time.Since(start))
+			db.pausingAof.RUnlock()
+			db.markAofSynced(count, err)
+			if closed {
 				return
 			}
-			fixedLen = 0
-			readBytes += n
+		case <-ticker.C:
+			db.checkAutoRewrite()
+			if len(buf) == 0 && (!dirty || config.Properties().AppendFsync != config.FsyncEverySec) {
+				continue
+			}
+			db.pausingAof.RLock()
+			var err error
+			buf, err = db.writeAof(buf)
+			if err == nil && dirty && config.Properties().AppendFsync != config.FsyncNo {
+				err = db.aofFile.Sync()
+				dirty = err != nil
+			}
+			db.pausingAof.RUnlock()
+			db.markAofSynced(0, err)
 		}
-		if err != nil {
-			logger.Warn(err)
-			return
+	}
+}
+
+// writeAof writes buf into aof file, returns bytes need to be retried
+func (db *DB) writeAof(buf []byte) ([]byte, error) {
+	if len(buf) == 0 {
+		return buf, nil
+	}
+	n, err := db.aofFile.Write(buf)
+	atomic.AddInt64(&db.aofCurrentSize, int64(n))
+	if err == nil {
+		return buf[:0], nil
+	}
+	if n > 0 {
+		// remove partial command, whole buffer will be written again
+		info, statErr := db.aofFile.Stat()
+		if statErr != nil || db.aofFile.Truncate(info.Size()-int64(n)) != nil {
+			// partial data cannot be removed, only retry the rest
+			return buf[n:], err
 		}
+		atomic.AddInt64(&db.aofCurrentSize, -int64(n))
+	}
+	return buf, err
+}
 
-		if !processing {
-			// new request
-			if msg[0] == '*' {
-				// bulk multi msg
-				expectedLine, err := strconv.ParseUint(trim(msg[1:]), 10, 32)
-				if err != nil {
-					logger.Warn(err)
-					return
-				}
-				expectedArgsCount = uint32(expectedLine)
-				receivedCount = 0
-				processing = true
-				args = make([][]byte, expectedLine)
-			} else {
-				logger.Warn("msg should start with '*'")
-				return
-			}
-		} else {
-			// receive following part of a request
-			line := msg[0 : len(msg)-2]
-			if line[0] == '$' {
-				fixedLen, err = strconv.ParseInt(trim(line[1:]), 10, 64)
+// openAofManifest loads manifest in appenddirname, aof file of old version is upgraded into base file
+func openAofManifest() (*aof.Manifest, error) {
+	dir := config.Properties().AppendDirname
+	filename := config.Properties().AppendFilename
+	// single aof file of old version lays beside appenddirname
+	legacyFilename := filepath.Join(filepath.Dir(dir), filename)
+
+	manifest, err := aof.LoadManifest(dir, filename)
+	if os.IsNotExist(err) {
+		manifest = aof.MakeManifest(dir, filename)
+		if _, err := os.Stat(legacyFilename); err == nil {
+			manifest.SetBase(manifest.NextBase())
+		}
+		// persist manifest before moving the old file, so it won't be lost after a crash
+		err = manifest.Save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Base != nil {
+		if _, err := os.Stat(manifest.Path(manifest.Base)); os.IsNotExist(err) {
+			if _, err := os.Stat(legacyFilename); err == nil {
+				logger.Info("upgrading " + legacyFilename + " into " + manifest.Path(manifest.Base))
+				err = os.Rename(legacyFilename, manifest.Path(manifest.Base))
 				if err != nil {
-					logger.Warn(err)
-					return
-				}
-				if fixedLen <= 0 {
-					logger.Warn("invalid multibulk length")
-					return
+					return nil, err
 				}
-			} else {
-				args[receivedCount] = line
-				receivedCount++
 			}
+		}
+	}
+	return manifest, nil
+}
 
-			// if sending finished
-			if receivedCount == expectedArgsCount {
-				processing = false
+// loadLimit stops loading at point-in-time given by aof-load-until-timestamp or aof-load-until-offset
+type loadLimit struct {
+	// unix time, loading stops at the first timestamp annotation after it. 0 means no limit
+	until int64
+	// offset in files of manifest order, commands ending after it are not loaded. 0 means no limit
+	offset int64
+	// size of files loaded before current one
+	loaded int64
+}
 
-				cmd := strings.ToLower(string(args[0]))
-				cmdFunc, ok := router[cmd]
-				if ok {
-					cmdFunc(db, args[1:])
-				}
+/*
+ * loadAof reads aof file and replays commands, returns *aof.FormatError if aof file is corrupted
+ * returns true if loading is stopped by limit
+ */
+func (db *DB) loadAof(filename string, limit *loadLimit) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
 
-				// finish
-				expectedArgsCount = 0
-				receivedCount = 0
-				args = nil
+	reader := aof.NewReader(file)
+	keys := 0
+	ok, err := reader.ReadPreamble(func(key string, val interface{}, expireAt time.Time) {
+		db.Put(key, &DataEntity{Data: val})
+		if !expireAt.IsZero() {
+			db.Expire(key, expireAt)
+		}
+		keys++
+	})
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		logger.Info(fmt.Sprintf("loaded %d keys from preamble of %s", keys, filename))
+	}
+	if limit.offset > 0 && limit.loaded+reader.Offset() > limit.offset {
+		return false, fmt.Errorf("aof-load-until-offset %d is inside preamble of %s", limit.offset, filename)
+	}
+	failed := 0
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		if entry.Args == nil {
+			if limit.until > 0 && entry.Timestamp > limit.until {
+				return true, nil
 			}
+			continue
+		}
+		if limit.offset > 0 && limit.loaded+reader.Offset() > limit.offset {
+			return true, nil
 		}
+		result := db.replay(entry.Args)
+		if reply.IsErrorReply(result) {
+			failed++
+			logger.Warn(fmt.Sprintf("replay %s at offset %d of %s failed: %s",
+				strings.ToUpper(string(entry.Args[0])), entry.Offset, filename, strings.TrimPrefix(trim(result.ToBytes()), "-")))
+		}
+	}
+	if failed > 0 {
+		logger.Warn(fmt.Sprintf("%d commands failed while loading %s", failed, filename))
 	}
+	limit.loaded += reader.Offset()
+	return false, nil
 }
 
-/*-- aof rewrite --*/
-func (db *DB) aofRewrite() {
-	file, fileSize, err := db.startRewrite()
+/*
+ * loadAofOnStartup loads base file and incremental files in manifest order
+ * incomplete last command of the last file is trimmed if aof-load-truncated is enabled
+ * if loading is stopped at point-in-time, the restored dataset is saved into a new aof
+ */
+func (db *DB) loadAofOnStartup() error {
+	manifest, err := openAofManifest()
 	if err != nil {
-		logger.Warn(err)
-		return
+		return err
+	}
+	db.aofManifest = manifest
+	// no rewrite is running before loading
+	removeTempBases(manifest.Dir)
+	limit := &loadLimit{
+		until:  config.Properties().AofLoadUntilTimestamp,
+		offset: config.Properties().AofLoadUntilOffset,
+	}
+	files := manifest.Files()
+	for i, file := range files {
+		filename := manifest.Path(file)
+		last := i == len(files)-1
+		stopped, err := db.loadAof(filename, limit)
+		if stopped {
+			if file.Type == aof.TypeBase {
+				return fmt.Errorf("point-in-time to restore is earlier than base file %s", filename)
+			}
+			return db.restoreAof()
+		}
+		if os.IsNotExist(err) && last && file.Type == aof.TypeIncr {
+			// crashed after manifest saved but before incremental file created
+			continue
+		}
+		formatErr, ok := err.(*aof.FormatError)
+		if !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !formatErr.Truncated || !last || !config.Properties().AofLoadTruncated {
+			return fmt.Errorf("bad aof file %s, %s, use godis-check-aof --fix to repair it", filename, err.Error())
+		}
+		logger.Warn(fmt.Sprintf("aof file %s is truncated at offset %d, trimming the incomplete command", filename, formatErr.Offset))
+		err = os.Truncate(filename, formatErr.Offset)
+		if err != nil {
+			return err
+		}
+	}
+	if limit.until > 0 || limit.offset > 0 {
+		logger.Warn("point-in-time to restore is after the end of aof, whole aof is loaded")
 	}
+	return nil
+}
 
-	// load aof file
-	tmpDB := &DB{
-		Data:     dict.MakeSimple(),
-		TTLMap:   dict.MakeSimple(),
-		Locker:   lock.Make(lockerSize),
-		interval: 5 * time.Second,
+// restoreAof moves aof files aside and saves dataset restored to point-in-time as the new aof
+func (db *DB) restoreAof() error {
+	dir := db.aofManifest.Dir
+	backup := dir + ".before-restore-" + strconv.FormatInt(time.Now().Unix(), 10)
+	err := os.Rename(dir, backup)
+	if err != nil {
+		return err
+	}
+	logger.Warn(fmt.Sprintf("dataset is restored to point-in-time, original aof files are moved into %s", backup))
+	manifest := aof.MakeManifest(dir, db.aofManifest.Filename)
+	manifest, err = db.saveDataset(manifest, 0)
+	if err != nil {
+		return err
+	}
+	db.aofManifest = manifest
 
-		aofFilename: db.aofFilename,
+	// restoring is one-shot, the new base file is later than point-in-time and limits would fail next startup
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofLoadUntilTimestamp = 0
+		properties.AofLoadUntilOffset = 0
+	})
+	if err := config.RemoveFromFile("aof-load-until-timestamp", "aof-load-until-offset"); err != nil {
+		logger.Warn("failed to clear point-in-time in config file, remove aof-load-until-timestamp " +
+			"and aof-load-until-offset before restarting: " + err.Error())
 	}
-	tmpDB.loadAof(int(fileSize))
+	return nil
+}
 
-	// rewrite aof file
-	tmpDB.Data.ForEach(func(key string, raw interface{}) bool {
-		var cmd *reply.MultiBulkReply
-		entity, _ := raw.(*DataEntity)
-		switch val := entity.Data.(type) {
-		case []byte:
-			cmd = persistString(key, val)
-		case *List.LinkedList:
-			cmd = persistList(key, val)
-		case *set.Set:
-			cmd = persistSet(key, val)
-		case dict.Dict:
-			cmd = persistHash(key, val)
-		case *SortedSet.SortedSet:
-			cmd = persistZSet(key, val)
+// replay command read from aof
+func (db *DB) replay(args [][]byte) redis.Reply {
+	cmd := strings.ToLower(string(args[0]))
+	command, ok := cmdTable[cmd]
+	if !ok || command.executor == nil {
+		return reply.MakeErrReply("ERR unknown command '" + cmd + "'")
+	}
+	if !command.validArity(args) {
+		return &reply.ArgNumErrReply{Cmd: cmd}
+	}
+	return command.executor(db, args[1:])
+}
 
-		}
+func trim(msg []byte) string {
+	return strings.TrimRight(string(msg), "\r\n")
+}
+
+// persistEntity makes command which rebuilds the entity, returns nil for unknown type
+func persistEntity(key string, entity *DataEntity) *reply.MultiBulkReply {
+	switch val := entity.Data.(type) {
+	case []byte:
+		return persistString(key, val)
+	case *List.QuickList:
+		return persistList(key, val)
+	case *set.Set:
+		return persistSet(key, val)
+	case dict.Dict:
+		return persistHash(key, val)
+	case *SortedSet.SortedSet:
+		return persistZSet(key, val)
+	}
+	return nil
+}
+
+// write preamble or commands which rebuild the dataset
+func writeDataset(file io.Writer, data dict.Dict, ttlMap dict.Dict, fieldTTLMap dict.Dict) {
+	if config.Properties().AofUseRdbPreamble {
+		writer := aof.NewPreambleWriter(file)
+		data.ForEach(func(key string, raw interface{}) bool {
+			entity, _ := raw.(*DataEntity)
+			entry := aof.EncodeEntry(key, entity.Data)
+			if entry == nil {
+				return true
+			}
+			if rawExpireTime, ok := ttlMap.Get(key); ok {
+				expireTime, _ := rawExpireTime.(time.Time)
+				writer.WriteExpire(expireTime)
+			}
+			writer.WriteEntry(entry)
+			return true
+		})
+		_ = writer.Close()
+		writeFieldTTLs(file, fieldTTLMap)
+		return
+	}
+	data.ForEach(func(key string, raw interface{}) bool {
+		entity, _ := raw.(*DataEntity)
+		cmd := persistEntity(key, entity)
 		if cmd != nil {
 			_, _ = file.Write(cmd.ToBytes())
 		}
 		return true
 	})
-	tmpDB.TTLMap.ForEach(func(key string, raw interface{}) bool {
+	ttlMap.ForEach(func(key string, raw interface{}) bool {
 		expireTime, _ := raw.(time.Time)
 		cmd := makeExpireCmd(key, expireTime)
-		if cmd != nil {
+		_, _ = file.Write(cmd.ToBytes())
+		return true
+	})
+	writeFieldTTLs(file, fieldTTLMap)
+}
+
+// writeFieldTTLs writes HPEXPIREAT of hash fields, which follows the dataset
+func writeFieldTTLs(file io.Writer, fieldTTLMap dict.Dict) {
+	fieldTTLMap.ForEach(func(key string, raw interface{}) bool {
+		ttls, _ := raw.(map[string]time.Time)
+		for _, cmd := range persistFieldTTLs(key, ttls) {
 			_, _ = file.Write(cmd.ToBytes())
 		}
 		return true
 	})
+}
 
-	db.finishRewrite(file)
+// writeBaseTimestamp annotates the time of dataset at the end of base file,
+// so that restoring to an earlier time than the base could be detected
+func writeBaseTimestamp(file io.Writer, t time.Time) {
+	if config.Properties().AofTimestampEnabled {
+		_, _ = file.Write(aof.MakeTimestamp(t))
+	}
 }
 
 var setCmd = []byte("SET")
@@ -240,15 +717,15 @@ func persistString(key string, bytes []byte) *reply.MultiBulkReply {
 	return reply.MakeMultiBulkReply(args)
 }
 
-var rPushAllCmd = []byte("RPUSHALL")
+var rPushCmd = []byte("RPUSH")
 
-func persistList(key string, list *List.LinkedList) *reply.MultiBulkReply {
+func persistList(key string, list *List.QuickList) *reply.MultiBulkReply {
 	args := make([][]byte, 2+list.Len())
-	args[0] = rPushAllCmd
+	args[0] = rPushCmd
 	args[1] = []byte(key)
-	list.ForEach(func(i int, val interface{}) bool {
-		bytes, _ := val.([]byte)
-		args[2+i] = bytes
+	// entries share memory with list, copy them
+	list.ForEach(func(i int, val []byte) bool {
+		args[2+i] = append([]byte(nil), val...)
 		return true
 	})
 	return reply.MakeMultiBulkReply(args)
@@ -286,6 +763,27 @@ func persistHash(key string, hash dict.Dict) *reply.MultiBulkReply {
 	return reply.MakeMultiBulkReply(args)
 }
 
+// persistFieldTTLs makes HPEXPIREAT of fields, fields expiring at the same time share a command
+func persistFieldTTLs(key string, ttls map[string]time.Time) []*reply.MultiBulkReply {
+	groups := make(map[int64][][]byte)
+	for field, expireTime := range ttls {
+		ms := expireTime.UnixNano() / 1e6
+		groups[ms] = append(groups[ms], []byte(field))
+	}
+	times := make([]int64, 0, len(groups))
+	for ms := range groups {
+		times = append(times, ms)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i] < times[j]
+	})
+	cmds := make([]*reply.MultiBulkReply, len(times))
+	for i, ms := range times {
+		cmds[i] = makeFieldExpireCmd(key, time.Unix(0, ms*1e6), groups[ms])
+	}
+	return cmds
+}
+
 var zAddCmd = []byte("ZADD")
 
 func persistZSet(key string, zset *SortedSet.SortedSet) *reply.MultiBulkReply {
@@ -302,57 +800,3 @@ func persistZSet(key string, zset *SortedSet.SortedSet) *reply.MultiBulkReply {
 	})
 	return reply.MakeMultiBulkReply(args)
 }
-
-func (db *DB) startRewrite() (*os.File, int64, error) {
-	db.pausingAof.Lock() // pausing aof
-	defer db.pausingAof.Unlock()
-
-	// create rewrite channel
-	db.aofRewriteChan = make(chan *reply.MultiBulkReply, aofQueueSize)
-
-	// get current aof file size
-	fileInfo, _ := os.Stat(db.aofFilename)
-	filesize := fileInfo.Size()
-
-	// create tmp file
-	file, err := ioutil.TempFile("", "aof")
-	if err != nil {
-		logger.Warn("tmp file create failed")
-		return nil, 0, err
-	}
-	return file, filesize, nil
-}
-
-func (db *DB) finishRewrite(tmpFile *os.File) {
-	db.pausingAof.Lock() // pausing aof
-	defer db.pausingAof.Unlock()
-
-	// write commands created during rewriting to tmp file
-loop:
-	for {
-		// aof is pausing, there won't be any new commands in aofRewriteChan
-		select {
-		case cmd := <-db.aofRewriteChan:
-			_, err := tmpFile.Write(cmd.ToBytes())
-			if err != nil {
-				logger.Warn(err)
-			}
-		default:
-			// channel is empty, break loop
-			break loop
-		}
-	}
-	close(db.aofRewriteChan)
-	db.aofRewriteChan = nil
-
-	// replace current aof file by tmp file
-	_ = db.aofFile.Close()
-	_ = os.Rename(tmpFile.Name(), db.aofFilename)
-
-	// reopen aof file for further write
-	aofFile, err := os.OpenFile(db.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		panic(err)
-	}
-	db.aofFile = aofFile
-}
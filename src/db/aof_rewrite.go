@@ -0,0 +1,379 @@
+package db
+
+/*
+ * aof rewrite with multi part aof:
+ * 1. wait for running write commands, switch to a new incremental file and take snapshots of dataset
+ * 2. write snapshots into a temp base file in background, commands go on appending to the new incremental file
+ * 3. rename temp file into the new base, save manifest with the new base and incremental files after it,
+ *    then remove files replaced
+ */
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"my-godis/src/aof"
+	"my-godis/src/config"
+	"my-godis/src/datastruct/dict"
+	"my-godis/src/latency"
+	"my-godis/src/lib/logger"
+	"my-godis/src/redis/reply"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// startBackgroundRewrite starts rewriting aof in background, returns false if a rewrite is in progress
+func (db *DB) startBackgroundRewrite() bool {
+	if !atomic.CompareAndSwapInt32(&db.aofRewriting, 0, 1) {
+		return false
+	}
+	go func() {
+		defer atomic.StoreInt32(&db.aofRewriting, 0)
+		db.aofRewrite()
+	}()
+	return true
+}
+
+func (db *DB) isRewriting() bool {
+	return atomic.LoadInt32(&db.aofRewriting) == 1
+}
+
+// checkAutoRewrite starts rewriting if aof grows more than auto-aof-rewrite-percentage since last rewrite
+func (db *DB) checkAutoRewrite() {
+	percentage := config.Properties().AutoAofRewritePercentage
+	if percentage <= 0 || db.isRewriting() {
+		return
+	}
+	size := atomic.LoadInt64(&db.aofCurrentSize)
+	if size < config.Properties().AutoAofRewriteMinSize {
+		return
+	}
+	base := atomic.LoadInt64(&db.aofBaseSize)
+	if base == 0 {
+		base = 1
+	}
+	growth := (size - base) * 100 / base
+	if growth >= int64(percentage) {
+		logger.Info(fmt.Sprintf("starting automatic rewriting of aof on %d%% growth", growth))
+		db.startBackgroundRewrite()
+	}
+}
+
+// rewrite aof from snapshot of dataset into a new base file
+func (db *DB) aofRewrite() {
+	start := time.Now()
+	defer func() {
+		latency.Default.Record(latency.EventAofRewrite, time.Since(start))
+	}()
+	snapshot, incr, err := db.startRewrite(config.Properties().AofUseRdbPreamble)
+	if err != nil {
+		logger.Warn("aof rewrite aborted: " + err.Error())
+		return
+	}
+	err = db.writeBase(snapshot, incr)
+	if err != nil {
+		logger.Warn("aof rewrite failed: " + err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("aof rewrite finished in %s", time.Since(start)))
+}
+
+// writeBase writes snapshot into a new base file, which replaces files before incr. snapshot is released
+func (db *DB) writeBase(snapshot *datasetSnapshot, incr *aof.FileInfo) error {
+	db.pausingAof.RLock()
+	dir := db.aofManifest.Dir
+	db.pausingAof.RUnlock()
+	tmpFilename, err := writeTempBase(dir, func(w io.Writer) {
+		writeSnapshot(w, snapshot.data, snapshot.ttlMap, snapshot.fieldTTLMap, snapshot.preamble)
+		writeFunctions(w, snapshot.libraries)
+		writeBaseTimestamp(w, snapshot.time)
+	})
+	snapshot.release()
+	if err != nil {
+		return err
+	}
+	return db.finishRewrite(tmpFilename, incr)
+}
+
+// write preamble or commands which rebuild the dataset at the moment snapshots taken
+func writeSnapshot(file io.Writer, data *dict.Snapshot, ttlMap *dict.Snapshot, fieldTTLMap *dict.Snapshot, preamble bool) {
+	if preamble {
+		writer := aof.NewPreambleWriter(file)
+		data.ForEachKey(func(key string) bool {
+			// values have been encoded by snapshot copier
+			raw, exists := data.Visit(key)
+			entry, ok := raw.([]byte)
+			if !exists || !ok || entry == nil {
+				return true
+			}
+			if rawExpireTime, ok := ttlMap.Visit(key); ok {
+				expireTime, _ := rawExpireTime.(time.Time)
+				writer.WriteExpire(expireTime)
+			}
+			writer.WriteEntry(entry)
+			return true
+		})
+		_ = writer.Close()
+		writeFieldTTLSnapshot(file, fieldTTLMap)
+		return
+	}
+	data.ForEachKey(func(key string) bool {
+		// values have been converted to commands by snapshot copier
+		raw, exists := data.Visit(key)
+		if cmd, ok := raw.(*reply.MultiBulkReply); exists && ok && cmd != nil {
+			_, _ = file.Write(cmd.ToBytes())
+		}
+		return true
+	})
+	ttlMap.ForEachKey(func(key string) bool {
+		raw, exists := ttlMap.Visit(key)
+		if expireTime, ok := raw.(time.Time); exists && ok {
+			_, _ = file.Write(makeExpireCmd(key, expireTime).ToBytes())
+		}
+		return true
+	})
+	writeFieldTTLSnapshot(file, fieldTTLMap)
+}
+
+// writeFieldTTLSnapshot writes HPEXPIREAT of hash fields, which follows the dataset
+func writeFieldTTLSnapshot(file io.Writer, fieldTTLMap *dict.Snapshot) {
+	fieldTTLMap.ForEachKey(func(key string) bool {
+		raw, exists := fieldTTLMap.Visit(key)
+		if ttls, ok := raw.(map[string]time.Time); exists && ok {
+			for _, cmd := range persistFieldTTLs(key, ttls) {
+				_, _ = file.Write(cmd.ToBytes())
+			}
+		}
+		return true
+	})
+}
+
+// writeFunctions writes commands which load function libraries
+func writeFunctions(file io.Writer, libraries [][]byte) {
+	for _, code := range libraries {
+		_, _ = file.Write(makeFunctionLoadCmd(code).ToBytes())
+	}
+}
+
+// datasetSnapshot holds snapshots of dataset and function libraries taken at the same moment
+type datasetSnapshot struct {
+	data        *dict.Snapshot
+	ttlMap      *dict.Snapshot
+	fieldTTLMap *dict.Snapshot
+	libraries   [][]byte
+	// values are encoded as preamble records if preamble is true, otherwise converted to commands
+	preamble bool
+	time     time.Time
+}
+
+func (snapshot *datasetSnapshot) release() {
+	snapshot.data.Release()
+	snapshot.ttlMap.Release()
+	snapshot.fieldTTLMap.Release()
+}
+
+// startRewrite switches to a new incremental file and takes snapshot of dataset at the same moment
+func (db *DB) startRewrite(preamble bool) (*datasetSnapshot, *aof.FileInfo, error) {
+	// wait for running write commands, then no command is executing until snapshots taken
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+	// commands before snapshot must be written into the old incremental file,
+	// commands failed to write are retried into the new one, but their effect is in snapshot already
+	if err := db.waitAofSync(); err != nil {
+		return nil, nil, errors.New("aof write failed, rewrite aborted: " + err.Error())
+	}
+
+	db.pausingAof.Lock()
+	incr, err := db.switchIncr()
+	db.pausingAof.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot, err := db.takeSnapshot(preamble)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, incr, nil
+}
+
+// takeSnapshot snapshots dataset, caller must hold snapshotMu so that no write command is running
+func (db *DB) takeSnapshot(preamble bool) (*datasetSnapshot, error) {
+	data, ok1 := db.Data.(*dict.ConcurrentDict)
+	ttlMap, ok2 := db.TTLMap.(*dict.ConcurrentDict)
+	fieldTTLMap, ok3 := db.FieldTTLMap.(*dict.ConcurrentDict)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("dataset doesn't support snapshot")
+	}
+
+	dataSnapshot, err := data.Snapshot(func(key string, val interface{}) interface{} {
+		entity, _ := val.(*DataEntity)
+		if preamble {
+			return aof.EncodeEntry(key, entity.Data)
+		}
+		return persistEntity(key, entity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ttlSnapshot, err := ttlMap.Snapshot(func(key string, val interface{}) interface{} {
+		// time.Time is immutable
+		return val
+	})
+	if err != nil {
+		dataSnapshot.Release()
+		return nil, err
+	}
+	fieldTTLSnapshot, err := fieldTTLMap.Snapshot(func(key string, val interface{}) interface{} {
+		// maps of field ttl are modified in place
+		ttls, _ := val.(map[string]time.Time)
+		copied := make(map[string]time.Time, len(ttls))
+		for field, expireTime := range ttls {
+			copied[field] = expireTime
+		}
+		return copied
+	})
+	if err != nil {
+		dataSnapshot.Release()
+		ttlSnapshot.Release()
+		return nil, err
+	}
+	return &datasetSnapshot{
+		data:        dataSnapshot,
+		ttlMap:      ttlSnapshot,
+		fieldTTLMap: fieldTTLSnapshot,
+		libraries:   db.functions.codes(),
+		preamble:    preamble,
+		time:        time.Now(),
+	}, nil
+}
+
+// switchIncr opens a new incremental file for appending, caller must hold pausingAof
+func (db *DB) switchIncr() (*aof.FileInfo, error) {
+	if db.aofFile == nil {
+		return nil, errors.New("aof is not enabled")
+	}
+	manifest := db.aofManifest.Clone()
+	incr := manifest.NextIncr()
+	filename := manifest.Path(incr)
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	manifest.AddIncr(incr)
+	err = manifest.Save()
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(filename)
+		return nil, err
+	}
+	if err := db.aofFile.Sync(); err != nil {
+		logger.Warn(err)
+	}
+	_ = db.aofFile.Close()
+	db.aofFile = file
+	db.aofFilename = filename
+	db.aofManifest = manifest
+	return incr, nil
+}
+
+// finishRewrite installs rewritten base, incremental files before incr are replaced by it
+func (db *DB) finishRewrite(tmpFilename string, incr *aof.FileInfo) error {
+	db.pausingAof.Lock()
+	defer db.pausingAof.Unlock()
+	if db.aofFile == nil || db.aofManifest.LastIncr().Seq < incr.Seq {
+		// aof stopped or restarted during rewriting
+		_ = os.Remove(tmpFilename)
+		return errors.New("aof is restarted")
+	}
+	manifest, err := replaceBase(db.aofManifest, tmpFilename, incr.Seq)
+	if err != nil {
+		return err
+	}
+	db.aofManifest = manifest
+	db.updateAofSize()
+	return nil
+}
+
+// name pattern of temp base files, they are left in aof directory if server crashed during rewriting
+const tempBasePattern = "temp-rewrite-*.aof"
+
+// removeTempBases removes temp base files left by unfinished rewrites
+func removeTempBases(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if matched, _ := filepath.Match(tempBasePattern, entry.Name()); !matched || entry.IsDir() {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		logger.Info("removing temp file of unfinished rewrite " + filename)
+		if err := os.Remove(filename); err != nil {
+			logger.Warn(err)
+		}
+	}
+}
+
+// writeTempBase writes base file into a temp file in aof directory, returns its name
+func writeTempBase(dir string, write func(w io.Writer)) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	// create temp file in the same directory, so that it could be renamed to base file
+	file, err := ioutil.TempFile(dir, tempBasePattern)
+	if err != nil {
+		return "", err
+	}
+	writer := bufio.NewWriter(file)
+	write(writer)
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+/*
+ * replaceBase renames temp file into a new base file and removes incremental files before keepSeq from manifest,
+ * files replaced are deleted after the new manifest saved. returns the new manifest
+ */
+func replaceBase(manifest *aof.Manifest, tmpFilename string, keepSeq int64) (*aof.Manifest, error) {
+	next := manifest.Clone()
+	base := next.NextBase()
+	err := os.Rename(tmpFilename, next.Path(base))
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return nil, err
+	}
+	oldBase := next.Base
+	next.SetBase(base)
+	replaced := next.TrimIncrs(keepSeq)
+	err = next.Save()
+	if err != nil {
+		_ = os.Remove(next.Path(base))
+		return nil, err
+	}
+	if oldBase != nil {
+		replaced = append(replaced, oldBase)
+	}
+	for _, file := range replaced {
+		if err := os.Remove(next.Path(file)); err != nil && !os.IsNotExist(err) {
+			logger.Warn(err)
+		}
+	}
+	return next, nil
+}
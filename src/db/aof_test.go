@@ -0,0 +1,603 @@
+package db
+
+import (
+	"errors"
+	"my-godis/src/aof"
+	"my-godis/src/config"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAppendFsyncAlways(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AppendFsync = config.FsyncAlways
+	})
+	defer func() {
+		Config(testDB, toArgs("set", "appendonly", "no"))
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendFsync = config.FsyncEverySec
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+
+	// commands are acknowledged only after they are written into aof file
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "fsync" + strconv.Itoa(i)
+			result := testDB.Exec(nil, toArgs("set", key, "1"))
+			if _, ok := result.(*reply.OkReply); !ok {
+				t.Error("expected OK, actually " + string(result.ToBytes()))
+				return
+			}
+			content := readAof(t)
+			if !strings.Contains(content, key) {
+				t.Errorf("%s is acknowledged before written into aof", key)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestAofWriteError(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AppendOnly = true
+	})
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+		})
+	}()
+	testDB.markAofSynced(0, errors.New("no space left on device"))
+	result := testDB.Exec(nil, toArgs("set", "misconf", "1"))
+	if !strings.HasPrefix(string(result.ToBytes()), "-MISCONF") {
+		t.Errorf("expected MISCONF error, actually %s", string(result.ToBytes()))
+	}
+	if _, ok := testDB.Get("misconf"); ok {
+		t.Error("write command should be refused")
+	}
+	// read commands are still served
+	result = testDB.Exec(nil, toArgs("get", "misconf"))
+	if reply.IsErrorReply(result) {
+		t.Errorf("unexpected error %s", string(result.ToBytes()))
+	}
+
+	testDB.markAofSynced(0, nil)
+	result = testDB.Exec(nil, toArgs("set", "misconf", "1"))
+	if _, ok := result.(*reply.OkReply); !ok {
+		t.Error("expected OK, actually " + string(result.ToBytes()))
+	}
+}
+
+func TestLoadTruncatedAof(t *testing.T) {
+	valid := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$4\r\nINCR\r\n$1\r\nb\r\n*2\r\n$4\r\nLPOP\r\n$1\r\na\r\n"
+	filename := writeLegacyAof(t, valid+"*3\r\n$3\r\nSET\r\n$1\r\nc")
+	db := makeTestDB()
+	err := db.loadAofOnStartup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// commands before truncated tail are loaded, including the one failed to replay
+	asserts.AssertBulkReply(t, Get(db, toArgs("b")), "1")
+	asserts.AssertBulkReply(t, Get(db, toArgs("a")), "1")
+	content, _ := os.ReadFile(db.aofManifest.Path(db.aofManifest.Base))
+	if string(content) != valid {
+		t.Errorf("expected truncated file %q, actually %q", valid, content)
+	}
+	// aof of old version is moved into appenddirname
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Error("expected old aof file moved into base file")
+	}
+
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofLoadTruncated = false
+	})
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AofLoadTruncated = true
+		})
+	}()
+	writeLegacyAof(t, valid+"*3\r\n$3\r\nSET")
+	if makeTestDB().loadAofOnStartup() == nil {
+		t.Error("expected error while aof-load-truncated is disabled")
+	}
+}
+
+func TestLoadCorruptedAof(t *testing.T) {
+	writeLegacyAof(t, "*2\r\n$4\r\nINCR\r\n$1\r\nb\r\n+OK\r\n*2\r\n$4\r\nINCR\r\n$1\r\nb\r\n")
+	if makeTestDB().loadAofOnStartup() == nil {
+		t.Error("expected error for corrupted aof")
+	}
+}
+
+func TestLoadMultiPartAof(t *testing.T) {
+	dir := useTempAofDir(t)
+	manifest := aof.MakeManifest(dir, config.Properties().AppendFilename)
+	manifest.SetBase(manifest.NextBase())
+	files := []string{
+		"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n",
+		"*2\r\n$4\r\nINCR\r\n$1\r\na\r\n",
+		"*2\r\n$4\r\nINCR\r\n$1\r\na\r\n*2\r\n$4\r\nINCR",
+	}
+	for i := 1; i < len(files); i++ {
+		manifest.AddIncr(manifest.NextIncr())
+	}
+	if err := manifest.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range manifest.Files() {
+		if err := os.WriteFile(manifest.Path(file), []byte(files[i]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, Get(db, toArgs("a")), "3")
+
+	// temp base left by a crash during rewriting is removed on startup
+	tmpFilename := filepath.Join(dir, "temp-rewrite-123.aof")
+	if err := os.WriteFile(tmpFilename, []byte(files[0]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := makeTestDB().loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmpFilename); !os.IsNotExist(err) {
+		t.Error("expected temp base removed")
+	}
+
+	// only the last file could be truncated
+	second := manifest.Path(manifest.Incrs[0])
+	if err := os.WriteFile(second, []byte(files[1]+"*2\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if makeTestDB().loadAofOnStartup() == nil {
+		t.Error("expected error for truncated file before the last one")
+	}
+}
+
+// useTempAofDir makes aof files created in a temp dir, returns appenddirname
+func useTempAofDir(t *testing.T) string {
+	root := t.TempDir()
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AppendDirname = filepath.Join(root, "appendonlydir")
+		properties.AppendFilename = "appendonly.aof"
+	})
+	return config.Properties().AppendDirname
+}
+
+// writeLegacyAof writes single aof file of old version beside appenddirname, returns its path
+func writeLegacyAof(t *testing.T, content string) string {
+	useTempAofDir(t)
+	filename := filepath.Join(filepath.Dir(config.Properties().AppendDirname), config.Properties().AppendFilename)
+	err := os.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// readAof returns content of all aof files in manifest order
+func readAof(t *testing.T) string {
+	manifest, err := aof.LoadManifest(config.Properties().AppendDirname, config.Properties().AppendFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var builder strings.Builder
+	for _, file := range manifest.Files() {
+		content, err := os.ReadFile(manifest.Path(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder.Write(content)
+	}
+	return builder.String()
+}
+
+func TestAofRewriteFromSnapshot(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	// rewrite into commands
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofUseRdbPreamble = false
+	})
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+			properties.AofUseRdbPreamble = true
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	for i := 0; i < 100; i++ {
+		testDB.Exec(nil, toArgs("incr", "counter"))
+		testDB.Exec(nil, toArgs("sadd", "set", strconv.Itoa(i)))
+		testDB.Exec(nil, toArgs("set", "k"+strconv.Itoa(i), strconv.Itoa(i)))
+	}
+	testDB.Exec(nil, toArgs("expire", "k0", "1000"))
+
+	if !testDB.startBackgroundRewrite() {
+		t.Fatal("expected rewrite started")
+	}
+	// commands during rewriting
+	for i := 0; i < 100; i++ {
+		testDB.Exec(nil, toArgs("incr", "counter"))
+		testDB.Exec(nil, toArgs("sadd", "set", strconv.Itoa(i+100)))
+		testDB.Exec(nil, toArgs("del", "k"+strconv.Itoa(i)))
+	}
+	for testDB.isRewriting() {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		testDB.Exec(nil, toArgs("incr", "counter"))
+	}
+	Config(testDB, toArgs("set", "appendonly", "no"))
+
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, Get(db, toArgs("counter")), "210")
+	asserts.AssertIntReply(t, SCard(db, toArgs("set")), 200)
+	for _, key := range []string{"k0", "k50", "k99"} {
+		asserts.AssertIntReply(t, Exists(db, toArgs(key)), 0)
+	}
+
+	if strings.Count(readAof(t), "INCR") > 110 {
+		t.Error("aof is not rewritten")
+	}
+	// files replaced by the new base are removed
+	entries, _ := os.ReadDir(config.Properties().AppendDirname)
+	if len(entries) != len(db.aofManifest.Files())+1 {
+		t.Errorf("expected only files in manifest left, actually %d files", len(entries))
+	}
+	if db.aofManifest.Base == nil || db.aofManifest.Base.Seq != 2 {
+		t.Error("expected the second base file after rewrite")
+	}
+}
+
+func TestAofRewriteAbortedOnWriteError(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	testDB.Exec(nil, toArgs("incr", "counter"))
+	incr := testDB.aofManifest.LastIncr()
+
+	_ = testDB.waitAofSync()
+	testDB.markAofSynced(0, errors.New("no space left on device"))
+	if _, _, err := testDB.startRewrite(true); err == nil {
+		t.Error("expected rewrite aborted")
+	}
+	if testDB.aofManifest.LastIncr().Seq != incr.Seq {
+		t.Error("incremental file should not be switched")
+	}
+	testDB.markAofSynced(0, nil)
+	Config(testDB, toArgs("set", "appendonly", "no"))
+}
+
+func TestAutoAofRewrite(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	defer func() {
+		Config(testDB, toArgs("set", "appendonly", "no"))
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AutoAofRewriteMinSize = 64 << 20
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	for i := 0; i < 100; i++ {
+		testDB.Exec(nil, toArgs("set", "key", strconv.Itoa(i)))
+		testDB.Exec(nil, toArgs("del", "key"))
+	}
+	_ = testDB.waitAofSync()
+	size := atomic.LoadInt64(&testDB.aofCurrentSize)
+	if size < 1024 {
+		t.Fatalf("unexpected aof size %d", size)
+	}
+	testDB.checkAutoRewrite()
+	if testDB.isRewriting() {
+		t.Fatal("aof smaller than auto-aof-rewrite-min-size should not be rewritten")
+	}
+
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AutoAofRewriteMinSize = 1024
+	})
+	testDB.checkAutoRewrite()
+	deadline := time.Now().Add(5 * time.Second)
+	for testDB.isRewriting() || atomic.LoadInt64(&testDB.aofCurrentSize) >= 1024 {
+		if time.Now().After(deadline) {
+			t.Fatal("aof is not rewritten")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt64(&testDB.aofBaseSize) != atomic.LoadInt64(&testDB.aofCurrentSize) {
+		t.Error("base size should be updated after rewrite")
+	}
+}
+
+func TestAofRewriteWithPreamble(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	testDB.Exec(nil, toArgs("set", "str", "v"))
+	testDB.Exec(nil, toArgs("expire", "str", "1000"))
+	testDB.Exec(nil, toArgs("sadd", "set", "a", "b"))
+	testDB.Exec(nil, toArgs("hset", "hash", "f", "v"))
+	testDB.Exec(nil, toArgs("zadd", "zset", "1.5", "m"))
+	testDB.Exec(nil, toArgs("lpush", "list", "1", "2"))
+
+	if !testDB.startBackgroundRewrite() {
+		t.Fatal("expected rewrite started")
+	}
+	for testDB.isRewriting() {
+		time.Sleep(time.Millisecond)
+	}
+	// commands after rewriting are appended to incremental file
+	testDB.Exec(nil, toArgs("sadd", "set", "c"))
+	Config(testDB, toArgs("set", "appendonly", "no"))
+
+	if !aof.HasPreamble([]byte(readAof(t))) {
+		t.Fatal("expected base file begins with preamble")
+	}
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, Get(db, toArgs("str")), "v")
+	if _, ok := db.TTLMap.Get("str"); !ok {
+		t.Error("expected ttl of str loaded")
+	}
+	asserts.AssertIntReply(t, SCard(db, toArgs("set")), 3)
+	asserts.AssertBulkReply(t, HGet(db, toArgs("hash", "f")), "v")
+	asserts.AssertBulkReply(t, ZScore(db, toArgs("zset", "m")), "1.5")
+	asserts.AssertMultiBulkReply(t, LRange(db, toArgs("list", "0", "-1")), []string{"2", "1"})
+}
+
+func TestPropagation(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofUseRdbPreamble = false
+	})
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+			properties.AofUseRdbPreamble = true
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	testDB.Exec(nil, toArgs("set", "str", "v", "ex", "1000"))
+	testDB.Exec(nil, toArgs("incrbyfloat", "float", "1.5"))
+	testDB.Exec(nil, toArgs("expire", "float", "1000"))
+	testDB.Exec(nil, toArgs("lpop", "none"))
+	testDB.Exec(nil, toArgs("rpush", "list", "a", "b"))
+	testDB.Exec(nil, toArgs("lpop", "list", "0"))
+	testDB.Exec(nil, toArgs("eval", "redis.call('RPUSH', KEYS[1], 'c')", "1", "list"))
+	_ = testDB.waitAofSync()
+
+	// effects are appended in deterministic forms, commands changing nothing are not appended
+	content := readAof(t)
+	for _, cmd := range []string{"$2\r\nex\r\n", "incrbyfloat", "$6\r\nexpire\r\n", "lpop", "eval"} {
+		if strings.Contains(content, cmd) {
+			t.Errorf("unexpected %q in aof", cmd)
+		}
+	}
+	if strings.Count(content, "PEXPIREAT") != 2 {
+		t.Errorf("expected PEXPIREAT of str and float, actually %q", content)
+	}
+
+	// lists are rewritten as RPUSH
+	if !testDB.startBackgroundRewrite() {
+		t.Fatal("expected rewrite started")
+	}
+	for testDB.isRewriting() {
+		time.Sleep(time.Millisecond)
+	}
+	Config(testDB, toArgs("set", "appendonly", "no"))
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertMultiBulkReply(t, LRange(db, toArgs("list", "0", "-1")), []string{"a", "b", "c"})
+	asserts.AssertBulkReply(t, Get(db, toArgs("float")), "1.5")
+	for _, key := range []string{"str", "float"} {
+		if _, ok := db.TTLMap.Get(key); !ok {
+			t.Errorf("expected ttl of %s loaded", key)
+		}
+	}
+}
+
+func TestPropagateFlushAll(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	defer func() {
+		Config(testDB, toArgs("set", "appendonly", "no"))
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	testDB.Exec(nil, toArgs("set", "k", "v"))
+	testDB.Exec(nil, toArgs("flushall"))
+	_ = testDB.waitAofSync()
+	content := readAof(t)
+	if !strings.Contains(content, "flushall") || strings.Contains(content, "flushdb") {
+		t.Errorf("expected flushall in aof, actually %q", content)
+	}
+}
+
+// writeTimestampAof writes aof of an incremental file:
+// set a 1 at 1000, incr a at 1001, flushdb at 1002
+func writeTimestampAof(t *testing.T) string {
+	dir := useTempAofDir(t)
+	manifest := aof.MakeManifest(dir, config.Properties().AppendFilename)
+	manifest.AddIncr(manifest.NextIncr())
+	if err := manifest.Save(); err != nil {
+		t.Fatal(err)
+	}
+	content := "#TS:1000\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"#TS:1001\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\n" +
+		"#TS:1002\r\n*1\r\n$7\r\nFLUSHDB\r\n"
+	if err := os.WriteFile(manifest.Path(manifest.LastIncr()), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadAofUntilTimestamp(t *testing.T) {
+	dir := writeTimestampAof(t)
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofLoadUntilTimestamp = 1001
+	})
+	config.ConfigFilename = filepath.Join(t.TempDir(), "redis.conf")
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AofLoadUntilTimestamp = 0
+		})
+		config.ConfigFilename = ""
+	}()
+	content := "port 7000\n# point-in-time\naof-load-until-timestamp 1001\naof-load-until-offset 0\nmaxclients 10\n"
+	if err := os.WriteFile(config.ConfigFilename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, Get(db, toArgs("a")), "2")
+
+	// original files are kept, restored dataset is saved as new aof
+	backups, _ := filepath.Glob(dir + ".before-restore-*")
+	if len(backups) != 1 {
+		t.Fatalf("expected backup of aof, actually %v", backups)
+	}
+	// restoring is one-shot
+	if config.Properties().AofLoadUntilTimestamp != 0 {
+		t.Error("expected point-in-time cleared")
+	}
+	// only point-in-time lines are removed, runtime config is not written into config file
+	expected := "port 7000\n# point-in-time\nmaxclients 10\n"
+	if actual, _ := os.ReadFile(config.ConfigFilename); string(actual) != expected {
+		t.Errorf("expected config file %q, actually %q", expected, actual)
+	}
+	db = makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, Get(db, toArgs("a")), "2")
+
+	// restoring to the time before base file
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofLoadUntilTimestamp = 1000
+	})
+	if makeTestDB().loadAofOnStartup() == nil {
+		t.Error("expected error for point-in-time earlier than base")
+	}
+}
+
+func TestLoadAofUntilOffset(t *testing.T) {
+	writeTimestampAof(t)
+	// end of set command
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofLoadUntilOffset = 10 + 27
+	})
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AofLoadUntilOffset = 0
+		})
+	}()
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, Get(db, toArgs("a")), "1")
+}
+
+func TestAofTimestampAnnotation(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	testDB.Exec(nil, toArgs("set", "ts", "1"))
+	Config(testDB, toArgs("set", "appendonly", "no"))
+	timestamps, err := aof.ListTimestamps(strings.NewReader(readAof(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written at the end of base file and before commands
+	if len(timestamps) != 2 {
+		t.Errorf("expected 2 timestamps, actually %d", len(timestamps))
+	}
+}
+
+func TestFieldTTLAof(t *testing.T) {
+	for _, preamble := range []bool{false, true} {
+		FlushAll(testDB, [][]byte{})
+		useTempAofDir(t)
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AofUseRdbPreamble = preamble
+		})
+		Config(testDB, toArgs("set", "appendonly", "yes"))
+		testDB.Exec(nil, toArgs("hmset", "h", "a", "1", "b", "2", "c", "3"))
+		testDB.Exec(nil, toArgs("hexpire", "h", "1000", "FIELDS", "2", "a", "b"))
+		testDB.Exec(nil, toArgs("hexpire", "h", "0", "FIELDS", "1", "c"))
+		testDB.Exec(nil, toArgs("sadd", "s", "x", "y"))
+		testDB.Exec(nil, toArgs("spop", "s"))
+		_ = testDB.waitAofSync()
+		content := readAof(t)
+		for _, cmd := range []string{"hexpire", "spop"} {
+			if strings.Contains(content, cmd) {
+				t.Errorf("unexpected %q in aof", cmd)
+			}
+		}
+
+		if !testDB.startBackgroundRewrite() {
+			t.Fatal("expected rewrite started")
+		}
+		for testDB.isRewriting() {
+			time.Sleep(time.Millisecond)
+		}
+		testDB.Exec(nil, toArgs("hpexpire", "h", "2000000", "FIELDS", "1", "a"))
+		Config(testDB, toArgs("set", "appendonly", "no"))
+
+		db := makeTestDB()
+		if err := db.loadAofOnStartup(); err != nil {
+			t.Fatal(err)
+		}
+		asserts.AssertIntReply(t, HLen(db, toArgs("h")), 2)
+		assertRawReply(t, HTTL(db, toArgs("h", "FIELDS", "3", "a", "b", "c")), "*3\r\n:1999\r\n:999\r\n:-2\r\n")
+		asserts.AssertIntReply(t, SCard(db, toArgs("s")), 1)
+		if popped, ok := SPop(testDB, toArgs("s")).(*reply.BulkReply); !ok || SIsMember(db, toArgs("s", string(popped.Arg))).(*reply.IntReply).Code != 1 {
+			t.Error("expected the same member left after loading")
+		}
+	}
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AppendOnly = false
+		properties.AofUseRdbPreamble = true
+	})
+}
@@ -0,0 +1,548 @@
+package db
+
+import (
+	"my-godis/src/datastruct/bitmap"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"strconv"
+	"strings"
+)
+
+// bitmap offset is limited by max length of string
+const maxBitOffset = maxStringLength*8 - 1
+
+func parseBitOffset(arg []byte) (int64, redis.Reply) {
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+	return offset, nil
+}
+
+// SETBIT key offset value
+func SetBit(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	var val byte
+	switch string(args[2]) {
+	case "0":
+	case "1":
+		val = 1
+	default:
+		return reply.MakeErrReply("ERR bit is not an integer or out of range")
+	}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	// modify a copy, the old value may be shared by replies or args
+	bm := bitmap.FromBytes(bytes)
+	old := bm.SetBit(offset, val)
+	db.Put(key, &DataEntity{Data: bm.ToBytes()})
+	return reply.MakeIntReply(int64(old))
+}
+
+// GETBIT key offset
+func GetBit(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	bm := bitmap.BitMap(bytes)
+	return reply.MakeIntReply(int64(bm.GetBit(offset)))
+}
+
+// parseBitRange parses start end [BYTE|BIT] into bit range inside bitmap, ok is false if range is empty
+func parseBitRange(args [][]byte, bitSize int64) (start int64, end int64, ok bool, errReply redis.Reply) {
+	start, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return 0, 0, false, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	end = -1
+	if len(args) > 1 {
+		end, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return 0, 0, false, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	}
+	bitMode := false
+	if len(args) > 2 {
+		switch strings.ToUpper(string(args[2])) {
+		case "BYTE":
+		case "BIT":
+			bitMode = true
+		default:
+			return 0, 0, false, &reply.SyntaxErrReply{}
+		}
+	}
+
+	// range is normalized in unit of mode like GETRANGE
+	size := bitSize
+	if !bitMode {
+		size = bitSize / 8
+	}
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, false, nil
+	}
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end {
+		return 0, 0, false, nil
+	}
+	if !bitMode {
+		return start * 8, end*8 + 7, true, nil
+	}
+	return start, end, true, nil
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func BitCount(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if len(args) == 2 || len(args) > 4 {
+		return &reply.SyntaxErrReply{}
+	}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	bm := bitmap.BitMap(bytes)
+	start, end := int64(0), bm.BitSize()-1
+	if len(args) > 1 {
+		var ok bool
+		var errReply redis.Reply
+		start, end, ok, errReply = parseBitRange(args[1:], bm.BitSize())
+		if errReply != nil {
+			return errReply
+		}
+		if !ok {
+			return reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeIntReply(bm.Count(start, end))
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func BitPos(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if len(args) > 5 {
+		return &reply.SyntaxErrReply{}
+	}
+	var val byte
+	switch string(args[1]) {
+	case "0":
+	case "1":
+		val = 1
+	default:
+		return reply.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	if bytes == nil {
+		// missing key is taken as infinite zero bits
+		if val == 1 {
+			return reply.MakeIntReply(-1)
+		}
+		return reply.MakeIntReply(0)
+	}
+
+	bm := bitmap.BitMap(bytes)
+	start, end := int64(0), bm.BitSize()-1
+	if len(args) > 2 {
+		var ok bool
+		var errReply redis.Reply
+		start, end, ok, errReply = parseBitRange(args[2:], bm.BitSize())
+		if errReply != nil {
+			return errReply
+		}
+		if !ok {
+			return reply.MakeIntReply(-1)
+		}
+	}
+	pos := bm.Pos(val, start, end)
+	if pos < 0 && val == 0 && len(args) <= 3 {
+		// without end, the string is taken as padded with zero bits on the right
+		return reply.MakeIntReply(bm.BitSize())
+	}
+	return reply.MakeIntReply(pos)
+}
+
+const (
+	bitOpAnd = iota
+	bitOpOr
+	bitOpXor
+	bitOpNot
+)
+
+// BitOpValues returns the result of BITOP on values, missing values are taken as empty strings
+func BitOpValues(opName string, values [][]byte) ([]byte, redis.Reply) {
+	var op int
+	switch strings.ToUpper(opName) {
+	case "AND":
+		op = bitOpAnd
+	case "OR":
+		op = bitOpOr
+	case "XOR":
+		op = bitOpXor
+	case "NOT":
+		if len(values) != 1 {
+			return nil, reply.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+		op = bitOpNot
+	default:
+		return nil, &reply.SyntaxErrReply{}
+	}
+
+	// result is as long as the longest value, shorter ones are padded with zero bytes
+	size := 0
+	for _, value := range values {
+		if len(value) > size {
+			size = len(value)
+		}
+	}
+	result := make([]byte, size)
+	for i := 0; i < size; i++ {
+		var b byte
+		for j, value := range values {
+			var v byte
+			if i < len(value) {
+				v = value[i]
+			}
+			if j == 0 {
+				b = v
+				continue
+			}
+			switch op {
+			case bitOpAnd:
+				b &= v
+			case bitOpOr:
+				b |= v
+			case bitOpXor:
+				b ^= v
+			}
+		}
+		if op == bitOpNot {
+			b = ^b
+		}
+		result[i] = b
+	}
+	return result, nil
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func BitOp(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[1])
+	values := make([][]byte, len(args)-2)
+	for i, arg := range args[2:] {
+		bytes, err := db.getAsString(string(arg))
+		if err != nil {
+			return err
+		}
+		values[i] = bytes
+	}
+	result, errReply := BitOpValues(string(args[0]), values)
+	if errReply != nil {
+		return errReply
+	}
+	if len(result) == 0 {
+		// empty result removes dest
+		db.Remove(dest)
+		return reply.MakeIntReply(0)
+	}
+	db.Put(dest, &DataEntity{Data: result})
+	db.Persist(dest)
+	return reply.MakeIntReply(int64(len(result)))
+}
+
+/* ---- bitfield ---- */
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+const (
+	bitFieldGet = iota
+	bitFieldSet
+	bitFieldIncrBy
+)
+
+type bitFieldOp struct {
+	kind     int
+	signed   bool
+	width    uint
+	offset   int64
+	value    int64 // value of SET or increment of INCRBY
+	overflow int
+}
+
+// parseBitFieldType parses i1 to i64 and u1 to u63
+func parseBitFieldType(arg []byte) (bool, uint, redis.Reply) {
+	errReply := reply.MakeErrReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(arg) < 2 {
+		return false, 0, errReply
+	}
+	signed := arg[0] == 'i' || arg[0] == 'I'
+	if !signed && arg[0] != 'u' && arg[0] != 'U' {
+		return false, 0, errReply
+	}
+	width, err := strconv.ParseUint(string(arg[1:]), 10, 8)
+	if err != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, errReply
+	}
+	return signed, uint(width), nil
+}
+
+// parseBitFieldOffset parses offset in bits or #N which is multiplied by width
+func parseBitFieldOffset(arg []byte, width uint) (int64, redis.Reply) {
+	errReply := reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	multiply := len(arg) > 0 && arg[0] == '#'
+	if multiply {
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, errReply
+	}
+	if multiply {
+		if offset > maxBitOffset/int64(width) {
+			return 0, errReply
+		}
+		offset *= int64(width)
+	}
+	if offset+int64(width)-1 > maxBitOffset {
+		return 0, errReply
+	}
+	return offset, nil
+}
+
+func parseBitFieldOps(args [][]byte) ([]*bitFieldOp, redis.Reply) {
+	var ops []*bitFieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); i++ {
+		subCmd := strings.ToUpper(string(args[i]))
+		if subCmd == "OVERFLOW" {
+			if i+1 >= len(args) {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, reply.MakeErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i++
+			continue
+		}
+
+		op := &bitFieldOp{overflow: overflow}
+		argCount := 3
+		switch subCmd {
+		case "GET":
+			op.kind = bitFieldGet
+			argCount = 2
+		case "SET":
+			op.kind = bitFieldSet
+		case "INCRBY":
+			op.kind = bitFieldIncrBy
+		default:
+			return nil, &reply.SyntaxErrReply{}
+		}
+		if i+argCount >= len(args) {
+			return nil, &reply.SyntaxErrReply{}
+		}
+		var errReply redis.Reply
+		op.signed, op.width, errReply = parseBitFieldType(args[i+1])
+		if errReply != nil {
+			return nil, errReply
+		}
+		op.offset, errReply = parseBitFieldOffset(args[i+2], op.width)
+		if errReply != nil {
+			return nil, errReply
+		}
+		if argCount == 3 {
+			value, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			op.value = value
+		}
+		ops = append(ops, op)
+		i += argCount
+	}
+	return ops, nil
+}
+
+// unsignedFieldResult returns value+incr limited by overflow policy, ok is false if it overflows under FAIL
+func unsignedFieldResult(value uint64, incr int64, width uint, overflow int) (uint64, bool) {
+	max := uint64(1)<<width - 1
+	maxIncr := int64(max - value)
+	minIncr := -int64(value)
+	if value > max || (incr > 0 && incr > maxIncr) {
+		switch overflow {
+		case overflowSat:
+			return max, true
+		case overflowFail:
+			return 0, false
+		}
+	} else if incr < 0 && incr < minIncr {
+		switch overflow {
+		case overflowSat:
+			return 0, true
+		case overflowFail:
+			return 0, false
+		}
+	}
+	// wrap
+	return (value + uint64(incr)) & max, true
+}
+
+// signedFieldResult returns value+incr limited by overflow policy, ok is false if it overflows under FAIL
+func signedFieldResult(value int64, incr int64, width uint, overflow int) (int64, bool) {
+	max := int64(uint64(1)<<(width-1) - 1)
+	min := -max - 1
+	// the differences may overflow, they are used only after value is known to be in range
+	maxIncr := int64(uint64(max) - uint64(value))
+	minIncr := min - value
+	if value > max || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+		switch overflow {
+		case overflowSat:
+			return max, true
+		case overflowFail:
+			return 0, false
+		}
+	} else if value < min || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+		switch overflow {
+		case overflowSat:
+			return min, true
+		case overflowFail:
+			return 0, false
+		}
+	}
+	// wrap, sign bit of the field is extended to the higher bits
+	result := uint64(value) + uint64(incr)
+	if width < 64 {
+		mask := ^uint64(0) << width
+		if result&(uint64(1)<<(width-1)) != 0 {
+			result |= mask
+		} else {
+			result &^= mask
+		}
+	}
+	return int64(result), true
+}
+
+// getField returns the field as int64, sign bit is extended for signed field
+func getField(bm *bitmap.BitMap, op *bitFieldOp) int64 {
+	value := bm.GetField(op.offset, op.width)
+	if op.signed && op.width < 64 && value&(uint64(1)<<(op.width-1)) != 0 {
+		value |= ^uint64(0) << op.width
+	}
+	return int64(value)
+}
+
+// execBitField executes ops on a copy of bytes, changed is false if nothing is written
+func execBitField(bytes []byte, ops []*bitFieldOp) ([]redis.Reply, []byte, bool) {
+	bm := bitmap.FromBytes(bytes)
+	results := make([]redis.Reply, len(ops))
+	changed := false
+	for i, op := range ops {
+		old := getField(bm, op)
+		if op.kind == bitFieldGet {
+			results[i] = reply.MakeIntReply(old)
+			continue
+		}
+
+		// SET checks whether the new value fits in field, INCRBY checks the sum
+		value, incr := old, op.value
+		if op.kind == bitFieldSet {
+			value, incr = op.value, 0
+		}
+		var result int64
+		ok := true
+		if op.signed {
+			result, ok = signedFieldResult(value, incr, op.width, op.overflow)
+		} else {
+			var unsigned uint64
+			unsigned, ok = unsignedFieldResult(uint64(value), incr, op.width, op.overflow)
+			result = int64(unsigned)
+		}
+		if !ok {
+			results[i] = &reply.NullBulkReply{}
+			continue
+		}
+		bm.SetField(op.offset, op.width, uint64(result))
+		changed = true
+		if op.kind == bitFieldSet {
+			results[i] = reply.MakeIntReply(old)
+		} else {
+			results[i] = reply.MakeIntReply(result)
+		}
+	}
+	return results, bm.ToBytes(), changed
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+func BitField(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ops, errReply := parseBitFieldOps(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	results, value, changed := execBitField(bytes, ops)
+	if changed {
+		db.Put(key, &DataEntity{Data: value})
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+// BITFIELD_RO key [GET type offset] ...
+func BitFieldRO(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ops, errReply := parseBitFieldOps(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	for _, op := range ops {
+		if op.kind != bitFieldGet {
+			return reply.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+	}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	results, _, _ := execBitField(bytes, ops)
+	return reply.MakeMultiRawReply(results)
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"testing"
+)
+
+func TestSetBit(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	asserts.AssertIntReply(t, SetBit(testDB, toArgs("bm", "7", "1")), 0)
+	asserts.AssertIntReply(t, SetBit(testDB, toArgs("bm", "7", "0")), 1)
+	asserts.AssertIntReply(t, SetBit(testDB, toArgs("bm", "1", "1")), 0)
+	asserts.AssertIntReply(t, SetBit(testDB, toArgs("bm", "23", "1")), 0)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("bm")), "\x40\x00\x01")
+	asserts.AssertIntReply(t, GetBit(testDB, toArgs("bm", "1")), 1)
+	asserts.AssertIntReply(t, GetBit(testDB, toArgs("bm", "7")), 0)
+	asserts.AssertIntReply(t, GetBit(testDB, toArgs("bm", "100")), 0)
+	asserts.AssertIntReply(t, GetBit(testDB, toArgs("none", "0")), 0)
+
+	// bits of string
+	Set(testDB, toArgs("str", "a"))
+	asserts.AssertIntReply(t, SetBit(testDB, toArgs("str", "6", "1")), 0)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("str")), "c")
+
+	asserts.AssertErrReply(t, SetBit(testDB, toArgs("bm", "-1", "1")), "ERR bit offset is not an integer or out of range")
+	asserts.AssertErrReply(t, SetBit(testDB, toArgs("bm", "4294967296", "1")), "ERR bit offset is not an integer or out of range")
+	asserts.AssertErrReply(t, SetBit(testDB, toArgs("bm", "0", "2")), "ERR bit is not an integer or out of range")
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, SetBit(testDB, toArgs("list", "0", "1")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertErrReply(t, GetBit(testDB, toArgs("list", "0")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestBitCount(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("k", "foobar"))
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("k")), 26)
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("k", "0", "0")), 4)
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("k", "1", "1")), 6)
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("k", "1", "1", "BYTE")), 6)
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("k", "5", "30", "BIT")), 17)
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("k", "-2", "-1")), 7)
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("k", "3", "1")), 0)
+	asserts.AssertIntReply(t, BitCount(testDB, toArgs("none")), 0)
+	asserts.AssertErrReply(t, BitCount(testDB, toArgs("k", "0")), "Err syntax error")
+	asserts.AssertErrReply(t, BitCount(testDB, toArgs("k", "0", "1", "BITS")), "Err syntax error")
+}
+
+func TestBitPos(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("k", "\xff\xf0\x00"))
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("k", "0")), 12)
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("k", "1", "2")), -1)
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("k", "1", "1")), 8)
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("k", "1", "7", "15", "BIT")), 7)
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("k", "0", "-1", "-1", "BYTE")), 16)
+
+	// clear bits are searched in zero padding only if end is not given
+	Set(testDB, toArgs("ones", "\xff\xff"))
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("ones", "0")), 16)
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("ones", "0", "0")), 16)
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("ones", "0", "0", "-1")), -1)
+
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("none", "0")), 0)
+	asserts.AssertIntReply(t, BitPos(testDB, toArgs("none", "1")), -1)
+	asserts.AssertErrReply(t, BitPos(testDB, toArgs("k", "2")), "ERR The bit argument must be 1 or 0.")
+}
+
+func TestBitOp(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("a", "\xf0\x0f"))
+	Set(testDB, toArgs("b", "\x3c"))
+	asserts.AssertIntReply(t, BitOp(testDB, toArgs("AND", "dest", "a", "b")), 2)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("dest")), "\x30\x00")
+	asserts.AssertIntReply(t, BitOp(testDB, toArgs("or", "dest", "a", "b", "none")), 2)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("dest")), "\xfc\x0f")
+	asserts.AssertIntReply(t, BitOp(testDB, toArgs("XOR", "dest", "a", "b")), 2)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("dest")), "\xcc\x0f")
+	asserts.AssertIntReply(t, BitOp(testDB, toArgs("NOT", "dest", "a")), 2)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("dest")), "\x0f\xf0")
+
+	// dest is overwritten with ttl removed, empty result removes dest
+	Expire(testDB, toArgs("dest", "100"))
+	BitOp(testDB, toArgs("AND", "dest", "a", "b"))
+	asserts.AssertIntReply(t, TTL(testDB, toArgs("dest")), -1)
+	asserts.AssertIntReply(t, BitOp(testDB, toArgs("AND", "dest", "none")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("dest")), 0)
+
+	asserts.AssertErrReply(t, BitOp(testDB, toArgs("NOT", "dest", "a", "b")), "ERR BITOP NOT must be called with a single source key.")
+	asserts.AssertErrReply(t, BitOp(testDB, toArgs("NAND", "dest", "a", "b")), "Err syntax error")
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, BitOp(testDB, toArgs("AND", "dest", "a", "list")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func assertRawReply(t *testing.T, actual redis.Reply, expected string) {
+	t.Helper()
+	if string(actual.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, actual.ToBytes())
+	}
+}
+
+func TestBitField(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	result := BitField(testDB, toArgs("bf", "SET", "i8", "0", "100", "GET", "u4", "0", "INCRBY", "i8", "#1", "-5"))
+	assertRawReply(t, result, "*3\r\n:0\r\n:6\r\n:-5\r\n")
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("bf")), "\x64\xfb")
+
+	// overflow of unsigned and signed fields
+	result = BitField(testDB, toArgs("bf", "SET", "u8", "0", "250",
+		"INCRBY", "u8", "0", "10",
+		"OVERFLOW", "SAT", "INCRBY", "u8", "0", "300", "INCRBY", "i8", "#1", "-200",
+		"OVERFLOW", "FAIL", "INCRBY", "u8", "0", "1", "SET", "u2", "0", "4"))
+	assertRawReply(t, result, "*6\r\n:100\r\n:4\r\n:255\r\n:-128\r\n$-1\r\n$-1\r\n")
+	result = BitField(testDB, toArgs("bf", "SET", "i8", "8", "128", "GET", "i8", "8", "INCRBY", "i64", "0", "1"))
+	assertRawReply(t, result, "*3\r\n:-128\r\n:-128\r\n:-36028797018963967\r\n")
+	result = BitField(testDB, toArgs("bf", "OVERFLOW", "SAT", "SET", "i64", "0", "9223372036854775807", "INCRBY", "i64", "0", "1"))
+	assertRawReply(t, result, "*2\r\n:-36028797018963967\r\n:9223372036854775807\r\n")
+
+	// GET doesn't create key
+	result = BitField(testDB, toArgs("none", "GET", "u8", "0"))
+	assertRawReply(t, result, "*1\r\n:0\r\n")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("none")), 0)
+	result = BitFieldRO(testDB, toArgs("bf", "GET", "u8", "0"))
+	assertRawReply(t, result, "*1\r\n:127\r\n")
+
+	asserts.AssertErrReply(t, BitFieldRO(testDB, toArgs("bf", "SET", "u8", "0", "1")), "ERR BITFIELD_RO only supports the GET subcommand")
+	asserts.AssertErrReply(t, BitField(testDB, toArgs("bf", "GET", "u64", "0")),
+		"ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	asserts.AssertErrReply(t, BitField(testDB, toArgs("bf", "GET", "u8", "-1")), "ERR bit offset is not an integer or out of range")
+	asserts.AssertErrReply(t, BitField(testDB, toArgs("bf", "OVERFLOW", "NONE")), "ERR Invalid OVERFLOW type specified")
+	asserts.AssertErrReply(t, BitField(testDB, toArgs("bf", "SET", "u8", "0")), "Err syntax error")
+	if _, ok := BitField(testDB, toArgs("bf")).(*reply.MultiRawReply); !ok {
+		t.Error("expected empty array")
+	}
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"math"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * blocking commands such as BZPOPMIN return null array if there is nothing to pop,
+ * Exec waits for writes on their keys and runs them again until timeout.
+ * waiting ends if the connection is closed by server such as CLIENT KILL, or by peer if the connection is a closeWatcher,
+ * so values are not popped for clients gone
+ */
+
+// closeWatcher is implemented by connections which could notice peer closing while handler is blocked
+type closeWatcher interface {
+	// WatchClose calls onClose if peer closes connection before stop is called
+	WatchClose(onClose func()) (stop func())
+}
+
+type blockedClient struct {
+	conn redis.Connection
+	keys []string
+	// signaled after keys are written, buffered so signals don't block writers
+	wakeup chan struct{}
+	// closed after the connection is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type blockingState struct {
+	mu sync.Mutex
+	// key -> clients blocked on it
+	waiters map[string]map[*blockedClient]struct{}
+	clients map[redis.Connection]*blockedClient
+	// count of blocked clients, writers skip signaling if there is none
+	count atomic.Int32
+}
+
+// parseBlockingTimeout parses timeout in seconds, 0 means blocking forever
+func parseBlockingTimeout(arg []byte) (time.Duration, redis.Reply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, reply.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, reply.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (db *DB) block(c redis.Connection, keys []string) *blockedClient {
+	client := &blockedClient{
+		conn:   c,
+		keys:   keys,
+		wakeup: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	state := &db.blocking
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.waiters == nil {
+		state.waiters = make(map[string]map[*blockedClient]struct{})
+		state.clients = make(map[redis.Connection]*blockedClient)
+	}
+	for _, key := range keys {
+		waiters, ok := state.waiters[key]
+		if !ok {
+			waiters = make(map[*blockedClient]struct{})
+			state.waiters[key] = waiters
+		}
+		waiters[client] = struct{}{}
+	}
+	if c != nil {
+		state.clients[c] = client
+	}
+	state.count.Add(1)
+	return client
+}
+
+func (db *DB) unblock(client *blockedClient) {
+	state := &db.blocking
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for _, key := range client.keys {
+		waiters := state.waiters[key]
+		delete(waiters, client)
+		if len(waiters) == 0 {
+			delete(state.waiters, key)
+		}
+	}
+	if client.conn != nil && state.clients[client.conn] == client {
+		delete(state.clients, client.conn)
+	}
+	state.count.Add(-1)
+}
+
+// signalKeys wakes up clients blocked on keys, called after keys are written by commands other than blocking ones
+func (db *DB) signalKeys(keys ...string) {
+	state := &db.blocking
+	if state.count.Load() == 0 {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for _, key := range keys {
+		for client := range state.waiters[key] {
+			select {
+			case client.wakeup <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// cancelBlocking returns blocked command of closed connection
+func (db *DB) cancelBlocking(c redis.Connection) {
+	state := &db.blocking
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if client, ok := state.clients[c]; ok {
+		client.close()
+	}
+}
+
+func (client *blockedClient) close() {
+	client.closeOnce.Do(func() {
+		close(client.closed)
+	})
+}
+
+// BlockedClients returns count of clients waiting in blocking commands
+func (db *DB) BlockedClients() int {
+	return int(db.blocking.count.Load())
+}
+
+// execBlocking runs blocking command until it returns something other than null array, or timeout which is the last argument
+func (db *DB) execBlocking(c redis.Connection, command *command, args [][]byte) redis.Reply {
+	timeout, errReply := parseBlockingTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	// registered before the first try, so writes between the try and waiting are not missed
+	client := db.block(c, command.keys(args))
+	defer db.unblock(client)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	var stopWatching func()
+	defer func() {
+		if stopWatching != nil {
+			stopWatching()
+		}
+	}()
+	for {
+		select {
+		case <-client.closed:
+			// closing wins over wakeup, nothing is popped for clients gone
+			return &reply.NullMultiBulkReply{}
+		default:
+		}
+		result := db.execWrite(command, args)
+		if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+			return result
+		}
+		if watcher, ok := c.(closeWatcher); ok && stopWatching == nil {
+			stopWatching = watcher.WatchClose(client.close)
+		}
+		select {
+		case <-client.wakeup:
+		case <-deadline:
+			return &reply.NullMultiBulkReply{}
+		case <-client.closed:
+			return &reply.NullMultiBulkReply{}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// flags of commands
+const (
+	flagWrite = 1 << iota
+	flagReadOnly
+	flagDenyOOM
+	flagPubSub
+	flagAdmin
+	flagNoScript
+	flagFast
+	// propagated although dataset is not modified, such as PUBLISH
+	flagMayReplicate
+	// waits for keys to be written, such as BZPOPMIN
+	flagBlocking
+)
+
+var flagNames = []struct {
+	flag int
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadOnly, "readonly"},
+	{flagDenyOOM, "denyoom"},
+	{flagPubSub, "pubsub"},
+	{flagAdmin, "admin"},
+	{flagNoScript, "noscript"},
+	{flagFast, "fast"},
+	{flagMayReplicate, "may_replicate"},
+	{flagBlocking, "blocking"},
+}
+
+// command describes arity, flags and key positions of a command
+// positions are indices in args including command name: keys are args[firstKey], args[firstKey+keyStep] ... args[lastKey]
+// negative lastKey counts from the end, firstKey is 0 for commands without keys
+type command struct {
+	name     string
+	executor CmdFunc
+	// exact count of args including command name, or minimum count if negative
+	arity      int
+	flags      int
+	firstKey   int
+	lastKey    int
+	keyStep    int
+	categories []string
+	// extracts keys of commands which could not be described by positions, such as EVAL
+	getKeys func(args [][]byte) []string
+	// converts successful write command into commands appended to aof, the command itself is appended if nil
+	// called before locks of keys are released, returns nil if dataset is not changed
+	rewrite func(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply
+}
+
+var cmdTable = make(map[string]*command)
+
+func registerCommand(name string, executor CmdFunc, arity int, flags int, firstKey int, lastKey int, keyStep int,
+	categories ...string) *command {
+	cmd := &command{
+		name:       name,
+		executor:   executor,
+		arity:      arity,
+		flags:      flags,
+		firstKey:   firstKey,
+		lastKey:    lastKey,
+		keyStep:    keyStep,
+		categories: categories,
+	}
+	cmdTable[name] = cmd
+	return cmd
+}
+
+func (cmd *command) keysBy(getKeys func(args [][]byte) []string) *command {
+	cmd.getKeys = getKeys
+	return cmd
+}
+
+// keysAfterNumKeys gets keys of commands like `LMPOP numkeys key [key ...] ...`
+func keysAfterNumKeys(args [][]byte) []string {
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return nil
+	}
+	keys := make([]string, numKeys)
+	for i, arg := range args[2 : 2+numKeys] {
+		keys[i] = string(arg)
+	}
+	return keys
+}
+
+func (cmd *command) rewriteBy(rewrite func(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply) *command {
+	cmd.rewrite = rewrite
+	return cmd
+}
+
+// validArity checks count of args including command name
+func (cmd *command) validArity(args [][]byte) bool {
+	if cmd.arity >= 0 {
+		return len(args) == cmd.arity
+	}
+	return len(args) >= -cmd.arity
+}
+
+func (cmd *command) hasKeys() bool {
+	return cmd.firstKey > 0 || cmd.getKeys != nil
+}
+
+// keys returns keys in args including command name, args must have valid arity
+func (cmd *command) keys(args [][]byte) []string {
+	if cmd.getKeys != nil {
+		return cmd.getKeys(args)
+	}
+	if cmd.firstKey <= 0 {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	keys := make([]string, 0, (last-cmd.firstKey)/cmd.keyStep+1)
+	for i := cmd.firstKey; i <= last && i < len(args); i += cmd.keyStep {
+		keys = append(keys, string(args[i]))
+	}
+	return keys
+}
+
+// keys of EVAL, EVALSHA, FCALL and FCALL_RO: script numkeys [key ...] [arg ...]
+func scriptKeys(args [][]byte) []string {
+	numKeys, err := strconv.Atoi(string(args[2]))
+	if err != nil || numKeys < 0 || numKeys > len(args)-3 {
+		return nil
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+3])
+	}
+	return keys
+}
+
+// keys of MIGRATE: host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+func migrateKeys(args [][]byte) []string {
+	if len(args[3]) > 0 {
+		return []string{string(args[3])}
+	}
+	for i := 6; i < len(args); i++ {
+		if strings.ToUpper(string(args[i])) == "KEYS" {
+			keys := make([]string, 0, len(args)-i-1)
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			return keys
+		}
+	}
+	return nil
+}
+
+// GetKeys returns keys of command line according to command table, for routing of cluster
+func GetKeys(args [][]byte) ([]string, reply.ErrorReply) {
+	cmd, ok := cmdTable[strings.ToLower(string(args[0]))]
+	if !ok {
+		return nil, reply.MakeErrReply("ERR Invalid command specified")
+	}
+	if !cmd.validArity(args) {
+		return nil, reply.MakeErrReply("ERR Invalid number of arguments specified for command")
+	}
+	if !cmd.hasKeys() {
+		return nil, reply.MakeErrReply("ERR The command has no key arguments")
+	}
+	return cmd.keys(args), nil
+}
+
+// KeyedCommands returns names of commands which have key arguments
+func KeyedCommands() []string {
+	names := make([]string, 0, len(cmdTable))
+	for name, cmd := range cmdTable {
+		if cmd.hasKeys() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// COMMAND [COUNT | INFO [command ...] | GETKEYS command [arg ...]]
+func Command(db *DB, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		names := make([]string, 0, len(cmdTable))
+		for name := range cmdTable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		result := make([]redis.Reply, len(names))
+		for i, name := range names {
+			result[i] = makeCommandInfo(cmdTable[name])
+		}
+		return reply.MakeMultiRawReply(result)
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "count":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'command|count' command")
+		}
+		return reply.MakeIntReply(int64(len(cmdTable)))
+	case "info":
+		result := make([]redis.Reply, len(args)-1)
+		for i, name := range args[1:] {
+			if cmd, ok := cmdTable[strings.ToLower(string(name))]; ok {
+				result[i] = makeCommandInfo(cmd)
+			} else {
+				result[i] = &reply.NullBulkReply{}
+			}
+		}
+		return reply.MakeMultiRawReply(result)
+	case "getkeys":
+		if len(args) < 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'command|getkeys' command")
+		}
+		keys, errReply := GetKeys(args[1:])
+		if errReply != nil {
+			return errReply
+		}
+		result := make([][]byte, len(keys))
+		for i, key := range keys {
+			result[i] = []byte(key)
+		}
+		return reply.MakeMultiBulkReply(result)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try COMMAND HELP.")
+}
+
+// makeCommandInfo replies name, arity, flags, first key, last key, step and acl categories
+func makeCommandInfo(cmd *command) redis.Reply {
+	flags := make([]redis.Reply, 0)
+	for _, item := range flagNames {
+		if cmd.flags&item.flag != 0 {
+			flags = append(flags, reply.MakeStatusReply(item.name))
+		}
+	}
+	if cmd.getKeys != nil {
+		flags = append(flags, reply.MakeStatusReply("movablekeys"))
+	}
+
+	categories := make([]redis.Reply, 0)
+	for _, item := range []struct {
+		flag     int
+		category string
+	}{
+		{flagWrite, "write"}, {flagReadOnly, "read"}, {flagAdmin, "admin"}, {flagPubSub, "pubsub"}, {flagFast, "fast"},
+	} {
+		if cmd.flags&item.flag != 0 {
+			categories = append(categories, reply.MakeStatusReply("@"+item.category))
+		}
+	}
+	if cmd.flags&flagFast == 0 {
+		categories = append(categories, reply.MakeStatusReply("@slow"))
+	}
+	if cmd.flags&flagAdmin != 0 {
+		categories = append(categories, reply.MakeStatusReply("@dangerous"))
+	}
+	for _, category := range cmd.categories {
+		// categories implied by flags are not repeated
+		if (category == "slow" && cmd.flags&flagFast == 0) || (category == "dangerous" && cmd.flags&flagAdmin != 0) {
+			continue
+		}
+		categories = append(categories, reply.MakeStatusReply("@"+category))
+	}
+
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeBulkReply([]byte(cmd.name)),
+		reply.MakeIntReply(int64(cmd.arity)),
+		reply.MakeMultiRawReply(flags),
+		reply.MakeIntReply(int64(cmd.firstKey)),
+		reply.MakeIntReply(int64(cmd.lastKey)),
+		reply.MakeIntReply(int64(cmd.keyStep)),
+		reply.MakeMultiRawReply(categories),
+	})
+}
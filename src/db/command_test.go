@@ -0,0 +1,49 @@
+package db
+
+import (
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"testing"
+)
+
+func TestCommandInfo(t *testing.T) {
+	asserts.AssertIntReply(t, Command(testDB, toArgs("count")), len(cmdTable))
+
+	result := Command(testDB, toArgs("info", "GET", "none"))
+	expected := "*2\r\n*7\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+		"*3\r\n+@read\r\n+@fast\r\n+@string\r\n$-1\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.ToBytes())
+	}
+	result = Command(testDB, toArgs("info", "info"))
+	expected = "*1\r\n*7\r\n$4\r\ninfo\r\n:-1\r\n*0\r\n:0\r\n:0\r\n:0\r\n*2\r\n+@slow\r\n+@dangerous\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.ToBytes())
+	}
+	result = Command(testDB, toArgs())
+	if multi, ok := result.(*reply.MultiRawReply); !ok || len(multi.Replies) != len(cmdTable) {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	result := Command(testDB, toArgs("getkeys", "mset", "a", "1", "b", "2"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b"})
+	result = Command(testDB, toArgs("getkeys", "eval", "return 1", "2", "a", "b", "c"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b"})
+	result = Command(testDB, toArgs("getkeys", "ping"))
+	asserts.AssertErrReply(t, result, "ERR The command has no key arguments")
+	result = Command(testDB, toArgs("getkeys", "get"))
+	asserts.AssertErrReply(t, result, "ERR Invalid number of arguments specified for command")
+	result = Command(testDB, toArgs("getkeys", "none"))
+	asserts.AssertErrReply(t, result, "ERR Invalid command specified")
+}
+
+func TestCommandArity(t *testing.T) {
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("get")), "ERR wrong number of arguments for 'get' command")
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("get", "a", "b")), "ERR wrong number of arguments for 'get' command")
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("del")), "ERR wrong number of arguments for 'del' command")
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("none")), "ERR unknown command 'none'")
+	result := Eval(testDB, toArgs("return redis.pcall('GET')['err']", "0"))
+	asserts.AssertBulkReply(t, result, "ERR Wrong number of args calling Redis command from script")
+}
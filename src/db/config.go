@@ -0,0 +1,80 @@
+package db
+
+import (
+	"my-godis/src/config"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"my-godis/src/stats"
+	"strings"
+)
+
+// CONFIG GET pattern | SET parameter value [parameter value ...] | REWRITE | RESETSTAT
+func Config(db *DB, args [][]byte) redis.Reply {
+	switch strings.ToLower(string(args[0])) {
+	case "get":
+		if len(args) < 2 {
+			return &reply.ArgNumErrReply{Cmd: "config|get"}
+		}
+		seen := make(map[string]bool)
+		result := make([][]byte, 0)
+		for _, pattern := range args[1:] {
+			for _, pair := range config.Get(string(pattern)) {
+				if seen[pair[0]] {
+					continue
+				}
+				seen[pair[0]] = true
+				result = append(result, []byte(pair[0]), []byte(pair[1]))
+			}
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "set":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return &reply.ArgNumErrReply{Cmd: "config|set"}
+		}
+		for i := 1; i < len(args); i += 2 {
+			errReply := db.setConfig(string(args[i]), string(args[i+1]))
+			if errReply != nil {
+				return errReply
+			}
+		}
+		return &reply.OkReply{}
+	case "rewrite":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "config|rewrite"}
+		}
+		err := config.Rewrite()
+		if err != nil {
+			return reply.MakeErrReply("ERR Rewriting config file: " + err.Error())
+		}
+		return &reply.OkReply{}
+	case "resetstat":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "config|resetstat"}
+		}
+		stats.ResetStat()
+		return &reply.OkReply{}
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CONFIG HELP.")
+}
+
+// set config and apply side effect of it
+func (db *DB) setConfig(key string, value string) reply.ErrorReply {
+	key = strings.ToLower(key)
+	wasAppendOnly := config.Properties().AppendOnly
+	err := config.Set(key, value)
+	if err != nil {
+		return reply.MakeErrReply("ERR CONFIG SET failed (possibly related to argument '" + key + "') - " + err.Error())
+	}
+	if key == "appendonly" && wasAppendOnly != config.Properties().AppendOnly {
+		if config.Properties().AppendOnly {
+			err = db.enableAof()
+			if err != nil {
+				_ = config.Set(key, "no")
+				return reply.MakeErrReply("ERR CONFIG SET failed (possibly related to argument 'appendonly') - " + err.Error())
+			}
+		} else {
+			db.stopAof()
+		}
+	}
+	return nil
+}
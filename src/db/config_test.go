@@ -0,0 +1,107 @@
+package db
+
+import (
+	"my-godis/src/config"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"my-godis/src/stats"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigSetAppendOnly(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+		})
+	}()
+	testDB.Exec(nil, toArgs("set", "before", "1"))
+
+	result := Config(testDB, toArgs("set", "appendonly", "yes"))
+	if _, ok := result.(*reply.OkReply); !ok {
+		t.Fatal("expected OK, actually " + string(result.ToBytes()))
+	}
+	testDB.Exec(nil, toArgs("set", "after", "2"))
+	Config(testDB, toArgs("set", "appendonly", "no"))
+	testDB.Exec(nil, toArgs("set", "stopped", "3"))
+
+	content := readAof(t)
+	for _, key := range []string{"before", "after"} {
+		if !strings.Contains(content, key) {
+			t.Errorf("expected %s in aof", key)
+		}
+	}
+	if strings.Contains(content, "stopped") {
+		t.Error("unexpected command in aof after aof stopped")
+	}
+	asserts.AssertMultiBulkReply(t, Config(testDB, toArgs("get", "appendonly")), []string{"appendonly", "no"})
+}
+
+func TestConfigSetMaxMemory(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	testDB.Exec(nil, toArgs("set", "a", "1"))
+	asserts.AssertStatusReply(t, Config(testDB, toArgs("set", "maxmemory", "1")), "OK")
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.MaxMemory = 0
+		})
+	}()
+	stats.SampleMemory()
+
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("set", "b", "1")), "OOM command not allowed when used memory > 'maxmemory'.")
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("rpush", "l", "1")), "OOM command not allowed when used memory > 'maxmemory'.")
+	// commands freeing memory and read commands are still served
+	asserts.AssertBulkReply(t, testDB.Exec(nil, toArgs("get", "a")), "1")
+	asserts.AssertIntReply(t, testDB.Exec(nil, toArgs("del", "a")), 1)
+
+	asserts.AssertStatusReply(t, Config(testDB, toArgs("set", "maxmemory", "0")), "OK")
+	asserts.AssertStatusReply(t, testDB.Exec(nil, toArgs("set", "b", "1")), "OK")
+}
+
+func TestConfigSetAppendOnlyUnderWrites(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	defer func() {
+		Config(testDB, toArgs("set", "appendonly", "no"))
+	}()
+	for i := 0; i < 10000; i++ {
+		testDB.Exec(nil, toArgs("rpush", "list", strconv.Itoa(i)))
+		testDB.Exec(nil, toArgs("hset", "hash", strconv.Itoa(i), "v"))
+		testDB.Exec(nil, toArgs("set", "k"+strconv.Itoa(i), "v"))
+	}
+	// values are modified in place while aof is enabling
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 10000; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			testDB.Exec(nil, toArgs("rpush", "list", strconv.Itoa(i)))
+			testDB.Exec(nil, toArgs("hset", "hash", strconv.Itoa(i), "v"))
+		}
+	}()
+	asserts.AssertStatusReply(t, Config(testDB, toArgs("set", "appendonly", "yes")), "OK")
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	listLen := LLen(testDB, toArgs("list"))
+	hashLen := HLen(testDB, toArgs("hash"))
+	Config(testDB, toArgs("set", "appendonly", "no"))
+
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	assertRawReply(t, LLen(db, toArgs("list")), string(listLen.ToBytes()))
+	assertRawReply(t, HLen(db, toArgs("hash")), string(hashLen.ToBytes()))
+}
@@ -2,18 +2,24 @@ package db
 
 import (
 	"fmt"
+	"my-godis/src/aof"
 	"my-godis/src/config"
 	"my-godis/src/datastruct/dict"
 	List "my-godis/src/datastruct/list"
 	"my-godis/src/datastruct/lock"
 	"my-godis/src/interface/redis"
+	"my-godis/src/latency"
 	"my-godis/src/lib/logger"
+	"my-godis/src/monitor"
 	"my-godis/src/pubsub"
 	"my-godis/src/redis/reply"
+	"my-godis/src/slowlog"
+	"my-godis/src/stats"
 	"os"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,11 +42,13 @@ type DB struct {
 	Data dict.Dict
 	// key -> expireTime (time.Time)
 	TTLMap dict.Dict
+	// key -> field -> expireTime (map[string]time.Time), ttl of hash fields
+	FieldTTLMap dict.Dict
 	// channel -> list<*client>
 	SubMap dict.Dict
 
 	// dict will ensure thread safety of its method
-	// use this mutex for complicated command only, eg. rpush, incr ...
+	// locks of keys are held by Exec while executing commands, according to key positions in command table
 	Locker *lock.Locks
 
 	// TimerTask interval
@@ -54,50 +62,87 @@ type DB struct {
 	aofChan     chan *reply.MultiBulkReply
 	aofFile     *os.File
 	aofFilename string
-
-	aofRewriteChan chan *reply.MultiBulkReply
-	pausingAof     sync.RWMutex
+	// protect aofChan while aof is started or stopped at runtime
+	aofMu sync.RWMutex
+	// closed after aof goroutine exits
+	aofFinished chan struct{}
+
+	// base and incremental files of aof, replaced while rewriting
+	aofManifest *aof.Manifest
+	// protect aofFile and aofManifest while switching files
+	pausingAof sync.RWMutex
+	// 1 while rewriting
+	aofRewriting int32
+	// write commands hold read lock, rewrite takes snapshot of dataset with write lock
+	snapshotMu sync.RWMutex
+	// size of aof file, and size after latest rewrite for auto-aof-rewrite-percentage
+	aofCurrentSize int64
+	aofBaseSize    int64
+
+	// count of commands sent to aofChan, protected by aofSeqMu
+	aofSeq   uint64
+	aofSeqMu sync.Mutex
+	// count of commands flushed by aof goroutine and last write error, protected by aofSyncMu
+	// clients wait on aofSyncCond for fsync while appendfsync is always
+	aofSynced   uint64
+	aofErr      error
+	aofSyncMu   sync.Mutex
+	aofSyncCond *sync.Cond
+
+	// compiled lua scripts
+	scripts scriptCache
+	// function libraries loaded by FUNCTION LOAD
+	functions functionRegistry
+	// scripts run one by one, script is the one in execution or nil
+	scriptMu sync.Mutex
+	script   atomic.Pointer[runningScript]
+
+	// clients blocked by commands such as BZPOPMIN
+	blocking blockingState
+
+	// redis_mode in INFO, ModeCluster if db serves a cluster node
+	Mode string
 }
 
-var router = MakeRouter()
+// values of DB.Mode
+const (
+	ModeStandalone = "standalone"
+	ModeCluster    = "cluster"
+)
 
 func MakeDB() *DB {
 	db := &DB{
-		Data:     dict.MakeConcurrent(dataDictSize),
-		TTLMap:   dict.MakeConcurrent(ttlDictSize),
-		Locker:   lock.Make(lockerSize),
-		interval: 5 * time.Second,
-		hub:      pubsub.MakeHub(),
+		Data:        dict.MakeConcurrent(dataDictSize),
+		TTLMap:      dict.MakeConcurrent(ttlDictSize),
+		FieldTTLMap: dict.MakeConcurrent(ttlDictSize),
+		Locker:      lock.Make(lockerSize),
+		interval:    5 * time.Second,
+		hub:         pubsub.MakeHub(),
+		Mode:        ModeStandalone,
 	}
+	db.aofSyncCond = sync.NewCond(&db.aofSyncMu)
 
 	// aof
-	if config.Properties.AppendOnly {
-		db.aofFilename = config.Properties.AppendFilename
-		db.loadAof(0)
-		aofFile, err := os.OpenFile(db.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if config.Properties().AppendOnly {
+		err := db.loadAofOnStartup()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		err = db.startAof()
 		if err != nil {
 			logger.Warn(err)
-		} else {
-			db.aofFile = aofFile
-			db.aofChan = make(chan *reply.MultiBulkReply, aofQueueSize)
 		}
-		go func() {
-			db.handleAof()
-		}()
 	}
 
 	// start timer
 	db.TimerTask()
+	stats.StartSampler()
+	db.registerMetrics()
 	return db
 }
 
 func (db *DB) Close() {
-	if db.aofFile != nil {
-		err := db.aofFile.Close()
-		if err != nil {
-			logger.Warn(err)
-		}
-	}
+	db.stopAof()
 }
 
 func (db *DB) Exec(c redis.Connection, args [][]byte) (result redis.Reply) {
@@ -109,37 +154,128 @@ func (db *DB) Exec(c redis.Connection, args [][]byte) (result redis.Reply) {
 	}()
 
 	cmd := strings.ToLower(string(args[0]))
+	stats.Incr(&stats.TotalCommandsProcessed)
+
+	if cmd != "monitor" {
+		monitor.Default.Feed(c, args)
+	}
+	start := time.Now()
+	result = db.execCommand(c, cmd, args)
+	if IsKnownCommand(cmd) {
+		RecordCommand(c, cmd, args, start, time.Since(start))
+	}
+	return
+}
+
+func IsKnownCommand(cmd string) bool {
+	_, ok := cmdTable[cmd]
+	return ok
+}
+
+// RecordCommand records execution of command into metrics, slowlog and latency monitor
+func RecordCommand(c redis.Connection, cmd string, args [][]byte, start time.Time, elapsed time.Duration) {
+	observeCommand(cmd, elapsed)
+	addr := ""
+	if c != nil {
+		addr = c.RemoteAddr()
+	}
+	slowlog.Default.Record(args, addr, start, elapsed)
+	latency.Default.Record(latency.EventCommand, elapsed)
+}
+
+// Dispatch executes command without recording stats, for cluster which records commands by itself
+func (db *DB) Dispatch(c redis.Connection, args [][]byte) redis.Reply {
+	cmd := strings.ToLower(string(args[0]))
+	return db.execCommand(c, cmd, args)
+}
 
-	// special commands
-	if cmd == "subscribe" {
-		if len(args) < 2 {
-			return &reply.ArgNumErrReply{Cmd: "subscribe"}
+func (db *DB) execCommand(c redis.Connection, cmd string, args [][]byte) redis.Reply {
+	if !isScriptKill(cmd, args) {
+		if errReply := db.checkScriptBusy(); errReply != nil {
+			return errReply
 		}
-		return pubsub.Subscribe(db.hub, c, args[1:])
-	} else if cmd == "publish" {
-		return pubsub.Publish(db.hub, args[1:])
-	} else if cmd == "unsubscribe" {
-		return pubsub.UnSubscribe(db.hub, c, args[1:])
-	} else if cmd == "bgrewriteaof" {
-		// aof.go imports router.go, router.go cannot import BGRewriteAOF from aof.go
-		reply := BGRewriteAOF(db, args[1:])
-		return reply
 	}
 
-	// normal commands
-	cmdFunc, ok := router[cmd]
+	command, ok := cmdTable[cmd]
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmd + "'")
 	}
-	if len(args) > 1 {
-		result = cmdFunc(db, args[1:])
+	if !command.validArity(args) {
+		return &reply.ArgNumErrReply{Cmd: cmd}
+	}
+	// commands need connection
+	switch cmd {
+	case "subscribe":
+		return pubsub.Subscribe(db.hub, c, args[1:])
+	case "unsubscribe":
+		return pubsub.UnSubscribe(db.hub, c, args[1:])
+	case "monitor":
+		if monitor.Default.Add(c) {
+			// OK is written by the monitor goroutine ahead of fed commands
+			return &reply.NoReply{}
+		}
+		return &reply.OkReply{}
+	}
+
+	if command.flags&flagWrite == 0 {
+		return db.execRead(command, args)
+	}
+	if err := db.aofError(); err != nil {
+		return makeMisconfErrReply(err)
+	}
+	if command.flags&flagDenyOOM != 0 && overMaxMemory() {
+		return makeOOMErrReply()
+	}
+	var result redis.Reply
+	if command.flags&flagBlocking != 0 {
+		result = db.execBlocking(c, command, args)
 	} else {
-		result = cmdFunc(db, [][]byte{})
+		result = db.execWrite(command, args)
+		db.signalKeys(command.keys(args)...)
 	}
+	if properties := config.Properties(); properties.AppendOnly && properties.AppendFsync == config.FsyncAlways {
+		// reply after the command is fsynced
+		if err := db.waitAofSync(); err != nil {
+			return makeMisconfErrReply(err)
+		}
+	}
+	return result
+}
 
-	// aof
+// overMaxMemory reports whether sampled used memory exceeds maxmemory.
+// keys are never evicted, commands may grow memory are refused until memory is freed
+func overMaxMemory() bool {
+	maxMemory := config.Properties().MaxMemory
+	return maxMemory > 0 && stats.UsedMemory() > maxMemory
+}
 
-	return
+func makeOOMErrReply() *reply.StandardErrReply {
+	return reply.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+}
+
+// execRead runs command holding read locks of its keys
+func (db *DB) execRead(command *command, args [][]byte) redis.Reply {
+	keys := command.keys(args)
+	// FLUSHDB replaces db.Locker, locks are released on the locker they are acquired
+	locker := db.Locker
+	locker.RLocks(keys...)
+	defer locker.RUnLocks(keys...)
+	return command.executor(db, args[1:])
+}
+
+// execWrite runs command holding locks of its keys, and propagates its effect to aof before the locks are released,
+// so commands on the same key are written into aof in the order they are executed
+func (db *DB) execWrite(command *command, args [][]byte) redis.Reply {
+	db.snapshotMu.RLock()
+	defer db.snapshotMu.RUnlock()
+	keys := command.keys(args)
+	locker := db.Locker
+	locker.Locks(keys...)
+	db.preserve(keys...)
+	defer locker.UnLocks(keys...)
+	result := command.executor(db, args[1:])
+	db.propagate(command, args, result)
+	return result
 }
 
 /* ---- Data Access ----- */
@@ -149,22 +285,27 @@ func (db *DB) Get(key string) (*DataEntity, bool) {
 
 	raw, ok := db.Data.Get(key)
 	if !ok {
+		stats.Incr(&stats.KeyspaceMisses)
 		return nil, false
 	}
-	if db.IsExpired(key) {
+	entity, _ := raw.(*DataEntity)
+	if db.IsExpired(key) || db.isHashExpired(key, entity) {
+		stats.Incr(&stats.KeyspaceMisses)
 		return nil, false
 	}
-	entity, _ := raw.(*DataEntity)
+	stats.Incr(&stats.KeyspaceHits)
 	return entity, true
 }
 
 func (db *DB) Put(key string, entity *DataEntity) int {
 	db.stopWorld.Wait()
+	db.removeFieldTTLs(key)
 	return db.Data.Put(key, entity)
 }
 
 func (db *DB) PutIfExists(key string, entity *DataEntity) int {
 	db.stopWorld.Wait()
+	db.removeFieldTTLs(key)
 	return db.Data.PutIfExists(key, entity)
 }
 
@@ -177,6 +318,7 @@ func (db *DB) Remove(key string) {
 	db.stopWorld.Wait()
 	db.Data.Remove(key)
 	db.TTLMap.Remove(key)
+	db.removeFieldTTLs(key)
 }
 
 func (db *DB) Removes(keys ...string) (deleted int) {
@@ -187,6 +329,7 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 		if exists {
 			db.Data.Remove(key)
 			db.TTLMap.Remove(key)
+			db.removeFieldTTLs(key)
 			deleted++
 		}
 	}
@@ -199,6 +342,7 @@ func (db *DB) Flush() {
 
 	db.Data = dict.MakeConcurrent(dataDictSize)
 	db.TTLMap = dict.MakeConcurrent(ttlDictSize)
+	db.FieldTTLMap = dict.MakeConcurrent(ttlDictSize)
 	db.Locker = lock.Make(lockerSize)
 
 }
@@ -207,6 +351,7 @@ func (db *DB) Flush() {
 
 func (db *DB) Lock(key string) {
 	db.Locker.Lock(key)
+	db.preserve(key)
 }
 
 func (db *DB) RLock(key string) {
@@ -223,6 +368,17 @@ func (db *DB) RUnLock(key string) {
 
 func (db *DB) Locks(keys ...string) {
 	db.Locker.Locks(keys...)
+	db.preserve(keys...)
+}
+
+// preserve values of keys in aof rewrite snapshot before they are modified in place
+func (db *DB) preserve(keys ...string) {
+	if data, ok := db.Data.(*dict.ConcurrentDict); ok {
+		data.Preserve(keys...)
+	}
+	if fieldTTLMap, ok := db.FieldTTLMap.(*dict.ConcurrentDict); ok {
+		fieldTTLMap.Preserve(keys...)
+	}
 }
 
 func (db *DB) RLocks(keys ...string) {
@@ -258,12 +414,16 @@ func (db *DB) IsExpired(key string) bool {
 	expired := time.Now().After(expireTime)
 	if expired {
 		db.Remove(key)
+		stats.Incr(&stats.ExpiredKeys)
 	}
 	return expired
 }
 
 func (db *DB) CleanExpired() {
 	now := time.Now()
+	defer func() {
+		latency.Default.Record(latency.EventExpireCycle, time.Since(now))
+	}()
 	toRemove := &List.LinkedList{}
 	db.TTLMap.ForEach(func(key string, val interface{}) bool {
 		expireTime, _ := val.(time.Time)
@@ -271,14 +431,17 @@ func (db *DB) CleanExpired() {
 			// expired
 			db.Data.Remove(key)
 			toRemove.Add(key)
+			stats.Incr(&stats.ExpiredKeys)
 		}
 		return true
 	})
 	toRemove.ForEach(func(i int, val interface{}) bool {
 		key, _ := val.(string)
 		db.TTLMap.Remove(key)
+		db.removeFieldTTLs(key)
 		return true
 	})
+	db.cleanExpiredFields(now)
 }
 
 func (db *DB) TimerTask() {
@@ -294,4 +457,6 @@ func (db *DB) TimerTask() {
 
 func (db *DB) AfterClientClose(c redis.Connection) {
 	pubsub.UnsubscribeAll(db.hub, c)
+	monitor.Default.Remove(c)
+	db.cancelBlocking(c)
 }
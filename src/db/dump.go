@@ -0,0 +1,264 @@
+package db
+
+import (
+	"my-godis/src/datastruct/dict"
+	"my-godis/src/interface/redis"
+	"my-godis/src/lib/marshal/payload"
+	"my-godis/src/redis/client"
+	"my-godis/src/redis/reply"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DUMP key
+func Dump(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	entity, exists := db.Get(key)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	data, err := db.dumpValue(key, entity)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	if data == nil {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeBulkReply(data)
+}
+
+// dumpValue serializes value of key for DUMP and MIGRATE, hash is dumped with ttl of its fields and without expired ones.
+// returns nil if all fields of hash are expired
+func (db *DB) dumpValue(key string, entity *DataEntity) ([]byte, error) {
+	if _, ok := entity.Data.(dict.Dict); !ok || db.FieldTTLMap.Len() == 0 {
+		return payload.Dump(entity.Data)
+	}
+	raw, ok := db.FieldTTLMap.Get(key)
+	if !ok {
+		return payload.Dump(entity.Data)
+	}
+	hash, _ := db.getAsLiveDict(key)
+	if hash == nil {
+		return nil, nil
+	}
+	ttls := make(map[string]time.Time)
+	for field, expireTime := range raw.(map[string]time.Time) {
+		if _, ok := hash.Get(field); ok {
+			ttls[field] = expireTime
+		}
+	}
+	return payload.Dump(&payload.HashWithTTL{Hash: hash, TTLs: ttls})
+}
+
+var restoreCmd = []byte("RESTORE")
+
+// RESTORE key ttl payload [REPLACE] [ABSTTL]
+func Restore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace := false
+	absTTL := false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(string(arg)) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return reply.MakeErrReply("ERR syntax error")
+		}
+	}
+	val, err := payload.Load(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	var expireTime time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireTime = time.Unix(0, ttl*int64(time.Millisecond))
+		} else {
+			expireTime = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+
+	_, exists := db.Get(key)
+	if exists && !replace {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	if !expireTime.IsZero() && !expireTime.After(time.Now()) {
+		// key is expired already, so it is not created
+		if exists {
+			db.Remove(key)
+		}
+		return &reply.OkReply{}
+	}
+	var fieldTTLs map[string]time.Time
+	if hash, ok := val.(*payload.HashWithTTL); ok {
+		// fields expired during migrating are not restored
+		now := time.Now()
+		for field, expireTime := range hash.TTLs {
+			if !expireTime.After(now) {
+				hash.Hash.Remove(field)
+				delete(hash.TTLs, field)
+			}
+		}
+		if hash.Hash.Len() == 0 {
+			if exists {
+				db.Remove(key)
+			}
+			return &reply.OkReply{}
+		}
+		val = hash.Hash
+		fieldTTLs = hash.TTLs
+	}
+	db.Put(key, &DataEntity{Data: val})
+	db.Persist(key)
+	if !expireTime.IsZero() {
+		db.Expire(key, expireTime)
+	}
+	for field, fieldExpireTime := range fieldTTLs {
+		db.expireField(key, field, fieldExpireTime)
+	}
+	return &reply.OkReply{}
+}
+
+// default timeout of MIGRATE in milliseconds
+const defaultMigrateTimeout = 1000
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+func Migrate(db *DB, args [][]byte) redis.Reply {
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	destDB, err := strconv.Atoi(string(args[3]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if destDB != 0 {
+		// godis has only one database
+		return reply.MakeErrReply("ERR DB index is out of range")
+	}
+	timeout, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = defaultMigrateTimeout
+	}
+	copyKeys := false
+	replace := false
+	var keys []string
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "KEYS":
+			if len(args[2]) != 0 {
+				return reply.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(args)
+		default:
+			return reply.MakeErrReply("ERR syntax error")
+		}
+	}
+	if keys == nil {
+		keys = []string{string(args[2])}
+	}
+
+	// RESTORE commands of existing keys
+	var migrating []string
+	var cmds [][][]byte
+	for _, key := range keys {
+		entity, exists := db.Get(key)
+		if !exists {
+			continue
+		}
+		data, err := db.dumpValue(key, entity)
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		if data == nil {
+			continue
+		}
+		ttl := int64(0)
+		if raw, ok := db.TTLMap.Get(key); ok {
+			expireTime, _ := raw.(time.Time)
+			ttl = int64(time.Until(expireTime) / time.Millisecond)
+			if ttl <= 0 {
+				continue
+			}
+		}
+		cmd := [][]byte{restoreCmd, []byte(key), []byte(strconv.FormatInt(ttl, 10)), data}
+		if replace {
+			cmd = append(cmd, []byte("REPLACE"))
+		}
+		cmds = append(cmds, cmd)
+		migrating = append(migrating, key)
+	}
+	if len(cmds) == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+
+	target, err := client.MakeClient(addr)
+	if err != nil {
+		return reply.MakeErrReply("IOERR error or timeout connecting to the client")
+	}
+	target.Start()
+	defer target.Close()
+	for _, cmd := range cmds {
+		result := target.SendWithTimeout(cmd, time.Duration(timeout)*time.Millisecond)
+		if reply.IsErrorReply(result) {
+			msg := strings.TrimPrefix(trim(result.ToBytes()), "-")
+			return reply.MakeErrReply("ERR Target instance replied with error: " + msg)
+		}
+	}
+
+	if !copyKeys {
+		db.Removes(migrating...)
+	}
+	return &reply.OkReply{}
+}
+
+// RESTORE is appended with REPLACE and absolute expire time, or DEL if the key is expired already
+func rewriteRestore(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	key := string(args[1])
+	if _, exists := db.Get(key); !exists {
+		return []*reply.MultiBulkReply{makeAofCmd("del", args[1:2])}
+	}
+	cmd := reply.MakeMultiBulkReply([][]byte{restoreCmd, args[1], []byte("0"), args[3], []byte("REPLACE")})
+	return makeTTLCmds(db, key, cmd)
+}
+
+// keys moved by MIGRATE without COPY are deleted
+func rewriteMigrate(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	if _, ok := result.(*reply.OkReply); !ok {
+		return nil
+	}
+	for _, arg := range args[6:] {
+		option := strings.ToUpper(string(arg))
+		if option == "COPY" {
+			return nil
+		}
+		if option == "KEYS" {
+			break
+		}
+	}
+	keys := migrateKeys(args)
+	delArgs := make([][]byte, len(keys))
+	for i, key := range keys {
+		delArgs[i] = []byte(key)
+	}
+	return []*reply.MultiBulkReply{makeAofCmd("del", delArgs)}
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"my-godis/src/aof"
+	"my-godis/src/datastruct/dict"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDumpAndRestore(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("list", "a", "b", "c"))
+	dumped, ok := Dump(testDB, toArgs("list")).(*reply.BulkReply)
+	if !ok {
+		t.Fatal("expected bulk reply")
+	}
+	payload := string(dumped.Arg)
+
+	result := Restore(testDB, toArgs("list", "0", payload))
+	asserts.AssertErrReply(t, result, "BUSYKEY Target key name already exists.")
+	result = Restore(testDB, toArgs("copy", "0", payload))
+	asserts.AssertStatusReply(t, result, "OK")
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("copy", "0", "-1")), []string{"a", "b", "c"})
+
+	// replace with ttl
+	Set(testDB, toArgs("str", "v"))
+	result = Restore(testDB, toArgs("str", "10000", payload, "REPLACE"))
+	asserts.AssertStatusReply(t, result, "OK")
+	asserts.AssertIntReply(t, LLen(testDB, toArgs("str")), 3)
+	ttl, _ := PTTL(testDB, toArgs("str")).(*reply.IntReply)
+	if ttl.Code <= 0 || ttl.Code > 10000 {
+		t.Errorf("unexpected ttl %d", ttl.Code)
+	}
+
+	// expired absolute ttl
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixNano()/int64(time.Millisecond), 10)
+	result = Restore(testDB, toArgs("str", past, payload, "REPLACE", "ABSTTL"))
+	asserts.AssertStatusReply(t, result, "OK")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("str")), 0)
+
+	asserts.AssertNullBulk(t, Dump(testDB, toArgs("none")))
+	result = Restore(testDB, toArgs("bad", "0", payload[:len(payload)-1]+"x"))
+	asserts.AssertErrReply(t, result, "ERR DUMP payload version or checksum are wrong")
+	result = Restore(testDB, toArgs("bad", "-1", payload))
+	asserts.AssertErrReply(t, result, "ERR Invalid TTL value, must be >= 0")
+}
+
+// fakeTarget accepts one connection, records commands and replies them with reply
+func fakeTarget(t *testing.T, result string) (string, func() [][][]byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var cmds [][][]byte
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := aof.NewReader(conn)
+		for {
+			entry, err := reader.Next()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			cmds = append(cmds, entry.Args)
+			mu.Unlock()
+			_, _ = conn.Write([]byte(result))
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return listener.Addr().String(), func() [][][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return cmds
+	}
+}
+
+func TestDumpHashFieldTTL(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	HMSet(testDB, toArgs("h", "f1", "v1", "f2", "v2", "f3", "v3"))
+	HPExpire(testDB, toArgs("h", "100000", "FIELDS", "1", "f1"))
+	// expired field waiting to be removed
+	testDB.expireField("h", "f3", time.Now().Add(-time.Second))
+	dumped, ok := Dump(testDB, toArgs("h")).(*reply.BulkReply)
+	if !ok {
+		t.Fatal("expected bulk reply")
+	}
+
+	asserts.AssertStatusReply(t, Restore(testDB, toArgs("copy", "0", string(dumped.Arg))), "OK")
+	raw, _ := testDB.Get("copy")
+	if hash := raw.Data.(dict.Dict); hash.Len() != 2 {
+		t.Errorf("expected 2 fields restored, actually %d", hash.Len())
+	}
+	asserts.AssertBulkReply(t, HGet(testDB, toArgs("copy", "f2")), "v2")
+	ttls := HPTTL(testDB, toArgs("copy", "FIELDS", "2", "f1", "f2")).(*reply.MultiRawReply)
+	if ttl := ttls.Replies[0].(*reply.IntReply).Code; ttl <= 0 || ttl > 100000 {
+		t.Errorf("unexpected ttl of f1 %d", ttl)
+	}
+	assertRawReply(t, ttls.Replies[1], ":-1\r\n")
+
+	// hash with all fields expired is gone
+	testDB.expireField("h", "f1", time.Now().Add(-time.Second))
+	testDB.expireField("h", "f2", time.Now().Add(-time.Second))
+	asserts.AssertNullBulk(t, Dump(testDB, toArgs("h")))
+}
+
+func TestMigrate(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("k1", "v1"))
+	SAdd(testDB, toArgs("k2", "a"))
+	Expire(testDB, toArgs("k2", "100"))
+
+	addr, received := fakeTarget(t, "+OK\r\n")
+	host, port, _ := net.SplitHostPort(addr)
+	result := Migrate(testDB, toArgs(host, port, "", "0", "1000", "REPLACE", "KEYS", "k1", "k2", "none"))
+	asserts.AssertStatusReply(t, result, "OK")
+	cmds := received()
+	if len(cmds) != 2 {
+		t.Fatalf("expected 2 commands, actually %d", len(cmds))
+	}
+	if strings.ToUpper(string(cmds[0][0])) != "RESTORE" || string(cmds[0][1]) != "k1" ||
+		string(cmds[0][2]) != "0" || string(cmds[0][4]) != "REPLACE" {
+		t.Errorf("unexpected command %q", cmds[0])
+	}
+	if ttl, _ := strconv.Atoi(string(cmds[1][2])); ttl <= 0 || ttl > 100000 {
+		t.Errorf("unexpected ttl %q", cmds[1][2])
+	}
+	// keys are removed without COPY
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("k1")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("k2")), 0)
+
+	result = Migrate(testDB, toArgs(host, port, "k1", "0", "1000"))
+	asserts.AssertStatusReply(t, result, "NOKEY")
+
+	// target refuses
+	Set(testDB, toArgs("k3", "v3"))
+	addr, _ = fakeTarget(t, "-BUSYKEY Target key name already exists.\r\n")
+	host, port, _ = net.SplitHostPort(addr)
+	result = Migrate(testDB, toArgs(host, port, "k3", "0", "1000", "COPY"))
+	asserts.AssertErrReply(t, result, "ERR Target instance replied with error: BUSYKEY Target key name already exists.")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("k3")), 1)
+
+	result = Migrate(testDB, toArgs(host, port, "k3", "1", "1000"))
+	asserts.AssertErrReply(t, result, "ERR DB index is out of range")
+}
@@ -0,0 +1,525 @@
+package db
+
+/*
+ * function libraries, a library is lua code beginning with shebang "#!lua name=<library>"
+ * which registers functions by redis.register_function while it is loaded.
+ * every library keeps its own lua state, functions run one by one as scripts do.
+ * FUNCTION LOAD/DELETE/FLUSH/RESTORE are written into aof, libraries are written into base file while rewriting.
+ */
+
+import (
+	"bytes"
+	"context"
+	List "my-godis/src/datastruct/list"
+	"my-godis/src/interface/redis"
+	"my-godis/src/lib/marshal/payload"
+	"my-godis/src/lib/wildcard"
+	"my-godis/src/redis/reply"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// max time of running top level code of library
+const libraryLoadTimeout = 500 * time.Millisecond
+
+// flags of functions, only no-writes takes effect
+var functionFlags = map[string]bool{
+	"no-writes": true, "allow-oom": true, "allow-stale": true, "no-cluster": true, "allow-cross-slot-keys": true,
+}
+
+type luaFunction struct {
+	name     string
+	library  *library
+	callback *lua.LFunction
+	flags    []string
+	// with no-writes flag, could be called by FCALL_RO
+	readOnly bool
+}
+
+type library struct {
+	name string
+	code []byte
+	// lua state where functions are registered, used by one script at a time
+	state     *lua.LState
+	functions []*luaFunction
+	// script calling functions of the library, nil while loading
+	running *runningScript
+}
+
+// functionRegistry holds libraries and functions by name
+type functionRegistry struct {
+	mu        sync.RWMutex
+	libraries map[string]*library
+	functions map[string]*luaFunction
+}
+
+func (registry *functionRegistry) get(name string) *luaFunction {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return registry.functions[name]
+}
+
+// add libraries, existing libraries with the same name are replaced if replace is true
+// nothing is changed if any library or function conflicts
+func (registry *functionRegistry) add(libs []*library, replace bool) redis.Reply {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	names := make(map[string]string)
+	for _, lib := range libs {
+		if _, exists := registry.libraries[lib.name]; exists && !replace {
+			return reply.MakeErrReply("ERR Library '" + lib.name + "' already exists")
+		}
+		for _, fn := range lib.functions {
+			if existing, ok := registry.functions[fn.name]; ok && existing.library.name != lib.name {
+				if !replace || !containsLibrary(libs, existing.library.name) {
+					return reply.MakeErrReply("ERR Function " + fn.name + " already exists")
+				}
+			}
+			if other, ok := names[fn.name]; ok && other != lib.name {
+				return reply.MakeErrReply("ERR Function " + fn.name + " already exists")
+			}
+			names[fn.name] = lib.name
+		}
+	}
+	if registry.libraries == nil {
+		registry.libraries = make(map[string]*library)
+		registry.functions = make(map[string]*luaFunction)
+	}
+	for _, lib := range libs {
+		registry.remove(lib.name)
+	}
+	for _, lib := range libs {
+		registry.libraries[lib.name] = lib
+		for _, fn := range lib.functions {
+			registry.functions[fn.name] = fn
+		}
+	}
+	return nil
+}
+
+func containsLibrary(libs []*library, name string) bool {
+	for _, lib := range libs {
+		if lib.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// remove library and its functions, caller must hold mu
+// lua state of removed library is not closed, since a running function may still use it
+func (registry *functionRegistry) remove(name string) bool {
+	lib, ok := registry.libraries[name]
+	if !ok {
+		return false
+	}
+	for _, fn := range lib.functions {
+		delete(registry.functions, fn.name)
+	}
+	delete(registry.libraries, name)
+	return true
+}
+
+func (registry *functionRegistry) delete(name string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return registry.remove(name)
+}
+
+func (registry *functionRegistry) flush() {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.libraries = nil
+	registry.functions = nil
+}
+
+// list returns libraries sorted by name
+func (registry *functionRegistry) list() []*library {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	libs := make([]*library, 0, len(registry.libraries))
+	for _, lib := range registry.libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool {
+		return libs[i].name < libs[j].name
+	})
+	return libs
+}
+
+// codes returns code of all libraries, for aof rewrite and FUNCTION DUMP
+func (registry *functionRegistry) codes() [][]byte {
+	libs := registry.list()
+	codes := make([][]byte, len(libs))
+	for i, lib := range libs {
+		codes[i] = lib.code
+	}
+	return codes
+}
+
+func isValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseShebang reads library name from the first line: #!lua name=<library>
+func parseShebang(code []byte) (string, redis.Reply) {
+	line := code
+	if i := bytes.IndexByte(code, '\n'); i >= 0 {
+		line = code[:i]
+	}
+	if !bytes.HasPrefix(line, []byte("#!")) {
+		return "", reply.MakeErrReply("ERR Missing library metadata")
+	}
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 || fields[0] != "lua" {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return "", reply.MakeErrReply("ERR Engine '" + engine + "' not found")
+	}
+	name := ""
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "name=") {
+			return "", reply.MakeErrReply("ERR Invalid metadata value given: " + field)
+		}
+		name = strings.TrimPrefix(field, "name=")
+	}
+	if name == "" {
+		return "", reply.MakeErrReply("ERR Library name was not given")
+	}
+	if !isValidName(name) {
+		return "", reply.MakeErrReply("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+// loadLibrary runs top level code of library, which registers functions
+func (db *DB) loadLibrary(code []byte) (*library, redis.Reply) {
+	name, errReply := parseShebang(code)
+	if errReply != nil {
+		return nil, errReply
+	}
+	// shebang is not lua, keep an empty line so that line numbers in errors are right
+	src := []byte{}
+	if i := bytes.IndexByte(code, '\n'); i >= 0 {
+		src = code[i:]
+	}
+	chunk, err := parse.Parse(bytes.NewReader(src), "user_function")
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR Error compiling function: " + err.Error())
+	}
+	proto, err := lua.Compile(chunk, "user_function")
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR Error compiling function: " + err.Error())
+	}
+
+	lib := &library{
+		name: name,
+		code: code,
+	}
+	L := db.newLuaState(func() *runningScript {
+		return lib.running
+	})
+	lib.state = L
+	loading := true
+	redisMod := L.GetGlobal("redis").(*lua.LTable)
+	L.SetField(redisMod, "register_function", L.NewFunction(func(L *lua.LState) int {
+		if !loading {
+			L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+			return 0
+		}
+		fn := parseRegisterArgs(L)
+		for _, registered := range lib.functions {
+			if registered.name == fn.name {
+				L.RaiseError("Function already exists in the library")
+				return 0
+			}
+		}
+		fn.library = lib
+		lib.functions = append(lib.functions, fn)
+		return 0
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), libraryLoadTimeout)
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	err = L.PCall(0, 0, nil)
+	L.RemoveContext()
+	cancel()
+	loading = false
+	if err != nil {
+		L.Close()
+		if ctx.Err() != nil {
+			return nil, reply.MakeErrReply("ERR FUNCTION LOAD timeout")
+		}
+		return nil, reply.MakeErrReply("ERR Error registering functions: " + err.Error())
+	}
+	if len(lib.functions) == 0 {
+		L.Close()
+		return nil, reply.MakeErrReply("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// redis.register_function(name, callback) or redis.register_function{function_name=..., callback=..., flags=...}
+func parseRegisterArgs(L *lua.LState) *luaFunction {
+	fn := &luaFunction{}
+	if table, ok := L.Get(1).(*lua.LTable); ok && L.GetTop() == 1 {
+		name, _ := table.RawGetString("function_name").(lua.LString)
+		fn.name = string(name)
+		fn.callback, _ = table.RawGetString("callback").(*lua.LFunction)
+		switch flags := table.RawGetString("flags").(type) {
+		case *lua.LTable:
+			for i := 1; i <= flags.Len(); i++ {
+				flag, ok := flags.RawGetInt(i).(lua.LString)
+				if !ok || !functionFlags[string(flag)] {
+					L.RaiseError("unknown flag given")
+					return nil
+				}
+				fn.flags = append(fn.flags, string(flag))
+				if flag == "no-writes" {
+					fn.readOnly = true
+				}
+			}
+		case *lua.LNilType:
+		default:
+			L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+			return nil
+		}
+	} else {
+		fn.name = L.CheckString(1)
+		fn.callback = L.CheckFunction(2)
+	}
+	if !isValidName(fn.name) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+		return nil
+	}
+	if fn.callback == nil {
+		L.RaiseError("callback argument given to redis.register_function must be a function")
+		return nil
+	}
+	return fn
+}
+
+// FCALL function numkeys [key ...] [arg ...]
+func FCall(db *DB, args [][]byte) redis.Reply {
+	return db.fcall(args, false)
+}
+
+// FCALL_RO function numkeys [key ...] [arg ...], only functions with no-writes flag could be called
+func FCallRO(db *DB, args [][]byte) redis.Reply {
+	return db.fcall(args, true)
+}
+
+func (db *DB) fcall(args [][]byte, readOnly bool) redis.Reply {
+	if len(args) < 2 {
+		cmd := "fcall"
+		if readOnly {
+			cmd = "fcall_ro"
+		}
+		return reply.MakeErrReply("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	fn := db.functions.get(string(args[0]))
+	if fn == nil {
+		return reply.MakeErrReply("ERR Function not found")
+	}
+	if readOnly && !fn.readOnly {
+		return reply.MakeErrReply("ERR Can not execute a script with write flag using *_ro command.")
+	}
+	return db.runScript(args[1:], fn.readOnly, func(script *runningScript, keys [][]byte, argv [][]byte) redis.Reply {
+		lib := fn.library
+		lib.running = script
+		defer func() {
+			lib.running = nil
+		}()
+		L := lib.state
+		L.SetContext(script.ctx)
+		defer L.RemoveContext()
+
+		L.Push(fn.callback)
+		L.Push(makeLuaArray(L, keys))
+		L.Push(makeLuaArray(L, argv))
+		err := L.PCall(2, 1, nil)
+		if err != nil {
+			return makeScriptErrReply(script, err, "Error running function "+fn.name)
+		}
+		result := luaToReply(L.Get(-1))
+		L.Pop(1)
+		return result
+	})
+}
+
+// FUNCTION LOAD [REPLACE] code | DELETE library | FLUSH [ASYNC|SYNC] | LIST [LIBRARYNAME pattern] [WITHCODE]
+// | DUMP | RESTORE payload [FLUSH|APPEND|REPLACE] | KILL
+func Function(db *DB, args [][]byte) redis.Reply {
+	switch strings.ToLower(string(args[0])) {
+	case "load":
+		return functionLoad(db, args)
+	case "delete":
+		if len(args) != 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'function|delete' command")
+		}
+		if !db.functions.delete(string(args[1])) {
+			return reply.MakeErrReply("ERR Library not found")
+		}
+		return &reply.OkReply{}
+	case "flush":
+		if len(args) > 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'function|flush' command")
+		}
+		if len(args) == 2 {
+			mode := strings.ToUpper(string(args[1]))
+			if mode != "ASYNC" && mode != "SYNC" {
+				return reply.MakeErrReply("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+			}
+		}
+		db.functions.flush()
+		return &reply.OkReply{}
+	case "list":
+		return functionList(db, args)
+	case "dump":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'function|dump' command")
+		}
+		data, _ := payload.Dump(List.MakeQuickList(db.functions.codes()...))
+		return reply.MakeBulkReply(data)
+	case "restore":
+		return functionRestore(db, args)
+	case "kill":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'function|kill' command")
+		}
+		return db.killScript()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try FUNCTION HELP.")
+}
+
+func functionLoad(db *DB, args [][]byte) redis.Reply {
+	replace := false
+	if len(args) == 3 && strings.ToUpper(string(args[1])) == "REPLACE" {
+		replace = true
+	} else if len(args) != 2 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'function|load' command")
+	}
+	lib, errReply := db.loadLibrary(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	if errReply = db.functions.add([]*library{lib}, replace); errReply != nil {
+		return errReply
+	}
+	return reply.MakeBulkReply([]byte(lib.name))
+}
+
+func functionList(db *DB, args [][]byte) redis.Reply {
+	var pattern *wildcard.Pattern
+	withCode := false
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 >= len(args) {
+				return reply.MakeErrReply("ERR library name argument was not given")
+			}
+			pattern = wildcard.CompilePattern(string(args[i+1]))
+			i++
+		default:
+			return reply.MakeErrReply("ERR Unknown argument " + string(args[i]))
+		}
+	}
+	result := make([]redis.Reply, 0)
+	for _, lib := range db.functions.list() {
+		if pattern != nil && !pattern.IsMatch(lib.name) {
+			continue
+		}
+		functions := make([]redis.Reply, len(lib.functions))
+		for i, fn := range lib.functions {
+			flags := make([][]byte, len(fn.flags))
+			for j, flag := range fn.flags {
+				flags[j] = []byte(flag)
+			}
+			functions[i] = reply.MakeMultiRawReply([]redis.Reply{
+				reply.MakeBulkReply([]byte("name")), reply.MakeBulkReply([]byte(fn.name)),
+				reply.MakeBulkReply([]byte("description")), &reply.NullBulkReply{},
+				reply.MakeBulkReply([]byte("flags")), reply.MakeMultiBulkReply(flags),
+			})
+		}
+		item := []redis.Reply{
+			reply.MakeBulkReply([]byte("library_name")), reply.MakeBulkReply([]byte(lib.name)),
+			reply.MakeBulkReply([]byte("engine")), reply.MakeBulkReply([]byte("LUA")),
+			reply.MakeBulkReply([]byte("functions")), reply.MakeMultiRawReply(functions),
+		}
+		if withCode {
+			item = append(item, reply.MakeBulkReply([]byte("library_code")), reply.MakeBulkReply(lib.code))
+		}
+		result = append(result, reply.MakeMultiRawReply(item))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// FUNCTION RESTORE payload [FLUSH|APPEND|REPLACE], payload is made by FUNCTION DUMP
+func functionRestore(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 2 && len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'function|restore' command")
+	}
+	policy := "APPEND"
+	if len(args) == 3 {
+		policy = strings.ToUpper(string(args[2]))
+		if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+			return reply.MakeErrReply("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+		}
+	}
+	val, err := payload.Load(args[1])
+	list, ok := val.(*List.QuickList)
+	if err != nil || !ok {
+		return reply.MakeErrReply("ERR payload version or checksum are wrong")
+	}
+	libs := make([]*library, 0, list.Len())
+	var errReply redis.Reply
+	list.ForEach(func(i int, code []byte) bool {
+		var lib *library
+		lib, errReply = db.loadLibrary(code)
+		libs = append(libs, lib)
+		return errReply == nil
+	})
+	if errReply != nil {
+		return errReply
+	}
+	if policy == "FLUSH" {
+		db.functions.flush()
+	}
+	if errReply = db.functions.add(libs, policy != "APPEND"); errReply != nil {
+		return errReply
+	}
+	return &reply.OkReply{}
+}
+
+// only sub commands changing libraries are appended to aof
+func rewriteFunction(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	switch strings.ToLower(string(args[1])) {
+	case "load", "delete", "flush", "restore":
+		return []*reply.MultiBulkReply{reply.MakeMultiBulkReply(args)}
+	}
+	return nil
+}
+
+// makeFunctionLoadCmd makes command which loads library, for aof rewrite
+func makeFunctionLoadCmd(code []byte) *reply.MultiBulkReply {
+	return reply.MakeMultiBulkReply([][]byte{[]byte("FUNCTION"), []byte("LOAD"), []byte("REPLACE"), code})
+}
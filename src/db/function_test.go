@@ -0,0 +1,109 @@
+package db
+
+import (
+	"my-godis/src/config"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLibrary = `#!lua name=mylib
+redis.register_function('myset', function(keys, args)
+	return redis.call('SET', keys[1], args[1])
+end)
+redis.register_function{
+	function_name = 'myget',
+	callback = function(keys, args) return redis.call('GET', keys[1]) end,
+	flags = {'no-writes'},
+}
+redis.register_function{
+	function_name = 'badget',
+	callback = function(keys, args) return redis.call('SET', keys[1], 'x') end,
+	flags = {'no-writes'},
+}
+`
+
+func TestFunction(t *testing.T) {
+	db := makeTestDB()
+	asserts.AssertBulkReply(t, Function(db, toArgs("load", testLibrary)), "mylib")
+	asserts.AssertStatusReply(t, FCall(db, toArgs("myset", "1", "k", "v")), "OK")
+	asserts.AssertBulkReply(t, FCall(db, toArgs("myget", "1", "k")), "v")
+	asserts.AssertBulkReply(t, FCallRO(db, toArgs("myget", "1", "k")), "v")
+	asserts.AssertErrReply(t, FCallRO(db, toArgs("myset", "1", "k", "v")),
+		"ERR Can not execute a script with write flag using *_ro command.")
+	asserts.AssertErrReply(t, FCall(db, toArgs("badget", "1", "k")),
+		"ERR Write commands are not allowed from read-only scripts.")
+	asserts.AssertErrReply(t, FCall(db, toArgs("none", "0")), "ERR Function not found")
+
+	asserts.AssertErrReply(t, Function(db, toArgs("load", testLibrary)), "ERR Library 'mylib' already exists")
+	asserts.AssertBulkReply(t, Function(db, toArgs("load", "replace", testLibrary)), "mylib")
+	other := "#!lua name=other\nredis.register_function('myget', function() return 1 end)"
+	asserts.AssertErrReply(t, Function(db, toArgs("load", other)), "ERR Function myget already exists")
+	asserts.AssertErrReply(t, Function(db, toArgs("load", "return 1")), "ERR Missing library metadata")
+	asserts.AssertErrReply(t, Function(db, toArgs("load", "#!lua name=empty\n")), "ERR No functions registered")
+	result := Function(db, toArgs("load", "#!lua name=slow\nwhile true do end"))
+	asserts.AssertErrReply(t, result, "ERR FUNCTION LOAD timeout")
+	result = Function(db, toArgs("load", "#!lua name=call\nredis.call('GET', 'k')"))
+	if !reply.IsErrorReply(result) {
+		t.Error("expected redis.call refused while loading")
+	}
+
+	result = Function(db, toArgs("list", "withcode"))
+	list := string(result.ToBytes())
+	if !strings.HasPrefix(list, "*1\r\n*8\r\n$12\r\nlibrary_name\r\n$5\r\nmylib\r\n") ||
+		!strings.Contains(list, "$4\r\nname\r\n$5\r\nmyget\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n") ||
+		!strings.Contains(list, testLibrary) {
+		t.Errorf("unexpected list %q", list)
+	}
+	asserts.AssertStatusReply(t, Function(db, toArgs("delete", "mylib")), "OK")
+	asserts.AssertErrReply(t, Function(db, toArgs("delete", "mylib")), "ERR Library not found")
+	asserts.AssertErrReply(t, FCall(db, toArgs("myget", "1", "k")), "ERR Function not found")
+}
+
+func TestFunctionDumpAndRestore(t *testing.T) {
+	db := makeTestDB()
+	Function(db, toArgs("load", testLibrary))
+	dumped, _ := Function(db, toArgs("dump")).(*reply.BulkReply)
+	asserts.AssertErrReply(t, Function(db, toArgs("restore", string(dumped.Arg))), "ERR Library 'mylib' already exists")
+	asserts.AssertStatusReply(t, Function(db, toArgs("restore", string(dumped.Arg), "replace")), "OK")
+	asserts.AssertStatusReply(t, Function(db, toArgs("flush")), "OK")
+	asserts.AssertErrReply(t, FCall(db, toArgs("myget", "1", "k")), "ERR Function not found")
+	asserts.AssertStatusReply(t, Function(db, toArgs("restore", string(dumped.Arg))), "OK")
+	asserts.AssertStatusReply(t, FCall(db, toArgs("myset", "1", "k", "v")), "OK")
+	asserts.AssertErrReply(t, Function(db, toArgs("restore", "bad")), "ERR payload version or checksum are wrong")
+}
+
+func TestFunctionAof(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Function(testDB, toArgs("flush"))
+	useTempAofDir(t)
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AppendOnly = false
+		})
+		Function(testDB, toArgs("flush"))
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	testDB.Exec(nil, toArgs("function", "load", testLibrary))
+	testDB.Exec(nil, toArgs("fcall", "myset", "1", "k", "v"))
+
+	// libraries are written into base file by rewrite, and the incremental file has commands after it
+	if !testDB.startBackgroundRewrite() {
+		t.Fatal("expected rewrite started")
+	}
+	for testDB.isRewriting() {
+		time.Sleep(time.Millisecond)
+	}
+	other := "#!lua name=other\nredis.register_function('one', function() return 1 end)"
+	testDB.Exec(nil, toArgs("function", "load", other))
+	Config(testDB, toArgs("set", "appendonly", "no"))
+
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, FCallRO(db, toArgs("myget", "1", "k")), "v")
+	asserts.AssertIntReply(t, FCall(db, toArgs("one", "0")), 1)
+}
@@ -0,0 +1,506 @@
+package db
+
+import (
+	"fmt"
+	SortedSet "my-godis/src/datastruct/sortedset"
+	"my-godis/src/interface/redis"
+	"my-godis/src/lib/geohash"
+	"my-godis/src/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// members of geo are stored in sortedset with 52 bits geohash as score
+
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+func parseGeoUnit(arg []byte) (float64, redis.Reply) {
+	unit, ok := geoUnits[strings.ToLower(string(arg))]
+	if !ok {
+		return 0, reply.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return unit, nil
+}
+
+func parseCoordinate(lonArg, latArg []byte) (float64, float64, redis.Reply) {
+	lon, err := strconv.ParseFloat(string(lonArg), 64)
+	if err != nil {
+		return 0, 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	lat, err := strconv.ParseFloat(string(latArg), 64)
+	if err != nil {
+		return 0, 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	if lon < geohash.LonMin || lon > geohash.LonMax || lat < geohash.LatMin || lat > geohash.LatMax {
+		return 0, 0, reply.MakeErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", lon, lat))
+	}
+	return lon, lat, nil
+}
+
+func formatCoordinate(lon, lat float64) [][]byte {
+	return [][]byte{
+		[]byte(strconv.FormatFloat(lon, 'f', -1, 64)),
+		[]byte(strconv.FormatFloat(lat, 'f', -1, 64)),
+	}
+}
+
+func formatDistance(dist float64) []byte {
+	return []byte(strconv.FormatFloat(dist, 'f', 4, 64))
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func GeoAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	nx, xx, ch := false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NX" {
+			nx = true
+		} else if option == "XX" {
+			xx = true
+		} else if option == "CH" {
+			ch = true
+		} else {
+			break
+		}
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return &reply.SyntaxErrReply{}
+	}
+
+	size := len(rest) / 3
+	members := make([]string, size)
+	scores := make([]float64, size)
+	for j := 0; j < size; j++ {
+		lon, lat, errReply := parseCoordinate(rest[3*j], rest[3*j+1])
+		if errReply != nil {
+			return errReply
+		}
+		members[j] = string(rest[3*j+2])
+		scores[j] = float64(geohash.Encode(lon, lat))
+	}
+
+	var sortedSet *SortedSet.SortedSet
+	var errReply reply.ErrorReply
+	if xx {
+		// XX never adds members, so don't create empty set
+		sortedSet, errReply = db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			return reply.MakeIntReply(0)
+		}
+	} else {
+		sortedSet, _, errReply = db.getOrInitSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
+
+	added, changed := 0, 0
+	for j, member := range members {
+		element, exists := sortedSet.Get(member)
+		if exists {
+			if nx || element.Score == scores[j] {
+				continue
+			}
+			sortedSet.Add(member, scores[j])
+			changed++
+		} else {
+			if xx {
+				continue
+			}
+			sortedSet.Add(member, scores[j])
+			added++
+		}
+	}
+	if ch {
+		return reply.MakeIntReply(int64(added + changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// GEOPOS key [member [member ...]]
+func GeoPos(db *DB, args [][]byte) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	positions := make([]redis.Reply, len(args)-1)
+	for i, arg := range args[1:] {
+		if sortedSet == nil {
+			positions[i] = &reply.NullBulkReply{}
+			continue
+		}
+		element, exists := sortedSet.Get(string(arg))
+		if !exists {
+			positions[i] = &reply.NullBulkReply{}
+			continue
+		}
+		positions[i] = reply.MakeMultiBulkReply(formatCoordinate(geohash.Decode(uint64(element.Score))))
+	}
+	return reply.MakeMultiRawReply(positions)
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func GeoDist(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 3 && len(args) != 4 {
+		return &reply.SyntaxErrReply{}
+	}
+	unit := 1.0
+	if len(args) == 4 {
+		var errReply redis.Reply
+		unit, errReply = parseGeoUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+	element1, exists1 := sortedSet.Get(string(args[1]))
+	element2, exists2 := sortedSet.Get(string(args[2]))
+	if !exists1 || !exists2 {
+		return &reply.NullBulkReply{}
+	}
+	lon1, lat1 := geohash.Decode(uint64(element1.Score))
+	lon2, lat2 := geohash.Decode(uint64(element2.Score))
+	return reply.MakeBulkReply(formatDistance(geohash.Distance(lon1, lat1, lon2, lat2) / unit))
+}
+
+// GEOHASH key [member [member ...]]
+func GeoHash(db *DB, args [][]byte) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	hashes := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		if sortedSet == nil {
+			continue
+		}
+		if element, exists := sortedSet.Get(string(arg)); exists {
+			hashes[i] = []byte(geohash.ToString(uint64(element.Score)))
+		}
+	}
+	return reply.MakeMultiBulkReply(hashes)
+}
+
+/* ---- search ---- */
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+type geoSearchOptions struct {
+	fromMember string
+	hasMember  bool
+	hasLonLat  bool
+	lon        float64
+	lat        float64
+
+	byRadius bool
+	byBox    bool
+	// radius, width and height are in meters
+	radius float64
+	width  float64
+	height float64
+	unit   float64
+
+	sort      int
+	count     int64
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+type geoPoint struct {
+	member string
+	score  float64
+	lon    float64
+	lat    float64
+	// dist is in meters
+	dist float64
+}
+
+func parseGeoSearchOptions(cmdName string, args [][]byte, store bool) (*geoSearchOptions, redis.Reply) {
+	opts := &geoSearchOptions{}
+	hasCount := false
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		left := len(args) - i - 1
+		switch {
+		case option == "FROMMEMBER" && left >= 1:
+			opts.fromMember = string(args[i+1])
+			opts.hasMember = true
+			i++
+		case option == "FROMLONLAT" && left >= 2:
+			lon, lat, errReply := parseCoordinate(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.lon, opts.lat = lon, lat
+			opts.hasLonLat = true
+			i += 2
+		case option == "BYRADIUS" && left >= 2:
+			radius, err := strconv.ParseFloat(string(args[i+1]), 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR need numeric radius")
+			}
+			if radius < 0 {
+				return nil, reply.MakeErrReply("ERR radius cannot be negative")
+			}
+			unit, errReply := parseGeoUnit(args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.radius = radius * unit
+			opts.unit = unit
+			opts.byRadius = true
+			i += 2
+		case option == "BYBOX" && left >= 3:
+			width, err1 := strconv.ParseFloat(string(args[i+1]), 64)
+			height, err2 := strconv.ParseFloat(string(args[i+2]), 64)
+			if err1 != nil || err2 != nil {
+				return nil, reply.MakeErrReply("ERR need numeric width and height")
+			}
+			if width < 0 || height < 0 {
+				return nil, reply.MakeErrReply("ERR height or width cannot be negative")
+			}
+			unit, errReply := parseGeoUnit(args[i+3])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.width = width * unit
+			opts.height = height * unit
+			opts.unit = unit
+			opts.byBox = true
+			i += 3
+		case option == "ASC":
+			opts.sort = geoSortAsc
+		case option == "DESC":
+			opts.sort = geoSortDesc
+		case option == "COUNT" && left >= 1:
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return nil, reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			opts.count = count
+			hasCount = true
+			i++
+			if i+1 < len(args) && strings.ToUpper(string(args[i+1])) == "ANY" {
+				opts.any = true
+				i++
+			}
+		case option == "ANY":
+			// ANY is only valid right after COUNT
+			return nil, reply.MakeErrReply("ERR the ANY argument requires COUNT argument")
+		case option == "WITHCOORD" && !store:
+			opts.withCoord = true
+		case option == "WITHDIST" && !store:
+			opts.withDist = true
+		case option == "WITHHASH" && !store:
+			opts.withHash = true
+		case option == "STOREDIST" && store:
+			opts.storeDist = true
+		default:
+			return nil, &reply.SyntaxErrReply{}
+		}
+	}
+	if opts.hasMember == opts.hasLonLat {
+		return nil, reply.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+	}
+	if opts.byRadius == opts.byBox {
+		return nil, reply.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+	}
+	if opts.any && !hasCount {
+		return nil, reply.MakeErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	return opts, nil
+}
+
+// contains returns distance to center and whether the point is inside the shape
+func (opts *geoSearchOptions) contains(lon, lat float64) (float64, bool) {
+	if opts.byRadius {
+		dist := geohash.Distance(opts.lon, opts.lat, lon, lat)
+		return dist, dist <= opts.radius
+	}
+	// distance along meridian and parallel to the center, same as redis
+	if geohash.LatDistance(opts.lat, lat) > opts.height/2 ||
+		geohash.Distance(opts.lon, lat, lon, lat) > opts.width/2 {
+		return 0, false
+	}
+	return geohash.Distance(opts.lon, opts.lat, lon, lat), true
+}
+
+// geoSearch scans members in neighbouring cells of center, instead of the whole sortedset
+func geoSearch(sortedSet *SortedSet.SortedSet, opts *geoSearchOptions) []*geoPoint {
+	width, height := opts.width, opts.height
+	if opts.byRadius {
+		width, height = 2*opts.radius, 2*opts.radius
+	}
+	points := make([]*geoPoint, 0)
+	enough := false
+	for _, scoreRange := range geohash.SearchRanges(opts.lon, opts.lat, width, height) {
+		min := &SortedSet.ScoreBorder{Value: float64(scoreRange.Min)}
+		max := &SortedSet.ScoreBorder{Value: float64(scoreRange.Max), Exclude: true}
+		sortedSet.ForEachByScore(min, max, 0, -1, false, func(element *SortedSet.Element) bool {
+			lon, lat := geohash.Decode(uint64(element.Score))
+			dist, ok := opts.contains(lon, lat)
+			if !ok {
+				return true
+			}
+			points = append(points, &geoPoint{
+				member: element.Member,
+				score:  element.Score,
+				lon:    lon,
+				lat:    lat,
+				dist:   dist,
+			})
+			// ANY returns as soon as enough matches are found
+			enough = opts.any && int64(len(points)) >= opts.count
+			return !enough
+		})
+		if enough {
+			break
+		}
+	}
+
+	sortOrder := opts.sort
+	if sortOrder == geoSortNone && opts.count > 0 && !opts.any {
+		// the nearest are returned for COUNT
+		sortOrder = geoSortAsc
+	}
+	if sortOrder == geoSortAsc {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].dist < points[j].dist
+		})
+	} else if sortOrder == geoSortDesc {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].dist > points[j].dist
+		})
+	}
+	if opts.count > 0 && int64(len(points)) > opts.count {
+		points = points[:opts.count]
+	}
+	return points
+}
+
+// resolveCenter sets center of search to the position of FROMMEMBER
+func (opts *geoSearchOptions) resolveCenter(sortedSet *SortedSet.SortedSet) redis.Reply {
+	if !opts.hasMember {
+		return nil
+	}
+	if sortedSet == nil {
+		return reply.MakeErrReply("ERR could not decode requested zset member")
+	}
+	element, exists := sortedSet.Get(opts.fromMember)
+	if !exists {
+		return reply.MakeErrReply("ERR could not decode requested zset member")
+	}
+	opts.lon, opts.lat = geohash.Decode(uint64(element.Score))
+	return nil
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT lon lat BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func GeoSearch(db *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseGeoSearchOptions("geosearch", args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply([][]byte{})
+	}
+	if errReply := opts.resolveCenter(sortedSet); errReply != nil {
+		return errReply
+	}
+	points := geoSearch(sortedSet, opts)
+
+	if !opts.withCoord && !opts.withDist && !opts.withHash {
+		members := make([][]byte, len(points))
+		for i, point := range points {
+			members[i] = []byte(point.member)
+		}
+		return reply.MakeMultiBulkReply(members)
+	}
+	results := make([]redis.Reply, len(points))
+	for i, point := range points {
+		result := []redis.Reply{reply.MakeBulkReply([]byte(point.member))}
+		if opts.withDist {
+			result = append(result, reply.MakeBulkReply(formatDistance(point.dist/opts.unit)))
+		}
+		if opts.withHash {
+			result = append(result, reply.MakeIntReply(int64(point.score)))
+		}
+		if opts.withCoord {
+			result = append(result, reply.MakeMultiBulkReply(formatCoordinate(point.lon, point.lat)))
+		}
+		results[i] = reply.MakeMultiRawReply(result)
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+// GEOSEARCHSTORE destination source FROMMEMBER member|FROMLONLAT lon lat BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [STOREDIST]
+func GeoSearchStore(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[0])
+	opts, errReply := parseGeoSearchOptions("geosearchstore", args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	var points []*geoPoint
+	if sortedSet != nil {
+		if errReply := opts.resolveCenter(sortedSet); errReply != nil {
+			return errReply
+		}
+		points = geoSearch(sortedSet, opts)
+	}
+	if len(points) == 0 {
+		db.Remove(dest)
+		return reply.MakeIntReply(0)
+	}
+
+	result := SortedSet.Make()
+	for _, point := range points {
+		if opts.storeDist {
+			result.Add(point.member, point.dist/opts.unit)
+		} else {
+			result.Add(point.member, point.score)
+		}
+	}
+	db.Put(dest, &DataEntity{Data: result})
+	db.Persist(dest)
+	return reply.MakeIntReply(int64(len(points)))
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"math"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"strconv"
+	"testing"
+)
+
+func addSicily() {
+	GeoAdd(testDB, toArgs("Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"))
+}
+
+func TestGeoAdd(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	asserts.AssertIntReply(t, GeoAdd(testDB, toArgs("Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")), 2)
+	asserts.AssertIntReply(t, GeoAdd(testDB, toArgs("Sicily", "13.361389", "38.115556", "Palermo")), 0)
+	asserts.AssertBulkReply(t, ZScore(testDB, toArgs("Sicily", "Palermo")), "3479099956230698")
+	asserts.AssertIntReply(t, GeoAdd(testDB, toArgs("Sicily", "CH", "13.5", "38.1", "Palermo", "15", "37", "Agrigento")), 2)
+	asserts.AssertIntReply(t, GeoAdd(testDB, toArgs("Sicily", "NX", "13.361389", "38.115556", "Palermo")), 0)
+	asserts.AssertIntReply(t, GeoAdd(testDB, toArgs("Sicily", "XX", "CH", "13.361389", "38.115556", "Palermo", "14", "37", "Gela")), 1)
+	asserts.AssertIntReply(t, ZCard(testDB, toArgs("Sicily")), 3)
+
+	// XX doesn't create key
+	asserts.AssertIntReply(t, GeoAdd(testDB, toArgs("none", "XX", "13", "38", "a")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("none")), 0)
+
+	asserts.AssertErrReply(t, GeoAdd(testDB, toArgs("Sicily", "NX", "XX", "13", "38", "a")), "ERR XX and NX options at the same time are not compatible")
+	asserts.AssertErrReply(t, GeoAdd(testDB, toArgs("Sicily", "13", "38")), "Err syntax error")
+	asserts.AssertErrReply(t, GeoAdd(testDB, toArgs("Sicily", "13", "86", "a")), "ERR invalid longitude,latitude pair 13.000000,86.000000")
+	asserts.AssertErrReply(t, GeoAdd(testDB, toArgs("Sicily", "x", "38", "a")), "ERR value is not a valid float")
+}
+
+func TestGeoPos(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	addSicily()
+	result, ok := GeoPos(testDB, toArgs("Sicily", "Palermo", "none")).(*reply.MultiRawReply)
+	if !ok || len(result.Replies) != 2 {
+		t.Fatal("expected array of 2")
+	}
+	pos, ok := result.Replies[0].(*reply.MultiBulkReply)
+	if !ok || len(pos.Args) != 2 {
+		t.Fatal("expected position")
+	}
+	lon, _ := strconv.ParseFloat(string(pos.Args[0]), 64)
+	lat, _ := strconv.ParseFloat(string(pos.Args[1]), 64)
+	if math.Abs(lon-13.361389) > 1e-5 || math.Abs(lat-38.115556) > 1e-5 {
+		t.Errorf("unexpected position %f,%f", lon, lat)
+	}
+	asserts.AssertNullBulk(t, result.Replies[1])
+}
+
+func TestGeoDist(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	addSicily()
+	asserts.AssertBulkReply(t, GeoDist(testDB, toArgs("Sicily", "Palermo", "Catania")), "166274.1516")
+	asserts.AssertBulkReply(t, GeoDist(testDB, toArgs("Sicily", "Palermo", "Catania", "km")), "166.2742")
+	asserts.AssertBulkReply(t, GeoDist(testDB, toArgs("Sicily", "Palermo", "Catania", "MI")), "103.3182")
+	asserts.AssertNullBulk(t, GeoDist(testDB, toArgs("Sicily", "Palermo", "none")))
+	asserts.AssertNullBulk(t, GeoDist(testDB, toArgs("none", "Palermo", "Catania")))
+	asserts.AssertErrReply(t, GeoDist(testDB, toArgs("Sicily", "Palermo", "Catania", "yd")), "ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+func TestGeoHash(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	addSicily()
+	result := GeoHash(testDB, toArgs("Sicily", "Palermo", "Catania", "none"))
+	assertRawReply(t, result, "*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n")
+}
+
+func TestGeoSearch(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	addSicily()
+	GeoAdd(testDB, toArgs("Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2"))
+
+	asserts.AssertMultiBulkReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC")),
+		[]string{"Catania", "Palermo"})
+	asserts.AssertMultiBulkReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC")),
+		[]string{"Palermo", "Catania"})
+	asserts.AssertMultiBulkReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC")),
+		[]string{"Catania", "Palermo", "edge2", "edge1"})
+	asserts.AssertMultiBulkReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "50", "km")),
+		[]string{"Palermo"})
+	// nearest members are returned for COUNT
+	asserts.AssertMultiBulkReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "1")),
+		[]string{"Catania"})
+	if result, ok := GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "2", "ANY")).(*reply.MultiBulkReply); !ok || len(result.Args) != 2 {
+		t.Error("expected 2 members for COUNT ANY")
+	}
+
+	result := GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST", "WITHHASH"))
+	assertRawReply(t, result, "*2\r\n"+
+		"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n:3479447370796909\r\n"+
+		"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n:3479099956230698\r\n")
+	result = GeoSearch(testDB, toArgs("Sicily", "FROMMEMBER", "Catania", "BYRADIUS", "1", "m", "WITHCOORD"))
+	rows, ok := result.(*reply.MultiRawReply)
+	if !ok || len(rows.Replies) != 1 || len(rows.Replies[0].(*reply.MultiRawReply).Replies) != 2 {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+
+	asserts.AssertMultiBulkReply(t, GeoSearch(testDB, toArgs("none", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km")), []string{})
+	asserts.AssertErrReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMMEMBER", "none", "BYRADIUS", "200", "km")), "ERR could not decode requested zset member")
+	asserts.AssertErrReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMMEMBER", "Palermo", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km")),
+		"ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+	asserts.AssertErrReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "COUNT", "1")),
+		"ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+	asserts.AssertErrReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "COUNT", "0")), "ERR COUNT must be > 0")
+	asserts.AssertErrReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ANY")), "ERR the ANY argument requires COUNT argument")
+	asserts.AssertErrReply(t, GeoSearch(testDB, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "km")), "ERR radius cannot be negative")
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	addSicily()
+	asserts.AssertIntReply(t, GeoSearchStore(testDB, toArgs("dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km")), 2)
+	assertRawReply(t, GeoHash(testDB, toArgs("dest", "Palermo")), "*1\r\n$11\r\nsqc8b49rny0\r\n")
+
+	// distances are stored as scores with STOREDIST
+	asserts.AssertIntReply(t, GeoSearchStore(testDB, toArgs("dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km", "STOREDIST")), 1)
+	score, _ := strconv.ParseFloat(string(ZScore(testDB, toArgs("dest", "Catania")).(*reply.BulkReply).Arg), 64)
+	if math.Abs(score-56.4413) > 0.0001 {
+		t.Errorf("expected distance 56.4413, actually %f", score)
+	}
+	asserts.AssertIntReply(t, ZCard(testDB, toArgs("dest")), 1)
+
+	// empty result removes dest
+	asserts.AssertIntReply(t, GeoSearchStore(testDB, toArgs("dest", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("dest")), 0)
+	asserts.AssertErrReply(t, GeoSearchStore(testDB, toArgs("dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "WITHDIST")), "Err syntax error")
+}
@@ -1,10 +1,14 @@
 package db
 
 import (
+	"math"
 	"my-godis/src/datastruct/decimal"
 	Dict "my-godis/src/datastruct/dict"
 	"my-godis/src/interface/redis"
 	"my-godis/src/redis/reply"
+	"my-godis/src/stats"
+	"strings"
+	"time"
 
 	"strconv"
 )
@@ -39,58 +43,41 @@ func (db *DB) getOrInitDict(key string) (dict Dict.Dict, inited bool, errReply r
 
 func HSet(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hset' command")
-	}
 	key := string(args[0])
 	field := string(args[1])
 	value := args[2]
 
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	// get or init entity
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
 	}
 
+	// overwriting field clears its ttl
+	db.purgeField(key, dict, field)
 	result := dict.Put(field, value)
-	db.AddAof(makeAofCmd("hset", args))
+	db.persistField(key, field)
 	return reply.MakeIntReply(int64(result))
 }
 
 func HSetNX(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hsetnx' command")
-	}
 	key := string(args[0])
 	field := string(args[1])
 	value := args[2]
 
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
 	}
 
+	db.purgeField(key, dict, field)
 	result := dict.PutIfAbsent(field, value)
-	if result > 0 {
-		db.AddAof(makeAofCmd("hsetnx", args))
-
-	}
 	return reply.MakeIntReply(int64(result))
 }
 
 func HGet(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hget' command")
-	}
 	key := string(args[0])
 	field := string(args[1])
 
@@ -103,19 +90,15 @@ func HGet(db *DB, args [][]byte) redis.Reply {
 		return &reply.NullBulkReply{}
 	}
 
-	raw, exists := dict.Get(field)
+	value, exists := db.getField(key, dict, field)
 	if !exists {
 		return &reply.NullBulkReply{}
 	}
-	value, _ := raw.([]byte)
 	return reply.MakeBulkReply(value)
 }
 
 func HExists(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hexists' command")
-	}
 	key := string(args[0])
 	field := string(args[1])
 
@@ -128,7 +111,7 @@ func HExists(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeIntReply(0)
 	}
 
-	_, exists := dict.Get(field)
+	_, exists := db.getField(key, dict, field)
 	if exists {
 		return reply.MakeIntReply(1)
 	}
@@ -137,9 +120,6 @@ func HExists(db *DB, args [][]byte) redis.Reply {
 
 func HDel(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hdel' command")
-	}
 	key := string(args[0])
 	fields := make([]string, len(args)-1)
 	fieldArgs := args[1:]
@@ -147,9 +127,6 @@ func HDel(db *DB, args [][]byte) redis.Reply {
 		fields[i] = string(v)
 	}
 
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	// get entity
 	dict, errReply := db.getAsDict(key)
 	if errReply != nil {
@@ -161,27 +138,23 @@ func HDel(db *DB, args [][]byte) redis.Reply {
 
 	deleted := 0
 	for _, field := range fields {
+		db.purgeField(key, dict, field)
 		result := dict.Remove(field)
+		db.persistField(key, field)
 		deleted += result
 	}
 	if dict.Len() == 0 {
 		db.Remove(key)
 	}
-	if deleted > 0 {
-		db.AddAof(makeAofCmd("hdel", args))
-	}
 
 	return reply.MakeIntReply(int64(deleted))
 }
 
 func HLen(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hlen' command")
-	}
 	key := string(args[0])
 
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.getAsLiveDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -205,10 +178,6 @@ func HMSet(db *DB, args [][]byte) redis.Reply {
 		values[i] = args[2*i+2]
 	}
 
-	// lock key
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
 	// get or init entity
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
@@ -218,16 +187,14 @@ func HMSet(db *DB, args [][]byte) redis.Reply {
 	// put data
 	for i, field := range fields {
 		value := values[i]
+		db.purgeField(key, dict, field)
 		dict.Put(field, value)
+		db.persistField(key, field)
 	}
-	db.AddAof(makeAofCmd("hmset", args))
 	return &reply.OkReply{}
 }
 
 func HMGet(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hmget' command")
-	}
 	key := string(args[0])
 	size := len(args) - 1
 	fields := make([]string, size)
@@ -235,9 +202,6 @@ func HMGet(db *DB, args [][]byte) redis.Reply {
 		fields[i] = string(args[i+1])
 	}
 
-	db.RLock(key)
-	defer db.RUnLock(key)
-
 	// get entity
 	result := make([][]byte, size)
 	dict, errReply := db.getAsDict(key)
@@ -249,27 +213,15 @@ func HMGet(db *DB, args [][]byte) redis.Reply {
 	}
 
 	for i, field := range fields {
-		value, ok := dict.Get(field)
-		if !ok {
-			result[i] = nil
-		} else {
-			bytes, _ := value.([]byte)
-			result[i] = bytes
-		}
+		result[i], _ = db.getField(key, dict, field)
 	}
 	return reply.MakeMultiBulkReply(result)
 }
 
 func HKeys(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hkeys' command")
-	}
 	key := string(args[0])
 
-	db.RLock(key)
-	defer db.RUnLock(key)
-
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.getAsLiveDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -288,16 +240,10 @@ func HKeys(db *DB, args [][]byte) redis.Reply {
 }
 
 func HVals(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hvals' command")
-	}
 	key := string(args[0])
 
-	db.RLock(key)
-	defer db.RUnLock(key)
-
 	// get entity
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.getAsLiveDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -316,16 +262,10 @@ func HVals(db *DB, args [][]byte) redis.Reply {
 }
 
 func HGetAll(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hgetAll' command")
-	}
 	key := string(args[0])
 
-	db.RLock(key)
-	defer db.RUnLock(key)
-
 	// get entity
-	dict, errReply := db.getAsDict(key)
+	dict, errReply := db.getAsLiveDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -347,9 +287,6 @@ func HGetAll(db *DB, args [][]byte) redis.Reply {
 }
 
 func HIncrBy(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hincrby' command")
-	}
 	key := string(args[0])
 	field := string(args[1])
 	rawDelta := string(args[2])
@@ -358,18 +295,16 @@ func HIncrBy(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
 
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
 	}
 
+	// ttl of field is kept
+	db.purgeField(key, dict, field)
 	value, exists := dict.Get(field)
 	if !exists {
 		dict.Put(field, args[2])
-		db.AddAof(makeAofCmd("hincrby", args))
 		return reply.MakeBulkReply(args[2])
 	} else {
 		val, err := strconv.ParseInt(string(value.([]byte)), 10, 64)
@@ -379,15 +314,11 @@ func HIncrBy(db *DB, args [][]byte) redis.Reply {
 		val += delta
 		bytes := []byte(strconv.FormatInt(val, 10))
 		dict.Put(field, bytes)
-		db.AddAof(makeAofCmd("hincrby", args))
 		return reply.MakeBulkReply(bytes)
 	}
 }
 
 func HIncrByFloat(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'hincrbyfloat' command")
-	}
 	key := string(args[0])
 	field := string(args[1])
 	rawDelta := string(args[2])
@@ -396,15 +327,14 @@ func HIncrByFloat(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
 
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	// get or init entity
 	dict, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
 	}
 
+	// ttl of field is kept
+	db.purgeField(key, dict, field)
 	value, exists := dict.Get(field)
 	if !exists {
 		dict.Put(field, args[2])
@@ -417,7 +347,526 @@ func HIncrByFloat(db *DB, args [][]byte) redis.Reply {
 		result := val.Add(delta)
 		resultBytes := []byte(result.String())
 		dict.Put(field, resultBytes)
-		db.AddAof(makeAofCmd("hincrbyfloat", args))
 		return reply.MakeBulkReply(resultBytes)
 	}
 }
+
+// HINCRBYFLOAT is appended as HSET of the result
+func rewriteHIncrByFloat(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	bulk, ok := result.(*reply.BulkReply)
+	if !ok {
+		return nil
+	}
+	return []*reply.MultiBulkReply{makeAofCmd("hset", [][]byte{args[1], args[2], bulk.Arg})}
+}
+
+// HSTRLEN key field
+func HStrLen(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+	value, _ := db.getField(key, dict, field)
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+func HRandField(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 3 || (len(args) == 3 && strings.ToUpper(string(args[2])) != "WITHVALUES") {
+		return &reply.SyntaxErrReply{}
+	}
+	key := string(args[0])
+	dict, errReply := db.getAsLiveDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if dict == nil {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply([]byte(dict.RandomKeys(1)[0]))
+	}
+
+	count, errReply := parseRandomCount(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil || count == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	// positive count returns distinct fields, negative count allows repeated ones
+	var fields []string
+	if count > 0 {
+		fields = dict.RandomDistinctKeys(count)
+	} else {
+		fields = dict.RandomKeys(-count)
+	}
+	withValues := len(args) == 3
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			value, _ := dict.Get(field)
+			bytes, _ := value.([]byte)
+			result = append(result, bytes)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+/* ---- field ttl ---- */
+
+/*
+ * hash fields could expire by themselves, FieldTTLMap indexes their expire time by key.
+ * maps in FieldTTLMap are modified in place while the key is locked, the same as hashes in Data.
+ * expired fields are hidden from read commands, removed by write commands touching them
+ * and by the active expiry cycle.
+ */
+
+// fieldExpireTime returns expire time of field, false if it has no ttl
+func (db *DB) fieldExpireTime(key string, field string) (time.Time, bool) {
+	if db.FieldTTLMap.Len() == 0 {
+		return time.Time{}, false
+	}
+	raw, ok := db.FieldTTLMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	expireTime, ok := raw.(map[string]time.Time)[field]
+	return expireTime, ok
+}
+
+func (db *DB) isFieldExpired(key string, field string) bool {
+	expireTime, ok := db.fieldExpireTime(key, field)
+	return ok && time.Now().After(expireTime)
+}
+
+// expireField sets ttl of field, caller must hold lock of key
+func (db *DB) expireField(key string, field string, expireTime time.Time) {
+	raw, ok := db.FieldTTLMap.Get(key)
+	if !ok {
+		db.FieldTTLMap.Put(key, map[string]time.Time{field: expireTime})
+		return
+	}
+	raw.(map[string]time.Time)[field] = expireTime
+}
+
+// persistField removes ttl of field, caller must hold lock of key
+func (db *DB) persistField(key string, field string) bool {
+	if db.FieldTTLMap.Len() == 0 {
+		return false
+	}
+	raw, ok := db.FieldTTLMap.Get(key)
+	if !ok {
+		return false
+	}
+	ttls := raw.(map[string]time.Time)
+	if _, ok := ttls[field]; !ok {
+		return false
+	}
+	delete(ttls, field)
+	if len(ttls) == 0 {
+		db.FieldTTLMap.Remove(key)
+	}
+	return true
+}
+
+// removeFieldTTLs removes ttl of all fields of key, called after key is removed or replaced
+func (db *DB) removeFieldTTLs(key string) {
+	if db.FieldTTLMap.Len() > 0 {
+		db.FieldTTLMap.Remove(key)
+	}
+}
+
+// purgeField removes field if it is expired, write commands call it before touching the field
+func (db *DB) purgeField(key string, dict Dict.Dict, field string) {
+	if db.isFieldExpired(key, field) {
+		dict.Remove(field)
+		db.persistField(key, field)
+	}
+}
+
+// getField returns value of field unless it is expired, it doesn't modify the hash so that read commands could call it
+func (db *DB) getField(key string, dict Dict.Dict, field string) ([]byte, bool) {
+	raw, ok := dict.Get(field)
+	if !ok || db.isFieldExpired(key, field) {
+		return nil, false
+	}
+	value, _ := raw.([]byte)
+	return value, true
+}
+
+// allFieldsExpired reports whether every field of hash has expired, the hash is still kept
+func (db *DB) allFieldsExpired(key string, dict Dict.Dict, now time.Time) bool {
+	if db.FieldTTLMap.Len() == 0 {
+		return false
+	}
+	raw, ok := db.FieldTTLMap.Get(key)
+	if !ok {
+		return false
+	}
+	ttls := raw.(map[string]time.Time)
+	if len(ttls) < dict.Len() {
+		return false
+	}
+	expired := 0
+	for _, expireTime := range ttls {
+		if now.After(expireTime) {
+			expired++
+		}
+	}
+	return expired >= dict.Len()
+}
+
+// isHashExpired removes hash whose fields have all expired, like IsExpired does for keys
+func (db *DB) isHashExpired(key string, entity *DataEntity) bool {
+	dict, ok := entity.Data.(Dict.Dict)
+	if !ok || !db.allFieldsExpired(key, dict, time.Now()) {
+		return false
+	}
+	db.Remove(key)
+	stats.Incr(&stats.ExpiredKeys)
+	return true
+}
+
+// getAsLiveDict returns hash without expired fields for read commands,
+// which is a copy if there are expired fields waiting to be removed, or nil if all fields are expired
+func (db *DB) getAsLiveDict(key string) (Dict.Dict, reply.ErrorReply) {
+	dict, errReply := db.getAsDict(key)
+	if dict == nil || errReply != nil || db.FieldTTLMap.Len() == 0 {
+		return dict, errReply
+	}
+	raw, ok := db.FieldTTLMap.Get(key)
+	if !ok {
+		return dict, nil
+	}
+	ttls := raw.(map[string]time.Time)
+	now := time.Now()
+	expired := 0
+	for _, expireTime := range ttls {
+		if now.After(expireTime) {
+			expired++
+		}
+	}
+	if expired == 0 {
+		return dict, nil
+	}
+	if expired >= dict.Len() {
+		return nil, nil
+	}
+	live := Dict.MakeSimple()
+	dict.ForEach(func(field string, val interface{}) bool {
+		if expireTime, ok := ttls[field]; !ok || !now.After(expireTime) {
+			live.Put(field, val)
+		}
+		return true
+	})
+	return live, nil
+}
+
+// cleanExpiredFields removes expired fields, and hashes which become empty
+func (db *DB) cleanExpiredFields(now time.Time) {
+	if db.FieldTTLMap.Len() == 0 {
+		return
+	}
+	// maps are read after keys are locked
+	keys := make([]string, 0, db.FieldTTLMap.Len())
+	db.FieldTTLMap.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		db.cleanExpiredFieldsOf(key, now)
+	}
+}
+
+func (db *DB) cleanExpiredFieldsOf(key string, now time.Time) {
+	db.snapshotMu.RLock()
+	defer db.snapshotMu.RUnlock()
+	locker := db.Locker
+	locker.Locks(key)
+	db.preserve(key)
+	defer locker.UnLocks(key)
+
+	rawTTLs, ok := db.FieldTTLMap.Get(key)
+	if !ok {
+		return
+	}
+	ttls := rawTTLs.(map[string]time.Time)
+	raw, ok := db.Data.Get(key)
+	if !ok {
+		db.FieldTTLMap.Remove(key)
+		return
+	}
+	dict, ok := raw.(*DataEntity).Data.(Dict.Dict)
+	if !ok {
+		db.FieldTTLMap.Remove(key)
+		return
+	}
+	for field, expireTime := range ttls {
+		if now.After(expireTime) {
+			dict.Remove(field)
+			delete(ttls, field)
+		}
+	}
+	if len(ttls) == 0 {
+		db.FieldTTLMap.Remove(key)
+	}
+	if dict.Len() == 0 {
+		db.Remove(key)
+		stats.Incr(&stats.ExpiredKeys)
+	}
+}
+
+// parseFields parses `FIELDS numfields field [field ...]` which ends the command
+func parseFields(args [][]byte) ([]string, redis.Reply) {
+	if len(args) < 2 || strings.ToUpper(string(args[0])) != "FIELDS" {
+		return nil, reply.MakeErrReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numFields <= 0 {
+		return nil, reply.MakeErrReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	if numFields != len(args)-2 {
+		return nil, reply.MakeErrReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	fields := make([]string, numFields)
+	for i, arg := range args[2:] {
+		fields[i] = string(arg)
+	}
+	return fields, nil
+}
+
+func makeCodesReply(codes []int64) redis.Reply {
+	replies := make([]redis.Reply, len(codes))
+	for i, code := range codes {
+		replies[i] = reply.MakeIntReply(code)
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+const (
+	fieldNotExists = -2
+	fieldNoTTL     = -1
+)
+
+// hexpire implements `key time [NX | XX | GT | LT] FIELDS numfields field [field ...]`,
+// time is in unit, and is unix time if absolute
+func hexpire(db *DB, cmdName string, args [][]byte, unit time.Duration, absolute bool) redis.Reply {
+	key := string(args[0])
+	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if raw < 0 {
+		return reply.MakeErrReply("ERR invalid expire time, must be >= 0")
+	}
+	if raw > math.MaxInt64/int64(unit) {
+		return reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	var expireTime time.Time
+	if absolute {
+		expireTime = time.Unix(0, 0).Add(time.Duration(raw) * unit)
+	} else {
+		expireTime = time.Now().Add(time.Duration(raw) * unit)
+	}
+	rest := args[2:]
+	condition := ""
+	if len(rest) > 0 {
+		switch option := strings.ToUpper(string(rest[0])); option {
+		case "NX", "XX", "GT", "LT":
+			condition = option
+			rest = rest[1:]
+		}
+	}
+	fields, errReply := parseFields(rest)
+	if errReply != nil {
+		return errReply
+	}
+
+	codes := make([]int64, len(fields))
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		for i := range codes {
+			codes[i] = fieldNotExists
+		}
+		return makeCodesReply(codes)
+	}
+	now := time.Now()
+	for i, field := range fields {
+		db.purgeField(key, dict, field)
+		if _, exists := dict.Get(field); !exists {
+			codes[i] = fieldNotExists
+			continue
+		}
+		// fields without ttl are treated as having infinite ttl
+		current, hasTTL := db.fieldExpireTime(key, field)
+		if (condition == "NX" && hasTTL) ||
+			(condition == "XX" && !hasTTL) ||
+			(condition == "GT" && (!hasTTL || !expireTime.After(current))) ||
+			(condition == "LT" && hasTTL && !expireTime.Before(current)) {
+			codes[i] = 0
+			continue
+		}
+		if !expireTime.After(now) {
+			// expire time in the past removes the field
+			dict.Remove(field)
+			db.persistField(key, field)
+			codes[i] = 2
+			continue
+		}
+		db.expireField(key, field, expireTime)
+		codes[i] = 1
+	}
+	if dict.Len() == 0 {
+		db.Remove(key)
+	}
+	return makeCodesReply(codes)
+}
+
+// HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HExpire(db *DB, args [][]byte) redis.Reply {
+	return hexpire(db, "hexpire", args, time.Second, false)
+}
+
+// HPEXPIRE key milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HPExpire(db *DB, args [][]byte) redis.Reply {
+	return hexpire(db, "hpexpire", args, time.Millisecond, false)
+}
+
+// HEXPIREAT key unix-time-seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HExpireAt(db *DB, args [][]byte) redis.Reply {
+	return hexpire(db, "hexpireat", args, time.Second, true)
+}
+
+// HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HPExpireAt(db *DB, args [][]byte) redis.Reply {
+	return hexpire(db, "hpexpireat", args, time.Millisecond, true)
+}
+
+// field ttl is appended as HPEXPIREAT with absolute time, fields removed by expire time in the past are appended as HDEL
+func rewriteHExpire(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	codes, ok := result.(*reply.MultiRawReply)
+	if !ok {
+		return nil
+	}
+	key := string(args[1])
+	// fields are the last arguments
+	fields := args[len(args)-len(codes.Replies):]
+	var expired, removed [][]byte
+	for i, r := range codes.Replies {
+		code, _ := r.(*reply.IntReply)
+		switch code.Code {
+		case 1:
+			expired = append(expired, fields[i])
+		case 2:
+			removed = append(removed, fields[i])
+		}
+	}
+	var cmds []*reply.MultiBulkReply
+	if len(expired) > 0 {
+		expireTime, _ := db.fieldExpireTime(key, string(expired[0]))
+		cmds = append(cmds, makeFieldExpireCmd(key, expireTime, expired))
+	}
+	if len(removed) > 0 {
+		cmds = append(cmds, makeAofCmd("hdel", append([][]byte{args[1]}, removed...)))
+	}
+	return cmds
+}
+
+// httl implements `key FIELDS numfields field [field ...]`, returns ttl in unit, or unix time if absolute
+func httl(db *DB, args [][]byte, unit time.Duration, absolute bool) redis.Reply {
+	key := string(args[0])
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	codes := make([]int64, len(fields))
+	for i, field := range fields {
+		if dict == nil {
+			codes[i] = fieldNotExists
+			continue
+		}
+		if _, exists := db.getField(key, dict, field); !exists {
+			codes[i] = fieldNotExists
+			continue
+		}
+		expireTime, hasTTL := db.fieldExpireTime(key, field)
+		if !hasTTL {
+			codes[i] = fieldNoTTL
+		} else if absolute {
+			codes[i] = expireTime.UnixNano() / int64(unit)
+		} else {
+			codes[i] = int64(time.Until(expireTime) / unit)
+		}
+	}
+	return makeCodesReply(codes)
+}
+
+// HTTL key FIELDS numfields field [field ...]
+func HTTL(db *DB, args [][]byte) redis.Reply {
+	return httl(db, args, time.Second, false)
+}
+
+// HPTTL key FIELDS numfields field [field ...]
+func HPTTL(db *DB, args [][]byte) redis.Reply {
+	return httl(db, args, time.Millisecond, false)
+}
+
+// HEXPIRETIME key FIELDS numfields field [field ...]
+func HExpireTime(db *DB, args [][]byte) redis.Reply {
+	return httl(db, args, time.Second, true)
+}
+
+// HPEXPIRETIME key FIELDS numfields field [field ...]
+func HPExpireTime(db *DB, args [][]byte) redis.Reply {
+	return httl(db, args, time.Millisecond, true)
+}
+
+// HPERSIST key FIELDS numfields field [field ...]
+func HPersist(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	codes := make([]int64, len(fields))
+	for i, field := range fields {
+		if dict == nil {
+			codes[i] = fieldNotExists
+			continue
+		}
+		db.purgeField(key, dict, field)
+		if _, exists := dict.Get(field); !exists {
+			codes[i] = fieldNotExists
+		} else if db.persistField(key, field) {
+			codes[i] = 1
+		} else {
+			codes[i] = fieldNoTTL
+		}
+	}
+	if dict != nil && dict.Len() == 0 {
+		db.Remove(key)
+	}
+	return makeCodesReply(codes)
+}
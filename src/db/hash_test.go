@@ -5,8 +5,10 @@ import (
 	"math/rand"
 	"my-godis/src/datastruct/utils"
 	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestHSet(t *testing.T) {
@@ -198,3 +200,137 @@ func TestHIncrBy(t *testing.T) {
 		t.Error(fmt.Sprintf("expected %s, actually %s", "2.4", string(bulkResult.Arg)))
 	}
 }
+
+func TestHStrLen(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	HSet(testDB, toArgs("h", "f", "hello"))
+	asserts.AssertIntReply(t, HStrLen(testDB, toArgs("h", "f")), 5)
+	asserts.AssertIntReply(t, HStrLen(testDB, toArgs("h", "none")), 0)
+	asserts.AssertIntReply(t, HStrLen(testDB, toArgs("none", "f")), 0)
+}
+
+func TestHRandField(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	HMSet(testDB, toArgs("h", "a", "1", "b", "2", "c", "3"))
+	if r, ok := HRandField(testDB, toArgs("h")).(*reply.BulkReply); !ok || len(r.Arg) != 1 {
+		t.Error("expected a field")
+	}
+	if r, ok := HRandField(testDB, toArgs("h", "5")).(*reply.MultiBulkReply); !ok || len(r.Args) != 3 {
+		t.Error("expected 3 distinct fields")
+	}
+	if r, ok := HRandField(testDB, toArgs("h", "-5")).(*reply.MultiBulkReply); !ok || len(r.Args) != 5 {
+		t.Error("expected 5 fields")
+	}
+	r, ok := HRandField(testDB, toArgs("h", "2", "WITHVALUES")).(*reply.MultiBulkReply)
+	if !ok || len(r.Args) != 4 {
+		t.Fatal("expected 2 fields with values")
+	}
+	for i := 0; i < len(r.Args); i += 2 {
+		asserts.AssertBulkReply(t, HGet(testDB, toArgs("h", string(r.Args[i]))), string(r.Args[i+1]))
+	}
+	asserts.AssertNullBulk(t, HRandField(testDB, toArgs("none")))
+	assertRawReply(t, HRandField(testDB, toArgs("none", "1")), "*0\r\n")
+	asserts.AssertErrReply(t, HRandField(testDB, toArgs("h", "1", "WITHSCORES")), "Err syntax error")
+	asserts.AssertErrReply(t, HRandField(testDB, toArgs("h", "-2000000000")), "ERR value is out of range")
+}
+
+func TestHExpire(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	HMSet(testDB, toArgs("h", "a", "1", "b", "2", "c", "3"))
+	assertRawReply(t, HExpire(testDB, toArgs("h", "100", "FIELDS", "2", "a", "none")), "*2\r\n:1\r\n:-2\r\n")
+	assertRawReply(t, HTTL(testDB, toArgs("h", "FIELDS", "2", "a", "b")), "*2\r\n:99\r\n:-1\r\n")
+	if r := HPTTL(testDB, toArgs("h", "FIELDS", "1", "a")).(*reply.MultiRawReply); r.Replies[0].(*reply.IntReply).Code <= 99000 {
+		t.Error("expected ttl in milliseconds")
+	}
+
+	// conditions
+	assertRawReply(t, HExpire(testDB, toArgs("h", "200", "NX", "FIELDS", "2", "a", "b")), "*2\r\n:0\r\n:1\r\n")
+	assertRawReply(t, HExpire(testDB, toArgs("h", "300", "XX", "FIELDS", "2", "a", "c")), "*2\r\n:1\r\n:0\r\n")
+	assertRawReply(t, HExpire(testDB, toArgs("h", "250", "GT", "FIELDS", "2", "a", "c")), "*2\r\n:0\r\n:0\r\n")
+	assertRawReply(t, HExpire(testDB, toArgs("h", "250", "LT", "FIELDS", "2", "a", "c")), "*2\r\n:1\r\n:1\r\n")
+	at := time.Now().Add(time.Hour).Unix()
+	HExpireAt(testDB, toArgs("h", strconv.FormatInt(at, 10), "FIELDS", "1", "a"))
+	assertRawReply(t, HExpireTime(testDB, toArgs("h", "FIELDS", "1", "a")), fmt.Sprintf("*1\r\n:%d\r\n", at))
+
+	// HPERSIST and HSET clear ttl, HINCRBY keeps it
+	assertRawReply(t, HPersist(testDB, toArgs("h", "FIELDS", "3", "a", "b", "none")), "*3\r\n:1\r\n:1\r\n:-2\r\n")
+	HExpire(testDB, toArgs("h", "100", "FIELDS", "2", "a", "b"))
+	HSet(testDB, toArgs("h", "a", "10"))
+	HIncrBy(testDB, toArgs("h", "b", "1"))
+	assertRawReply(t, HTTL(testDB, toArgs("h", "FIELDS", "2", "a", "b")), "*2\r\n:-1\r\n:99\r\n")
+
+	// expire time in the past removes fields, and the key if it becomes empty
+	assertRawReply(t, HExpire(testDB, toArgs("h", "0", "FIELDS", "2", "a", "b")), "*2\r\n:2\r\n:2\r\n")
+	asserts.AssertIntReply(t, HLen(testDB, toArgs("h")), 1)
+	assertRawReply(t, HPExpireAt(testDB, toArgs("h", "1", "FIELDS", "1", "c")), "*1\r\n:2\r\n")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("h")), 0)
+	assertRawReply(t, HTTL(testDB, toArgs("h", "FIELDS", "1", "a")), "*1\r\n:-2\r\n")
+
+	asserts.AssertErrReply(t, HExpire(testDB, toArgs("h", "100", "FIELDS", "2", "a")), "ERR The `numfields` parameter must match the number of arguments")
+	asserts.AssertErrReply(t, HExpire(testDB, toArgs("h", "100", "FIELDS", "0", "a")), "ERR Parameter `numFields` should be greater than 0")
+	asserts.AssertErrReply(t, HExpire(testDB, toArgs("h", "100", "NX", "a", "b", "c")), "ERR Mandatory argument FIELDS is missing or not at the right position")
+	asserts.AssertErrReply(t, HExpire(testDB, toArgs("h", "-1", "FIELDS", "1", "a")), "ERR invalid expire time, must be >= 0")
+}
+
+func TestAllFieldsExpired(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	HMSet(testDB, toArgs("h", "a", "1", "b", "2"))
+	HMSet(testDB, toArgs("h2", "a", "1"))
+	asserts.AssertStatusReply(t, Type(testDB, toArgs("h")), "hash")
+	testDB.expireField("h", "a", time.Now().Add(-time.Second))
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("h")), 1)
+
+	// the key is gone once its last field expires
+	testDB.expireField("h", "b", time.Now().Add(-time.Second))
+	testDB.expireField("h2", "a", time.Now().Add(-time.Second))
+	asserts.AssertMultiBulkReply(t, Keys(testDB, toArgs("h*")), []string{})
+	asserts.AssertStatusReply(t, Type(testDB, toArgs("h2")), "none")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("h")), 0)
+	for _, key := range []string{"h", "h2"} {
+		if _, ok := testDB.Data.Get(key); ok {
+			t.Errorf("expected %s removed", key)
+		}
+		if _, ok := testDB.FieldTTLMap.Get(key); ok {
+			t.Errorf("expected field ttl of %s removed", key)
+		}
+	}
+}
+
+func TestExpiredFields(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	HMSet(testDB, toArgs("h", "a", "1", "b", "2", "c", "3"))
+	// expired fields are hidden before they are removed
+	testDB.expireField("h", "a", time.Now().Add(-time.Second))
+	asserts.AssertNullBulk(t, HGet(testDB, toArgs("h", "a")))
+	asserts.AssertIntReply(t, HExists(testDB, toArgs("h", "a")), 0)
+	asserts.AssertIntReply(t, HLen(testDB, toArgs("h")), 2)
+	asserts.AssertIntReply(t, HStrLen(testDB, toArgs("h", "a")), 0)
+	if r := HKeys(testDB, toArgs("h")).(*reply.MultiBulkReply); len(r.Args) != 2 {
+		t.Error("expected 2 fields")
+	}
+	// write commands treat expired fields as missing
+	asserts.AssertIntReply(t, HSetNX(testDB, toArgs("h", "a", "new")), 1)
+	asserts.AssertBulkReply(t, HGet(testDB, toArgs("h", "a")), "new")
+	testDB.expireField("h", "b", time.Now().Add(-time.Second))
+	asserts.AssertBulkReply(t, HIncrBy(testDB, toArgs("h", "b", "5")), "5")
+
+	// active expiry removes fields and empty hashes
+	testDB.expireField("h", "a", time.Now().Add(-time.Second))
+	testDB.expireField("h", "c", time.Now().Add(-time.Second))
+	testDB.CleanExpired()
+	if _, ok := testDB.FieldTTLMap.Get("h"); ok {
+		t.Error("expected field ttl removed")
+	}
+	asserts.AssertMultiBulkReply(t, HKeys(testDB, toArgs("h")), []string{"b"})
+	testDB.expireField("h", "b", time.Now().Add(-time.Second))
+	testDB.CleanExpired()
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("h")), 0)
+
+	// ttl of fields is removed with the key
+	HSet(testDB, toArgs("h", "a", "1"))
+	HExpire(testDB, toArgs("h", "100", "FIELDS", "1", "a"))
+	Set(testDB, toArgs("h", "str"))
+	Del(testDB, toArgs("h"))
+	HSet(testDB, toArgs("h", "a", "1"))
+	assertRawReply(t, HTTL(testDB, toArgs("h", "FIELDS", "1", "a")), "*1\r\n:-1\r\n")
+}
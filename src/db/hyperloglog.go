@@ -0,0 +1,105 @@
+package db
+
+import (
+	"my-godis/src/datastruct/hyperloglog"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+)
+
+// getAsHyperLogLog returns nil if key not exists, the hyperloglog shares memory with the value in db
+func (db *DB) getAsHyperLogLog(key string) (*hyperloglog.HyperLogLog, redis.Reply) {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if bytes == nil {
+		return nil, nil
+	}
+	h, err := hyperloglog.FromBytes(bytes)
+	if err != nil {
+		return nil, reply.MakeErrReply(err.Error())
+	}
+	return h, nil
+}
+
+// copyHyperLogLog makes a hyperloglog which could be modified, the value in db may be shared by replies or args
+func copyHyperLogLog(h *hyperloglog.HyperLogLog) *hyperloglog.HyperLogLog {
+	if h == nil {
+		return hyperloglog.Make()
+	}
+	bytes := make([]byte, len(h.ToBytes()))
+	copy(bytes, h.ToBytes())
+	h, _ = hyperloglog.FromBytes(bytes)
+	return h
+}
+
+// PFADD key [element [element ...]]
+func PFAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	old, errReply := db.getAsHyperLogLog(key)
+	if errReply != nil {
+		return errReply
+	}
+	h := copyHyperLogLog(old)
+	changed, err := h.Add(args[1:]...)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	if old != nil && !changed {
+		return reply.MakeIntReply(0)
+	}
+	db.Put(key, &DataEntity{Data: h.ToBytes()})
+	return reply.MakeIntReply(1)
+}
+
+// PFCOUNT key [key ...]
+func PFCount(db *DB, args [][]byte) redis.Reply {
+	hlls := make([]*hyperloglog.HyperLogLog, 0, len(args))
+	for _, arg := range args {
+		h, errReply := db.getAsHyperLogLog(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if h != nil {
+			hlls = append(hlls, h)
+		}
+	}
+	var count uint64
+	var err error
+	if len(hlls) == 1 {
+		// the cached cardinality is only valid in values restored from redis,
+		// pfcount holds read locks and never writes it back
+		count, err = hlls[0].Count()
+	} else {
+		count, err = hyperloglog.CountUnion(hlls...)
+	}
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeIntReply(int64(count))
+}
+
+// PFMERGE destkey [sourcekey [sourcekey ...]]
+func PFMerge(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[0])
+	old, errReply := db.getAsHyperLogLog(dest)
+	if errReply != nil {
+		return errReply
+	}
+	sources := make([]*hyperloglog.HyperLogLog, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		h, errReply := db.getAsHyperLogLog(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if h != nil {
+			sources = append(sources, h)
+		}
+	}
+	h := copyHyperLogLog(old)
+	if err := h.Merge(sources...); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	db.Put(dest, &DataEntity{Data: h.ToBytes()})
+	return &reply.OkReply{}
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"strconv"
+	"testing"
+)
+
+func TestPFAdd(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	asserts.AssertIntReply(t, PFAdd(testDB, toArgs("hll", "a", "b", "c")), 1)
+	asserts.AssertIntReply(t, PFAdd(testDB, toArgs("hll", "a")), 0)
+	asserts.AssertIntReply(t, PFCount(testDB, toArgs("hll")), 3)
+	asserts.AssertIntReply(t, PFCount(testDB, toArgs("none")), 0)
+
+	// key is created without elements
+	asserts.AssertIntReply(t, PFAdd(testDB, toArgs("empty")), 1)
+	asserts.AssertIntReply(t, PFAdd(testDB, toArgs("empty")), 0)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("empty")), "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff")
+
+	// values are strings which could be copied by GET and SET
+	raw := Get(testDB, toArgs("hll")).(*reply.BulkReply).Arg
+	Set(testDB, toArgs("copy", string(raw)))
+	asserts.AssertIntReply(t, PFCount(testDB, toArgs("copy")), 3)
+
+	Set(testDB, toArgs("str", "hello"))
+	asserts.AssertErrReply(t, PFAdd(testDB, toArgs("str", "a")), "WRONGTYPE Key is not a valid HyperLogLog string value.")
+	asserts.AssertErrReply(t, PFCount(testDB, toArgs("str")), "WRONGTYPE Key is not a valid HyperLogLog string value.")
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, PFCount(testDB, toArgs("list")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+	Set(testDB, toArgs("corrupted", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe"))
+	asserts.AssertErrReply(t, PFCount(testDB, toArgs("corrupted")), "INVALIDOBJ Corrupted HLL object detected")
+}
+
+func TestPFMerge(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	for i := 0; i < 100; i++ {
+		PFAdd(testDB, toArgs("h1", strconv.Itoa(i)))
+		PFAdd(testDB, toArgs("h2", strconv.Itoa(i+50)))
+	}
+	count, _ := PFCount(testDB, toArgs("h1", "h2", "none")).(*reply.IntReply)
+	if count == nil || count.Code < 145 || count.Code > 155 {
+		t.Errorf("expected about 150, actually %v", count)
+	}
+	asserts.AssertStatusReply(t, PFMerge(testDB, toArgs("dest", "h1", "h2")), "OK")
+	asserts.AssertIntReply(t, PFCount(testDB, toArgs("dest")), int(count.Code))
+
+	// dest is merged too
+	PFAdd(testDB, toArgs("h3", "x"))
+	PFMerge(testDB, toArgs("h3", "h1"))
+	asserts.AssertIntReply(t, PFCount(testDB, toArgs("h3")), int(PFCount(testDB, toArgs("h1", "h3")).(*reply.IntReply).Code))
+	asserts.AssertStatusReply(t, PFMerge(testDB, toArgs("new")), "OK")
+	asserts.AssertIntReply(t, PFCount(testDB, toArgs("new")), 0)
+}
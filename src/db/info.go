@@ -0,0 +1,192 @@
+package db
+
+import (
+	"fmt"
+	"my-godis/src/config"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"my-godis/src/stats"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const godisVersion = "1.0.0"
+
+// sections of INFO without arguments
+var defaultInfoSections = []string{"server", "clients", "memory", "persistence", "stats", "keyspace"}
+
+// INFO [section ...]
+func Info(db *DB, args [][]byte) redis.Reply {
+	sections := defaultInfoSections
+	if len(args) > 0 {
+		sections = make([]string, 0, len(args))
+		for _, arg := range args {
+			section := strings.ToLower(string(arg))
+			if section == "all" || section == "everything" || section == "default" {
+				sections = defaultInfoSections
+				break
+			}
+			sections = append(sections, section)
+		}
+	}
+
+	var builder strings.Builder
+	for _, section := range sections {
+		var content string
+		switch section {
+		case "server":
+			content = db.infoServer()
+		case "clients":
+			content = db.infoClients()
+		case "memory":
+			content = db.infoMemory()
+		case "persistence":
+			content = db.infoPersistence()
+		case "stats":
+			content = db.infoStats()
+		case "keyspace":
+			content = db.infoKeyspace()
+		default:
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString(reply.CRLF)
+		}
+		builder.WriteString(content)
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+// infoBuilder writes lines in `field:value\r\n` format
+type infoBuilder struct {
+	builder strings.Builder
+}
+
+func makeInfoBuilder(title string) *infoBuilder {
+	b := &infoBuilder{}
+	b.builder.WriteString("# " + title + reply.CRLF)
+	return b
+}
+
+func (b *infoBuilder) add(field string, value interface{}) {
+	b.builder.WriteString(field + ":" + fmt.Sprint(value) + reply.CRLF)
+}
+
+func (b *infoBuilder) String() string {
+	return b.builder.String()
+}
+
+func (db *DB) infoServer() string {
+	uptime := int64(time.Since(stats.StartTime) / time.Second)
+	b := makeInfoBuilder("Server")
+	b.add("redis_version", godisVersion)
+	b.add("redis_mode", db.Mode)
+	b.add("os", runtime.GOOS)
+	b.add("arch_bits", strconv.IntSize)
+	b.add("go_version", runtime.Version())
+	b.add("process_id", os.Getpid())
+	b.add("tcp_port", config.Properties().Port)
+	b.add("uptime_in_seconds", uptime)
+	b.add("uptime_in_days", uptime/(3600*24))
+	return b.String()
+}
+
+func (db *DB) infoClients() string {
+	b := makeInfoBuilder("Clients")
+	b.add("connected_clients", stats.Load(&stats.ConnectedClients))
+	b.add("maxclients", config.Properties().MaxClients)
+	b.add("blocked_clients", db.BlockedClients())
+	return b.String()
+}
+
+// format bytes as human readable string, like 1.50M
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	value := float64(n)
+	suffixes := []string{"K", "M", "G", "T", "P"}
+	i := -1
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + suffixes[i]
+}
+
+func (db *DB) infoMemory() string {
+	mem := stats.SampleMemory()
+	b := makeInfoBuilder("Memory")
+	b.add("used_memory", mem.Alloc)
+	b.add("used_memory_human", humanBytes(mem.Alloc))
+	b.add("used_memory_rss", mem.Sys)
+	b.add("used_memory_rss_human", humanBytes(mem.Sys))
+	peak := uint64(stats.UsedMemoryPeak())
+	b.add("used_memory_peak", peak)
+	b.add("used_memory_peak_human", humanBytes(peak))
+	b.add("maxmemory", config.Properties().MaxMemory)
+	b.add("maxmemory_human", humanBytes(uint64(config.Properties().MaxMemory)))
+	b.add("maxmemory_policy", "noeviction")
+	b.add("go_heap_alloc", mem.HeapAlloc)
+	b.add("go_heap_inuse", mem.HeapInuse)
+	b.add("go_heap_objects", mem.HeapObjects)
+	b.add("go_gc_count", mem.NumGC)
+	b.add("go_gc_pause_total_ns", mem.PauseTotalNs)
+	b.add("go_goroutines", runtime.NumGoroutine())
+	return b.String()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (db *DB) infoPersistence() string {
+	b := makeInfoBuilder("Persistence")
+	b.add("loading", 0)
+	b.add("aof_enabled", boolToInt(config.Properties().AppendOnly))
+	b.add("aof_rewrite_in_progress", boolToInt(db.isRewriting()))
+	b.add("aof_rewrite_scheduled", 0)
+	if config.Properties().AppendOnly {
+		b.add("aof_current_size", atomic.LoadInt64(&db.aofCurrentSize))
+		b.add("aof_base_size", atomic.LoadInt64(&db.aofBaseSize))
+		b.add("aof_buffer_length", len(db.aofChan))
+		status := "ok"
+		if db.aofError() != nil {
+			status = "err"
+		}
+		b.add("aof_last_write_status", status)
+	}
+	return b.String()
+}
+
+func (db *DB) infoStats() string {
+	b := makeInfoBuilder("Stats")
+	b.add("total_connections_received", stats.Load(&stats.TotalConnectionsReceived))
+	b.add("total_commands_processed", stats.Load(&stats.TotalCommandsProcessed))
+	b.add("instantaneous_ops_per_sec", stats.InstantaneousOps())
+	b.add("expired_keys", stats.Load(&stats.ExpiredKeys))
+	b.add("evicted_keys", stats.Load(&stats.EvictedKeys))
+	b.add("keyspace_hits", stats.Load(&stats.KeyspaceHits))
+	b.add("keyspace_misses", stats.Load(&stats.KeyspaceMisses))
+	if db.hub != nil {
+		b.add("pubsub_channels", db.hub.ChannelCount())
+	}
+	return b.String()
+}
+
+func (db *DB) infoKeyspace() string {
+	b := makeInfoBuilder("Keyspace")
+	keys := db.Data.Len()
+	if keys > 0 {
+		b.add("db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=0", keys, db.TTLMap.Len()))
+	}
+	return b.String()
+}
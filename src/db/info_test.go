@@ -0,0 +1,77 @@
+package db
+
+import (
+	"bytes"
+	"my-godis/src/lib/metrics"
+	"my-godis/src/redis/reply"
+	"my-godis/src/stats"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("a", "a"))
+	Set(testDB, toArgs("b", "b", "EX", "100"))
+
+	actual := Info(testDB, toArgs("keyspace"))
+	bulk, ok := actual.(*reply.BulkReply)
+	if !ok {
+		t.Error("expected bulk reply, actually " + string(actual.ToBytes()))
+		return
+	}
+	expected := "# Keyspace\r\ndb0:keys=2,expires=1,avg_ttl=0\r\n"
+	if string(bulk.Arg) != expected {
+		t.Error("expected " + expected + ", actually " + string(bulk.Arg))
+	}
+
+	actual = Info(testDB, toArgs())
+	bulk, _ = actual.(*reply.BulkReply)
+	for _, section := range []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Keyspace"} {
+		if !strings.Contains(string(bulk.Arg), section) {
+			t.Error("missing section " + section)
+		}
+	}
+}
+
+func TestInfoMode(t *testing.T) {
+	bulk, _ := Info(testDB, toArgs("server")).(*reply.BulkReply)
+	if !strings.Contains(string(bulk.Arg), "redis_mode:standalone\r\n") {
+		t.Errorf("expected standalone mode, actually %s", bulk.Arg)
+	}
+	db := makeTestDB()
+	db.Mode = ModeCluster
+	bulk, _ = Info(db, toArgs("server")).(*reply.BulkReply)
+	if !strings.Contains(string(bulk.Arg), "redis_mode:cluster\r\n") {
+		t.Errorf("expected cluster mode, actually %s", bulk.Arg)
+	}
+}
+
+func TestInfoMemoryPeak(t *testing.T) {
+	fields := make(map[string]int64)
+	bulk, _ := Info(testDB, toArgs("memory")).(*reply.BulkReply)
+	for _, line := range strings.Split(string(bulk.Arg), "\r\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 {
+			fields[kv[0]], _ = strconv.ParseInt(kv[1], 10, 64)
+		}
+	}
+	if fields["used_memory"] == 0 || fields["used_memory_peak"] < fields["used_memory"] {
+		t.Errorf("expected peak %d >= used memory %d", fields["used_memory_peak"], fields["used_memory"])
+	}
+}
+
+func TestEvictedKeys(t *testing.T) {
+	stats.Add(&stats.EvictedKeys, 3)
+	defer stats.Add(&stats.EvictedKeys, -3)
+	bulk, _ := Info(testDB, toArgs("stats")).(*reply.BulkReply)
+	if !strings.Contains(string(bulk.Arg), "evicted_keys:3\r\n") {
+		t.Errorf("expected evicted_keys:3, actually %s", bulk.Arg)
+	}
+	buf := &bytes.Buffer{}
+	metrics.DefaultRegistry.Export(buf)
+	if !strings.Contains(buf.String(), "godis_evicted_keys_total 3\n") {
+		t.Errorf("expected godis_evicted_keys_total 3, actually:\n%s", buf.String())
+	}
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"my-godis/src/config"
 	"my-godis/src/datastruct/dict"
 	"my-godis/src/datastruct/list"
 	"my-godis/src/datastruct/set"
@@ -13,28 +14,16 @@ import (
 )
 
 func Del(db *DB, args [][]byte) redis.Reply {
-	if len(args) == 0 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'del' command")
-	}
 	keys := make([]string, len(args))
 	for i, v := range args {
 		keys[i] = string(v)
 	}
 
-	db.Locks(keys...)
-	defer db.UnLocks(keys...)
-
 	deleted := db.Removes(keys...)
-	if deleted > 0 {
-		db.AddAof(makeAofCmd("del", args))
-	}
 	return reply.MakeIntReply(int64(deleted))
 }
 
 func Exists(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'exists' command")
-	}
 	key := string(args[0])
 	_, exists := db.Get(key)
 	if exists {
@@ -45,27 +34,16 @@ func Exists(db *DB, args [][]byte) redis.Reply {
 }
 
 func FlushDB(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 0 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'flushdb' command")
-	}
 	db.Flush()
-	db.AddAof(makeAofCmd("flushdb", args))
 	return &reply.OkReply{}
 }
 
 func FlushAll(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 0 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'flushall' command")
-	}
 	db.Flush()
-	db.AddAof(makeAofCmd("flushdb", args))
 	return &reply.OkReply{}
 }
 
 func Type(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'type' command")
-	}
 	key := string(args[0])
 	entity, exists := db.Get(key)
 	if !exists {
@@ -74,9 +52,9 @@ func Type(db *DB, args [][]byte) redis.Reply {
 	switch entity.Data.(type) {
 	case []byte:
 		return reply.MakeStatusReply("string")
-	case *list.LinkedList:
+	case *list.QuickList:
 		return reply.MakeStatusReply("list")
-	case *dict.Dict:
+	case dict.Dict:
 		return reply.MakeStatusReply("hash")
 	case *set.Set:
 		return reply.MakeStatusReply("set")
@@ -87,20 +65,15 @@ func Type(db *DB, args [][]byte) redis.Reply {
 }
 
 func Rename(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rename' command")
-	}
 	src := string(args[0])
 	dest := string(args[1])
 
-	db.Locks(src, dest)
-	defer db.UnLocks(src, dest)
-
 	entity, ok := db.Get(src)
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
 	rawTTL, hasTTL := db.TTLMap.Get(src)
+	fieldTTLs, hasFieldTTLs := db.FieldTTLMap.Get(src)
 	db.Persist(src) // clean src and dest with their ttl
 	db.Persist(dest)
 	db.Put(dest, entity)
@@ -108,20 +81,17 @@ func Rename(db *DB, args [][]byte) redis.Reply {
 		expireTime, _ := rawTTL.(time.Time)
 		db.Expire(dest, expireTime)
 	}
-	db.AddAof(makeAofCmd("rename", args))
+	if hasFieldTTLs {
+		db.FieldTTLMap.Remove(src)
+		db.FieldTTLMap.Put(dest, fieldTTLs)
+	}
 	return &reply.OkReply{}
 }
 
 func RenameNx(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'renamenx' command")
-	}
 	src := string(args[0])
 	dest := string(args[1])
 
-	db.Locks(src, dest)
-	defer db.UnLocks(src, dest)
-
 	_, ok := db.Get(dest)
 	if ok {
 		return reply.MakeIntReply(0)
@@ -132,20 +102,20 @@ func RenameNx(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("no such key")
 	}
 	rawTTL, hasTTL := db.TTLMap.Get(src)
+	fieldTTLs, hasFieldTTLs := db.FieldTTLMap.Get(src)
 	db.Removes(src, dest) // clean src and dest with their ttl
 	db.Put(dest, entity)
 	if hasTTL {
 		expireTime, _ := rawTTL.(time.Time)
 		db.Expire(dest, expireTime)
 	}
-	db.AddAof(makeAofCmd("renamenx", args))
+	if hasFieldTTLs {
+		db.FieldTTLMap.Put(dest, fieldTTLs)
+	}
 	return reply.MakeIntReply(1)
 }
 
 func Expire(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'expire' command")
-	}
 	key := string(args[0])
 
 	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
@@ -161,14 +131,10 @@ func Expire(db *DB, args [][]byte) redis.Reply {
 
 	expireAt := time.Now().Add(ttl)
 	db.Expire(key, expireAt)
-	db.AddAof(makeExpireCmd(key, expireAt))
 	return reply.MakeIntReply(1)
 }
 
 func ExpireAt(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'expireat' command")
-	}
 	key := string(args[0])
 
 	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
@@ -183,14 +149,10 @@ func ExpireAt(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.Expire(key, expireTime)
-	db.AddAof(makeExpireCmd(key, expireTime))
 	return reply.MakeIntReply(1)
 }
 
 func PExpire(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'pexpire' command")
-	}
 	key := string(args[0])
 
 	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
@@ -206,14 +168,10 @@ func PExpire(db *DB, args [][]byte) redis.Reply {
 
 	expireTime := time.Now().Add(ttl)
 	db.Expire(key, expireTime)
-	db.AddAof(makeExpireCmd(key, expireTime))
 	return reply.MakeIntReply(1)
 }
 
 func PExpireAt(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'pexpireat' command")
-	}
 	key := string(args[0])
 
 	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
@@ -229,14 +187,10 @@ func PExpireAt(db *DB, args [][]byte) redis.Reply {
 
 	db.Expire(key, expireTime)
 
-	db.AddAof(makeExpireCmd(key, expireTime))
 	return reply.MakeIntReply(1)
 }
 
 func TTL(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'ttl' command")
-	}
 	key := string(args[0])
 	_, exists := db.Get(key)
 	if !exists {
@@ -253,9 +207,6 @@ func TTL(db *DB, args [][]byte) redis.Reply {
 }
 
 func PTTL(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'pttl' command")
-	}
 	key := string(args[0])
 	_, exists := db.Get(key)
 	if !exists {
@@ -272,9 +223,6 @@ func PTTL(db *DB, args [][]byte) redis.Reply {
 }
 
 func Persist(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'persist' command")
-	}
 	key := string(args[0])
 	_, exists := db.Get(key)
 	if !exists {
@@ -287,28 +235,32 @@ func Persist(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.TTLMap.Remove(key)
-	db.AddAof(makeAofCmd("persist", args))
 	return reply.MakeIntReply(1)
 }
 
 func BGRewriteAOF(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 0 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'bgrewriteaof' command")
+	if !config.Properties().AppendOnly {
+		return reply.MakeErrReply("ERR Background append only file rewriting is not enabled")
+	}
+	if !db.startBackgroundRewrite() {
+		return reply.MakeErrReply("ERR Background append only file rewriting already in progress")
 	}
-	go db.aofRewrite()
 	return reply.MakeStatusReply("Background append only file rewriting started")
 }
 
 func Keys(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'keys' command")
-	}
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
+	now := time.Now()
 	db.Data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) {
-			result = append(result, []byte(key))
+		if !pattern.IsMatch(key) {
+			return true
+		}
+		// hashes whose fields have all expired are left to the active expiry cycle
+		if hash, ok := val.(*DataEntity).Data.(dict.Dict); ok && db.allFieldsExpired(key, hash, now) {
+			return true
 		}
+		result = append(result, []byte(key))
 		return true
 	})
 	return reply.MakeMultiBulkReply(result)
@@ -1,32 +1,34 @@
 package db
 
 import (
+	"bytes"
 	List "my-godis/src/datastruct/list"
 	"my-godis/src/interface/redis"
 	"my-godis/src/redis/reply"
 	"strconv"
+	"strings"
 )
 
-func (db *DB) getAsList(key string) (*List.LinkedList, reply.ErrorReply) {
+func (db *DB) getAsList(key string) (*List.QuickList, reply.ErrorReply) {
 	entity, ok := db.Get(key)
 	if !ok {
 		return nil, nil
 	}
-	bytes, ok := entity.Data.(*List.LinkedList)
+	list, ok := entity.Data.(*List.QuickList)
 	if !ok {
 		return nil, &reply.WrongTypeErrReply{}
 	}
-	return bytes, nil
+	return list, nil
 }
 
-func (db *DB) getOrInitList(key string) (list *List.LinkedList, inited bool, errReply reply.ErrorReply) {
+func (db *DB) getOrInitList(key string) (list *List.QuickList, inited bool, errReply reply.ErrorReply) {
 	list, errReply = db.getAsList(key)
 	if errReply != nil {
 		return nil, false, errReply
 	}
 	inited = false
 	if list == nil {
-		list = &List.LinkedList{}
+		list = List.MakeQuickList()
 		db.Put(key, &DataEntity{
 			Data: list,
 		})
@@ -37,9 +39,6 @@ func (db *DB) getOrInitList(key string) (list *List.LinkedList, inited bool, err
 
 func LIndex(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lindex' command")
-	}
 	key := string(args[0])
 	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
@@ -65,15 +64,12 @@ func LIndex(db *DB, args [][]byte) redis.Reply {
 		return &reply.NullBulkReply{}
 	}
 
-	val, _ := list.Get(index).([]byte)
+	val := list.Get(index)
 	return reply.MakeBulkReply(val)
 }
 
 func LLen(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'llen' command")
-	}
 	key := string(args[0])
 
 	list, errReply := db.getAsList(key)
@@ -88,45 +84,10 @@ func LLen(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeIntReply(size)
 }
 
-func LPop(db *DB, args [][]byte) redis.Reply {
-	// parse args
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lindex' command")
-	}
-	key := string(args[0])
-
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
-
-	// get data
-	list, errReply := db.getAsList(key)
-	if errReply != nil {
-		return errReply
-	}
-	if list == nil {
-		return &reply.NullBulkReply{}
-	}
-
-	val, _ := list.Remove(0).([]byte)
-	if list.Len() == 0 {
-		db.Remove(key)
-	}
-	db.AddAof(makeAofCmd("lpop", args))
-	return reply.MakeBulkReply(val)
-}
-
 func LPush(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lpush' command")
-	}
 	key := string(args[0])
 	values := args[1:]
 
-	// lock
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
 	// get or init entity
 	list, _, errReply := db.getOrInitList(key)
 	if errReply != nil {
@@ -138,21 +99,13 @@ func LPush(db *DB, args [][]byte) redis.Reply {
 		list.Insert(0, value)
 	}
 
-	db.AddAof(makeAofCmd("lpush", args))
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
 func LPushX(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lpushx' command")
-	}
 	key := string(args[0])
 	values := args[1:]
 
-	// lock
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
 	// get or init entity
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
@@ -166,15 +119,11 @@ func LPushX(db *DB, args [][]byte) redis.Reply {
 	for _, value := range values {
 		list.Insert(0, value)
 	}
-	db.AddAof(makeAofCmd("lpushx", args))
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
 func LRange(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lrange' command")
-	}
 	key := string(args[0])
 	start64, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
@@ -187,10 +136,6 @@ func LRange(db *DB, args [][]byte) redis.Reply {
 	}
 	stop := int(stop64)
 
-	// lock key
-	db.RLock(key)
-	defer db.RUnLock(key)
-
 	// get data
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
@@ -223,20 +168,11 @@ func LRange(db *DB, args [][]byte) redis.Reply {
 	}
 
 	// assert: start in [0, size - 1], stop in [start, size]
-	slice := list.Range(start, stop)
-	result := make([][]byte, len(slice))
-	for i, raw := range slice {
-		bytes, _ := raw.([]byte)
-		result[i] = bytes
-	}
-	return reply.MakeMultiBulkReply(result)
+	return reply.MakeMultiBulkReply(list.Range(start, stop))
 }
 
 func LRem(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lrem' command")
-	}
 	key := string(args[0])
 	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
@@ -245,10 +181,6 @@ func LRem(db *DB, args [][]byte) redis.Reply {
 	count := int(count64)
 	value := args[2]
 
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	// get data entity
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
@@ -270,18 +202,12 @@ func LRem(db *DB, args [][]byte) redis.Reply {
 	if list.Len() == 0 {
 		db.Remove(key)
 	}
-	if removed > 0 {
-		db.AddAof(makeAofCmd("lrem", args))
-	}
 
 	return reply.MakeIntReply(int64(removed))
 }
 
 func LSet(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lset' command")
-	}
 	key := string(args[0])
 	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
@@ -290,10 +216,6 @@ func LSet(db *DB, args [][]byte) redis.Reply {
 	index := int(index64)
 	value := args[2]
 
-	// lock
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
 	// get data
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
@@ -313,49 +235,135 @@ func LSet(db *DB, args [][]byte) redis.Reply {
 	}
 
 	list.Set(index, value)
-	db.AddAof(makeAofCmd("lset", args))
 	return &reply.OkReply{}
 }
 
-func RPop(db *DB, args [][]byte) redis.Reply {
+func RPush(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'lindex' command")
-	}
 	key := string(args[0])
+	values := args[1:]
 
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
+	// get or init entity
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
 
-	// get data
+	// put list
+	for _, value := range values {
+		list.Add(value)
+	}
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+func RPushX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	// get or init entity
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
 	}
 	if list == nil {
-		return &reply.NullBulkReply{}
+		return reply.MakeIntReply(0)
+	}
+
+	// put list
+	for _, value := range values {
+		list.Add(value)
+	}
+
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+func popList(list *List.QuickList, left bool) []byte {
+	if left {
+		return list.Remove(0)
+	}
+	return list.RemoveLast()
+}
+
+func pushList(list *List.QuickList, val []byte, left bool) {
+	if left {
+		list.Insert(0, val)
+	} else {
+		list.Add(val)
 	}
+}
 
-	val, _ := list.RemoveLast().([]byte)
+func (db *DB) removeIfEmptyList(key string, list *List.QuickList) {
 	if list.Len() == 0 {
 		db.Remove(key)
 	}
-	db.AddAof(makeAofCmd("rpop", args))
-	return reply.MakeBulkReply(val)
 }
 
-func RPopLPush(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rpoplpush' command")
+// parseListDirection parses LEFT or RIGHT, returns true for LEFT
+func parseListDirection(arg []byte) (bool, bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+func listPop(db *DB, args [][]byte, left bool) redis.Reply {
+	if len(args) > 2 {
+		return &reply.SyntaxErrReply{}
+	}
+	key := string(args[0])
+	var count int64 = 1
+	if len(args) == 2 {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
 	}
-	sourceKey := string(args[0])
-	destKey := string(args[1])
 
-	// lock
-	db.Locks(sourceKey, destKey)
-	defer db.UnLocks(sourceKey, destKey)
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if len(args) == 2 {
+			return &reply.NullMultiBulkReply{}
+		}
+		return &reply.NullBulkReply{}
+	}
+	if len(args) == 1 {
+		val := popList(list, left)
+		db.removeIfEmptyList(key, list)
+		return reply.MakeBulkReply(val)
+	}
 
+	if count > int64(list.Len()) {
+		count = int64(list.Len())
+	}
+	vals := make([][]byte, count)
+	for i := range vals {
+		vals[i] = popList(list, left)
+	}
+	db.removeIfEmptyList(key, list)
+	return reply.MakeMultiBulkReply(vals)
+}
+
+// LPOP key [count]
+func LPop(db *DB, args [][]byte) redis.Reply {
+	return listPop(db, args, true)
+}
+
+// RPOP key [count]
+func RPop(db *DB, args [][]byte) redis.Reply {
+	return listPop(db, args, false)
+}
+
+func listMove(db *DB, sourceKey string, destKey string, fromLeft bool, toLeft bool) redis.Reply {
 	// get source entity
 	sourceList, errReply := db.getAsList(sourceKey)
 	if errReply != nil {
@@ -365,75 +373,235 @@ func RPopLPush(db *DB, args [][]byte) redis.Reply {
 		return &reply.NullBulkReply{}
 	}
 
-	// get dest entity
+	// get dest entity, source is same as dest if keys are equal
 	destList, _, errReply := db.getOrInitList(destKey)
 	if errReply != nil {
 		return errReply
 	}
 
 	// pop and push
-	val, _ := sourceList.RemoveLast().([]byte)
-	destList.Insert(0, val)
+	val := popList(sourceList, fromLeft)
+	pushList(destList, val, toLeft)
+	db.removeIfEmptyList(sourceKey, sourceList)
+	return reply.MakeBulkReply(val)
+}
 
-	if sourceList.Len() == 0 {
-		db.Remove(sourceKey)
-	}
+func RPopLPush(db *DB, args [][]byte) redis.Reply {
+	return listMove(db, string(args[0]), string(args[1]), false, true)
+}
 
-	db.AddAof(makeAofCmd("rpoplpush", args))
-	return reply.MakeBulkReply(val)
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func LMove(db *DB, args [][]byte) redis.Reply {
+	fromLeft, ok1 := parseListDirection(args[2])
+	toLeft, ok2 := parseListDirection(args[3])
+	if !ok1 || !ok2 {
+		return &reply.SyntaxErrReply{}
+	}
+	return listMove(db, string(args[0]), string(args[1]), fromLeft, toLeft)
 }
 
-func RPush(db *DB, args [][]byte) redis.Reply {
-	// parse args
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rpush' command")
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func LMPop(db *DB, args [][]byte) redis.Reply {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	key := string(args[0])
-	values := args[1:]
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-2 {
+		return &reply.SyntaxErrReply{}
+	}
+	keys := args[1 : 1+numKeys]
+	left, ok := parseListDirection(args[1+numKeys])
+	if !ok {
+		return &reply.SyntaxErrReply{}
+	}
+	count := 1
+	rest := args[2+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "COUNT" {
+			return &reply.SyntaxErrReply{}
+		}
+		count, err = strconv.Atoi(string(rest[1]))
+		if err != nil || count <= 0 {
+			return reply.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+
+	for _, arg := range keys {
+		key := string(arg)
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+		if count > list.Len() {
+			count = list.Len()
+		}
+		vals := make([][]byte, count)
+		for i := range vals {
+			vals[i] = popList(list, left)
+		}
+		db.removeIfEmptyList(key, list)
+		return reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply(arg),
+			reply.MakeMultiBulkReply(vals),
+		})
+	}
+	return &reply.NullMultiBulkReply{}
+}
 
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
+// LINSERT key BEFORE|AFTER pivot element
+func LInsert(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	var before bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return &reply.SyntaxErrReply{}
+	}
+	pivot := args[2]
+	value := args[3]
 
-	// get or init entity
-	list, _, errReply := db.getOrInitList(key)
+	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
 	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
 
-	// put list
-	for _, value := range values {
-		list.Add(value)
+	index := -1
+	list.ForEach(func(i int, val []byte) bool {
+		if bytes.Equal(val, pivot) {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index < 0 {
+		return reply.MakeIntReply(-1)
+	}
+	if !before {
+		index++
 	}
-	db.AddAof(makeAofCmd("rpush", args))
+	list.Insert(index, value)
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
-func RPushX(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'rpush' command")
-	}
+// LTRIM key start stop
+func LTrim(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	values := args[1:]
-
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
 
-	// get or init entity
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
 		return errReply
 	}
 	if list == nil {
-		return reply.MakeIntReply(0)
+		return &reply.OkReply{}
 	}
 
-	// put list
-	for _, value := range values {
-		list.Add(value)
+	start, stop, ok := normalizeRank(start, stop, int64(list.Len()))
+	if !ok {
+		start, stop = 0, 0
+	}
+	list.Trim(int(start), int(stop))
+	db.removeIfEmptyList(key, list)
+	return &reply.OkReply{}
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func LPos(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	element := args[1]
+	rank := 1
+	count := -1 // -1 means COUNT is absent
+	maxLen := 0
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return &reply.SyntaxErrReply{}
+		}
+		value, err := strconv.Atoi(string(args[i+1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if value == 0 {
+				return reply.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = value
+		case "COUNT":
+			if value < 0 {
+				return reply.MakeErrReply("ERR COUNT can't be negative")
+			}
+			count = value
+		case "MAXLEN":
+			if value < 0 {
+				return reply.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = value
+		default:
+			return &reply.SyntaxErrReply{}
+		}
 	}
-	db.AddAof(makeAofCmd("rpushx", args))
 
-	return reply.MakeIntReply(int64(list.Len()))
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// negative rank searches from tail to head
+	var matches []redis.Reply
+	if list != nil {
+		skip := rank - 1
+		forEach := list.ForEach
+		if rank < 0 {
+			skip = -rank - 1
+			forEach = list.ReverseForEach
+		}
+		compared := 0
+		forEach(func(i int, val []byte) bool {
+			if maxLen > 0 && compared == maxLen {
+				return false
+			}
+			compared++
+			if !bytes.Equal(val, element) {
+				return true
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			matches = append(matches, reply.MakeIntReply(int64(i)))
+			// no COUNT means the first match, COUNT 0 means all matches
+			return count == 0 || len(matches) < count
+		})
+	}
+
+	if count < 0 {
+		if len(matches) == 0 {
+			return &reply.NullBulkReply{}
+		}
+		return matches[0]
+	}
+	if len(matches) == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	return reply.MakeMultiRawReply(matches)
 }
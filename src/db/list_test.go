@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"my-godis/src/datastruct/utils"
 	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
 	"strconv"
 	"testing"
 )
@@ -383,3 +384,106 @@ func TestLPushX(t *testing.T) {
 	}
 
 }
+
+func TestPopCount(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("l", "a", "b", "c", "d", "e"))
+	asserts.AssertMultiBulkReply(t, LPop(testDB, toArgs("l", "2")), []string{"a", "b"})
+	asserts.AssertMultiBulkReply(t, RPop(testDB, toArgs("l", "2")), []string{"e", "d"})
+	assertRawReply(t, LPop(testDB, toArgs("l", "0")), "*0\r\n")
+	asserts.AssertMultiBulkReply(t, RPop(testDB, toArgs("l", "5")), []string{"c"})
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("l")), 0)
+	assertRawReply(t, LPop(testDB, toArgs("l", "1")), "*-1\r\n")
+	asserts.AssertErrReply(t, LPop(testDB, toArgs("l", "-1")), "ERR value is out of range, must be positive")
+}
+
+func TestLInsert(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("l", "a", "c"))
+	asserts.AssertIntReply(t, LInsert(testDB, toArgs("l", "BEFORE", "c", "b")), 3)
+	asserts.AssertIntReply(t, LInsert(testDB, toArgs("l", "after", "c", "d")), 4)
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("l", "0", "-1")), []string{"a", "b", "c", "d"})
+	asserts.AssertIntReply(t, LInsert(testDB, toArgs("l", "BEFORE", "x", "b")), -1)
+	asserts.AssertIntReply(t, LInsert(testDB, toArgs("none", "BEFORE", "c", "b")), 0)
+	asserts.AssertErrReply(t, LInsert(testDB, toArgs("l", "MIDDLE", "c", "b")), "Err syntax error")
+}
+
+func TestLTrim(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("l", "a", "b", "c", "d", "e"))
+	asserts.AssertStatusReply(t, LTrim(testDB, toArgs("l", "1", "-2")), "OK")
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("l", "0", "-1")), []string{"b", "c", "d"})
+	asserts.AssertStatusReply(t, LTrim(testDB, toArgs("l", "1", "100")), "OK")
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("l", "0", "-1")), []string{"c", "d"})
+	// empty range removes key
+	asserts.AssertStatusReply(t, LTrim(testDB, toArgs("l", "5", "10")), "OK")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("l")), 0)
+}
+
+func TestLPos(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("l", "a", "b", "c", "1", "2", "3", "c", "c"))
+	asserts.AssertIntReply(t, LPos(testDB, toArgs("l", "c")), 2)
+	asserts.AssertIntReply(t, LPos(testDB, toArgs("l", "c", "RANK", "2")), 6)
+	asserts.AssertIntReply(t, LPos(testDB, toArgs("l", "c", "RANK", "-1")), 7)
+	assertRawReply(t, LPos(testDB, toArgs("l", "c", "COUNT", "2")), "*2\r\n:2\r\n:6\r\n")
+	assertRawReply(t, LPos(testDB, toArgs("l", "c", "COUNT", "0")), "*3\r\n:2\r\n:6\r\n:7\r\n")
+	assertRawReply(t, LPos(testDB, toArgs("l", "c", "RANK", "-2", "COUNT", "0")), "*2\r\n:6\r\n:2\r\n")
+	asserts.AssertNullBulk(t, LPos(testDB, toArgs("l", "c", "MAXLEN", "2")))
+	assertRawReply(t, LPos(testDB, toArgs("l", "x", "COUNT", "1")), "*0\r\n")
+	asserts.AssertNullBulk(t, LPos(testDB, toArgs("none", "c")))
+	asserts.AssertErrReply(t, LPos(testDB, toArgs("l", "c", "RANK", "0")),
+		"ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+	asserts.AssertErrReply(t, LPos(testDB, toArgs("l", "c", "COUNT", "-1")), "ERR COUNT can't be negative")
+	asserts.AssertErrReply(t, LPos(testDB, toArgs("l", "c", "MAXLEN")), "Err syntax error")
+}
+
+func TestLMove(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("src", "a", "b", "c"))
+	asserts.AssertBulkReply(t, LMove(testDB, toArgs("src", "dest", "LEFT", "RIGHT")), "a")
+	asserts.AssertBulkReply(t, LMove(testDB, toArgs("src", "dest", "RIGHT", "LEFT")), "c")
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("dest", "0", "-1")), []string{"c", "a"})
+	// rotate
+	asserts.AssertBulkReply(t, LMove(testDB, toArgs("dest", "dest", "LEFT", "RIGHT")), "c")
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("dest", "0", "-1")), []string{"a", "c"})
+	asserts.AssertBulkReply(t, LMove(testDB, toArgs("src", "src", "LEFT", "LEFT")), "b")
+	asserts.AssertIntReply(t, LLen(testDB, toArgs("src")), 1)
+
+	Set(testDB, toArgs("str", "x"))
+	asserts.AssertErrReply(t, LMove(testDB, toArgs("src", "str", "LEFT", "LEFT")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertIntReply(t, LLen(testDB, toArgs("src")), 1)
+	asserts.AssertNullBulk(t, LMove(testDB, toArgs("none", "dest", "LEFT", "LEFT")))
+	asserts.AssertErrReply(t, LMove(testDB, toArgs("src", "dest", "UP", "LEFT")), "Err syntax error")
+}
+
+func TestLMPop(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("l2", "a", "b", "c"))
+	assertRawReply(t, LMPop(testDB, toArgs("2", "l1", "l2", "LEFT")), "*2\r\n$2\r\nl2\r\n*1\r\n$1\r\na\r\n")
+	assertRawReply(t, LMPop(testDB, toArgs("2", "l1", "l2", "RIGHT", "COUNT", "5")), "*2\r\n$2\r\nl2\r\n*2\r\n$1\r\nc\r\n$1\r\nb\r\n")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("l2")), 0)
+	assertRawReply(t, LMPop(testDB, toArgs("2", "l1", "l2", "LEFT")), "*-1\r\n")
+	asserts.AssertErrReply(t, LMPop(testDB, toArgs("0", "l1", "LEFT")), "ERR numkeys should be greater than 0")
+	asserts.AssertErrReply(t, LMPop(testDB, toArgs("1", "l1", "LEFT", "COUNT", "0")), "ERR count should be greater than 0")
+	asserts.AssertErrReply(t, LMPop(testDB, toArgs("2", "l1", "LEFT")), "Err syntax error")
+	asserts.AssertErrReply(t, LMPop(testDB, toArgs("1", "l1", "UP")), "Err syntax error")
+}
+
+func TestLargeList(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	// enough entries for several chunks
+	values := make([]string, 0, 5001)
+	values = append(values, "l")
+	for i := 0; i < 5000; i++ {
+		values = append(values, strconv.Itoa(i))
+	}
+	RPush(testDB, toArgs(values...))
+	asserts.AssertBulkReply(t, LIndex(testDB, toArgs("l", "4321")), "4321")
+	asserts.AssertStatusReply(t, LSet(testDB, toArgs("l", "-1", "last")), "OK")
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("l", "-2", "-1")), []string{"4998", "last"})
+	asserts.AssertIntReply(t, LInsert(testDB, toArgs("l", "AFTER", "2500", "x")), 5001)
+	asserts.AssertIntReply(t, LPos(testDB, toArgs("l", "x")), 2501)
+	asserts.AssertStatusReply(t, LTrim(testDB, toArgs("l", "2500", "2502")), "OK")
+	asserts.AssertMultiBulkReply(t, LRange(testDB, toArgs("l", "0", "-1")), []string{"2500", "x", "2501"})
+}
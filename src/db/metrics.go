@@ -0,0 +1,34 @@
+package db
+
+import (
+	"my-godis/src/lib/metrics"
+	"time"
+)
+
+var (
+	cmdCallCounter = metrics.DefaultRegistry.CounterVec("godis_commands_total",
+		"Total number of calls per command.", "cmd")
+	cmdLatency = metrics.DefaultRegistry.HistogramVec("godis_command_duration_seconds",
+		"Latency of command execution in seconds.", "cmd", metrics.DefaultLatencyBuckets)
+)
+
+func observeCommand(cmd string, elapsed time.Duration) {
+	cmdCallCounter.Inc(cmd)
+	cmdLatency.Observe(cmd, elapsed.Seconds())
+}
+
+// register gauges sampled from this db, replaces gauges of former db
+func (db *DB) registerMetrics() {
+	metrics.DefaultRegistry.RegisterGaugeFunc("godis_aof_queue_depth",
+		"Number of commands waiting in aof queue.", func() float64 {
+			return float64(len(db.aofChan))
+		})
+	metrics.DefaultRegistry.RegisterGaugeFunc("godis_pubsub_channels",
+		"Number of channels with at least one subscriber.", func() float64 {
+			return float64(db.hub.ChannelCount())
+		})
+	metrics.DefaultRegistry.RegisterGaugeFunc("godis_keys",
+		"Number of keys in db.", func() float64 {
+			return float64(db.Data.Len())
+		})
+}
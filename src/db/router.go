@@ -1,95 +1,199 @@
 package db
 
-func MakeRouter() map[string]CmdFunc {
-	routerMap := make(map[string]CmdFunc)
-	routerMap["ping"] = Ping
-
-	routerMap["del"] = Del
-	routerMap["expire"] = Expire
-	routerMap["expireat"] = ExpireAt
-	routerMap["pexpire"] = PExpire
-	routerMap["pexpireat"] = PExpireAt
-	routerMap["ttl"] = TTL
-	routerMap["pttl"] = PTTL
-	routerMap["persist"] = Persist
-	routerMap["exists"] = Exists
-	routerMap["type"] = Type
-	routerMap["rename"] = Rename
-	routerMap["renamenx"] = RenameNx
-
-	routerMap["set"] = Set
-	routerMap["setnx"] = SetNX
-	routerMap["setex"] = SetEX
-	routerMap["psetex"] = PSetEX
-	routerMap["mset"] = MSet
-	routerMap["mget"] = MGet
-	routerMap["msetnx"] = MSetNX
-	routerMap["get"] = Get
-	routerMap["getset"] = GetSet
-	routerMap["incr"] = Incr
-	routerMap["incrby"] = IncrBy
-	routerMap["incrbyfloat"] = IncrByFloat
-	routerMap["decr"] = Decr
-	routerMap["decrby"] = DecrBy
-
-	routerMap["lpush"] = LPush
-	routerMap["lpushx"] = LPushX
-	routerMap["rpush"] = RPush
-	routerMap["rpushx"] = RPushX
-	routerMap["lpop"] = LPop
-	routerMap["rpop"] = RPop
-	routerMap["rpoplpush"] = RPopLPush
-	routerMap["lrem"] = LRem
-	routerMap["llen"] = LLen
-	routerMap["lindex"] = LIndex
-	routerMap["lset"] = LSet
-	routerMap["lrange"] = LRange
-
-	routerMap["hset"] = HSet
-	routerMap["hsetnx"] = HSetNX
-	routerMap["hget"] = HGet
-	routerMap["hexists"] = HExists
-	routerMap["hdel"] = HDel
-	routerMap["hlen"] = HLen
-	routerMap["hmget"] = HMGet
-	routerMap["hmset"] = HMSet
-	routerMap["hkeys"] = HKeys
-	routerMap["hvals"] = HVals
-	routerMap["hgetall"] = HGetAll
-	routerMap["hincrby"] = HIncrBy
-	routerMap["hincrbyfloat"] = HIncrByFloat
-
-	routerMap["sadd"] = SAdd
-	routerMap["sismember"] = SIsMember
-	routerMap["srem"] = SRem
-	routerMap["scard"] = SCard
-	routerMap["smembers"] = SMembers
-	routerMap["sinter"] = SInter
-	routerMap["sinterstore"] = SInterStore
-	routerMap["sunion"] = SUnion
-	routerMap["sunionstore"] = SUnionStore
-	routerMap["sdiff"] = SDiff
-	routerMap["sdiffstore"] = SDiffStore
-	routerMap["srandmember"] = SRandMember
-
-	routerMap["zadd"] = ZAdd
-	routerMap["zscore"] = ZScore
-	routerMap["zincrby"] = ZIncrBy
-	routerMap["zrank"] = ZRank
-	routerMap["zcount"] = ZCount
-	routerMap["zrevrank"] = ZRevRank
-	routerMap["zcard"] = ZCard
-	routerMap["zrange"] = ZRange
-	routerMap["zrevrange"] = ZRevRange
-	routerMap["zrangebyscore"] = ZRangeByScore
-	routerMap["zrevrangebyscore"] = ZRevRangeByScore
-	routerMap["zrem"] = ZRem
-	routerMap["zremrangebyscore"] = ZRemRangeByScore
-	routerMap["zremrangebyrank"] = ZRemRangeByRank
-
-	routerMap["flushdb"] = FlushDB
-	routerMap["flushall"] = FlushAll
-	routerMap["keys"] = Keys
-
-	return routerMap
+import (
+	"my-godis/src/interface/redis"
+	"my-godis/src/latency"
+	"my-godis/src/pubsub"
+	"my-godis/src/slowlog"
+)
+
+// command table is filled by init, since commands like EVAL call other commands through it
+func init() {
+	registerCommand("ping", Ping, -1, flagFast, 0, 0, 0, "connection")
+	registerCommand("command", Command, -1, flagFast, 0, 0, 0, "connection")
+	registerCommand("info", Info, -1, 0, 0, 0, 0, "slow", "dangerous")
+	registerCommand("config", Config, -2, flagAdmin|flagNoScript, 0, 0, 0)
+	registerCommand("slowlog", SlowLog, -2, flagAdmin, 0, 0, 0)
+	registerCommand("latency", Latency, -2, flagAdmin|flagNoScript, 0, 0, 0)
+	registerCommand("bgrewriteaof", BGRewriteAOF, 1, flagAdmin|flagNoScript, 0, 0, 0)
+	// commands need connection are executed by DB.execCommand
+	registerCommand("monitor", nil, 1, flagAdmin|flagNoScript, 0, 0, 0)
+	registerCommand("subscribe", nil, -2, flagPubSub|flagNoScript, 0, 0, 0)
+	registerCommand("unsubscribe", nil, -1, flagPubSub|flagNoScript, 0, 0, 0)
+	registerCommand("publish", Publish, 3, flagPubSub|flagMayReplicate|flagFast, 0, 0, 0)
+
+	registerCommand("del", Del, -2, flagWrite, 1, -1, 1, "keyspace").rewriteBy(changedOnly)
+	registerCommand("expire", Expire, 3, flagWrite|flagFast, 1, 1, 1, "keyspace").rewriteBy(rewriteExpire)
+	registerCommand("expireat", ExpireAt, 3, flagWrite|flagFast, 1, 1, 1, "keyspace").rewriteBy(rewriteExpire)
+	registerCommand("pexpire", PExpire, 3, flagWrite|flagFast, 1, 1, 1, "keyspace").rewriteBy(rewriteExpire)
+	registerCommand("pexpireat", PExpireAt, 3, flagWrite|flagFast, 1, 1, 1, "keyspace").rewriteBy(rewriteExpire)
+	registerCommand("ttl", TTL, 2, flagReadOnly|flagFast, 1, 1, 1, "keyspace")
+	registerCommand("pttl", PTTL, 2, flagReadOnly|flagFast, 1, 1, 1, "keyspace")
+	registerCommand("persist", Persist, 2, flagWrite|flagFast, 1, 1, 1, "keyspace").rewriteBy(changedOnly)
+	registerCommand("exists", Exists, 2, flagReadOnly|flagFast, 1, 1, 1, "keyspace")
+	registerCommand("type", Type, 2, flagReadOnly|flagFast, 1, 1, 1, "keyspace")
+	registerCommand("rename", Rename, 3, flagWrite, 1, 2, 1, "keyspace")
+	registerCommand("renamenx", RenameNx, 3, flagWrite|flagFast, 1, 2, 1, "keyspace").rewriteBy(changedOnly)
+	registerCommand("dump", Dump, 2, flagReadOnly, 1, 1, 1, "keyspace")
+	registerCommand("restore", Restore, -4, flagWrite|flagDenyOOM, 1, 1, 1, "keyspace", "dangerous").rewriteBy(rewriteRestore)
+	registerCommand("migrate", Migrate, -6, flagWrite, 0, 0, 0, "keyspace", "dangerous").keysBy(migrateKeys).rewriteBy(rewriteMigrate)
+	registerCommand("flushdb", FlushDB, 1, flagWrite, 0, 0, 0, "keyspace", "dangerous")
+	registerCommand("flushall", FlushAll, 1, flagWrite, 0, 0, 0, "keyspace", "dangerous")
+	registerCommand("keys", Keys, 2, flagReadOnly, 0, 0, 0, "keyspace", "dangerous")
+
+	registerCommand("set", Set, -3, flagWrite|flagDenyOOM, 1, 1, 1, "string").rewriteBy(rewriteSet)
+	registerCommand("setnx", SetNX, 3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string").rewriteBy(changedOnly)
+	registerCommand("setex", SetEX, 4, flagWrite|flagDenyOOM, 1, 1, 1, "string").rewriteBy(rewriteAsSet)
+	registerCommand("psetex", PSetEX, 4, flagWrite|flagDenyOOM, 1, 1, 1, "string").rewriteBy(rewriteAsSet)
+	registerCommand("mset", MSet, -3, flagWrite|flagDenyOOM, 1, -1, 2, "string")
+	registerCommand("mget", MGet, -2, flagReadOnly|flagFast, 1, -1, 1, "string")
+	registerCommand("msetnx", MSetNX, -3, flagWrite|flagDenyOOM, 1, -1, 2, "string").rewriteBy(changedOnly)
+	registerCommand("get", Get, 2, flagReadOnly|flagFast, 1, 1, 1, "string")
+	registerCommand("getset", GetSet, 3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string")
+	registerCommand("getdel", GetDel, 2, flagWrite|flagFast, 1, 1, 1, "string").rewriteBy(changedOnly)
+	registerCommand("getex", GetEx, -2, flagWrite|flagFast, 1, 1, 1, "string").rewriteBy(rewriteGetEx)
+	registerCommand("append", Append, 3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string")
+	registerCommand("strlen", StrLen, 2, flagReadOnly|flagFast, 1, 1, 1, "string")
+	registerCommand("getrange", GetRange, 4, flagReadOnly, 1, 1, 1, "string")
+	registerCommand("substr", GetRange, 4, flagReadOnly, 1, 1, 1, "string")
+	registerCommand("setrange", SetRange, 4, flagWrite|flagDenyOOM, 1, 1, 1, "string")
+	registerCommand("incr", Incr, 2, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string")
+	registerCommand("incrby", IncrBy, 3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string")
+	registerCommand("incrbyfloat", IncrByFloat, 3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string").rewriteBy(rewriteAsSet)
+	registerCommand("decr", Decr, 2, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string")
+	registerCommand("decrby", DecrBy, 3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "string")
+
+	registerCommand("setbit", SetBit, 4, flagWrite|flagDenyOOM, 1, 1, 1, "bitmap")
+	registerCommand("getbit", GetBit, 3, flagReadOnly|flagFast, 1, 1, 1, "bitmap")
+	registerCommand("bitcount", BitCount, -2, flagReadOnly, 1, 1, 1, "bitmap")
+	registerCommand("bitpos", BitPos, -3, flagReadOnly, 1, 1, 1, "bitmap")
+	registerCommand("bitop", BitOp, -4, flagWrite|flagDenyOOM, 2, -1, 1, "bitmap")
+	registerCommand("bitfield", BitField, -2, flagWrite|flagDenyOOM, 1, 1, 1, "bitmap")
+	registerCommand("bitfield_ro", BitFieldRO, -2, flagReadOnly|flagFast, 1, 1, 1, "bitmap")
+
+	registerCommand("pfadd", PFAdd, -2, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "hyperloglog")
+	registerCommand("pfcount", PFCount, -2, flagReadOnly|flagMayReplicate, 1, -1, 1, "hyperloglog")
+	registerCommand("pfmerge", PFMerge, -2, flagWrite|flagDenyOOM, 1, -1, 1, "hyperloglog")
+
+	registerCommand("lpush", LPush, -3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "list")
+	registerCommand("lpushx", LPushX, -3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "list").rewriteBy(changedOnly)
+	registerCommand("rpush", RPush, -3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "list")
+	registerCommand("rpushx", RPushX, -3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "list").rewriteBy(changedOnly)
+	registerCommand("lpop", LPop, -2, flagWrite|flagFast, 1, 1, 1, "list").rewriteBy(changedOnly)
+	registerCommand("rpop", RPop, -2, flagWrite|flagFast, 1, 1, 1, "list").rewriteBy(changedOnly)
+	registerCommand("rpoplpush", RPopLPush, 3, flagWrite|flagDenyOOM, 1, 2, 1, "list").rewriteBy(changedOnly)
+	registerCommand("lmove", LMove, 5, flagWrite|flagDenyOOM, 1, 2, 1, "list").rewriteBy(changedOnly)
+	registerCommand("lmpop", LMPop, -4, flagWrite, 0, 0, 0, "list").rewriteBy(changedOnly).keysBy(keysAfterNumKeys)
+	registerCommand("lrem", LRem, 4, flagWrite, 1, 1, 1, "list").rewriteBy(changedOnly)
+	registerCommand("llen", LLen, 2, flagReadOnly|flagFast, 1, 1, 1, "list")
+	registerCommand("lindex", LIndex, 3, flagReadOnly, 1, 1, 1, "list")
+	registerCommand("lset", LSet, 4, flagWrite|flagDenyOOM, 1, 1, 1, "list")
+	registerCommand("lrange", LRange, 4, flagReadOnly, 1, 1, 1, "list")
+	registerCommand("linsert", LInsert, 5, flagWrite|flagDenyOOM, 1, 1, 1, "list").rewriteBy(changedOnly)
+	registerCommand("ltrim", LTrim, 4, flagWrite, 1, 1, 1, "list")
+	registerCommand("lpos", LPos, -3, flagReadOnly, 1, 1, 1, "list")
+
+	registerCommand("hset", HSet, 4, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "hash")
+	registerCommand("hsetnx", HSetNX, 4, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "hash").rewriteBy(changedOnly)
+	registerCommand("hget", HGet, 3, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hexists", HExists, 3, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hdel", HDel, -3, flagWrite|flagFast, 1, 1, 1, "hash").rewriteBy(changedOnly)
+	registerCommand("hlen", HLen, 2, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hmget", HMGet, -3, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hmset", HMSet, -4, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "hash")
+	registerCommand("hkeys", HKeys, 2, flagReadOnly, 1, 1, 1, "hash")
+	registerCommand("hvals", HVals, 2, flagReadOnly, 1, 1, 1, "hash")
+	registerCommand("hgetall", HGetAll, 2, flagReadOnly, 1, 1, 1, "hash")
+	registerCommand("hincrby", HIncrBy, 4, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "hash")
+	registerCommand("hincrbyfloat", HIncrByFloat, 4, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "hash").rewriteBy(rewriteHIncrByFloat)
+	registerCommand("hstrlen", HStrLen, 3, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hrandfield", HRandField, -2, flagReadOnly, 1, 1, 1, "hash")
+	registerCommand("hexpire", HExpire, -6, flagWrite|flagFast, 1, 1, 1, "hash").rewriteBy(rewriteHExpire)
+	registerCommand("hpexpire", HPExpire, -6, flagWrite|flagFast, 1, 1, 1, "hash").rewriteBy(rewriteHExpire)
+	registerCommand("hexpireat", HExpireAt, -6, flagWrite|flagFast, 1, 1, 1, "hash").rewriteBy(rewriteHExpire)
+	registerCommand("hpexpireat", HPExpireAt, -6, flagWrite|flagFast, 1, 1, 1, "hash").rewriteBy(rewriteHExpire)
+	registerCommand("httl", HTTL, -5, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hpttl", HPTTL, -5, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hexpiretime", HExpireTime, -5, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hpexpiretime", HPExpireTime, -5, flagReadOnly|flagFast, 1, 1, 1, "hash")
+	registerCommand("hpersist", HPersist, -5, flagWrite|flagFast, 1, 1, 1, "hash")
+
+	registerCommand("sadd", SAdd, -3, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "set").rewriteBy(changedOnly)
+	registerCommand("sismember", SIsMember, 3, flagReadOnly|flagFast, 1, 1, 1, "set")
+	registerCommand("srem", SRem, -3, flagWrite|flagFast, 1, 1, 1, "set").rewriteBy(changedOnly)
+	registerCommand("scard", SCard, 2, flagReadOnly|flagFast, 1, 1, 1, "set")
+	registerCommand("smembers", SMembers, 2, flagReadOnly, 1, 1, 1, "set")
+	registerCommand("sinter", SInter, -2, flagReadOnly, 1, -1, 1, "set")
+	registerCommand("sinterstore", SInterStore, -3, flagWrite|flagDenyOOM, 1, -1, 1, "set")
+	registerCommand("sunion", SUnion, -2, flagReadOnly, 1, -1, 1, "set")
+	registerCommand("sunionstore", SUnionStore, -3, flagWrite|flagDenyOOM, 1, -1, 1, "set")
+	registerCommand("sdiff", SDiff, -2, flagReadOnly, 1, -1, 1, "set")
+	registerCommand("sdiffstore", SDiffStore, -3, flagWrite|flagDenyOOM, 1, -1, 1, "set")
+	registerCommand("srandmember", SRandMember, -2, flagReadOnly, 1, 1, 1, "set")
+	registerCommand("spop", SPop, -2, flagWrite|flagFast, 1, 1, 1, "set").rewriteBy(rewriteSPop)
+	registerCommand("smove", SMove, 4, flagWrite|flagFast, 1, 2, 1, "set").rewriteBy(changedOnly)
+	registerCommand("smismember", SMIsMember, -3, flagReadOnly|flagFast, 1, 1, 1, "set")
+	registerCommand("sintercard", SInterCard, -3, flagReadOnly, 0, 0, 0, "set").keysBy(keysAfterNumKeys)
+
+	registerCommand("zadd", ZAdd, -4, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zscore", ZScore, 3, flagReadOnly|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zmscore", ZMScore, -3, flagReadOnly|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zincrby", ZIncrBy, 4, flagWrite|flagDenyOOM|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zrank", ZRank, 3, flagReadOnly|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zcount", ZCount, 4, flagReadOnly|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zrevrank", ZRevRank, 3, flagReadOnly|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zcard", ZCard, 2, flagReadOnly|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zrange", ZRange, -4, flagReadOnly, 1, 1, 1, "sortedset")
+	registerCommand("zrevrange", ZRevRange, -4, flagReadOnly, 1, 1, 1, "sortedset")
+	registerCommand("zrangebyscore", ZRangeByScore, -4, flagReadOnly, 1, 1, 1, "sortedset")
+	registerCommand("zrevrangebyscore", ZRevRangeByScore, -4, flagReadOnly, 1, 1, 1, "sortedset")
+	registerCommand("zrangebylex", ZRangeByLex, -4, flagReadOnly, 1, 1, 1, "sortedset")
+	registerCommand("zrevrangebylex", ZRevRangeByLex, -4, flagReadOnly, 1, 1, 1, "sortedset")
+	registerCommand("zlexcount", ZLexCount, 4, flagReadOnly|flagFast, 1, 1, 1, "sortedset")
+	registerCommand("zrangestore", ZRangeStore, -5, flagWrite|flagDenyOOM, 1, 2, 1, "sortedset")
+	registerCommand("zrandmember", ZRandMember, -2, flagReadOnly, 1, 1, 1, "sortedset")
+	registerCommand("zrem", ZRem, -3, flagWrite|flagFast, 1, 1, 1, "sortedset").rewriteBy(changedOnly)
+	registerCommand("zremrangebyscore", ZRemRangeByScore, 4, flagWrite, 1, 1, 1, "sortedset").rewriteBy(changedOnly)
+	registerCommand("zremrangebyrank", ZRemRangeByRank, 4, flagWrite, 1, 1, 1, "sortedset").rewriteBy(changedOnly)
+	registerCommand("zremrangebylex", ZRemRangeByLex, 4, flagWrite, 1, 1, 1, "sortedset").rewriteBy(changedOnly)
+	registerCommand("zpopmin", ZPopMin, -2, flagWrite|flagFast, 1, 1, 1, "sortedset").rewriteBy(changedOnly)
+	registerCommand("zpopmax", ZPopMax, -2, flagWrite|flagFast, 1, 1, 1, "sortedset").rewriteBy(changedOnly)
+	registerCommand("bzpopmin", BZPopMin, -3, flagWrite|flagFast|flagBlocking, 1, -2, 1, "sortedset").rewriteBy(rewriteBZPop)
+	registerCommand("bzpopmax", BZPopMax, -3, flagWrite|flagFast|flagBlocking, 1, -2, 1, "sortedset").rewriteBy(rewriteBZPop)
+	registerCommand("zunionstore", ZUnionStore, -4, flagWrite|flagDenyOOM, 0, 0, 0, "sortedset").keysBy(zsetStoreKeys)
+	registerCommand("zinterstore", ZInterStore, -4, flagWrite|flagDenyOOM, 0, 0, 0, "sortedset").keysBy(zsetStoreKeys)
+	registerCommand("zdiffstore", ZDiffStore, -4, flagWrite|flagDenyOOM, 0, 0, 0, "sortedset").keysBy(zsetStoreKeys)
+
+	registerCommand("geoadd", GeoAdd, -5, flagWrite|flagDenyOOM, 1, 1, 1, "geo")
+	registerCommand("geopos", GeoPos, -2, flagReadOnly, 1, 1, 1, "geo")
+	registerCommand("geodist", GeoDist, -4, flagReadOnly, 1, 1, 1, "geo")
+	registerCommand("geohash", GeoHash, -2, flagReadOnly, 1, 1, 1, "geo")
+	registerCommand("geosearch", GeoSearch, -7, flagReadOnly, 1, 1, 1, "geo")
+	registerCommand("geosearchstore", GeoSearchStore, -8, flagWrite|flagDenyOOM, 1, 2, 1, "geo")
+
+	// scripts may write, they go through the path of write commands
+	registerCommand("eval", Eval, -3, flagWrite|flagNoScript, 0, 0, 0, "scripting").keysBy(scriptKeys).rewriteBy(noPropagate)
+	registerCommand("evalsha", EvalSha, -3, flagWrite|flagNoScript, 0, 0, 0, "scripting").keysBy(scriptKeys).rewriteBy(noPropagate)
+	registerCommand("script", Script, -2, flagNoScript, 0, 0, 0, "scripting")
+	registerCommand("function", Function, -2, flagWrite|flagNoScript, 0, 0, 0, "scripting").rewriteBy(rewriteFunction)
+	registerCommand("fcall", FCall, -3, flagWrite|flagNoScript, 0, 0, 0, "scripting").keysBy(scriptKeys).rewriteBy(noPropagate)
+	registerCommand("fcall_ro", FCallRO, -3, flagReadOnly|flagNoScript, 0, 0, 0, "scripting").keysBy(scriptKeys)
+}
+
+func Publish(db *DB, args [][]byte) redis.Reply {
+	return pubsub.Publish(db.hub, args)
+}
+
+func SlowLog(db *DB, args [][]byte) redis.Reply {
+	return slowlog.Exec(slowlog.Default, args)
+}
+
+func Latency(db *DB, args [][]byte) redis.Reply {
+	return latency.Exec(latency.Default, args)
+}
+
+// IsWriteCommand tells whether command modifies dataset or is propagated, CLIENT PAUSE WRITE blocks these commands
+func IsWriteCommand(cmd string) bool {
+	command, ok := cmdTable[cmd]
+	return ok && command.flags&(flagWrite|flagMayReplicate) != 0
 }
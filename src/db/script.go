@@ -0,0 +1,508 @@
+package db
+
+/*
+ * lua scripting, scripts run in an embedded lua vm one by one.
+ * DB.Exec holds locks of KEYS while the script runs, commands called by redis.call run without locking
+ * and refuse keys not declared in KEYS.
+ * effects of commands called by scripts are appended to aof, so aof is replayed without running scripts.
+ */
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"my-godis/src/config"
+	"my-godis/src/interface/redis"
+	"my-godis/src/lib/logger"
+	"my-godis/src/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// scriptCache maps sha1 of script to compiled function
+type scriptCache struct {
+	mu      sync.RWMutex
+	scripts map[string]*lua.FunctionProto
+}
+
+func (cache *scriptCache) get(sha string) *lua.FunctionProto {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.scripts[sha]
+}
+
+func (cache *scriptCache) put(sha string, proto *lua.FunctionProto) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.scripts == nil {
+		cache.scripts = make(map[string]*lua.FunctionProto)
+	}
+	cache.scripts[sha] = proto
+}
+
+func (cache *scriptCache) flush() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.scripts = nil
+}
+
+// states of running script
+const (
+	scriptRunning int32 = iota
+	// write commands were called, the script cannot be killed
+	scriptWrote
+	scriptKilled
+)
+
+// runningScript is the script in execution
+type runningScript struct {
+	// KEYS declared by the script
+	keys   map[string]bool
+	start  time.Time
+	ctx    context.Context
+	cancel context.CancelFunc
+	state  int32
+	// FCALL_RO or functions with no-writes flag
+	readOnly bool
+}
+
+// checkScriptBusy returns BUSY error while a script runs longer than lua-time-limit
+func (db *DB) checkScriptBusy() redis.Reply {
+	script := db.script.Load()
+	limit := config.Properties().LuaTimeLimit
+	if script == nil || limit <= 0 || time.Since(script.start) < limit {
+		return nil
+	}
+	return reply.MakeErrReply("BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.")
+}
+
+// SCRIPT KILL and FUNCTION KILL are allowed while script is busy
+func isScriptKill(cmd string, args [][]byte) bool {
+	return (cmd == "script" || cmd == "function") && len(args) == 2 && strings.ToLower(string(args[1])) == "kill"
+}
+
+func sha1Hex(src []byte) string {
+	sum := sha1.Sum(src)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadScript compiles script and caches it
+func (db *DB) loadScript(src []byte) (string, *lua.FunctionProto, redis.Reply) {
+	sha := sha1Hex(src)
+	if proto := db.scripts.get(sha); proto != nil {
+		return sha, proto, nil
+	}
+	chunk, err := parse.Parse(bytes.NewReader(src), "user_script")
+	if err != nil {
+		return "", nil, reply.MakeErrReply("ERR Error compiling script (new function): " + err.Error())
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return "", nil, reply.MakeErrReply("ERR Error compiling script (new function): " + err.Error())
+	}
+	db.scripts.put(sha, proto)
+	return sha, proto, nil
+}
+
+// EVAL script numkeys [key ...] [arg ...]
+func Eval(db *DB, args [][]byte) redis.Reply {
+	sha, proto, errReply := db.loadScript(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	return db.evalScript(sha, proto, args[1:])
+}
+
+// EVALSHA sha1 numkeys [key ...] [arg ...]
+func EvalSha(db *DB, args [][]byte) redis.Reply {
+	sha := strings.ToLower(string(args[0]))
+	proto := db.scripts.get(sha)
+	if proto == nil {
+		return reply.MakeErrReply("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return db.evalScript(sha, proto, args[1:])
+}
+
+// evalScript runs compiled script in a new lua state with args: numkeys [key ...] [arg ...]
+func (db *DB) evalScript(sha string, proto *lua.FunctionProto, args [][]byte) redis.Reply {
+	return db.runScript(args, false, func(script *runningScript, keys [][]byte, argv [][]byte) redis.Reply {
+		L := db.newLuaState(func() *runningScript {
+			return script
+		})
+		defer L.Close()
+		L.SetContext(script.ctx)
+		L.SetGlobal("KEYS", makeLuaArray(L, keys))
+		L.SetGlobal("ARGV", makeLuaArray(L, argv))
+
+		L.Push(L.NewFunctionFromProto(proto))
+		err := L.PCall(0, 1, nil)
+		if err != nil {
+			return makeScriptErrReply(script, err, "Error running script (call to f_"+sha+")")
+		}
+		return luaToReply(L.Get(-1))
+	})
+}
+
+// runScript parses args: numkeys [key ...] [arg ...], then calls run as the running script
+func (db *DB) runScript(args [][]byte, readOnly bool,
+	run func(script *runningScript, keys [][]byte, argv [][]byte) redis.Reply) redis.Reply {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return reply.MakeErrReply("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-1 {
+		return reply.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	argv := args[numKeys+1:]
+
+	db.scriptMu.Lock()
+	defer db.scriptMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	script := &runningScript{
+		keys:     make(map[string]bool, len(keys)),
+		start:    time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+		readOnly: readOnly,
+	}
+	for _, key := range keys {
+		script.keys[key] = true
+	}
+	db.script.Store(script)
+	defer db.script.Store(nil)
+	return run(script, args[1:numKeys+1], argv)
+}
+
+func makeLuaArray(L *lua.LState, values [][]byte) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+	for _, val := range values {
+		table.Append(lua.LString(val))
+	}
+	return table
+}
+
+// makeScriptErrReply converts error of lua call, errors raised by redis.call are returned as they are
+func makeScriptErrReply(script *runningScript, err error, prefix string) redis.Reply {
+	if atomic.LoadInt32(&script.state) == scriptKilled {
+		return reply.MakeErrReply("ERR Script killed by user with SCRIPT KILL...")
+	}
+	var apiErr *lua.ApiError
+	if !errors.As(err, &apiErr) {
+		return reply.MakeErrReply(fmt.Sprintf("ERR %s: %v", prefix, err))
+	}
+	if table, ok := apiErr.Object.(*lua.LTable); ok {
+		if msg, ok := table.RawGetString("err").(lua.LString); ok {
+			return reply.MakeErrReply(string(msg))
+		}
+	}
+	return reply.MakeErrReply(fmt.Sprintf("ERR %s: %s", prefix, apiErr.Object.String()))
+}
+
+// lua libs available in scripts, scripts cannot access files or os
+var luaLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+var luaLogLevels = []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"}
+
+// newLuaState makes a sandboxed lua state, redis.call runs commands as the script returned by current
+func (db *DB) newLuaState(current func() *runningScript) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range luaLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	L.SetGlobal("dofile", lua.LNil)
+	L.SetGlobal("loadfile", lua.LNil)
+
+	mod := L.NewTable()
+	L.SetFuncs(mod, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return db.luaCall(L, current(), true)
+		},
+		"pcall": func(L *lua.LState) int {
+			return db.luaCall(L, current(), false)
+		},
+		"error_reply":  luaErrorReply,
+		"status_reply": luaStatusReply,
+		"sha1hex":      luaSha1Hex,
+		"log":          luaLog,
+	})
+	for level, name := range luaLogLevels {
+		L.SetField(mod, name, lua.LNumber(level))
+	}
+	L.SetGlobal("redis", mod)
+	return L
+}
+
+// redis.call raises error replies, redis.pcall returns them as {err=message}
+func (db *DB) luaCall(L *lua.LState, script *runningScript, raise bool) int {
+	if script == nil {
+		L.RaiseError("redis.call and redis.pcall are not allowed while loading library")
+		return 0
+	}
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for redis.call()")
+		return 0
+	}
+	args := make([][]byte, n)
+	for i := 1; i <= n; i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			args[i-1] = []byte(arg)
+		case lua.LNumber:
+			args[i-1] = []byte(arg.String())
+		default:
+			L.RaiseError("Lua redis() command arguments must be strings or integers")
+			return 0
+		}
+	}
+	value := replyToLua(L, db.execScriptCommand(script, args))
+	if table, ok := value.(*lua.LTable); ok && raise && table.RawGetString("err") != lua.LNil {
+		L.Error(table, 1)
+		return 0
+	}
+	L.Push(value)
+	return 1
+}
+
+// execScriptCommand runs command called by script, the script holds locks of its KEYS
+func (db *DB) execScriptCommand(script *runningScript, args [][]byte) redis.Reply {
+	cmd, ok := cmdTable[strings.ToLower(string(args[0]))]
+	if !ok {
+		return reply.MakeErrReply("ERR Unknown Redis command called from script")
+	}
+	if cmd.flags&flagNoScript != 0 {
+		return reply.MakeErrReply("ERR This Redis command is not allowed from scripts")
+	}
+	if !cmd.validArity(args) {
+		return reply.MakeErrReply("ERR Wrong number of args calling Redis command from script")
+	}
+	isWrite := cmd.flags&flagWrite != 0
+	if isWrite && script.readOnly {
+		return reply.MakeErrReply("ERR Write commands are not allowed from read-only scripts.")
+	}
+	if cmd.flags&flagDenyOOM != 0 && overMaxMemory() {
+		return makeOOMErrReply()
+	}
+	if isWrite &&
+		!atomic.CompareAndSwapInt32(&script.state, scriptRunning, scriptWrote) &&
+		atomic.LoadInt32(&script.state) == scriptKilled {
+		return reply.MakeErrReply("ERR Script killed by user with SCRIPT KILL...")
+	}
+	for _, key := range cmd.keys(args) {
+		if !script.keys[key] {
+			// locks of undeclared keys are not held
+			return reply.MakeErrReply("ERR Script attempted to access a key not declared in KEYS")
+		}
+	}
+	result := cmd.executor(db, args[1:])
+	db.propagate(cmd, args, result)
+	if isWrite {
+		db.signalKeys(cmd.keys(args)...)
+	}
+	return result
+}
+
+// replyToLua converts reply by its RESP encoding, errors and status are converted to {err=...} and {ok=...}
+func replyToLua(L *lua.LState, r redis.Reply) lua.LValue {
+	value, _ := respToLua(L, r.ToBytes())
+	return value
+}
+
+// respToLua converts RESP value at the beginning of b, returns remaining bytes
+func respToLua(L *lua.LState, b []byte) (lua.LValue, []byte) {
+	end := bytes.Index(b, []byte(reply.CRLF))
+	if end < 1 {
+		return lua.LFalse, nil
+	}
+	line, rest := string(b[1:end]), b[end+2:]
+	switch b[0] {
+	case '+':
+		table := L.NewTable()
+		table.RawSetString("ok", lua.LString(line))
+		return table, rest
+	case '-':
+		table := L.NewTable()
+		table.RawSetString("err", lua.LString(line))
+		return table, rest
+	case ':':
+		n, _ := strconv.ParseInt(line, 10, 64)
+		return lua.LNumber(n), rest
+	case '$':
+		n, _ := strconv.Atoi(line)
+		if n < 0 || n+2 > len(rest) {
+			return lua.LFalse, rest
+		}
+		return lua.LString(rest[:n]), rest[n+2:]
+	case '*':
+		n, _ := strconv.Atoi(line)
+		if n < 0 {
+			return lua.LFalse, rest
+		}
+		table := L.CreateTable(n, 0)
+		for i := 0; i < n; i++ {
+			var value lua.LValue
+			value, rest = respToLua(L, rest)
+			table.Append(value)
+		}
+		return table, rest
+	}
+	return lua.LFalse, nil
+}
+
+// luaToReply converts value returned by script, arrays end at the first nil
+func luaToReply(value lua.LValue) redis.Reply {
+	switch value := value.(type) {
+	case lua.LString:
+		return reply.MakeBulkReply([]byte(value))
+	case lua.LNumber:
+		return reply.MakeIntReply(int64(value))
+	case lua.LBool:
+		if value {
+			return reply.MakeIntReply(1)
+		}
+	case *lua.LTable:
+		if msg, ok := value.RawGetString("err").(lua.LString); ok {
+			return reply.MakeErrReply(string(msg))
+		}
+		if status, ok := value.RawGetString("ok").(lua.LString); ok {
+			return reply.MakeStatusReply(string(status))
+		}
+		replies := make([]redis.Reply, 0, value.Len())
+		for i := 1; ; i++ {
+			item := value.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			replies = append(replies, luaToReply(item))
+		}
+		return reply.MakeMultiRawReply(replies)
+	}
+	return &reply.NullBulkReply{}
+}
+
+func luaErrorReply(L *lua.LState) int {
+	table := L.NewTable()
+	table.RawSetString("err", lua.LString(L.CheckString(1)))
+	L.Push(table)
+	return 1
+}
+
+func luaStatusReply(L *lua.LState) int {
+	table := L.NewTable()
+	table.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(table)
+	return 1
+}
+
+func luaSha1Hex(L *lua.LState) int {
+	L.Push(lua.LString(sha1Hex([]byte(L.CheckString(1)))))
+	return 1
+}
+
+// redis.log(level, message ...)
+func luaLog(L *lua.LState) int {
+	level := L.CheckInt(1)
+	parts := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.Get(i).String())
+	}
+	msg := strings.Join(parts, " ")
+	switch {
+	case level < 0 || level >= len(luaLogLevels):
+		L.RaiseError("Invalid debug level.")
+	case level == len(luaLogLevels)-1:
+		logger.Warn(msg)
+	default:
+		logger.Info(msg)
+	}
+	return 0
+}
+
+// SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH [ASYNC|SYNC] | KILL
+func Script(db *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "load":
+		if len(args) != 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'script|load' command")
+		}
+		sha, _, errReply := db.loadScript(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		return reply.MakeBulkReply([]byte(sha))
+	case "exists":
+		if len(args) < 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'script|exists' command")
+		}
+		result := make([]redis.Reply, len(args)-1)
+		for i, sha := range args[1:] {
+			if db.scripts.get(strings.ToLower(string(sha))) != nil {
+				result[i] = reply.MakeIntReply(1)
+			} else {
+				result[i] = reply.MakeIntReply(0)
+			}
+		}
+		return reply.MakeMultiRawReply(result)
+	case "flush":
+		if len(args) > 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'script|flush' command")
+		}
+		if len(args) == 2 {
+			mode := strings.ToUpper(string(args[1]))
+			if mode != "ASYNC" && mode != "SYNC" {
+				return reply.MakeErrReply("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+			}
+		}
+		db.scripts.flush()
+		return &reply.OkReply{}
+	case "kill":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'script|kill' command")
+		}
+		return db.killScript()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try SCRIPT HELP.")
+}
+
+func (db *DB) killScript() redis.Reply {
+	script := db.script.Load()
+	if script == nil {
+		return reply.MakeErrReply("NOTBUSY No scripts in execution right now.")
+	}
+	if !atomic.CompareAndSwapInt32(&script.state, scriptRunning, scriptKilled) &&
+		atomic.LoadInt32(&script.state) == scriptWrote {
+		return reply.MakeErrReply("UNKILLABLE Sorry the script already executed write commands against the dataset. " +
+			"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+	script.cancel()
+	return &reply.OkReply{}
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"my-godis/src/config"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	result := Eval(testDB, toArgs("redis.call('SET', KEYS[1], ARGV[1]); return redis.call('GET', KEYS[1])", "1", "k", "v"))
+	asserts.AssertBulkReply(t, result, "v")
+
+	// conversion of lua values
+	script := "return {1, 2.9, 'a', {ok='fine'}, true, false, {'x', {'y'}}, nil, 'ignored'}"
+	result = Eval(testDB, toArgs(script, "0"))
+	expected := "*7\r\n:1\r\n:2\r\n$1\r\na\r\n+fine\r\n:1\r\n$-1\r\n*2\r\n$1\r\nx\r\n*1\r\n$1\r\ny\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.ToBytes())
+	}
+	RPush(testDB, toArgs("list", "a", "b"))
+	result = Eval(testDB, toArgs("return redis.call('LRANGE', KEYS[1], 0, -1)", "1", "list"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\na\r\n$1\r\nb\r\n" {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	result = Eval(testDB, toArgs("return redis.call('GET', KEYS[1]) == false", "1", "none"))
+	asserts.AssertIntReply(t, result, 1)
+	result = Eval(testDB, toArgs("return redis.status_reply(redis.sha1hex(''))", "0"))
+	asserts.AssertStatusReply(t, result, "da39a3ee5e6b4b0d3255bfef95601890afd80709")
+
+	result = Eval(testDB, toArgs("return 1", "2", "k"))
+	asserts.AssertErrReply(t, result, "ERR Number of keys can't be greater than number of args")
+	result = Eval(testDB, toArgs("return +", "0"))
+	if !reply.IsErrorReply(result) {
+		t.Error("expected compile error")
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	RPush(testDB, toArgs("list", "a"))
+	result := Eval(testDB, toArgs("redis.call('GET', KEYS[1]); return 1", "1", "list"))
+	asserts.AssertErrReply(t, result, (&reply.WrongTypeErrReply{}).Error())
+	result = Eval(testDB, toArgs("return redis.pcall('GET', KEYS[1])['err']", "1", "list"))
+	asserts.AssertBulkReply(t, result, (&reply.WrongTypeErrReply{}).Error())
+	result = Eval(testDB, toArgs("return redis.error_reply('MY failure')", "0"))
+	asserts.AssertErrReply(t, result, "MY failure")
+
+	// keys must be declared
+	result = Eval(testDB, toArgs("return redis.pcall('SET', ARGV[1], 'v')['err']", "1", "list", "undeclared"))
+	asserts.AssertBulkReply(t, result, "ERR Script attempted to access a key not declared in KEYS")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("undeclared")), 0)
+	result = Eval(testDB, toArgs("return redis.call('EVAL', 'return 1', 0)", "0"))
+	asserts.AssertErrReply(t, result, "ERR This Redis command is not allowed from scripts")
+}
+
+func TestEvalSha(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Script(testDB, toArgs("flush"))
+	sha := "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"
+	result := EvalSha(testDB, toArgs(sha, "0"))
+	asserts.AssertErrReply(t, result, "NOSCRIPT No matching script. Please use EVAL.")
+	result = Script(testDB, toArgs("load", "return 1"))
+	asserts.AssertBulkReply(t, result, sha)
+	asserts.AssertIntReply(t, EvalSha(testDB, toArgs(sha, "0")), 1)
+	result = Script(testDB, toArgs("exists", sha, "ffffffffffffffffffffffffffffffffffffffff"))
+	if string(result.ToBytes()) != "*2\r\n:1\r\n:0\r\n" {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	asserts.AssertStatusReply(t, Script(testDB, toArgs("flush")), "OK")
+	asserts.AssertErrReply(t, EvalSha(testDB, toArgs(sha, "0")), "NOSCRIPT No matching script. Please use EVAL.")
+}
+
+// runLoop runs an endless script in background, and waits until it starts
+func runLoop(t *testing.T, db *DB, script string) <-chan string {
+	done := make(chan string, 1)
+	go func() {
+		done <- string(Eval(db, toArgs(script, "1", "k")).ToBytes())
+	}()
+	for db.script.Load() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	return done
+}
+
+func TestScriptKill(t *testing.T) {
+	db := makeTestDB()
+	asserts.AssertErrReply(t, Script(db, toArgs("kill")), "NOTBUSY No scripts in execution right now.")
+
+	limit := config.Properties().LuaTimeLimit
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.LuaTimeLimit = 10 * time.Millisecond
+	})
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.LuaTimeLimit = limit
+		})
+	}()
+
+	done := runLoop(t, db, "while true do end")
+	time.Sleep(20 * time.Millisecond)
+	result := db.Exec(nil, toArgs("get", "other"))
+	asserts.AssertErrReply(t, result, "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.")
+	asserts.AssertStatusReply(t, db.Exec(nil, toArgs("script", "kill")), "OK")
+	if msg := <-done; msg != "-ERR Script killed by user with SCRIPT KILL...\r\n" {
+		t.Errorf("unexpected reply %q", msg)
+	}
+	asserts.AssertNullBulk(t, db.Exec(nil, toArgs("get", "other")))
+
+	// scripts wrote dataset cannot be killed
+	done = runLoop(t, db, "redis.call('SET', KEYS[1], 'v') while true do end")
+	for atomic.LoadInt32(&db.script.Load().state) != scriptWrote {
+		time.Sleep(time.Millisecond)
+	}
+	result = Script(db, toArgs("kill"))
+	asserts.AssertErrReply(t, result, "UNKILLABLE Sorry the script already executed write commands against the dataset. "+
+		"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	db.script.Load().cancel()
+	<-done
+}
@@ -4,6 +4,8 @@ import (
 	HashSet "my-godis/src/datastruct/set"
 	"my-godis/src/interface/redis"
 	"my-godis/src/redis/reply"
+	"sort"
+	"strings"
 
 	"strconv"
 )
@@ -37,16 +39,9 @@ func (db *DB) getOrInitSet(key string) (set *HashSet.Set, inited bool, errReply
 }
 
 func SAdd(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sadd' command")
-	}
 	key := string(args[0])
 	members := args[1:]
 
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	// get or init entity
 	set, _, errReply := db.getOrInitSet(key)
 	if errReply != nil {
@@ -56,14 +51,10 @@ func SAdd(db *DB, args [][]byte) redis.Reply {
 	for _, member := range members {
 		counter += set.Add(string(member))
 	}
-	db.AddAof(makeAofCmd("sadd", args))
 	return reply.MakeIntReply(int64(counter))
 }
 
 func SIsMember(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sismember' command")
-	}
 	key := string(args[0])
 	member := string(args[1])
 
@@ -85,16 +76,9 @@ func SIsMember(db *DB, args [][]byte) redis.Reply {
 }
 
 func SRem(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'srem' command")
-	}
 	key := string(args[0])
 	members := args[1:]
 
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
@@ -109,16 +93,10 @@ func SRem(db *DB, args [][]byte) redis.Reply {
 	if set.Len() == 0 {
 		db.Remove(key)
 	}
-	if counter > 0 {
-		db.AddAof(makeAofCmd("srem", args))
-	}
 	return reply.MakeIntReply(int64(counter))
 }
 
 func SCard(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'scard' command")
-	}
 	key := string(args[0])
 
 	// get or init entity
@@ -133,15 +111,8 @@ func SCard(db *DB, args [][]byte) redis.Reply {
 }
 
 func SMembers(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'smembers' command")
-	}
 	key := string(args[0])
 
-	// lock
-	db.Locker.RLock(key)
-	defer db.Locker.RUnLock(key)
-
 	// get or init entity
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
@@ -162,18 +133,11 @@ func SMembers(db *DB, args [][]byte) redis.Reply {
 }
 
 func SInter(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sinter' command")
-	}
 	keys := make([]string, len(args))
 	for i, arg := range args {
 		keys[i] = string(arg)
 	}
 
-	// lock
-	db.Locker.RLocks(keys...)
-	defer db.Locker.RUnLocks(keys...)
-
 	var result *HashSet.Set
 	for _, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -207,9 +171,6 @@ func SInter(db *DB, args [][]byte) redis.Reply {
 }
 
 func SInterStore(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sinterstore' command")
-	}
 	dest := string(args[0])
 	keys := make([]string, len(args)-1)
 	keyArgs := args[1:]
@@ -217,12 +178,6 @@ func SInterStore(db *DB, args [][]byte) redis.Reply {
 		keys[i] = string(arg)
 	}
 
-	// lock
-	db.RLocks(keys...)
-	defer db.RUnLocks(keys...)
-	db.Lock(dest)
-	defer db.UnLock(dest)
-
 	var result *HashSet.Set
 	for _, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -251,23 +206,15 @@ func SInterStore(db *DB, args [][]byte) redis.Reply {
 	db.Put(dest, &DataEntity{
 		Data: set,
 	})
-	db.AddAof(makeAofCmd("sinterstore", args))
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
 func SUnion(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sunion' command")
-	}
 	keys := make([]string, len(args))
 	for i, arg := range args {
 		keys[i] = string(arg)
 	}
 
-	// lock
-	db.RLocks(keys...)
-	defer db.RUnLocks(keys...)
-
 	var result *HashSet.Set
 	for _, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -301,9 +248,6 @@ func SUnion(db *DB, args [][]byte) redis.Reply {
 }
 
 func SUnionStore(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sunionstore' command")
-	}
 	dest := string(args[0])
 	keys := make([]string, len(args)-1)
 	keyArgs := args[1:]
@@ -311,12 +255,6 @@ func SUnionStore(db *DB, args [][]byte) redis.Reply {
 		keys[i] = string(arg)
 	}
 
-	// lock
-	db.RLocks(keys...)
-	defer db.RUnLocks(keys...)
-	db.Lock(dest)
-	defer db.UnLock(dest)
-
 	var result *HashSet.Set
 	for _, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -345,23 +283,15 @@ func SUnionStore(db *DB, args [][]byte) redis.Reply {
 		Data: set,
 	})
 
-	db.AddAof(makeAofCmd("sunionstore", args))
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
 func SDiff(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sdiff' command")
-	}
 	keys := make([]string, len(args))
 	for i, arg := range args {
 		keys[i] = string(arg)
 	}
 
-	// lock
-	db.RLocks(keys...)
-	defer db.RUnLocks(keys...)
-
 	var result *HashSet.Set
 	for i, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -403,9 +333,6 @@ func SDiff(db *DB, args [][]byte) redis.Reply {
 }
 
 func SDiffStore(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'sdiffstore' command")
-	}
 	dest := string(args[0])
 	keys := make([]string, len(args)-1)
 	keyArgs := args[1:]
@@ -413,12 +340,6 @@ func SDiffStore(db *DB, args [][]byte) redis.Reply {
 		keys[i] = string(arg)
 	}
 
-	// lock
-	db.RLocks(keys...)
-	defer db.RUnLocks(keys...)
-	db.Lock(dest)
-	defer db.Locker.UnLock(dest)
-
 	var result *HashSet.Set
 	for i, key := range keys {
 		set, errReply := db.getAsSet(key)
@@ -457,54 +378,233 @@ func SDiffStore(db *DB, args [][]byte) redis.Reply {
 		Data: set,
 	})
 
-	db.AddAof(makeAofCmd("sdiffstore", args))
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
+// SRANDMEMBER key [count]
 func SRandMember(db *DB, args [][]byte) redis.Reply {
 	if len(args) != 1 && len(args) != 2 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'srandmember' command")
 	}
 	key := string(args[0])
-	// lock
-	db.RLock(key)
-	defer db.RUnLock(key)
-
 	// get or init entity
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
 	}
-	if set == nil {
-		return &reply.NullBulkReply{}
-	}
 	if len(args) == 1 {
+		if set == nil {
+			return &reply.NullBulkReply{}
+		}
 		members := set.RandomMembers(1)
 		return reply.MakeBulkReply([]byte(members[0]))
+	}
+
+	count, errReply := parseRandomCount(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil || count == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	// positive count returns distinct members, negative count returns exactly -count members which may repeat
+	var members []string
+	if count > 0 {
+		members = set.RandomDistinctMembers(count)
 	} else {
+		members = set.RandomMembers(-count)
+	}
+	return reply.MakeMultiBulkReply(membersToArgs(members))
+}
+
+// maxRandomCount bounds the reply size of SRANDMEMBER, HRANDFIELD and ZRANDMEMBER with negative count
+const maxRandomCount = 1 << 20
+
+// parseRandomCount parses count of SRANDMEMBER, HRANDFIELD and ZRANDMEMBER
+func parseRandomCount(arg []byte) (int, reply.ErrorReply) {
+	count, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if count < -maxRandomCount {
+		return 0, reply.MakeErrReply("ERR value is out of range")
+	}
+	return int(count), nil
+}
+
+func membersToArgs(members []string) [][]byte {
+	result := make([][]byte, len(members))
+	for i, v := range members {
+		result[i] = []byte(v)
+	}
+	return result
+}
+
+// SPOP key [count]
+func SPop(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 2 {
+		return &reply.SyntaxErrReply{}
+	}
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
 		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
 		if err != nil {
 			return reply.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		count := int(count64)
+		if count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
 
-		if count > 0 {
-			members := set.RandomMembers(count)
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		if len(args) == 2 {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		return &reply.NullBulkReply{}
+	}
+	if count == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
 
-			result := make([][]byte, len(members))
-			for i, v := range members {
-				result[i] = []byte(v)
-			}
-			return reply.MakeMultiBulkReply(result)
-		} else if count < 0 {
-			members := set.RandomDistinctMembers(-count)
-			result := make([][]byte, len(members))
-			for i, v := range members {
-				result[i] = []byte(v)
-			}
-			return reply.MakeMultiBulkReply(result)
+	members := set.RandomDistinctMembers(count)
+	for _, member := range members {
+		set.Remove(member)
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(args) == 1 {
+		return reply.MakeBulkReply([]byte(members[0]))
+	}
+	return reply.MakeMultiBulkReply(membersToArgs(members))
+}
+
+// SPOP is appended as SREM of popped members, which are chosen randomly
+func rewriteSPop(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	var members [][]byte
+	switch result := result.(type) {
+	case *reply.BulkReply:
+		members = [][]byte{result.Arg}
+	case *reply.MultiBulkReply:
+		members = result.Args
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return []*reply.MultiBulkReply{makeAofCmd("srem", append([][]byte{args[1]}, members...))}
+}
+
+// SMOVE source destination member
+func SMove(db *DB, args [][]byte) redis.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	// type of destination is checked even if nothing is moved
+	_, errReply = db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+	if src == dest {
+		return reply.MakeIntReply(1)
+	}
+
+	srcSet.Remove(member)
+	if srcSet.Len() == 0 {
+		db.Remove(src)
+	}
+	destSet, _, _ := db.getOrInitSet(dest)
+	destSet.Add(member)
+	return reply.MakeIntReply(1)
+}
+
+// SMISMEMBER key member [member ...]
+func SMIsMember(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		if set != nil && set.Has(string(member)) {
+			result[i] = reply.MakeIntReply(1)
 		} else {
-			return &reply.EmptyMultiBulkReply{}
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func SInterCard(db *DB, args [][]byte) redis.Reply {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-1 {
+		return reply.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	limit := 0
+	rest := args[1+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return &reply.SyntaxErrReply{}
+		}
+		limit, err = strconv.Atoi(string(rest[1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
 		}
+		if limit < 0 {
+			return reply.MakeErrReply("ERR LIMIT can't be negative")
+		}
+	}
+
+	sets := make([]*HashSet.Set, numKeys)
+	empty := false
+	for i, arg := range args[1 : 1+numKeys] {
+		set, errReply := db.getAsSet(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if set == nil {
+			empty = true
+		}
+		sets[i] = set
 	}
+	if empty {
+		return reply.MakeIntReply(0)
+	}
+
+	// check members of the smallest set, stop counting at limit
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Len() < sets[j].Len()
+	})
+	count := 0
+	sets[0].ForEach(func(member string) bool {
+		for _, set := range sets[1:] {
+			if !set.Has(member) {
+				return true
+			}
+		}
+		count++
+		return limit == 0 || count < limit
+	})
+	return reply.MakeIntReply(int64(count))
 }
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"testing"
+)
+
+func TestSPop(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	SAdd(testDB, toArgs("s", "a", "b", "c", "d"))
+	member, ok := SPop(testDB, toArgs("s")).(*reply.BulkReply)
+	if !ok {
+		t.Fatal("expected a member")
+	}
+	asserts.AssertIntReply(t, SIsMember(testDB, toArgs("s", string(member.Arg))), 0)
+	if r, ok := SPop(testDB, toArgs("s", "2")).(*reply.MultiBulkReply); !ok || len(r.Args) != 2 || string(r.Args[0]) == string(r.Args[1]) {
+		t.Error("expected 2 distinct members")
+	}
+	asserts.AssertIntReply(t, SCard(testDB, toArgs("s")), 1)
+	if r, ok := SPop(testDB, toArgs("s", "5")).(*reply.MultiBulkReply); !ok || len(r.Args) != 1 {
+		t.Error("expected the last member")
+	}
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("s")), 0)
+	asserts.AssertNullBulk(t, SPop(testDB, toArgs("s")))
+	assertRawReply(t, SPop(testDB, toArgs("s", "1")), "*0\r\n")
+	asserts.AssertErrReply(t, SPop(testDB, toArgs("s", "-1")), "ERR value is out of range, must be positive")
+}
+
+func TestSMove(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	SAdd(testDB, toArgs("src", "a", "b"))
+	asserts.AssertIntReply(t, SMove(testDB, toArgs("src", "dest", "a")), 1)
+	asserts.AssertIntReply(t, SMove(testDB, toArgs("src", "dest", "a")), 0)
+	asserts.AssertIntReply(t, SIsMember(testDB, toArgs("dest", "a")), 1)
+	asserts.AssertIntReply(t, SMove(testDB, toArgs("src", "src", "b")), 1)
+	asserts.AssertIntReply(t, SMove(testDB, toArgs("src", "dest", "b")), 1)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("src")), 0)
+	asserts.AssertIntReply(t, SCard(testDB, toArgs("dest")), 2)
+
+	Set(testDB, toArgs("str", "x"))
+	asserts.AssertErrReply(t, SMove(testDB, toArgs("dest", "str", "a")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertIntReply(t, SCard(testDB, toArgs("dest")), 2)
+}
+
+func TestSMIsMember(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	SAdd(testDB, toArgs("s", "a", "b"))
+	assertRawReply(t, SMIsMember(testDB, toArgs("s", "a", "x", "b")), "*3\r\n:1\r\n:0\r\n:1\r\n")
+	assertRawReply(t, SMIsMember(testDB, toArgs("none", "a")), "*1\r\n:0\r\n")
+}
+
+func TestSInterCard(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	SAdd(testDB, toArgs("s1", "a", "b", "c", "d"))
+	SAdd(testDB, toArgs("s2", "b", "c", "d", "e"))
+	asserts.AssertIntReply(t, SInterCard(testDB, toArgs("2", "s1", "s2")), 3)
+	asserts.AssertIntReply(t, SInterCard(testDB, toArgs("2", "s1", "s2", "LIMIT", "2")), 2)
+	asserts.AssertIntReply(t, SInterCard(testDB, toArgs("2", "s1", "s2", "LIMIT", "0")), 3)
+	asserts.AssertIntReply(t, SInterCard(testDB, toArgs("2", "s1", "none")), 0)
+	asserts.AssertErrReply(t, SInterCard(testDB, toArgs("0", "s1")), "ERR numkeys should be greater than 0")
+	asserts.AssertErrReply(t, SInterCard(testDB, toArgs("3", "s1", "s2")), "ERR Number of keys can't be greater than number of args")
+	asserts.AssertErrReply(t, SInterCard(testDB, toArgs("2", "s1", "s2", "LIMIT", "-1")), "ERR LIMIT can't be negative")
+	asserts.AssertErrReply(t, SInterCard(testDB, toArgs("1", "s1", "s2")), "Err syntax error")
+}
+
+func TestSRandMember(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	SAdd(testDB, toArgs("s", "a", "b", "c"))
+	// positive count returns distinct members
+	r, ok := SRandMember(testDB, toArgs("s", "5")).(*reply.MultiBulkReply)
+	if !ok || len(r.Args) != 3 {
+		t.Error("expected all 3 members")
+	}
+	// negative count returns exactly -count members
+	r, ok = SRandMember(testDB, toArgs("s", "-5")).(*reply.MultiBulkReply)
+	if !ok || len(r.Args) != 5 {
+		t.Error("expected 5 members")
+	}
+	assertRawReply(t, SRandMember(testDB, toArgs("none", "2")), "*0\r\n")
+	asserts.AssertNullBulk(t, SRandMember(testDB, toArgs("none")))
+	asserts.AssertErrReply(t, SRandMember(testDB, toArgs("s", "-2000000000")), "ERR value is out of range")
+}
+
+func TestSRandMemberEmptyMember(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	SAdd(testDB, toArgs("a", ""))
+	// stored sets are backed by a concurrent dict
+	SUnionStore(testDB, toArgs("b", "a"))
+	asserts.AssertBulkReply(t, SRandMember(testDB, toArgs("b")), "")
+	asserts.AssertMultiBulkReply(t, SRandMember(testDB, toArgs("b", "-2")), []string{"", ""})
+	asserts.AssertMultiBulkReply(t, SPop(testDB, toArgs("b", "1")), []string{""})
+}
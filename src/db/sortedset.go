@@ -1,6 +1,8 @@
 package db
 
 import (
+	"math"
+	"math/rand"
 	SortedSet "my-godis/src/datastruct/sortedset"
 	"my-godis/src/interface/redis"
 	"my-godis/src/redis/reply"
@@ -37,53 +39,161 @@ func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, in
 	return sortedSet, inited, nil
 }
 
-func ZAdd(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 3 || len(args)%2 != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zadd' command")
+// removeIfEmptySortedSet removes key after its last element is removed
+func (db *DB) removeIfEmptySortedSet(key string, sortedSet *SortedSet.SortedSet) {
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+}
+
+func parseScore(arg []byte) (float64, redis.Reply) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	return score, nil
+}
+
+// formatScore formats infinity as redis does
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	} else if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+func elementsReply(elements []*SortedSet.Element, withScores bool) redis.Reply {
+	if withScores {
+		result := make([][]byte, 0, len(elements)*2)
+		for _, element := range elements {
+			result = append(result, []byte(element.Member), formatScore(element.Score))
+		}
+		return reply.MakeMultiBulkReply(result)
+	}
+	result := make([][]byte, len(elements))
+	for i, element := range elements {
+		result[i] = []byte(element.Member)
 	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func ZAdd(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	size := (len(args) - 1) / 2
+	nx, xx, gt, lt, ch, incr := false, false, false, false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NX" {
+			nx = true
+		} else if option == "XX" {
+			xx = true
+		} else if option == "GT" {
+			gt = true
+		} else if option == "LT" {
+			lt = true
+		} else if option == "CH" {
+			ch = true
+		} else if option == "INCR" {
+			incr = true
+		} else {
+			break
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return &reply.SyntaxErrReply{}
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	size := len(pairs) / 2
+	if incr && size > 1 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
 	elements := make([]*SortedSet.Element, size)
 	for i := 0; i < size; i++ {
-		scoreValue := args[2*i+1]
-		member := string(args[2*i+2])
-		score, err := strconv.ParseFloat(string(scoreValue), 64)
-		if err != nil {
-			return reply.MakeErrReply("ERR value is not a valid float")
+		score, errReply := parseScore(pairs[2*i])
+		if errReply != nil {
+			return errReply
 		}
 		elements[i] = &SortedSet.Element{
-			Member: member,
+			Member: string(pairs[2*i+1]),
 			Score:  score,
 		}
 	}
 
-	// lock
-	db.Lock(key)
-	defer db.UnLock(key)
-
-	// get or init entity
-	sortedSet, _, errReply := db.getOrInitSortedSet(key)
-	if errReply != nil {
-		return errReply
+	var sortedSet *SortedSet.SortedSet
+	var errReply reply.ErrorReply
+	if xx {
+		// XX never adds members, so don't create empty set
+		sortedSet, errReply = db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			if incr {
+				return &reply.NullBulkReply{}
+			}
+			return reply.MakeIntReply(0)
+		}
+	} else {
+		sortedSet, _, errReply = db.getOrInitSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
 	}
 
-	i := 0
+	var added, changed int64 = 0, 0
 	for _, e := range elements {
-		if sortedSet.Add(e.Member, e.Score) {
-			i++
+		score := e.Score
+		current, exists := sortedSet.Get(e.Member)
+		if exists {
+			if nx {
+				continue
+			}
+			if incr {
+				score += current.Score
+				if math.IsNaN(score) {
+					return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+				}
+			}
+			if (gt && score <= current.Score) || (lt && score >= current.Score) {
+				continue
+			}
+			if score != current.Score {
+				sortedSet.Add(e.Member, score)
+				changed++
+			}
+		} else {
+			if xx {
+				continue
+			}
+			sortedSet.Add(e.Member, score)
+			added++
+		}
+		if incr {
+			return reply.MakeBulkReply(formatScore(score))
 		}
 	}
-
-	db.AddAof(makeAofCmd("sdiffstore", args))
-
-	return reply.MakeIntReply(int64(i))
+	if incr {
+		// the element is not updated because of NX, XX, GT or LT
+		db.removeIfEmptySortedSet(key, sortedSet)
+		return &reply.NullBulkReply{}
+	}
+	if ch {
+		return reply.MakeIntReply(added + changed)
+	}
+	return reply.MakeIntReply(added)
 }
 
 func ZScore(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zscore' command")
-	}
 	key := string(args[0])
 	member := string(args[1])
 
@@ -99,15 +209,29 @@ func ZScore(db *DB, args [][]byte) redis.Reply {
 	if !exists {
 		return &reply.NullBulkReply{}
 	}
-	value := strconv.FormatFloat(element.Score, 'f', -1, 64)
-	return reply.MakeBulkReply([]byte(value))
+	return reply.MakeBulkReply(formatScore(element.Score))
+}
+
+// ZMSCORE key member [member ...]
+func ZMScore(db *DB, args [][]byte) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	scores := make([][]byte, len(args)-1)
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply(scores)
+	}
+	for i, member := range args[1:] {
+		if element, exists := sortedSet.Get(string(member)); exists {
+			scores[i] = formatScore(element.Score)
+		}
+	}
+	return reply.MakeMultiBulkReply(scores)
 }
 
 func ZRank(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zrank' command")
-	}
 	key := string(args[0])
 	member := string(args[1])
 
@@ -129,9 +253,6 @@ func ZRank(db *DB, args [][]byte) redis.Reply {
 
 func ZRevRank(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zrevrank' command")
-	}
 	key := string(args[0])
 	member := string(args[1])
 
@@ -153,9 +274,6 @@ func ZRevRank(db *DB, args [][]byte) redis.Reply {
 
 func ZCard(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zcard' command")
-	}
 	key := string(args[0])
 
 	// get entity
@@ -170,78 +288,121 @@ func ZCard(db *DB, args [][]byte) redis.Reply {
 	return reply.MakeIntReply(int64(sortedSet.Len()))
 }
 
-func ZRange(db *DB, args [][]byte) redis.Reply {
-	// parse args
-	if len(args) != 3 && len(args) != 4 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zrange' command")
-	}
-	withScores := false
-	if len(args) == 4 {
-		if strings.ToUpper(string(args[3])) != "WITHSCORES" {
-			return reply.MakeErrReply("syntax error")
-		} else {
-			withScores = true
-		}
-	}
-	key := string(args[0])
-	start, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	return range0(db, key, start, stop, withScores, false)
+/* ---- range ---- */
+
+const (
+	rangeByRank = iota
+	rangeByScore
+	rangeByLex
+)
+
+type zrangeOptions struct {
+	by         int
+	rev        bool
+	withScores bool
+	// rank of rangeByRank
+	start int64
+	stop  int64
+	// borders of rangeByScore and rangeByLex
+	min SortedSet.Border
+	max SortedSet.Border
+	// limit < 0 means no limit
+	offset int64
+	limit  int64
 }
 
-func ZRevRange(db *DB, args [][]byte) redis.Reply {
-	// parse args
-	if len(args) != 3 && len(args) != 4 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zrevrange' command")
-	}
-	withScores := false
-	if len(args) == 4 {
-		if string(args[3]) != "WITHSCORES" {
-			return reply.MakeErrReply("syntax error")
-		} else {
-			withScores = true
+// parseZRange parses `start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]`,
+// BYSCORE, BYLEX and REV are accepted by ZRANGE and ZRANGESTORE only, others are ranged by their names
+func parseZRange(args [][]byte, by int, rev bool, unified bool, store bool) (*zrangeOptions, redis.Reply) {
+	opts := &zrangeOptions{
+		by:    by,
+		rev:   rev,
+		limit: -1,
+	}
+	hasLimit := false
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "WITHSCORES" && !store:
+			opts.withScores = true
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			limit, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			opts.offset = offset
+			opts.limit = limit
+			hasLimit = true
+			i += 2
+		case option == "BYSCORE" && unified:
+			opts.by = rangeByScore
+		case option == "BYLEX" && unified:
+			opts.by = rangeByLex
+		case option == "REV" && unified:
+			opts.rev = true
+		default:
+			return nil, &reply.SyntaxErrReply{}
 		}
 	}
-	key := string(args[0])
-	start, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	if hasLimit && opts.by == rangeByRank {
+		return nil, reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
 	}
-	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
-	if err != nil {
-		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	if opts.withScores && opts.by == rangeByLex {
+		return nil, reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
 	}
-	return range0(db, key, start, stop, withScores, true)
-}
-
-func range0(db *DB, key string, start int64, stop int64, withScores bool, desc bool) redis.Reply {
-	// lock key
-	db.Locker.RLock(key)
-	defer db.Locker.RUnLock(key)
 
-	// get data
-	sortedSet, errReply := db.getAsSortedSet(key)
-	if errReply != nil {
-		return errReply
+	// borders are given from max to min in reversed order
+	minArg, maxArg := args[0], args[1]
+	if opts.rev && opts.by != rangeByRank {
+		minArg, maxArg = maxArg, minArg
 	}
-	if sortedSet == nil {
-		return &reply.EmptyMultiBulkReply{}
+	switch opts.by {
+	case rangeByRank:
+		start, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		stop, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		opts.start, opts.stop = start, stop
+	case rangeByScore:
+		min, err := SortedSet.ParseScoreBorder(string(minArg))
+		if err != nil {
+			return nil, reply.MakeErrReply(err.Error())
+		}
+		max, err := SortedSet.ParseScoreBorder(string(maxArg))
+		if err != nil {
+			return nil, reply.MakeErrReply(err.Error())
+		}
+		opts.min, opts.max = min, max
+	case rangeByLex:
+		min, err := SortedSet.ParseLexBorder(string(minArg))
+		if err != nil {
+			return nil, reply.MakeErrReply(err.Error())
+		}
+		max, err := SortedSet.ParseLexBorder(string(maxArg))
+		if err != nil {
+			return nil, reply.MakeErrReply(err.Error())
+		}
+		opts.min, opts.max = min, max
 	}
+	return opts, nil
+}
 
-	// compute index
-	size := sortedSet.Len() // assert: size > 0
+// normalizeRank converts start and stop rank which may be negative into [start, stop) for size, ok is false if empty
+func normalizeRank(start int64, stop int64, size int64) (int64, int64, bool) {
 	if start < -1*size {
 		start = 0
 	} else if start < 0 {
 		start = size + start
 	} else if start >= size {
-		return &reply.EmptyMultiBulkReply{}
+		return 0, 0, false
 	}
 	if stop < -1*size {
 		stop = 0
@@ -255,198 +416,160 @@ func range0(db *DB, key string, start int64, stop int64, withScores bool, desc b
 	if stop < start {
 		stop = start
 	}
-
-	// assert: start in [0, size - 1], stop in [start, size]
-	slice := sortedSet.Range(start, stop, desc)
-	if withScores {
-		result := make([][]byte, len(slice)*2)
-		i := 0
-		for _, element := range slice {
-			result[i] = []byte(element.Member)
-			i++
-			scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
-			result[i] = []byte(scoreStr)
-			i++
-		}
-		return reply.MakeMultiBulkReply(result)
-	} else {
-		result := make([][]byte, len(slice))
-		i := 0
-		for _, element := range slice {
-			result[i] = []byte(element.Member)
-			i++
-		}
-		return reply.MakeMultiBulkReply(result)
-	}
+	return start, stop, true
 }
 
-func ZCount(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zcount' command")
+func rangeElements(sortedSet *SortedSet.SortedSet, opts *zrangeOptions) []*SortedSet.Element {
+	if opts.by != rangeByRank {
+		return sortedSet.RangeByBorder(opts.min, opts.max, opts.offset, opts.limit, opts.rev)
 	}
-	key := string(args[0])
-
-	min, err := SortedSet.ParseScoreBorder(string(args[1]))
-	if err != nil {
-		return reply.MakeErrReply(err.Error())
+	start, stop, ok := normalizeRank(opts.start, opts.stop, sortedSet.Len())
+	if !ok {
+		return nil
 	}
+	// assert: start in [0, size - 1], stop in [start, size]
+	return sortedSet.Range(start, stop, opts.rev)
+}
 
-	max, err := SortedSet.ParseScoreBorder(string(args[2]))
-	if err != nil {
-		return reply.MakeErrReply(err.Error())
+func zrange(db *DB, args [][]byte, by int, rev bool, unified bool) redis.Reply {
+	opts, errReply := parseZRange(args[1:], by, rev, unified, false)
+	if errReply != nil {
+		return errReply
 	}
-
-	db.Locker.RLock(key)
-	defer db.Locker.RUnLock(key)
-
-	// get data
-	sortedSet, errReply := db.getAsSortedSet(key)
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
 	if errReply != nil {
 		return errReply
 	}
 	if sortedSet == nil {
-		return reply.MakeIntReply(0)
+		return &reply.EmptyMultiBulkReply{}
 	}
+	return elementsReply(rangeElements(sortedSet, opts), opts.withScores)
+}
 
-	return reply.MakeIntReply(sortedSet.Count(min, max))
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func ZRange(db *DB, args [][]byte) redis.Reply {
+	return zrange(db, args, rangeByRank, false, true)
 }
 
-/*
- * param limit: limit < 0 means no limit
- */
-func rangeByScore0(db *DB, key string, min *SortedSet.ScoreBorder, max *SortedSet.ScoreBorder, offset int64, limit int64, withScores bool, desc bool) redis.Reply {
-	// lock key
-	db.Locker.RLock(key)
-	defer db.Locker.RUnLock(key)
+// ZREVRANGE key start stop [WITHSCORES]
+func ZRevRange(db *DB, args [][]byte) redis.Reply {
+	return zrange(db, args, rangeByRank, true, false)
+}
 
-	// get data
-	sortedSet, errReply := db.getAsSortedSet(key)
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func ZRangeByScore(db *DB, args [][]byte) redis.Reply {
+	return zrange(db, args, rangeByScore, false, false)
+}
+
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func ZRevRangeByScore(db *DB, args [][]byte) redis.Reply {
+	return zrange(db, args, rangeByScore, true, false)
+}
+
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func ZRangeByLex(db *DB, args [][]byte) redis.Reply {
+	return zrange(db, args, rangeByLex, false, false)
+}
+
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func ZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
+	return zrange(db, args, rangeByLex, true, false)
+}
+
+// ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func ZRangeStore(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[0])
+	opts, errReply := parseZRange(args[2:], rangeByRank, false, true, true)
 	if errReply != nil {
 		return errReply
 	}
-	if sortedSet == nil {
-		return &reply.EmptyMultiBulkReply{}
+	sortedSet, errReply := db.getAsSortedSet(string(args[1]))
+	if errReply != nil {
+		return errReply
 	}
-
-	slice := sortedSet.RangeByScore(min, max, offset, limit, desc)
-	if withScores {
-		result := make([][]byte, len(slice)*2)
-		i := 0
-		for _, element := range slice {
-			result[i] = []byte(element.Member)
-			i++
-			scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
-			result[i] = []byte(scoreStr)
-			i++
-		}
-		return reply.MakeMultiBulkReply(result)
-	} else {
-		result := make([][]byte, len(slice))
-		i := 0
-		for _, element := range slice {
-			result[i] = []byte(element.Member)
-			i++
-		}
-		return reply.MakeMultiBulkReply(result)
+	var elements []*SortedSet.Element
+	if sortedSet != nil {
+		elements = rangeElements(sortedSet, opts)
+	}
+	result := SortedSet.Make()
+	for _, element := range elements {
+		result.Add(element.Member, element.Score)
 	}
+	return db.storeSortedSet(dest, result)
 }
 
-func ZRangeByScore(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zrangebyscore' command")
+// storeSortedSet overwrites dest with ttl removed, empty result removes dest
+func (db *DB) storeSortedSet(dest string, sortedSet *SortedSet.SortedSet) redis.Reply {
+	if sortedSet.Len() == 0 {
+		db.Remove(dest)
+		return reply.MakeIntReply(0)
 	}
+	db.Put(dest, &DataEntity{Data: sortedSet})
+	db.Persist(dest)
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+func ZCount(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 
-	min, err := SortedSet.ParseScoreBorder(string(args[2]))
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
 
-	max, err := SortedSet.ParseScoreBorder(string(args[1]))
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
 
-	withScores := false
-	var offset int64 = 0
-	var limit int64 = -1
-	if len(args) > 3 {
-		for i := 3; i < len(args); {
-			s := string(args[i])
-			if strings.ToUpper(s) == "WITHSCORES" {
-				withScores = true
-				i++
-			} else if strings.ToUpper(s) == "LIMIT" {
-				if len(args) < i+3 {
-					return reply.MakeErrReply("ERR syntax error")
-				}
-				offset, err = strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return reply.MakeErrReply("ERR value is not an integer or out of range")
-				}
-				limit, err = strconv.ParseInt(string(args[i+2]), 10, 64)
-				if err != nil {
-					return reply.MakeErrReply("ERR value is not an integer or out of range")
-				}
-				i += 3
-			} else {
-				return reply.MakeErrReply("ERR syntax error")
-			}
-		}
+	// get data
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
 	}
-	return rangeByScore0(db, key, min, max, offset, limit, withScores, false)
-}
-
-func ZRevRangeByScore(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zrangebyscore' command")
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
 	}
-	key := string(args[0])
 
-	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	return reply.MakeIntReply(sortedSet.Count(min, max))
+}
+
+// ZLEXCOUNT key min max
+func ZLexCount(db *DB, args [][]byte) redis.Reply {
+	min, err := SortedSet.ParseLexBorder(string(args[1]))
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
-
-	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	max, err := SortedSet.ParseLexBorder(string(args[2]))
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.CountByBorder(min, max))
+}
 
-	withScores := false
-	var offset int64 = 0
-	var limit int64 = -1
-	if len(args) > 3 {
-		for i := 3; i < len(args); {
-			s := string(args[i])
-			if strings.ToUpper(s) == "WITHSCORES" {
-				withScores = true
-				i++
-			} else if strings.ToUpper(s) == "LIMIT" {
-				if len(args) < i+3 {
-					return reply.MakeErrReply("ERR syntax error")
-				}
-				offset, err = strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return reply.MakeErrReply("ERR value is not an integer or out of range")
-				}
-				limit, err = strconv.ParseInt(string(args[i+2]), 10, 64)
-				if err != nil {
-					return reply.MakeErrReply("ERR value is not an integer or out of range")
-				}
-				i += 3
-			} else {
-				return reply.MakeErrReply("ERR syntax error")
-			}
-		}
+/* ---- remove ---- */
+
+func (db *DB) removeByBorder(key string, min SortedSet.Border, max SortedSet.Border) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
 	}
-	return rangeByScore0(db, key, min, max, offset, limit, withScores, true)
+	removed := sortedSet.RemoveByBorder(min, max)
+	db.removeIfEmptySortedSet(key, sortedSet)
+	return reply.MakeIntReply(removed)
 }
 
 func ZRemRangeByScore(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zremrangebyscore' command")
-	}
 	key := string(args[0])
 
 	min, err := SortedSet.ParseScoreBorder(string(args[1]))
@@ -458,30 +581,23 @@ func ZRemRangeByScore(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return reply.MakeErrReply(err.Error())
 	}
+	return db.removeByBorder(key, min, max)
+}
 
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
-	// get data
-	sortedSet, errReply := db.getAsSortedSet(key)
-	if errReply != nil {
-		return errReply
-	}
-	if sortedSet == nil {
-		return &reply.EmptyMultiBulkReply{}
+// ZREMRANGEBYLEX key min max
+func ZRemRangeByLex(db *DB, args [][]byte) redis.Reply {
+	min, err := SortedSet.ParseLexBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
 	}
-
-	removed := sortedSet.RemoveByScore(min, max)
-	if removed > 0 {
-		db.AddAof(makeAofCmd("zremrangebyscore", args))
+	max, err := SortedSet.ParseLexBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
 	}
-	return reply.MakeIntReply(removed)
+	return db.removeByBorder(string(args[0]), min, max)
 }
 
 func ZRemRangeByRank(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zremrangebyrank' command")
-	}
 	key := string(args[0])
 	start, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
@@ -492,9 +608,6 @@ func ZRemRangeByRank(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
 
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
 	// get data
 	sortedSet, errReply := db.getAsSortedSet(key)
 	if errReply != nil {
@@ -504,41 +617,18 @@ func ZRemRangeByRank(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeIntReply(0)
 	}
 
-	// compute index
-	size := sortedSet.Len() // assert: size > 0
-	if start < -1*size {
-		start = 0
-	} else if start < 0 {
-		start = size + start
-	} else if start >= size {
+	start, stop, ok := normalizeRank(start, stop, sortedSet.Len())
+	if !ok {
 		return reply.MakeIntReply(0)
 	}
-	if stop < -1*size {
-		stop = 0
-	} else if stop < 0 {
-		stop = size + stop + 1
-	} else if stop < size {
-		stop = stop + 1
-	} else {
-		stop = size
-	}
-	if stop < start {
-		stop = start
-	}
-
 	// assert: start in [0, size - 1], stop in [start, size]
 	removed := sortedSet.RemoveByRank(start, stop)
-	if removed > 0 {
-		db.AddAof(makeAofCmd("zremrangebyrank", args))
-	}
+	db.removeIfEmptySortedSet(key, sortedSet)
 	return reply.MakeIntReply(removed)
 }
 
 func ZRem(db *DB, args [][]byte) redis.Reply {
 	// parse args
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zrem' command")
-	}
 	key := string(args[0])
 	fields := make([]string, len(args)-1)
 	fieldArgs := args[1:]
@@ -546,9 +636,6 @@ func ZRem(db *DB, args [][]byte) redis.Reply {
 		fields[i] = string(v)
 	}
 
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	// get entity
 	sortedSet, errReply := db.getAsSortedSet(key)
 	if errReply != nil {
@@ -564,16 +651,11 @@ func ZRem(db *DB, args [][]byte) redis.Reply {
 			deleted++
 		}
 	}
-	if deleted > 0 {
-		db.AddAof(makeAofCmd("zrem", args))
-	}
+	db.removeIfEmptySortedSet(key, sortedSet)
 	return reply.MakeIntReply(deleted)
 }
 
 func ZIncrBy(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'zincrby' command")
-	}
 	key := string(args[0])
 	rawDelta := string(args[1])
 	field := string(args[2])
@@ -582,9 +664,6 @@ func ZIncrBy(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
 
-	db.Locker.Lock(key)
-	defer db.Locker.UnLock(key)
-
 	// get or init entity
 	sortedSet, _, errReply := db.getOrInitSortedSet(key)
 	if errReply != nil {
@@ -594,13 +673,341 @@ func ZIncrBy(db *DB, args [][]byte) redis.Reply {
 	element, exists := sortedSet.Get(field)
 	if !exists {
 		sortedSet.Add(field, delta)
-		db.AddAof(makeAofCmd("zincrby", args))
 		return reply.MakeBulkReply(args[1])
 	} else {
 		score := element.Score + delta
 		sortedSet.Add(field, score)
-		bytes := []byte(strconv.FormatFloat(score, 'f', -1, 64))
-		db.AddAof(makeAofCmd("zincrby", args))
-		return reply.MakeBulkReply(bytes)
+		return reply.MakeBulkReply(formatScore(score))
+	}
+}
+
+/* ---- pop ---- */
+
+func zpop(db *DB, args [][]byte, max bool) redis.Reply {
+	if len(args) > 2 {
+		return &reply.SyntaxErrReply{}
+	}
+	key := string(args[0])
+	var count int64 = 1
+	if len(args) == 2 {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil || count == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	var elements []*SortedSet.Element
+	if max {
+		elements = sortedSet.PopMax(count)
+	} else {
+		elements = sortedSet.PopMin(count)
+	}
+	db.removeIfEmptySortedSet(key, sortedSet)
+	return elementsReply(elements, true)
+}
+
+// ZPOPMIN key [count]
+func ZPopMin(db *DB, args [][]byte) redis.Reply {
+	return zpop(db, args, false)
+}
+
+// ZPOPMAX key [count]
+func ZPopMax(db *DB, args [][]byte) redis.Reply {
+	return zpop(db, args, true)
+}
+
+// bzpop pops from the first non-empty key, returns null array if all keys are empty and the client will be blocked
+func bzpop(db *DB, args [][]byte, max bool) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			continue
+		}
+		var elements []*SortedSet.Element
+		if max {
+			elements = sortedSet.PopMax(1)
+		} else {
+			elements = sortedSet.PopMin(1)
+		}
+		db.removeIfEmptySortedSet(key, sortedSet)
+		return reply.MakeMultiBulkReply([][]byte{arg, []byte(elements[0].Member), formatScore(elements[0].Score)})
+	}
+	return &reply.NullMultiBulkReply{}
+}
+
+// BZPOPMIN key [key ...] timeout
+func BZPopMin(db *DB, args [][]byte) redis.Reply {
+	return bzpop(db, args, false)
+}
+
+// BZPOPMAX key [key ...] timeout
+func BZPopMax(db *DB, args [][]byte) redis.Reply {
+	return bzpop(db, args, true)
+}
+
+// rewriteBZPop appends ZPOPMIN or ZPOPMAX of the key popped from
+func rewriteBZPop(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	popped, ok := result.(*reply.MultiBulkReply)
+	if !ok {
+		return nil
+	}
+	cmd := strings.TrimPrefix(strings.ToLower(string(args[0])), "b")
+	return []*reply.MultiBulkReply{makeAofCmd(cmd, popped.Args[:1])}
+}
+
+// ZRANDMEMBER key [count [WITHSCORES]]
+func ZRandMember(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 3 || (len(args) == 3 && strings.ToUpper(string(args[2])) != "WITHSCORES") {
+		return &reply.SyntaxErrReply{}
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if sortedSet == nil {
+			return &reply.NullBulkReply{}
+		}
+		element := sortedSet.GetByRank(rand.Int63n(sortedSet.Len()))
+		return reply.MakeBulkReply([]byte(element.Member))
+	}
+
+	count, errReply := parseRandomCount(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	withScores := len(args) == 3
+	if sortedSet == nil || count == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	size := sortedSet.Len()
+	var elements []*SortedSet.Element
+	if count < 0 {
+		// members may be repeated for negative count
+		elements = make([]*SortedSet.Element, -count)
+		for i := range elements {
+			elements[i] = sortedSet.GetByRank(rand.Int63n(size))
+		}
+	} else {
+		if int64(count) > size {
+			count = int(size)
+		}
+		elements = make([]*SortedSet.Element, count)
+		for i, rank := range rand.Perm(int(size))[:count] {
+			elements[i] = sortedSet.GetByRank(int64(rank))
+		}
+	}
+	return elementsReply(elements, withScores)
+}
+
+/* ---- union, intersection and difference ---- */
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// zsetStoreKeys returns dest and source keys of ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE: cmd dest numkeys key [key ...]
+func zsetStoreKeys(args [][]byte) []string {
+	keys := []string{string(args[1])}
+	numKeys, err := strconv.Atoi(string(args[2]))
+	if err != nil || numKeys < 0 || numKeys > len(args)-3 {
+		return keys
+	}
+	for _, arg := range args[3 : 3+numKeys] {
+		keys = append(keys, string(arg))
+	}
+	return keys
+}
+
+// parseZSetStore parses `numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]`
+func parseZSetStore(cmdName string, args [][]byte, withOptions bool) (keys []string, weights []float64, aggregate int, errReply redis.Reply) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return nil, nil, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, nil, 0, reply.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, 0, &reply.SyntaxErrReply{}
+	}
+	keys = make([]string, numKeys)
+	weights = make([]float64, numKeys)
+	for i := 0; i < numKeys; i++ {
+		keys[i] = string(args[i+1])
+		weights[i] = 1
+	}
+	aggregate = aggregateSum
+	for i := numKeys + 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if withOptions && option == "WEIGHTS" && i+numKeys < len(args) {
+			for j := 0; j < numKeys; j++ {
+				weight, err := strconv.ParseFloat(string(args[i+1+j]), 64)
+				if err != nil || math.IsNaN(weight) {
+					return nil, nil, 0, reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += numKeys
+		} else if withOptions && option == "AGGREGATE" && i+1 < len(args) {
+			switch strings.ToUpper(string(args[i+1])) {
+			case "SUM":
+				aggregate = aggregateSum
+			case "MIN":
+				aggregate = aggregateMin
+			case "MAX":
+				aggregate = aggregateMax
+			default:
+				return nil, nil, 0, &reply.SyntaxErrReply{}
+			}
+			i++
+		} else {
+			return nil, nil, 0, &reply.SyntaxErrReply{}
+		}
+	}
+	return keys, weights, aggregate, nil
+}
+
+// getZSetSource returns scores of members, members of set are taken with score 1 like redis
+func (db *DB) getZSetSource(key string) (map[string]float64, redis.Reply) {
+	entity, exists := db.Get(key)
+	if !exists {
+		return nil, nil
+	}
+	switch data := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		scores := make(map[string]float64, data.Len())
+		data.ForEach(0, data.Len(), false, func(element *SortedSet.Element) bool {
+			scores[element.Member] = element.Score
+			return true
+		})
+		return scores, nil
+	default:
+		set, errReply := db.getAsSet(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		scores := make(map[string]float64, set.Len())
+		set.ForEach(func(member string) bool {
+			scores[member] = 1
+			return true
+		})
+		return scores, nil
+	}
+}
+
+func weightScore(score float64, weight float64) float64 {
+	value := score * weight
+	// 0 * inf
+	if math.IsNaN(value) {
+		return 0
+	}
+	return value
+}
+
+func aggregateScore(aggregate int, current float64, score float64) float64 {
+	switch aggregate {
+	case aggregateMin:
+		return math.Min(current, score)
+	case aggregateMax:
+		return math.Max(current, score)
 	}
+	sum := current + score
+	// inf + -inf
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+func zsetStore(db *DB, cmdName string, args [][]byte, op string) redis.Reply {
+	dest := string(args[0])
+	keys, weights, aggregate, errReply := parseZSetStore(cmdName, args[1:], op != "diff")
+	if errReply != nil {
+		return errReply
+	}
+	sources := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		source, errReply := db.getZSetSource(key)
+		if errReply != nil {
+			return errReply
+		}
+		sources[i] = source
+	}
+
+	result := SortedSet.Make()
+	switch op {
+	case "union":
+		scores := make(map[string]float64)
+		for i, source := range sources {
+			for member, score := range source {
+				score = weightScore(score, weights[i])
+				if current, ok := scores[member]; ok {
+					score = aggregateScore(aggregate, current, score)
+				}
+				scores[member] = score
+			}
+		}
+		for member, score := range scores {
+			result.Add(member, score)
+		}
+	case "inter":
+	members:
+		for member, score := range sources[0] {
+			score = weightScore(score, weights[0])
+			for i, source := range sources[1:] {
+				other, ok := source[member]
+				if !ok {
+					continue members
+				}
+				score = aggregateScore(aggregate, score, weightScore(other, weights[i+1]))
+			}
+			result.Add(member, score)
+		}
+	case "diff":
+	diffMembers:
+		for member, score := range sources[0] {
+			for _, source := range sources[1:] {
+				if _, ok := source[member]; ok {
+					continue diffMembers
+				}
+			}
+			result.Add(member, score)
+		}
+	}
+	return db.storeSortedSet(dest, result)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZUnionStore(db *DB, args [][]byte) redis.Reply {
+	return zsetStore(db, "zunionstore", args, "union")
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZInterStore(db *DB, args [][]byte) redis.Reply {
+	return zsetStore(db, "zinterstore", args, "inter")
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func ZDiffStore(db *DB, args [][]byte) redis.Reply {
+	return zsetStore(db, "zdiffstore", args, "diff")
 }
@@ -2,9 +2,12 @@ package db
 
 import (
 	"math/rand"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
 	"my-godis/src/redis/reply/asserts"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestZAdd(t *testing.T) {
@@ -296,3 +299,187 @@ func TestZIncrBy(t *testing.T) {
 	result = ZScore(testDB, toArgs(key, "a"))
 	asserts.AssertBulkReply(t, result, "20")
 }
+
+func TestZAddOptions(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	asserts.AssertIntReply(t, ZAdd(testDB, toArgs("z", "1", "a", "2", "b")), 2)
+	asserts.AssertIntReply(t, ZAdd(testDB, toArgs("z", "NX", "5", "a", "3", "c")), 1)
+	asserts.AssertBulkReply(t, ZScore(testDB, toArgs("z", "a")), "1")
+	asserts.AssertIntReply(t, ZAdd(testDB, toArgs("z", "XX", "CH", "5", "a", "4", "d")), 1)
+	asserts.AssertBulkReply(t, ZScore(testDB, toArgs("z", "a")), "5")
+	asserts.AssertNullBulk(t, ZScore(testDB, toArgs("z", "d")))
+	asserts.AssertIntReply(t, ZAdd(testDB, toArgs("z", "GT", "CH", "1", "a", "10", "b")), 1)
+	asserts.AssertBulkReply(t, ZScore(testDB, toArgs("z", "a")), "5")
+	asserts.AssertBulkReply(t, ZScore(testDB, toArgs("z", "b")), "10")
+	asserts.AssertIntReply(t, ZAdd(testDB, toArgs("z", "LT", "CH", "1", "a", "20", "b")), 1)
+	asserts.AssertBulkReply(t, ZScore(testDB, toArgs("z", "a")), "1")
+	// updated members are ordered by their new scores
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "0", "-1")), []string{"a", "c", "b"})
+
+	asserts.AssertBulkReply(t, ZAdd(testDB, toArgs("z", "INCR", "2.5", "a")), "3.5")
+	asserts.AssertNullBulk(t, ZAdd(testDB, toArgs("z", "NX", "INCR", "1", "a")))
+	asserts.AssertNullBulk(t, ZAdd(testDB, toArgs("z", "GT", "INCR", "-1", "a")))
+	asserts.AssertBulkReply(t, ZAdd(testDB, toArgs("z", "INCR", "+inf", "a")), "inf")
+	asserts.AssertErrReply(t, ZAdd(testDB, toArgs("z", "INCR", "-inf", "a")), "ERR resulting score is not a number (NaN)")
+
+	// XX doesn't create key
+	asserts.AssertIntReply(t, ZAdd(testDB, toArgs("none", "XX", "1", "a")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("none")), 0)
+
+	asserts.AssertErrReply(t, ZAdd(testDB, toArgs("z", "NX", "XX", "1", "a")), "ERR XX and NX options at the same time are not compatible")
+	asserts.AssertErrReply(t, ZAdd(testDB, toArgs("z", "GT", "LT", "1", "a")), "ERR GT, LT, and/or NX options at the same time are not compatible")
+	asserts.AssertErrReply(t, ZAdd(testDB, toArgs("z", "INCR", "1", "a", "2", "b")), "ERR INCR option supports a single increment-element pair")
+	asserts.AssertErrReply(t, ZAdd(testDB, toArgs("z", "1", "a", "2")), "Err syntax error")
+	asserts.AssertErrReply(t, ZAdd(testDB, toArgs("z", "nan", "a")), "ERR value is not a valid float")
+}
+
+func TestZRangeOptions(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("z", "1", "a", "2", "b", "3", "c", "4", "d"))
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "0", "1", "REV", "WITHSCORES")), []string{"d", "4", "c", "3"})
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "(1", "3", "BYSCORE")), []string{"b", "c"})
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2")), []string{"c", "b"})
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "-inf", "+inf", "BYSCORE", "LIMIT", "3", "-1")), []string{"d"})
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "-inf", "+inf", "BYSCORE", "LIMIT", "10", "1")), []string{})
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "[b", "(d", "BYLEX")), []string{"b", "c"})
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "+", "-", "BYLEX", "REV", "LIMIT", "0", "1")), []string{"d"})
+	asserts.AssertMultiBulkReply(t, ZRevRange(testDB, toArgs("z", "0", "0")), []string{"d"})
+
+	asserts.AssertErrReply(t, ZRange(testDB, toArgs("z", "0", "1", "LIMIT", "0", "1")),
+		"ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	asserts.AssertErrReply(t, ZRange(testDB, toArgs("z", "-", "+", "BYLEX", "WITHSCORES")),
+		"ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	asserts.AssertErrReply(t, ZRange(testDB, toArgs("z", "a", "c", "BYLEX")), "ERR min or max not valid string range item")
+	asserts.AssertErrReply(t, ZRange(testDB, toArgs("z", "a", "1", "BYSCORE")), "ERR min or max is not a float")
+	asserts.AssertErrReply(t, ZRevRange(testDB, toArgs("z", "0", "1", "REV")), "Err syntax error")
+}
+
+func TestZLex(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("z", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"))
+	asserts.AssertMultiBulkReply(t, ZRangeByLex(testDB, toArgs("z", "-", "[c")), []string{"a", "b", "c"})
+	asserts.AssertMultiBulkReply(t, ZRangeByLex(testDB, toArgs("z", "(b", "+", "LIMIT", "1", "2")), []string{"d", "e"})
+	asserts.AssertMultiBulkReply(t, ZRevRangeByLex(testDB, toArgs("z", "[c", "-")), []string{"c", "b", "a"})
+	asserts.AssertMultiBulkReply(t, ZRangeByLex(testDB, toArgs("z", "[d", "[b")), []string{})
+	asserts.AssertIntReply(t, ZLexCount(testDB, toArgs("z", "-", "+")), 5)
+	asserts.AssertIntReply(t, ZLexCount(testDB, toArgs("z", "(a", "[c")), 2)
+	asserts.AssertIntReply(t, ZLexCount(testDB, toArgs("none", "-", "+")), 0)
+	asserts.AssertIntReply(t, ZRemRangeByLex(testDB, toArgs("z", "[b", "(d")), 2)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("z", "0", "-1")), []string{"a", "d", "e"})
+
+	// empty sortedset is removed
+	asserts.AssertIntReply(t, ZRemRangeByLex(testDB, toArgs("z", "-", "+")), 3)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("z")), 0)
+}
+
+func TestZRangeStore(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("src", "1", "a", "2", "b", "3", "c"))
+	asserts.AssertIntReply(t, ZRangeStore(testDB, toArgs("dest", "src", "2", "+inf", "BYSCORE")), 2)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("dest", "0", "-1", "WITHSCORES")), []string{"b", "2", "c", "3"})
+	asserts.AssertIntReply(t, ZRangeStore(testDB, toArgs("dest", "src", "0", "0", "REV")), 1)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("dest", "0", "-1")), []string{"c"})
+	asserts.AssertIntReply(t, ZRangeStore(testDB, toArgs("dest", "none", "0", "-1")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("dest")), 0)
+	asserts.AssertErrReply(t, ZRangeStore(testDB, toArgs("dest", "src", "0", "-1", "WITHSCORES")), "Err syntax error")
+}
+
+func TestZPop(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("z", "1", "a", "2", "b", "3", "c"))
+	asserts.AssertMultiBulkReply(t, ZPopMin(testDB, toArgs("z")), []string{"a", "1"})
+	asserts.AssertMultiBulkReply(t, ZPopMax(testDB, toArgs("z", "5")), []string{"c", "3", "b", "2"})
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("z")), 0)
+	assertRawReply(t, ZPopMin(testDB, toArgs("z")), "*0\r\n")
+	asserts.AssertErrReply(t, ZPopMin(testDB, toArgs("z", "-1")), "ERR value is out of range, must be positive")
+}
+
+func TestBZPop(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("z2", "1", "a", "2", "b"))
+	asserts.AssertMultiBulkReply(t, testDB.Exec(nil, toArgs("bzpopmax", "z1", "z2", "0")), []string{"z2", "b", "2"})
+
+	// timeout
+	result := testDB.Exec(nil, toArgs("bzpopmin", "z1", "0.01"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected null array, actually %q", result.ToBytes())
+	}
+
+	// blocked until another client writes
+	done := make(chan redis.Reply)
+	go func() {
+		done <- testDB.Exec(nil, toArgs("bzpopmin", "z1", "z3", "5"))
+	}()
+	for testDB.BlockedClients() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	testDB.Exec(nil, toArgs("zadd", "z3", "3", "c"))
+	asserts.AssertMultiBulkReply(t, <-done, []string{"z3", "c", "3"})
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("z3")), 0)
+
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("bzpopmin", "z1", "-1")), "ERR timeout is negative")
+	asserts.AssertErrReply(t, testDB.Exec(nil, toArgs("bzpopmin", "z1", "x")), "ERR timeout is not a float or out of range")
+}
+
+func TestZMScore(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("z", "1", "a", "2.5", "b"))
+	result := ZMScore(testDB, toArgs("z", "a", "none", "b"))
+	if string(result.ToBytes()) != "*3\r\n$1\r\n1\r\n$-1\r\n$3\r\n2.5\r\n" {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+}
+
+func TestZRandMember(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("z", "1", "a", "2", "b", "3", "c"))
+	if result, ok := ZRandMember(testDB, toArgs("z")).(*reply.BulkReply); !ok || len(result.Arg) != 1 {
+		t.Error("expected a member")
+	}
+	asserts.AssertNullBulk(t, ZRandMember(testDB, toArgs("none")))
+	result := ZRandMember(testDB, toArgs("z", "5")).(*reply.MultiBulkReply)
+	if len(result.Args) != 3 {
+		t.Errorf("expected 3 distinct members, actually %d", len(result.Args))
+	}
+	result = ZRandMember(testDB, toArgs("z", "-5", "WITHSCORES")).(*reply.MultiBulkReply)
+	if len(result.Args) != 10 {
+		t.Errorf("expected 5 members with scores, actually %d", len(result.Args)/2)
+	}
+	assertRawReply(t, ZRandMember(testDB, toArgs("none", "3")), "*0\r\n")
+	asserts.AssertErrReply(t, ZRandMember(testDB, toArgs("z", "-2000000000")), "ERR value is out of range")
+}
+
+func TestZSetStore(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	ZAdd(testDB, toArgs("z1", "1", "a", "2", "b"))
+	ZAdd(testDB, toArgs("z2", "1", "a", "2", "b", "3", "c"))
+	SAdd(testDB, toArgs("set", "a", "d"))
+
+	asserts.AssertIntReply(t, ZUnionStore(testDB, toArgs("out", "2", "z1", "z2", "WEIGHTS", "2", "3")), 3)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("out", "0", "-1", "WITHSCORES")), []string{"a", "5", "c", "9", "b", "10"})
+	asserts.AssertIntReply(t, ZUnionStore(testDB, toArgs("out", "2", "z2", "set", "AGGREGATE", "MAX")), 4)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("out", "0", "-1", "WITHSCORES")), []string{"a", "1", "d", "1", "b", "2", "c", "3"})
+
+	asserts.AssertIntReply(t, ZInterStore(testDB, toArgs("out", "2", "z1", "z2")), 2)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("out", "0", "-1", "WITHSCORES")), []string{"a", "2", "b", "4"})
+	asserts.AssertIntReply(t, ZInterStore(testDB, toArgs("out", "3", "z1", "z2", "set", "AGGREGATE", "MIN")), 1)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("out", "0", "-1", "WITHSCORES")), []string{"a", "1"})
+
+	asserts.AssertIntReply(t, ZDiffStore(testDB, toArgs("out", "2", "z2", "z1")), 1)
+	asserts.AssertMultiBulkReply(t, ZRange(testDB, toArgs("out", "0", "-1", "WITHSCORES")), []string{"c", "3"})
+
+	// empty result removes dest
+	asserts.AssertIntReply(t, ZInterStore(testDB, toArgs("out", "2", "z1", "none")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("out")), 0)
+
+	asserts.AssertErrReply(t, ZUnionStore(testDB, toArgs("out", "0", "z1")), "ERR at least 1 input key is needed for 'zunionstore' command")
+	asserts.AssertErrReply(t, ZUnionStore(testDB, toArgs("out", "3", "z1", "z2")), "Err syntax error")
+	asserts.AssertErrReply(t, ZUnionStore(testDB, toArgs("out", "2", "z1", "z2", "WEIGHTS", "1", "x")), "ERR weight value is not a float")
+	asserts.AssertErrReply(t, ZUnionStore(testDB, toArgs("out", "1", "z1", "AGGREGATE", "AVG")), "Err syntax error")
+	asserts.AssertErrReply(t, ZDiffStore(testDB, toArgs("out", "1", "z1", "WEIGHTS", "1")), "Err syntax error")
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, ZUnionStore(testDB, toArgs("out", "2", "z1", "list")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+	if keys := zsetStoreKeys(toArgs("zunionstore", "out", "2", "z1", "z2", "WEIGHTS", "1", "2")); len(keys) != 3 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
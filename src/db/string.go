@@ -1,6 +1,7 @@
 package db
 
 import (
+	"math"
 	"my-godis/src/datastruct/decimal"
 	"my-godis/src/interface/redis"
 	"my-godis/src/redis/reply"
@@ -22,9 +23,6 @@ func (db *DB) getAsString(key string) ([]byte, reply.ErrorReply) {
 }
 
 func Get(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'get' command")
-	}
 	key := string(args[0])
 	bytes, err := db.getAsString(key)
 	if err != nil {
@@ -44,66 +42,96 @@ const (
 
 const unlimitedTTL int64 = 0
 
-// SET key value [EX seconds] [PX milliseconds] [NX|XX]
-func Set(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'set' command")
+// max length of string value, same as proto-max-bulk-len of redis
+const maxStringLength = 512 * 1024 * 1024
+
+// parseExpireOption parses the argument of EX, PX, EXAT or PXAT into absolute expire time
+func parseExpireOption(cmdName string, option string, arg []byte) (time.Time, redis.Reply) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if n <= 0 || ((option == "EX" || option == "EXAT") && n > math.MaxInt64/1000) {
+		return time.Time{}, reply.MakeErrReply("ERR invalid expire time in " + cmdName)
+	}
+	switch option {
+	case "EX":
+		return time.Now().Add(time.Duration(n) * time.Second), nil
+	case "PX":
+		return time.Now().Add(time.Duration(n) * time.Millisecond), nil
+	case "EXAT":
+		return time.Unix(n, 0), nil
+	default: // PXAT
+		return time.UnixMilli(n), nil
+	}
+}
+
+type setOptions struct {
+	policy     int
+	get        bool
+	keepTTL    bool
+	expireTime time.Time // zero value means no ttl
+}
+
+// parseSetOptions parses [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|KEEPTTL]
+func parseSetOptions(args [][]byte) (*setOptions, redis.Reply) {
+	opts := &setOptions{policy: upsertPolicy}
+	hasTTL := false
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "NX": // insert
+			if opts.policy == updatePolicy {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			opts.policy = insertPolicy
+		case "XX": // update policy
+			if opts.policy == insertPolicy {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			opts.policy = updatePolicy
+		case "GET":
+			opts.get = true
+		case "KEEPTTL":
+			if hasTTL {
+				return nil, &reply.SyntaxErrReply{}
+			}
+			hasTTL = true
+			opts.keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasTTL || i+1 >= len(args) {
+				// ttl has been set
+				return nil, &reply.SyntaxErrReply{}
+			}
+			hasTTL = true
+			expireTime, errReply := parseExpireOption("set", arg, args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.expireTime = expireTime
+			i++ // skip next arg
+		default:
+			return nil, &reply.SyntaxErrReply{}
+		}
 	}
+	return opts, nil
+}
+
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|KEEPTTL]
+func Set(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
-	policy := upsertPolicy
-	ttl := unlimitedTTL
-
-	// parse options
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToUpper(string(args[i]))
-			if arg == "NX" { // insert
-				if policy == updatePolicy {
-					return &reply.SyntaxErrReply{}
-				}
-				policy = insertPolicy
-			} else if arg == "XX" { // update policy
-				if policy == insertPolicy {
-					return &reply.SyntaxErrReply{}
-				}
-				policy = updatePolicy
-			} else if arg == "EX" { // ttl in seconds
-				if ttl != unlimitedTTL {
-					// ttl has been set
-					return &reply.SyntaxErrReply{}
-				}
-				if i+1 >= len(args) {
-					return &reply.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &reply.SyntaxErrReply{}
-				}
-				if ttlArg <= 0 {
-					return reply.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg * 1000
-				i++ // skip next arg
-			} else if arg == "PX" { // ttl in milliseconds
-				if ttl != unlimitedTTL {
-					return &reply.SyntaxErrReply{}
-				}
-				if i+1 >= len(args) {
-					return &reply.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &reply.SyntaxErrReply{}
-				}
-				if ttlArg <= 0 {
-					return reply.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg
-				i++ // skip next arg
-			} else {
-				return &reply.SyntaxErrReply{}
-			}
+	opts, errReply := parseSetOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	var old []byte
+	if opts.get {
+		var err reply.ErrorReply
+		old, err = db.getAsString(key)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -111,9 +139,8 @@ func Set(db *DB, args [][]byte) redis.Reply {
 		Data: value,
 	}
 
-	db.Persist(key) // clean ttl
 	var result int
-	switch policy {
+	switch opts.policy {
 	case upsertPolicy:
 		result = db.Put(key, entity)
 	case insertPolicy:
@@ -121,48 +148,58 @@ func Set(db *DB, args [][]byte) redis.Reply {
 	case updatePolicy:
 		result = db.PutIfExists(key, entity)
 	}
-	if result > 0 {
-		if ttl != unlimitedTTL {
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.Expire(key, expireTime)
-			db.AddAof(reply.MakeMultiBulkReply([][]byte{
-				[]byte("SET"),
-				args[0],
-				args[1],
-			}))
-			db.AddAof(makeExpireCmd(key, expireTime))
-		} else {
+	if opts.policy == upsertPolicy || result > 0 {
+		if !opts.expireTime.IsZero() {
+			db.Expire(key, opts.expireTime)
+		} else if !opts.keepTTL {
 			db.Persist(key) // override ttl
-			db.AddAof(makeAofCmd("set", args))
 		}
 	}
-	if policy == upsertPolicy || result > 0 {
-		return &reply.OkReply{}
-	} else {
+
+	if opts.get {
+		if old == nil {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(old)
+	}
+	if opts.policy != upsertPolicy && result == 0 {
 		return &reply.NullBulkReply{}
 	}
+	return &reply.OkReply{}
 }
 
-func SetNX(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'setnx' command")
+// SET replies the old value with GET option, so whether the key is set depends on policy
+func rewriteSet(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	opts, errReply := parseSetOptions(args[3:])
+	if errReply != nil {
+		return nil
+	}
+	_, isNull := result.(*reply.NullBulkReply)
+	changed := true
+	if !opts.get {
+		changed = !isNull
+	} else if opts.policy == insertPolicy {
+		changed = isNull
+	} else if opts.policy == updatePolicy {
+		changed = !isNull
+	}
+	if !changed {
+		return nil
 	}
+	return rewriteAsSet(db, args, result)
+}
+
+func SetNX(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
 	entity := &DataEntity{
 		Data: value,
 	}
 	result := db.PutIfAbsent(key, entity)
-	if result > 0 {
-		db.AddAof(makeAofCmd("setnx", args))
-	}
 	return reply.MakeIntReply(int64(result))
 }
 
 func SetEX(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'setex' command")
-	}
 	key := string(args[0])
 	value := args[2]
 
@@ -179,25 +216,15 @@ func SetEX(db *DB, args [][]byte) redis.Reply {
 		Data: value,
 	}
 
-	db.Lock(key)
-	defer db.UnLock(key)
-
-	result := db.Put(key, entity)
+	db.Put(key, entity)
 	expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
 	db.Expire(key, expireTime)
-	if result > 0 {
-		db.AddAof(makeAofCmd("setex", args))
-		db.AddAof(makeExpireCmd(key, expireTime))
-	}
 	return &reply.OkReply{}
 }
 
 func PSetEX(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 3 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'psetex' command")
-	}
 	key := string(args[0])
-	value := args[1]
+	value := args[2]
 
 	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
@@ -210,15 +237,9 @@ func PSetEX(db *DB, args [][]byte) redis.Reply {
 	entity := &DataEntity{
 		Data: value,
 	}
-	result := db.PutIfExists(key, entity)
-	if result > 0 {
-		db.AddAof(makeAofCmd("psetex", args))
-		if ttl != unlimitedTTL {
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.Expire(key, expireTime)
-			db.AddAof(makeExpireCmd(key, expireTime))
-		}
-	}
+	db.Put(key, entity)
+	expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	db.Expire(key, expireTime)
 	return &reply.OkReply{}
 }
 
@@ -235,21 +256,14 @@ func MSet(db *DB, args [][]byte) redis.Reply {
 		values[i] = args[2*i+1]
 	}
 
-	db.Locks(keys...)
-	defer db.UnLocks(keys...)
-
 	for i, key := range keys {
 		value := values[i]
 		db.Put(key, &DataEntity{Data: value})
 	}
-	db.AddAof(makeAofCmd("mset", args))
 	return &reply.OkReply{}
 }
 
 func MGet(db *DB, args [][]byte) redis.Reply {
-	if len(args) == 0 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'mget' command")
-	}
 	keys := make([]string, len(args))
 	for i, v := range args {
 		keys[i] = string(v)
@@ -286,10 +300,6 @@ func MSetNX(db *DB, args [][]byte) redis.Reply {
 		values[i] = args[2*i+1]
 	}
 
-	// lock keys
-	db.Locks(keys...)
-	defer db.UnLocks(keys...)
-
 	for _, key := range keys {
 		_, exists := db.Get(key)
 		if exists {
@@ -301,14 +311,10 @@ func MSetNX(db *DB, args [][]byte) redis.Reply {
 		value := values[i]
 		db.Put(key, &DataEntity{Data: value})
 	}
-	db.AddAof(makeAofCmd("msetnx", args))
 	return reply.MakeIntReply(1)
 }
 
 func GetSet(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'getset' command")
-	}
 	key := string(args[0])
 	value := args[1]
 
@@ -319,84 +325,47 @@ func GetSet(db *DB, args [][]byte) redis.Reply {
 
 	db.Put(key, &DataEntity{Data: value})
 	db.Persist(key) // override ttl
-	db.AddAof(makeAofCmd("getset", args))
 
 	return reply.MakeBulkReply(old)
 }
 
-func Incr(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'incr' command")
-	}
-	key := string(args[0])
-
-	db.Lock(key)
-	defer db.UnLock(key)
-
-	bytes, err := db.getAsString(key)
-	if err != nil {
-		return err
+// incrBy adds delta to the integer stored at key, missing key is taken as 0
+func (db *DB) incrBy(key string, delta int64) redis.Reply {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
 	}
+	var val int64
 	if bytes != nil {
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
+		var err error
+		val, err = strconv.ParseInt(string(bytes), 10, 64)
 		if err != nil {
 			return reply.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		db.Put(key, &DataEntity{
-			Data: []byte(strconv.FormatInt(val+1, 10)),
-		})
-		db.AddAof(makeAofCmd("incr", args))
-		return reply.MakeIntReply(val + 1)
-	} else {
-		db.Put(key, &DataEntity{
-			Data: []byte("1"),
-		})
-		db.AddAof(makeAofCmd("incr", args))
-		return reply.MakeIntReply(1)
 	}
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return reply.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+	db.Put(key, &DataEntity{
+		Data: []byte(strconv.FormatInt(val, 10)),
+	})
+	return reply.MakeIntReply(val)
+}
+
+func Incr(db *DB, args [][]byte) redis.Reply {
+	return db.incrBy(string(args[0]), 1)
 }
 
 func IncrBy(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'incrby' command")
-	}
-	key := string(args[0])
-	rawDelta := string(args[1])
-	delta, err := strconv.ParseInt(rawDelta, 10, 64)
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
-
-	db.Lock(key)
-	defer db.UnLock(key)
-
-	bytes, errReply := db.getAsString(key)
-	if errReply != nil {
-		return errReply
-	}
-	if bytes != nil {
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
-		if err != nil {
-			return reply.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.Put(key, &DataEntity{
-			Data: []byte(strconv.FormatInt(val+delta, 10)),
-		})
-		db.AddAof(makeAofCmd("incrby", args))
-		return reply.MakeIntReply(val + delta)
-	} else {
-		db.Put(key, &DataEntity{
-			Data: args[1],
-		})
-		db.AddAof(makeAofCmd("incrby", args))
-		return reply.MakeIntReply(delta)
-	}
+	return db.incrBy(string(args[0]), delta)
 }
 
 func IncrByFloat(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'incrbyfloat' command")
-	}
 	key := string(args[0])
 	rawDelta := string(args[1])
 	delta, err := decimal.NewFromString(rawDelta)
@@ -404,9 +373,6 @@ func IncrByFloat(db *DB, args [][]byte) redis.Reply {
 		return reply.MakeErrReply("ERR value is not a valid float")
 	}
 
-	db.Lock(key)
-	defer db.UnLock(key)
-
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
@@ -420,84 +386,200 @@ func IncrByFloat(db *DB, args [][]byte) redis.Reply {
 		db.Put(key, &DataEntity{
 			Data: resultBytes,
 		})
-		db.AddAof(makeAofCmd("incrbyfloat", args))
 		return reply.MakeBulkReply(resultBytes)
 	} else {
 		db.Put(key, &DataEntity{
 			Data: args[1],
 		})
-		db.AddAof(makeAofCmd("incrbyfloat", args))
 		return reply.MakeBulkReply(args[1])
 	}
 }
 
 func Decr(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 1 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'decr' command")
-	}
-	key := string(args[0])
+	return db.incrBy(string(args[0]), -1)
+}
 
-	db.Lock(key)
-	defer db.UnLock(key)
+func DecrBy(db *DB, args [][]byte) redis.Reply {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if delta == math.MinInt64 {
+		return reply.MakeErrReply("ERR decrement would overflow")
+	}
+	return db.incrBy(string(args[0]), -delta)
+}
 
-	bytes, errReply := db.getAsString(key)
-	if errReply != nil {
-		return errReply
+// GETDEL key
+func GetDel(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
 	}
-	if bytes != nil {
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
-		if err != nil {
-			return reply.MakeErrReply("ERR value is not an integer or out of range")
+	if bytes == nil {
+		return &reply.NullBulkReply{}
+	}
+	db.Remove(key)
+	return reply.MakeBulkReply(bytes)
+}
+
+// GETEX key [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|PERSIST]
+func GetEx(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	var expireTime time.Time
+	persist := false
+	switch len(args) {
+	case 1:
+	case 2:
+		if strings.ToUpper(string(args[1])) != "PERSIST" {
+			return &reply.SyntaxErrReply{}
 		}
-		db.Put(key, &DataEntity{
-			Data: []byte(strconv.FormatInt(val-1, 10)),
-		})
-		db.AddAof(makeAofCmd("decr", args))
-		return reply.MakeIntReply(val - 1)
-	} else {
-		entity := &DataEntity{
-			Data: []byte("-1"),
+		persist = true
+	case 3:
+		option := strings.ToUpper(string(args[1]))
+		if option != "EX" && option != "PX" && option != "EXAT" && option != "PXAT" {
+			return &reply.SyntaxErrReply{}
+		}
+		var errReply redis.Reply
+		expireTime, errReply = parseExpireOption("getex", option, args[2])
+		if errReply != nil {
+			return errReply
 		}
-		db.Put(key, entity)
-		db.AddAof(makeAofCmd("decr", args))
-		return reply.MakeIntReply(-1)
+	default:
+		return &reply.SyntaxErrReply{}
+	}
+
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
 	}
+	if bytes == nil {
+		return &reply.NullBulkReply{}
+	}
+	if persist {
+		db.Persist(key)
+	} else if !expireTime.IsZero() {
+		db.Expire(key, expireTime)
+	}
+	return reply.MakeBulkReply(bytes)
 }
 
-func DecrBy(db *DB, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return reply.MakeErrReply("ERR wrong number of arguments for 'decrby' command")
+// GETEX is appended as PEXPIREAT or PERSIST, or DEL if the key is expired already
+func rewriteGetEx(db *DB, args [][]byte, result redis.Reply) []*reply.MultiBulkReply {
+	if _, ok := result.(*reply.NullBulkReply); ok || len(args) == 2 {
+		return nil
 	}
+	key := string(args[1])
+	if _, exists := db.Get(key); !exists {
+		return []*reply.MultiBulkReply{makeAofCmd("del", args[1:2])}
+	}
+	if len(args) == 3 {
+		return []*reply.MultiBulkReply{makeAofCmd("persist", args[1:2])}
+	}
+	return makeTTLCmds(db, key, nil)
+}
+
+// APPEND key value
+func Append(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	rawDelta := string(args[1])
-	delta, err := strconv.ParseInt(rawDelta, 10, 64)
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	if len(bytes)+len(args[1]) > maxStringLength {
+		return reply.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	// always make a new slice, the old one may be shared by replies or args
+	value := make([]byte, len(bytes)+len(args[1]))
+	copy(value, bytes)
+	copy(value[len(bytes):], args[1])
+	db.Put(key, &DataEntity{Data: value})
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// STRLEN key
+func StrLen(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	return reply.MakeIntReply(int64(len(bytes)))
+}
+
+// GETRANGE key start end
+func GetRange(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	end, err := strconv.ParseInt(string(args[2]), 10, 64)
 	if err != nil {
 		return reply.MakeErrReply("ERR value is not an integer or out of range")
 	}
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
 
-	db.Lock(key)
-	defer db.UnLock(key)
+	size := int64(len(bytes))
+	if start < 0 && end < 0 && start > end {
+		return reply.MakeBulkReply([]byte{})
+	}
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return reply.MakeBulkReply([]byte{})
+	}
+	return reply.MakeBulkReply(bytes[start : end+1])
+}
 
+// SETRANGE key offset value
+func SetRange(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return reply.MakeErrReply("ERR offset is out of range")
+	}
+	patch := args[2]
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
 	}
-	if bytes != nil {
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
-		if err != nil {
-			return reply.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.Put(key, &DataEntity{
-			Data: []byte(strconv.FormatInt(val-delta, 10)),
-		})
-		db.AddAof(makeAofCmd("decrby", args))
-		return reply.MakeIntReply(val - delta)
-	} else {
-		valueStr := strconv.FormatInt(-delta, 10)
-		db.Put(key, &DataEntity{
-			Data: []byte(valueStr),
-		})
-		db.AddAof(makeAofCmd("decrby", args))
-		return reply.MakeIntReply(-delta)
+	if len(patch) == 0 {
+		// nothing to write, missing key is not created
+		return reply.MakeIntReply(int64(len(bytes)))
+	}
+	if offset+int64(len(patch)) > maxStringLength {
+		return reply.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
 	}
+
+	size := int64(len(bytes))
+	if offset+int64(len(patch)) > size {
+		size = offset + int64(len(patch))
+	}
+	// the gap between old value and offset is padded with zero bytes
+	value := make([]byte, size)
+	copy(value, bytes)
+	copy(value[offset:], patch)
+	db.Put(key, &DataEntity{Data: value})
+	return reply.MakeIntReply(size)
 }
@@ -2,10 +2,14 @@ package db
 
 import (
 	"math/rand"
+	"my-godis/src/config"
 	"my-godis/src/datastruct/utils"
 	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 var testDB = makeTestDB()
@@ -150,3 +154,196 @@ func TestIncr(t *testing.T) {
 		}
 	}
 }
+
+// assertTTL allows ttl truncated by elapsed time
+func assertTTL(t *testing.T, db *DB, key string, expected int64) {
+	t.Helper()
+	ttl, ok := TTL(db, toArgs(key)).(*reply.IntReply)
+	if !ok || ttl.Code > expected || ttl.Code < expected-1 {
+		t.Errorf("expected ttl of %s about %d, actually %v", key, expected, ttl)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+
+	// get returns old value
+	asserts.AssertNullBulk(t, Set(testDB, toArgs("k", "a", "GET")))
+	asserts.AssertBulkReply(t, Set(testDB, toArgs("k", "b", "GET")), "a")
+	asserts.AssertBulkReply(t, Set(testDB, toArgs("k", "c", "NX", "GET")), "b")
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("k")), "b")
+	asserts.AssertNullBulk(t, Set(testDB, toArgs("none", "c", "XX", "GET")))
+	asserts.AssertNullBulk(t, Get(testDB, toArgs("none")))
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, Set(testDB, toArgs("list", "a", "GET")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	// keepttl
+	Set(testDB, toArgs("k", "v", "EX", "100"))
+	Set(testDB, toArgs("k", "v2", "KEEPTTL"))
+	assertTTL(t, testDB, "k", 100)
+	Set(testDB, toArgs("k", "v3"))
+	asserts.AssertIntReply(t, TTL(testDB, toArgs("k")), -1)
+
+	// absolute expire time
+	at := time.Now().Add(100 * time.Second)
+	Set(testDB, toArgs("k", "v", "EXAT", strconv.FormatInt(at.Unix(), 10)))
+	assertTTL(t, testDB, "k", 100)
+	Set(testDB, toArgs("k", "v", "PXAT", strconv.FormatInt(at.UnixMilli(), 10)))
+	assertTTL(t, testDB, "k", 100)
+	Set(testDB, toArgs("k", "v", "PXAT", "1"))
+	asserts.AssertNullBulk(t, Get(testDB, toArgs("k")))
+
+	asserts.AssertErrReply(t, Set(testDB, toArgs("k", "v", "EX", "10", "KEEPTTL")), "Err syntax error")
+	asserts.AssertErrReply(t, Set(testDB, toArgs("k", "v", "EXAT", "0")), "ERR invalid expire time in set")
+	asserts.AssertErrReply(t, Set(testDB, toArgs("k", "v", "PX", "a")), "ERR value is not an integer or out of range")
+}
+
+func TestPSetEX(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	PSetEX(testDB, toArgs("k", "100000", "v"))
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("k")), "v")
+	assertTTL(t, testDB, "k", 100)
+}
+
+func TestGetEx(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	asserts.AssertNullBulk(t, GetEx(testDB, toArgs("k")))
+	Set(testDB, toArgs("k", "v"))
+	asserts.AssertBulkReply(t, GetEx(testDB, toArgs("k", "EX", "100")), "v")
+	assertTTL(t, testDB, "k", 100)
+	asserts.AssertBulkReply(t, GetEx(testDB, toArgs("k")), "v")
+	assertTTL(t, testDB, "k", 100)
+	asserts.AssertBulkReply(t, GetEx(testDB, toArgs("k", "PERSIST")), "v")
+	asserts.AssertIntReply(t, TTL(testDB, toArgs("k")), -1)
+	GetEx(testDB, toArgs("k", "PX", "100000"))
+	assertTTL(t, testDB, "k", 100)
+	GetEx(testDB, toArgs("k", "EXAT", "1"))
+	asserts.AssertNullBulk(t, Get(testDB, toArgs("k")))
+
+	asserts.AssertErrReply(t, GetEx(testDB, toArgs("k", "EX")), "Err syntax error")
+	asserts.AssertErrReply(t, GetEx(testDB, toArgs("k", "EX", "-1")), "ERR invalid expire time in getex")
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, GetEx(testDB, toArgs("list")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestGetDel(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("k", "v"))
+	asserts.AssertBulkReply(t, GetDel(testDB, toArgs("k")), "v")
+	asserts.AssertNullBulk(t, GetDel(testDB, toArgs("k")))
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, GetDel(testDB, toArgs("list")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("list")), 1)
+}
+
+func TestAppend(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	asserts.AssertIntReply(t, Append(testDB, toArgs("k", "Hello")), 5)
+	asserts.AssertIntReply(t, Append(testDB, toArgs("k", " World")), 11)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("k")), "Hello World")
+	asserts.AssertIntReply(t, StrLen(testDB, toArgs("k")), 11)
+	asserts.AssertIntReply(t, StrLen(testDB, toArgs("none")), 0)
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, Append(testDB, toArgs("list", "a")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+	asserts.AssertErrReply(t, StrLen(testDB, toArgs("list")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestGetRange(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("k", "This is a string"))
+	asserts.AssertBulkReply(t, GetRange(testDB, toArgs("k", "0", "3")), "This")
+	asserts.AssertBulkReply(t, GetRange(testDB, toArgs("k", "-3", "-1")), "ing")
+	asserts.AssertBulkReply(t, GetRange(testDB, toArgs("k", "0", "-1")), "This is a string")
+	asserts.AssertBulkReply(t, GetRange(testDB, toArgs("k", "10", "100")), "string")
+	asserts.AssertBulkReply(t, GetRange(testDB, toArgs("k", "5", "3")), "")
+	asserts.AssertBulkReply(t, GetRange(testDB, toArgs("k", "-1", "-5")), "")
+	asserts.AssertBulkReply(t, GetRange(testDB, toArgs("none", "0", "-1")), "")
+	asserts.AssertErrReply(t, GetRange(testDB, toArgs("k", "a", "1")), "ERR value is not an integer or out of range")
+}
+
+func TestSetRange(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("k", "Hello World"))
+	asserts.AssertIntReply(t, SetRange(testDB, toArgs("k", "6", "Redis")), 11)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("k")), "Hello Redis")
+
+	// zero padding
+	asserts.AssertIntReply(t, SetRange(testDB, toArgs("pad", "3", "ab")), 5)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("pad")), "\x00\x00\x00ab")
+
+	// empty value does not create key
+	asserts.AssertIntReply(t, SetRange(testDB, toArgs("none", "3", "")), 0)
+	asserts.AssertIntReply(t, Exists(testDB, toArgs("none")), 0)
+
+	asserts.AssertErrReply(t, SetRange(testDB, toArgs("k", "-1", "a")), "ERR offset is out of range")
+	asserts.AssertErrReply(t, SetRange(testDB, toArgs("k", "a", "a")), "ERR value is not an integer or out of range")
+	asserts.AssertErrReply(t, SetRange(testDB, toArgs("k", "536870911", "ab")),
+		"ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	LPush(testDB, toArgs("list", "a"))
+	asserts.AssertErrReply(t, SetRange(testDB, toArgs("list", "0", "a")), "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestIncrOverflow(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	Set(testDB, toArgs("k", "9223372036854775806"))
+	asserts.AssertIntReply(t, Incr(testDB, toArgs("k")), 9223372036854775807)
+	asserts.AssertErrReply(t, Incr(testDB, toArgs("k")), "ERR increment or decrement would overflow")
+	asserts.AssertErrReply(t, IncrBy(testDB, toArgs("k", "10")), "ERR increment or decrement would overflow")
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("k")), "9223372036854775807")
+
+	Set(testDB, toArgs("k", "-9223372036854775807"))
+	asserts.AssertErrReply(t, DecrBy(testDB, toArgs("k", "2")), "ERR increment or decrement would overflow")
+	asserts.AssertErrReply(t, DecrBy(testDB, toArgs("k", "-9223372036854775808")), "ERR decrement would overflow")
+	asserts.AssertIntReply(t, Decr(testDB, toArgs("k")), -9223372036854775808)
+	asserts.AssertErrReply(t, Decr(testDB, toArgs("k")), "ERR increment or decrement would overflow")
+
+	asserts.AssertIntReply(t, IncrBy(testDB, toArgs("new", "+5")), 5)
+	asserts.AssertBulkReply(t, Get(testDB, toArgs("new")), "5")
+}
+
+func TestStringPropagation(t *testing.T) {
+	FlushAll(testDB, [][]byte{})
+	useTempAofDir(t)
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.AofUseRdbPreamble = false
+	})
+	defer func() {
+		Config(testDB, toArgs("set", "appendonly", "no"))
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.AofUseRdbPreamble = true
+		})
+	}()
+	Config(testDB, toArgs("set", "appendonly", "yes"))
+	testDB.Exec(nil, toArgs("set", "get", "v", "GET", "EX", "1000"))
+	testDB.Exec(nil, toArgs("set", "get", "v2", "NX", "GET"))
+	testDB.Exec(nil, toArgs("set", "past", "v", "PXAT", "1"))
+	testDB.Exec(nil, toArgs("set", "ex", "v"))
+	testDB.Exec(nil, toArgs("getex", "ex", "PX", "100000"))
+	testDB.Exec(nil, toArgs("getex", "ex"))
+	testDB.Exec(nil, toArgs("set", "persist", "v", "EX", "1000"))
+	testDB.Exec(nil, toArgs("getex", "persist", "PERSIST"))
+	testDB.Exec(nil, toArgs("set", "del", "v"))
+	testDB.Exec(nil, toArgs("getdel", "del"))
+	testDB.Exec(nil, toArgs("getdel", "none"))
+	testDB.Exec(nil, toArgs("append", "append", "ab"))
+	testDB.Exec(nil, toArgs("setrange", "append", "4", "c"))
+	_ = testDB.waitAofSync()
+
+	content := readAof(t)
+	for _, cmd := range []string{"GET", "NX", "PXAT", "getex", "none"} {
+		if strings.Contains(content, cmd) {
+			t.Errorf("unexpected %q in aof", cmd)
+		}
+	}
+	Config(testDB, toArgs("set", "appendonly", "no"))
+	db := makeTestDB()
+	if err := db.loadAofOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, Get(db, toArgs("get")), "v")
+	assertTTL(t, db, "get", 1000)
+	asserts.AssertIntReply(t, Exists(db, toArgs("past", "del")), 0)
+	assertTTL(t, db, "ex", 100)
+	asserts.AssertIntReply(t, TTL(db, toArgs("persist")), -1)
+	asserts.AssertBulkReply(t, Get(db, toArgs("append")), "ab\x00\x00c")
+}
@@ -3,16 +3,21 @@ package db
 import (
 	"my-godis/src/datastruct/dict"
 	"my-godis/src/datastruct/lock"
+	"sync"
 	"time"
 )
 
 func makeTestDB() *DB {
-	return &DB{
-		Data:     dict.MakeConcurrent(1),
-		TTLMap:   dict.MakeConcurrent(ttlDictSize),
-		Locker:   lock.Make(lockerSize),
-		interval: 5 * time.Second,
+	db := &DB{
+		Data:        dict.MakeConcurrent(1),
+		TTLMap:      dict.MakeConcurrent(ttlDictSize),
+		FieldTTLMap: dict.MakeConcurrent(ttlDictSize),
+		Locker:      lock.Make(lockerSize),
+		interval:    5 * time.Second,
+		Mode:        ModeStandalone,
 	}
+	db.aofSyncCond = sync.NewCond(&db.aofSyncMu)
+	return db
 }
 
 func toArgs(cmd ...string) [][]byte {
@@ -2,6 +2,8 @@ package redis
 
 type Connection interface {
 	Write([]byte) error
+	RemoteAddr() string
+	Close() error
 
 	// client should keep its subscribing channels
 	SubsChannel(channel string)
@@ -0,0 +1,254 @@
+package latency
+
+/*
+ * latency monitor records latency spikes per event class, like LATENCY of redis
+ */
+
+import (
+	"fmt"
+	"my-godis/src/config"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event classes
+const (
+	EventCommand     = "command"
+	EventAofWrite    = "aof-write"
+	EventAofRewrite  = "aof-rewrite"
+	EventExpireCycle = "expire-cycle"
+)
+
+// samples kept per event
+const historyLen = 160
+
+type Sample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+type eventSeries struct {
+	samples []*Sample // ring buffer, ordered from oldest to newest
+	head    int
+	max     time.Duration
+}
+
+func (s *eventSeries) add(sample *Sample) {
+	if len(s.samples) > 0 {
+		last := s.samples[(s.head+len(s.samples)-1)%len(s.samples)]
+		if last.Time.Unix() == sample.Time.Unix() {
+			// merge samples of the same second, keeps the max latency
+			if sample.Latency > last.Latency {
+				last.Latency = sample.Latency
+			}
+			if sample.Latency > s.max {
+				s.max = sample.Latency
+			}
+			return
+		}
+	}
+	if len(s.samples) < historyLen {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[s.head] = sample
+		s.head = (s.head + 1) % historyLen
+	}
+	if sample.Latency > s.max {
+		s.max = sample.Latency
+	}
+}
+
+func (s *eventSeries) history() []*Sample {
+	result := make([]*Sample, len(s.samples))
+	for i := range s.samples {
+		result[i] = s.samples[(s.head+i)%len(s.samples)]
+	}
+	return result
+}
+
+func (s *eventSeries) latest() *Sample {
+	return s.samples[(s.head+len(s.samples)-1)%len(s.samples)]
+}
+
+type Monitor struct {
+	mu     sync.Mutex
+	events map[string]*eventSeries
+}
+
+func Make() *Monitor {
+	return &Monitor{
+		events: make(map[string]*eventSeries),
+	}
+}
+
+// Default is the latency monitor of the server
+var Default = Make()
+
+// threshold from config, zero value disables monitor
+func threshold() time.Duration {
+	return config.Properties().LatencyMonitorThreshold
+}
+
+// Record adds a sample if latency reaches latency-monitor-threshold
+func (m *Monitor) Record(event string, latency time.Duration) {
+	limit := threshold()
+	if limit <= 0 || latency < limit {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.events[event]
+	if !ok {
+		series = &eventSeries{}
+		m.events[event] = series
+	}
+	series.add(&Sample{
+		Time:    time.Now(),
+		Latency: latency,
+	})
+}
+
+// Reset removes samples of given events, or of all events if no event specified. returns count of reset events
+func (m *Monitor) Reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*eventSeries)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+func (m *Monitor) sortedEvents() []string {
+	names := make([]string, 0, len(m.events))
+	for name := range m.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func toMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// LATENCY LATEST
+func (m *Monitor) latestReply() redis.Reply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replies := make([]redis.Reply, 0, len(m.events))
+	for _, name := range m.sortedEvents() {
+		series := m.events[name]
+		latest := series.latest()
+		replies = append(replies, reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeBulkReply([]byte(name)),
+			reply.MakeIntReply(latest.Time.Unix()),
+			reply.MakeIntReply(toMillis(latest.Latency)),
+			reply.MakeIntReply(toMillis(series.max)),
+		}))
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// LATENCY HISTORY event
+func (m *Monitor) historyReply(event string) redis.Reply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.events[event]
+	if !ok {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	history := series.history()
+	replies := make([]redis.Reply, len(history))
+	for i, sample := range history {
+		replies[i] = reply.MakeMultiRawReply([]redis.Reply{
+			reply.MakeIntReply(sample.Time.Unix()),
+			reply.MakeIntReply(toMillis(sample.Latency)),
+		})
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// advice for each event class
+var advices = map[string]string{
+	EventCommand:     "Check SLOWLOG GET for slow commands, avoid O(N) commands such as KEYS on big datasets.",
+	EventAofWrite:    "Disk writing of AOF is slow, check the I/O load of the disk or use a faster disk.",
+	EventAofRewrite:  "AOF rewrite takes long, consider tuning auto-aof-rewrite-percentage to rewrite less often.",
+	EventExpireCycle: "Many keys expire at the same time, consider adding random jitter to expire times.",
+}
+
+// LATENCY DOCTOR
+func (m *Monitor) doctor() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if threshold() <= 0 {
+		return "Latency monitoring is disabled. Use CONFIG SET latency-monitor-threshold <milliseconds> to enable it.\n"
+	}
+	if len(m.events) == 0 {
+		return "No latency spike was observed during the lifetime of this instance.\n"
+	}
+	var builder strings.Builder
+	builder.WriteString("Latency spikes were observed for the following events:\n\n")
+	for i, name := range m.sortedEvents() {
+		series := m.events[name]
+		history := series.history()
+		var sum time.Duration
+		for _, sample := range history {
+			sum += sample.Latency
+		}
+		avg := sum / time.Duration(len(history))
+		builder.WriteString(fmt.Sprintf("%d. %s: %d latency spikes (average %dms, latest %dms). Worst all time event %dms.\n",
+			i+1, name, len(history), toMillis(avg), toMillis(series.latest().Latency), toMillis(series.max)))
+	}
+	builder.WriteString("\nAdvices:\n")
+	for _, name := range m.sortedEvents() {
+		advice, ok := advices[name]
+		if ok {
+			builder.WriteString("- " + name + ": " + advice + "\n")
+		}
+	}
+	return builder.String()
+}
+
+// LATENCY LATEST | HISTORY event | RESET [event ...] | DOCTOR
+func Exec(m *Monitor, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return &reply.ArgNumErrReply{Cmd: "latency"}
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "latest":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "latency|latest"}
+		}
+		return m.latestReply()
+	case "history":
+		if len(args) != 2 {
+			return &reply.ArgNumErrReply{Cmd: "latency|history"}
+		}
+		return m.historyReply(string(args[1]))
+	case "reset":
+		events := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			events[i] = string(arg)
+		}
+		return reply.MakeIntReply(int64(m.Reset(events...)))
+	case "doctor":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "latency|doctor"}
+		}
+		return reply.MakeBulkReply([]byte(m.doctor()))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try LATENCY HELP.")
+}
@@ -0,0 +1,101 @@
+package latency
+
+import (
+	"my-godis/src/config"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"my-godis/src/redis/reply/asserts"
+	"strings"
+	"testing"
+	"time"
+)
+
+func toArgs(cmd ...string) [][]byte {
+	args := make([][]byte, len(cmd))
+	for i, s := range cmd {
+		args[i] = []byte(s)
+	}
+	return args
+}
+
+func assertEmpty(t *testing.T, actual redis.Reply) {
+	if string(actual.ToBytes()) != "*0\r\n" {
+		t.Errorf("expected empty array, actually %q", actual.ToBytes())
+	}
+}
+
+func TestLatency(t *testing.T) {
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.LatencyMonitorThreshold = 0
+	})
+	m := Make()
+	m.Record(EventCommand, time.Second)
+	assertEmpty(t, Exec(m, toArgs("latest")))
+	doctor, _ := Exec(m, toArgs("doctor")).(*reply.BulkReply)
+	if !strings.HasPrefix(string(doctor.Arg), "Latency monitoring is disabled") {
+		t.Errorf("unexpected doctor %q", doctor.Arg)
+	}
+
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.LatencyMonitorThreshold = 10 * time.Millisecond
+	})
+	defer func() {
+		config.Update(func(properties *config.PropertyHolder) {
+			properties.LatencyMonitorThreshold = 0
+		})
+	}()
+	m.Record(EventCommand, time.Millisecond)
+	assertEmpty(t, Exec(m, toArgs("latest")))
+
+	// samples of the same second are merged
+	m.Record(EventCommand, 20*time.Millisecond)
+	m.Record(EventCommand, 50*time.Millisecond)
+	m.Record(EventAofWrite, 30*time.Millisecond)
+	history, _ := Exec(m, toArgs("history", EventCommand)).(*reply.MultiRawReply)
+	if history == nil || len(history.Replies) != 1 {
+		t.Fatalf("expected 1 sample, actually %v", history)
+	}
+	sample := history.Replies[0].(*reply.MultiRawReply)
+	asserts.AssertIntReply(t, sample.Replies[1], 50)
+
+	latest, _ := Exec(m, toArgs("latest")).(*reply.MultiRawReply)
+	if latest == nil || len(latest.Replies) != 2 {
+		t.Fatalf("expected 2 events, actually %v", latest)
+	}
+	first := latest.Replies[0].(*reply.MultiRawReply)
+	asserts.AssertBulkReply(t, first.Replies[0], EventAofWrite)
+	asserts.AssertIntReply(t, first.Replies[3], 30)
+
+	doctor, _ = Exec(m, toArgs("doctor")).(*reply.BulkReply)
+	if !strings.Contains(string(doctor.Arg), "command: 1 latency spikes (average 50ms, latest 50ms). Worst all time event 50ms.") {
+		t.Errorf("unexpected doctor %q", doctor.Arg)
+	}
+
+	asserts.AssertIntReply(t, Exec(m, toArgs("reset", EventCommand, EventExpireCycle)), 1)
+	assertEmpty(t, Exec(m, toArgs("history", EventCommand)))
+	asserts.AssertIntReply(t, Exec(m, toArgs("reset")), 1)
+	asserts.AssertErrReply(t, Exec(m, toArgs("history")), "ERR wrong number of arguments for 'latency|history' command")
+}
+
+func TestHistoryRing(t *testing.T) {
+	series := &eventSeries{}
+	start := time.Now()
+	for i := 0; i < historyLen+10; i++ {
+		series.add(&Sample{
+			Time:    start.Add(time.Duration(i) * time.Second),
+			Latency: time.Duration(i) * time.Millisecond,
+		})
+	}
+	history := series.history()
+	if len(history) != historyLen {
+		t.Fatalf("expected %d samples, actually %d", historyLen, len(history))
+	}
+	if history[0].Latency != 10*time.Millisecond || series.latest().Latency != time.Duration(historyLen+9)*time.Millisecond {
+		t.Error("expected oldest samples to be dropped")
+	}
+	for i := 1; i < len(history); i++ {
+		if !history[i].Time.After(history[i-1].Time) {
+			t.Fatal("expected samples ordered from oldest to newest")
+		}
+	}
+}
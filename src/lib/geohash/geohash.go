@@ -0,0 +1,207 @@
+package geohash
+
+import (
+	"math"
+)
+
+/*
+ * geohash of redis: bits of longitude and latitude are interleaved into a 52 bits integer,
+ * which is exactly representable by float64 and used as score in sortedset.
+ * latitude is limited by web mercator projection, standard geohash string is encoded with latitude in [-90, 90].
+ */
+
+const (
+	// MaxStep is bits of each coordinate
+	MaxStep = 26
+
+	LonMin = -180.0
+	LonMax = 180.0
+	LatMin = -85.05112878
+	LatMax = 85.05112878
+
+	// EarthRadius in meters, same as redis
+	EarthRadius = 6372797.560856
+	// mercatorMax is half of the circumference of earth in meters
+	mercatorMax = 20037726.37
+)
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// interleave puts bits of lat on even positions and bits of lon on odd positions
+func interleave(lat, lon uint32) uint64 {
+	var bits uint64
+	for i := uint(0); i < 32; i++ {
+		bits |= uint64(lat>>i&1) << (2 * i)
+		bits |= uint64(lon>>i&1) << (2*i + 1)
+	}
+	return bits
+}
+
+func deinterleave(bits uint64) (lat, lon uint32) {
+	for i := uint(0); i < 32; i++ {
+		lat |= uint32(bits>>(2*i)&1) << i
+		lon |= uint32(bits>>(2*i+1)&1) << i
+	}
+	return lat, lon
+}
+
+// index returns the cell of value in [min, max] divided into 2^step cells
+func index(value, min, max float64, step uint) uint32 {
+	cells := uint64(1) << step
+	i := uint64((value - min) / (max - min) * float64(cells))
+	if i >= cells {
+		i = cells - 1
+	}
+	return uint32(i)
+}
+
+func encode(lon, lat float64, latMin, latMax float64, step uint) uint64 {
+	return interleave(index(lat, latMin, latMax, step), index(lon, LonMin, LonMax, step))
+}
+
+// Encode returns 52 bits geohash of coordinate
+func Encode(lon, lat float64) uint64 {
+	return encode(lon, lat, LatMin, LatMax, MaxStep)
+}
+
+// Decode returns center of the cell of geohash
+func Decode(bits uint64) (lon, lat float64) {
+	latIndex, lonIndex := deinterleave(bits)
+	cells := float64(uint64(1) << MaxStep)
+	// computed in the same order as redis, so the coordinates are exactly the same
+	lonMin := LonMin + (float64(lonIndex)/cells)*(LonMax-LonMin)
+	lonMax := LonMin + (float64(lonIndex+1)/cells)*(LonMax-LonMin)
+	latMin := LatMin + (float64(latIndex)/cells)*(LatMax-LatMin)
+	latMax := LatMin + (float64(latIndex+1)/cells)*(LatMax-LatMin)
+	lon = (lonMin + lonMax) / 2
+	lat = (latMin + latMax) / 2
+	lon = math.Max(LonMin, math.Min(LonMax, lon))
+	lat = math.Max(LatMin, math.Min(LatMax, lat))
+	return lon, lat
+}
+
+// ToString returns standard geohash string of 11 characters
+func ToString(bits uint64) string {
+	lon, lat := Decode(bits)
+	bits = encode(lon, lat, -90, 90, MaxStep)
+	buf := make([]byte, 11)
+	for i := 0; i < 10; i++ {
+		buf[i] = base32[bits>>(52-(i+1)*5)&0x1f]
+	}
+	// 52 bits are not enough for the last character, it is taken as zero like redis
+	buf[10] = base32[0]
+	return string(buf)
+}
+
+func toRadians(degree float64) float64 {
+	return degree * math.Pi / 180
+}
+
+func toDegrees(radian float64) float64 {
+	return radian * 180 / math.Pi
+}
+
+// Distance returns distance in meters by haversine formula
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r := toRadians(lat1)
+	lat2r := toRadians(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(toRadians(lon2-lon1) / 2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// LatDistance returns distance in meters along meridian
+func LatDistance(lat1, lat2 float64) float64 {
+	return EarthRadius * math.Abs(toRadians(lat2)-toRadians(lat1))
+}
+
+/* ---- search ---- */
+
+// ScoreRange is [Min, Max) of geohash of members inside a cell
+type ScoreRange struct {
+	Min uint64
+	Max uint64
+}
+
+// estimateStep returns the step whose cells are about as big as radius
+func estimateStep(radius float64, lat float64) uint {
+	if radius == 0 {
+		return MaxStep
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// make sure radius is included in most cases
+	step -= 2
+	// cells are narrower towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > MaxStep {
+		step = MaxStep
+	}
+	return uint(step)
+}
+
+// SearchRanges returns score ranges of the cell containing the center and its 8 neighbours,
+// the cells cover the box of width and height in meters around the center
+func SearchRanges(lon, lat float64, width, height float64) []*ScoreRange {
+	// bounding box, longitude span is computed at the latitude farthest from equator
+	latDelta := toDegrees(height / 2 / EarthRadius)
+	lonDelta := 180.0
+	if farthest := math.Abs(lat) + latDelta; farthest < 90 {
+		lonDelta = math.Min(180, toDegrees(width/2/EarthRadius/math.Cos(toRadians(farthest))))
+	}
+
+	step := estimateStep(math.Max(width, height)/2, lat)
+	for ; step > 1; step-- {
+		// cells are getting bigger until the neighbours cover bounding box
+		cells := uint64(1) << step
+		latCell := (LatMax - LatMin) / float64(cells)
+		lonCell := (LonMax - LonMin) / float64(cells)
+		latIndex := float64(index(lat, LatMin, LatMax, step))
+		lonIndex := float64(index(lon, LonMin, LonMax, step))
+		south := latIndex == 0 || LatMin+(latIndex-1)*latCell <= lat-latDelta
+		north := latIndex == float64(cells-1) || LatMin+(latIndex+2)*latCell >= lat+latDelta
+		west := LonMin+(lonIndex-1)*lonCell <= lon-lonDelta
+		east := LonMin+(lonIndex+2)*lonCell >= lon+lonDelta
+		if south && north && west && east {
+			break
+		}
+	}
+
+	cells := int64(1) << step
+	latIndex := int64(index(lat, LatMin, LatMax, step))
+	lonIndex := int64(index(lon, LonMin, LonMax, step))
+	shift := 2 * (MaxStep - step)
+	visited := make(map[uint64]bool)
+	var ranges []*ScoreRange
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		i := latIndex + dLat
+		if i < 0 || i >= cells {
+			continue
+		}
+		for dLon := int64(-1); dLon <= 1; dLon++ {
+			// longitude wraps around
+			j := (lonIndex + dLon + cells) % cells
+			bits := interleave(uint32(i), uint32(j))
+			if visited[bits] {
+				continue
+			}
+			visited[bits] = true
+			ranges = append(ranges, &ScoreRange{
+				Min: bits << shift,
+				Max: (bits + 1) << shift,
+			})
+		}
+	}
+	return ranges
+}
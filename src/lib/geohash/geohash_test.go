@@ -0,0 +1,79 @@
+package geohash
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	// example of redis documents
+	bits := Encode(13.361389, 38.115556)
+	if bits != 3479099956230698 {
+		t.Errorf("expected 3479099956230698, actually %d", bits)
+	}
+	if hash := ToString(bits); hash != "sqc8b49rny0" {
+		t.Errorf("expected sqc8b49rny0, actually %s", hash)
+	}
+	lon, lat := Decode(bits)
+	if math.Abs(lon-13.361389) > 1e-5 || math.Abs(lat-38.115556) > 1e-5 {
+		t.Errorf("unexpected coordinate %f,%f", lon, lat)
+	}
+	if hash := ToString(Encode(15.087269, 37.502669)); hash != "sqdtr74hyu0" {
+		t.Errorf("expected sqdtr74hyu0, actually %s", hash)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// distance of members is computed by decoded coordinates
+	lon1, lat1 := Decode(Encode(13.361389, 38.115556))
+	lon2, lat2 := Decode(Encode(15.087269, 37.502669))
+	dist := Distance(lon1, lat1, lon2, lat2)
+	if math.Abs(dist-166274.1516) > 0.01 {
+		t.Errorf("expected 166274.1516, actually %.4f", dist)
+	}
+	if Distance(1, 2, 1, 2) != 0 {
+		t.Error("expected 0")
+	}
+}
+
+func TestSearchRanges(t *testing.T) {
+	inRanges := func(ranges []*ScoreRange, bits uint64) bool {
+		for _, r := range ranges {
+			if bits >= r.Min && bits < r.Max {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < 1000; i++ {
+		lon := rand.Float64()*360 - 180
+		lat := rand.Float64()*170 - 85
+		radius := math.Pow(10, rand.Float64()*7)
+		ranges := SearchRanges(lon, lat, radius*2, radius*2)
+		if len(ranges) > 9 {
+			t.Fatalf("expected at most 9 ranges, actually %d", len(ranges))
+		}
+		// points within radius are inside ranges
+		for j := 0; j < 20; j++ {
+			pLon := lon + (rand.Float64()*2-1)*toDegrees(radius/EarthRadius)*4
+			pLat := lat + (rand.Float64()*2-1)*toDegrees(radius/EarthRadius)
+			if pLat < LatMin || pLat > LatMax {
+				continue
+			}
+			if pLon < LonMin {
+				pLon += 360
+			} else if pLon > LonMax {
+				pLon -= 360
+			}
+			if pLon < LonMin || pLon > LonMax {
+				continue
+			}
+			bits := Encode(pLon, pLat)
+			pLon, pLat = Decode(bits)
+			if Distance(lon, lat, pLon, pLat) <= radius && !inRanges(ranges, bits) {
+				t.Fatalf("%f,%f is within %f meters of %f,%f but not in ranges", pLon, pLat, radius, lon, lat)
+			}
+		}
+	}
+}
@@ -22,7 +22,7 @@ var (
 	F                  *os.File
 	DefaultPrefix      = ""
 	DefaultCallerDepth = 2
-	logger             *log.Logger
+	logger             = log.New(os.Stdout, DefaultPrefix, log.LstdFlags) // replaced by Setup
 	logPrefix          = ""
 	levelFlags         = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
 )
@@ -0,0 +1,275 @@
+package payload
+
+/*
+ * payload serializes values of DataEntity, it is used by DUMP/RESTORE, undo logs and snapshot preamble of aof
+ * format of DUMP payload:
+ *   type(1 byte) value version(2 bytes) crc64(8 bytes) of all bytes before it
+ * strings are encoded as uvarint length followed by bytes, counts of elements as uvarint,
+ * scores in little endian float64, expire time of hash fields as uvarint unix milliseconds and 0 for no ttl.
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"math"
+	"my-godis/src/datastruct/dict"
+	List "my-godis/src/datastruct/list"
+	"my-godis/src/datastruct/set"
+	SortedSet "my-godis/src/datastruct/sortedset"
+	"time"
+)
+
+// types of value
+const (
+	TypeString byte = 0
+	TypeList   byte = 1
+	TypeSet    byte = 2
+	TypeHash   byte = 3
+	TypeZSet   byte = 4
+	// hash with expire time of fields, only dumped by DUMP and MIGRATE
+	TypeHashWithTTL byte = 5
+)
+
+// HashWithTTL is a hash whose fields may have expire time
+type HashWithTTL struct {
+	Hash dict.Dict
+	TTLs map[string]time.Time
+}
+
+// Version of payload, payloads of newer version are refused
+const Version uint16 = 1
+
+// max length of a string or count of elements accepted while decoding
+const (
+	maxStringLen = 512 << 20
+	maxCount     = math.MaxInt32
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var ErrBadPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// Reader is source of decoding
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// ValueType returns type of value, returns false for unsupported value
+func ValueType(val interface{}) (byte, bool) {
+	switch val.(type) {
+	case []byte:
+		return TypeString, true
+	case *List.QuickList:
+		return TypeList, true
+	case *set.Set:
+		return TypeSet, true
+	case dict.Dict:
+		return TypeHash, true
+	case *SortedSet.SortedSet:
+		return TypeZSet, true
+	case *HashWithTTL:
+		return TypeHashWithTTL, true
+	}
+	return 0, false
+}
+
+// AppendValue appends encoded value without type, val should be supported by ValueType
+func AppendValue(buf []byte, val interface{}) []byte {
+	switch val := val.(type) {
+	case []byte:
+		buf = AppendString(buf, string(val))
+	case *List.QuickList:
+		buf = binary.AppendUvarint(buf, uint64(val.Len()))
+		val.ForEach(func(i int, v []byte) bool {
+			buf = AppendString(buf, string(v))
+			return true
+		})
+	case *set.Set:
+		buf = binary.AppendUvarint(buf, uint64(val.Len()))
+		val.ForEach(func(member string) bool {
+			buf = AppendString(buf, member)
+			return true
+		})
+	case dict.Dict:
+		buf = binary.AppendUvarint(buf, uint64(val.Len()))
+		val.ForEach(func(field string, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			buf = AppendString(buf, field)
+			buf = AppendString(buf, string(bytes))
+			return true
+		})
+	case *SortedSet.SortedSet:
+		buf = binary.AppendUvarint(buf, uint64(val.Len()))
+		val.ForEach(0, val.Len(), false, func(element *SortedSet.Element) bool {
+			buf = AppendString(buf, element.Member)
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(element.Score))
+			return true
+		})
+	case *HashWithTTL:
+		buf = binary.AppendUvarint(buf, uint64(val.Hash.Len()))
+		val.Hash.ForEach(func(field string, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			buf = AppendString(buf, field)
+			buf = AppendString(buf, string(bytes))
+			expireAt := uint64(0)
+			if expireTime, ok := val.TTLs[field]; ok {
+				expireAt = uint64(expireTime.UnixMilli())
+			}
+			buf = binary.AppendUvarint(buf, expireAt)
+			return true
+		})
+	}
+	return buf
+}
+
+// AppendString appends uvarint length and s
+func AppendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// ReadString reads string encoded by AppendString
+func ReadString(r Reader) ([]byte, error) {
+	n, err := readLength(r, maxStringLen)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func readLength(r Reader, max int) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(max) {
+		return 0, errors.New("length out of range")
+	}
+	return int(n), nil
+}
+
+// ReadValue reads value of typ encoded by AppendValue
+func ReadValue(r Reader, typ byte) (interface{}, error) {
+	if typ == TypeString {
+		return ReadString(r)
+	}
+	count, err := readLength(r, maxCount)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case TypeList:
+		list := List.MakeQuickList()
+		for i := 0; i < count; i++ {
+			val, err := ReadString(r)
+			if err != nil {
+				return nil, err
+			}
+			list.Add(val)
+		}
+		return list, nil
+	case TypeSet:
+		s := set.Make()
+		for i := 0; i < count; i++ {
+			member, err := ReadString(r)
+			if err != nil {
+				return nil, err
+			}
+			s.Add(string(member))
+		}
+		return s, nil
+	case TypeHash:
+		hash := dict.MakeSimple()
+		for i := 0; i < count; i++ {
+			field, err := ReadString(r)
+			if err != nil {
+				return nil, err
+			}
+			val, err := ReadString(r)
+			if err != nil {
+				return nil, err
+			}
+			hash.Put(string(field), val)
+		}
+		return hash, nil
+	case TypeZSet:
+		zset := SortedSet.Make()
+		var score [8]byte
+		for i := 0; i < count; i++ {
+			member, err := ReadString(r)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.ReadFull(r, score[:])
+			if err != nil {
+				return nil, err
+			}
+			zset.Add(string(member), math.Float64frombits(binary.LittleEndian.Uint64(score[:])))
+		}
+		return zset, nil
+	case TypeHashWithTTL:
+		hash := &HashWithTTL{
+			Hash: dict.MakeSimple(),
+			TTLs: make(map[string]time.Time),
+		}
+		for i := 0; i < count; i++ {
+			field, err := ReadString(r)
+			if err != nil {
+				return nil, err
+			}
+			val, err := ReadString(r)
+			if err != nil {
+				return nil, err
+			}
+			expireAt, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			hash.Hash.Put(string(field), val)
+			if expireAt > 0 {
+				hash.TTLs[string(field)] = time.UnixMilli(int64(expireAt))
+			}
+		}
+		return hash, nil
+	}
+	return nil, errors.New("unknown value type")
+}
+
+// Dump serializes value into payload with version and checksum
+func Dump(val interface{}) ([]byte, error) {
+	typ, ok := ValueType(val)
+	if !ok {
+		return nil, errors.New("unsupported value type")
+	}
+	buf := AppendValue([]byte{typ}, val)
+	buf = binary.LittleEndian.AppendUint16(buf, Version)
+	return binary.LittleEndian.AppendUint64(buf, crc64.Checksum(buf, crcTable)), nil
+}
+
+// Load deserializes payload made by Dump, returns ErrBadPayload if payload is invalid
+func Load(payload []byte) (interface{}, error) {
+	if len(payload) < 11 {
+		return nil, ErrBadPayload
+	}
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	checksum := binary.LittleEndian.Uint64(payload[footer+2:])
+	if version > Version || crc64.Checksum(payload[:footer+2], crcTable) != checksum {
+		return nil, ErrBadPayload
+	}
+	r := bytes.NewReader(payload[1:footer])
+	val, err := ReadValue(r, payload[0])
+	if err != nil || r.Len() > 0 {
+		return nil, ErrBadPayload
+	}
+	return val, nil
+}
@@ -0,0 +1,87 @@
+package payload
+
+import (
+	"my-godis/src/datastruct/dict"
+	List "my-godis/src/datastruct/list"
+	"my-godis/src/datastruct/set"
+	SortedSet "my-godis/src/datastruct/sortedset"
+	"testing"
+	"time"
+)
+
+func TestDumpAndLoad(t *testing.T) {
+	hash := dict.MakeSimple()
+	hash.Put("f1", []byte("v1"))
+	hash.Put("f2", []byte{})
+	zset := SortedSet.Make()
+	zset.Add("a", 1.5)
+	zset.Add("b", -3)
+	values := []interface{}{
+		[]byte("hello"),
+		List.MakeQuickList([]byte("1"), []byte("2")),
+		set.MakeFromVals("x", "y", "z"),
+		hash,
+		zset,
+	}
+	for _, val := range values {
+		data, err := Dump(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := Load(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// values are equal if they are encoded into the same bytes
+		reDumped, _ := Dump(loaded)
+		expectedType, _ := ValueType(val)
+		actualType, _ := ValueType(loaded)
+		if expectedType != actualType || len(reDumped) != len(data) {
+			t.Errorf("unexpected value %T loaded from %T", loaded, val)
+		}
+	}
+	if e, ok := zset.Get("b"); !ok || e.Score != -3 {
+		t.Error("unexpected zset")
+	}
+	if _, err := Dump(42); err == nil {
+		t.Error("expected error for unsupported value")
+	}
+}
+
+func TestDumpHashWithTTL(t *testing.T) {
+	hash := dict.MakeSimple()
+	hash.Put("f1", []byte("v1"))
+	hash.Put("f2", []byte("v2"))
+	expireTime := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	data, err := Dump(&HashWithTTL{Hash: hash, TTLs: map[string]time.Time{"f1": expireTime}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashWithTTL, ok := loaded.(*HashWithTTL)
+	if !ok || hashWithTTL.Hash.Len() != 2 || len(hashWithTTL.TTLs) != 1 {
+		t.Fatalf("unexpected value %v", loaded)
+	}
+	if v, _ := hashWithTTL.Hash.Get("f2"); string(v.([]byte)) != "v2" {
+		t.Error("unexpected field")
+	}
+	if !hashWithTTL.TTLs["f1"].Equal(expireTime) {
+		t.Errorf("expected expire time %v, actually %v", expireTime, hashWithTTL.TTLs["f1"])
+	}
+}
+
+func TestLoadBadPayload(t *testing.T) {
+	data, _ := Dump([]byte("hello"))
+	corrupted := append([]byte{}, data...)
+	corrupted[2] ^= 0xff
+	newer := append([]byte{}, data...)
+	newer[len(newer)-10] = byte(Version + 1)
+	for _, bad := range [][]byte{corrupted, newer, data[:5], nil} {
+		if _, err := Load(bad); err != ErrBadPayload {
+			t.Errorf("expected ErrBadPayload for %q, actually %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package metrics
+
+/*
+ * a tiny metrics registry which exports metrics in prometheus text format
+ */
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type collector interface {
+	// write samples in prometheus text format
+	write(w io.Writer)
+}
+
+type metricMeta struct {
+	name  string
+	help  string
+	label string // name of the only label, empty for metrics without label
+}
+
+func (m *metricMeta) writeHeader(w io.Writer, typ string) {
+	_, _ = io.WriteString(w, "# HELP "+m.name+" "+m.help+"\n")
+	_, _ = io.WriteString(w, "# TYPE "+m.name+" "+typ+"\n")
+}
+
+func escapeLabel(val string) string {
+	val = strings.ReplaceAll(val, "\\", "\\\\")
+	val = strings.ReplaceAll(val, "\"", "\\\"")
+	return strings.ReplaceAll(val, "\n", "\\n")
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+/* ---- Registry ---- */
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func MakeRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+// DefaultRegistry is exposed by the /metrics endpoint
+var DefaultRegistry = MakeRegistry()
+
+// register collector, the latter registered collector replaces the former one with same name
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[name] = c
+}
+
+// get registered collector or register a new one
+func (r *Registry) getOrRegister(name string, maker func() collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.collectors[name]; ok {
+		return c
+	}
+	c := maker()
+	r.collectors[name] = c
+	return c
+}
+
+// Export writes all metrics sorted by name
+func (r *Registry) Export(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]collector, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		collectors[i] = r.collectors[name]
+	}
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+/* ---- Counter ---- */
+
+// CounterVec is a monotonic counter partitioned by one label, use empty label value for metric without label
+type CounterVec struct {
+	meta   metricMeta
+	values sync.Map // label value -> *uint64
+}
+
+func (r *Registry) CounterVec(name string, help string, label string) *CounterVec {
+	c := r.getOrRegister(name, func() collector {
+		return &CounterVec{meta: metricMeta{name: name, help: help, label: label}}
+	})
+	return c.(*CounterVec)
+}
+
+func (c *CounterVec) Add(labelValue string, delta uint64) {
+	raw, ok := c.values.Load(labelValue)
+	if !ok {
+		raw, _ = c.values.LoadOrStore(labelValue, new(uint64))
+	}
+	atomic.AddUint64(raw.(*uint64), delta)
+}
+
+func (c *CounterVec) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.meta.writeHeader(w, "counter")
+	for _, labelValue := range sortedKeys(&c.values) {
+		raw, _ := c.values.Load(labelValue)
+		value := atomic.LoadUint64(raw.(*uint64))
+		_, _ = io.WriteString(w, c.meta.name+c.meta.labels(labelValue, "")+" "+strconv.FormatUint(value, 10)+"\n")
+	}
+}
+
+/* ---- Gauge ---- */
+
+// GaugeFunc samples value by calling fn while exporting
+type GaugeFunc struct {
+	meta metricMeta
+	fn   func() float64
+}
+
+// RegisterGaugeFunc registers gauge, replaces the former gauge with same name
+func (r *Registry) RegisterGaugeFunc(name string, help string, fn func() float64) {
+	r.register(name, &GaugeFunc{
+		meta: metricMeta{name: name, help: help},
+		fn:   fn,
+	})
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.meta.writeHeader(w, "gauge")
+	_, _ = io.WriteString(w, g.meta.name+" "+formatFloat(g.fn())+"\n")
+}
+
+// CounterFunc samples a monotonic value maintained elsewhere
+type CounterFunc struct {
+	meta metricMeta
+	fn   func() float64
+}
+
+func (r *Registry) RegisterCounterFunc(name string, help string, fn func() float64) {
+	r.register(name, &CounterFunc{
+		meta: metricMeta{name: name, help: help},
+		fn:   fn,
+	})
+}
+
+func (c *CounterFunc) write(w io.Writer) {
+	c.meta.writeHeader(w, "counter")
+	_, _ = io.WriteString(w, c.meta.name+" "+formatFloat(c.fn())+"\n")
+}
+
+/* ---- Histogram ---- */
+
+// DefaultLatencyBuckets ranges from 10us to 1s, in seconds
+var DefaultLatencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // counts[i] is count of observations <= buckets[i], not cumulative
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a histogram partitioned by one label
+type HistogramVec struct {
+	meta    metricMeta
+	buckets []float64
+	values  sync.Map // label value -> *histogram
+}
+
+func (r *Registry) HistogramVec(name string, help string, label string, buckets []float64) *HistogramVec {
+	c := r.getOrRegister(name, func() collector {
+		return &HistogramVec{
+			meta:    metricMeta{name: name, help: help, label: label},
+			buckets: buckets,
+		}
+	})
+	return c.(*HistogramVec)
+}
+
+func (h *HistogramVec) Observe(labelValue string, value float64) {
+	raw, ok := h.values.Load(labelValue)
+	if !ok {
+		raw, _ = h.values.LoadOrStore(labelValue, &histogram{counts: make([]uint64, len(h.buckets))})
+	}
+	hist := raw.(*histogram)
+	i := sort.SearchFloat64s(h.buckets, value)
+	hist.mu.Lock()
+	if i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.sum += value
+	hist.count++
+	hist.mu.Unlock()
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.meta.writeHeader(w, "histogram")
+	for _, labelValue := range sortedKeys(&h.values) {
+		raw, _ := h.values.Load(labelValue)
+		hist := raw.(*histogram)
+		hist.mu.Lock()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			_, _ = io.WriteString(w, h.meta.name+"_bucket"+h.meta.labels(labelValue, formatFloat(bound))+
+				" "+strconv.FormatUint(cumulative, 10)+"\n")
+		}
+		_, _ = io.WriteString(w, h.meta.name+"_bucket"+h.meta.labels(labelValue, "+Inf")+
+			" "+strconv.FormatUint(hist.count, 10)+"\n")
+		_, _ = io.WriteString(w, h.meta.name+"_sum"+h.meta.labels(labelValue, "")+" "+formatFloat(hist.sum)+"\n")
+		_, _ = io.WriteString(w, h.meta.name+"_count"+h.meta.labels(labelValue, "")+
+			" "+strconv.FormatUint(hist.count, 10)+"\n")
+		hist.mu.Unlock()
+	}
+}
+
+/* ---- utils ---- */
+
+// format label set, le is the upper bound of histogram bucket
+func (m *metricMeta) labels(labelValue string, le string) string {
+	pairs := make([]string, 0, 2)
+	if m.label != "" {
+		pairs = append(pairs, m.label+"=\""+escapeLabel(labelValue)+"\"")
+	}
+	if le != "" {
+		pairs = append(pairs, "le=\""+le+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m *sync.Map) []string {
+	keys := make([]string, 0)
+	m.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	r := MakeRegistry()
+	counter := r.CounterVec("test_calls_total", "calls", "cmd")
+	counter.Inc("get")
+	counter.Add("set", 2)
+	hist := r.HistogramVec("test_duration_seconds", "duration", "cmd", []float64{0.1, 1})
+	hist.Observe("get", 0.05)
+	hist.Observe("get", 0.5)
+	hist.Observe("get", 5)
+	r.RegisterGaugeFunc("test_gauge", "gauge", func() float64 {
+		return 3
+	})
+
+	buf := &bytes.Buffer{}
+	r.Export(buf)
+	output := buf.String()
+	expectedLines := []string{
+		"# TYPE test_calls_total counter",
+		`test_calls_total{cmd="get"} 1`,
+		`test_calls_total{cmd="set"} 2`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{cmd="get",le="0.1"} 1`,
+		`test_duration_seconds_bucket{cmd="get",le="1"} 2`,
+		`test_duration_seconds_bucket{cmd="get",le="+Inf"} 3`,
+		`test_duration_seconds_sum{cmd="get"} 5.55`,
+		`test_duration_seconds_count{cmd="get"} 3`,
+		"# TYPE test_gauge gauge",
+		"test_gauge 3",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("expected line %s, actually:\n%s", line, output)
+		}
+	}
+}
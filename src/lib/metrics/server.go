@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"my-godis/src/lib/logger"
+	"net/http"
+)
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	DefaultRegistry.Export(w)
+}
+
+// ListenAndServe serves DefaultRegistry on path /metrics, blocks until server stopped
+func ListenAndServe(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	logger.Info("metrics server listening on " + addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		logger.Error("metrics server stopped: " + err.Error())
+	}
+}
@@ -0,0 +1,169 @@
+package monitor
+
+/*
+ * MONITOR streams every command processed by server to monitoring connections
+ */
+
+import (
+	"my-godis/src/interface/redis"
+	"my-godis/src/lib/logger"
+	"my-godis/src/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// max lines pending for a monitor, the monitor is disconnected while it falls behind further
+const outputBufferLimit = 1 << 12
+
+type monitor struct {
+	conn  redis.Connection
+	lines chan []byte
+}
+
+type Hub struct {
+	mu       sync.RWMutex
+	monitors map[redis.Connection]*monitor
+	count    int32 // len(monitors), for fast path without monitor
+}
+
+func MakeHub() *Hub {
+	return &Hub{
+		monitors: make(map[redis.Connection]*monitor),
+	}
+}
+
+// Default is the monitor hub of the server
+var Default = MakeHub()
+
+// Add registers connection as monitor, returns false if it is monitoring already.
+// OK of MONITOR is written by the monitor goroutine, so it precedes fed commands
+func (hub *Hub) Add(c redis.Connection) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if _, ok := hub.monitors[c]; ok {
+		return false
+	}
+	m := &monitor{
+		conn:  c,
+		lines: make(chan []byte, outputBufferLimit),
+	}
+	m.lines <- (&reply.OkReply{}).ToBytes()
+	hub.monitors[c] = m
+	atomic.AddInt32(&hub.count, 1)
+	go m.serve()
+	return true
+}
+
+// Remove unregisters connection, returns false if it is not a monitor
+func (hub *Hub) Remove(c redis.Connection) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	m, ok := hub.monitors[c]
+	if !ok {
+		return false
+	}
+	delete(hub.monitors, c)
+	atomic.AddInt32(&hub.count, -1)
+	close(m.lines)
+	return true
+}
+
+func (hub *Hub) Len() int {
+	return int(atomic.LoadInt32(&hub.count))
+}
+
+// write lines to connection until channel closed
+func (m *monitor) serve() {
+	for line := range m.lines {
+		err := m.conn.Write(line)
+		if err != nil {
+			// connection closed, AfterClientClose will remove monitor
+			for range m.lines {
+			}
+			return
+		}
+	}
+}
+
+// Feed sends command to every monitor without blocking
+func (hub *Hub) Feed(c redis.Connection, args [][]byte) {
+	if atomic.LoadInt32(&hub.count) == 0 {
+		return
+	}
+	addr := ""
+	if c != nil {
+		addr = c.RemoteAddr()
+	}
+	line := FormatLine(time.Now(), addr, args)
+
+	var lagging []*monitor
+	hub.mu.RLock()
+	for _, m := range hub.monitors {
+		select {
+		case m.lines <- line:
+		default:
+			lagging = append(lagging, m)
+		}
+	}
+	hub.mu.RUnlock()
+
+	for _, m := range lagging {
+		if hub.Remove(m.conn) {
+			logger.Warn("disconnect monitor " + m.conn.RemoteAddr() + " for reaching output buffer limit")
+			go func(conn redis.Connection) {
+				_ = conn.Close()
+			}(m.conn)
+		}
+	}
+}
+
+// FormatLine formats command like `+1339518083.107412 [0 127.0.0.1:60866] "keys" "*"`
+func FormatLine(t time.Time, addr string, args [][]byte) []byte {
+	var builder strings.Builder
+	builder.WriteString(strconv.FormatInt(t.Unix(), 10))
+	builder.WriteString(".")
+	micros := strconv.FormatInt(int64(t.Nanosecond()/1000), 10)
+	builder.WriteString(strings.Repeat("0", 6-len(micros)) + micros)
+	builder.WriteString(" [0 " + addr + "]")
+	for _, arg := range args {
+		builder.WriteString(" ")
+		builder.WriteString(quote(arg))
+	}
+	return reply.MakeStatusReply(builder.String()).ToBytes()
+}
+
+// quote arg like sdscatrepr of redis
+func quote(arg []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, b := range arg {
+		switch b {
+		case '\\', '"':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case '\n':
+			builder.WriteString("\\n")
+		case '\r':
+			builder.WriteString("\\r")
+		case '\t':
+			builder.WriteString("\\t")
+		case '\a':
+			builder.WriteString("\\a")
+		case '\b':
+			builder.WriteString("\\b")
+		default:
+			if b >= 0x20 && b < 0x7f {
+				builder.WriteByte(b)
+			} else {
+				builder.WriteString("\\x")
+				builder.WriteString(strconv.FormatInt(int64(b)>>4, 16))
+				builder.WriteString(strconv.FormatInt(int64(b)&0xf, 16))
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeConn struct {
+	mu      sync.Mutex
+	written [][]byte
+	block   chan struct{} // Write blocks until closed if not nil
+	closed  bool
+}
+
+func (c *fakeConn) Write(b []byte) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, b)
+	return nil
+}
+
+func (c *fakeConn) RemoteAddr() string {
+	return "127.0.0.1:6000"
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConn) SubsChannel(channel string)   {}
+func (c *fakeConn) UnSubsChannel(channel string) {}
+func (c *fakeConn) SubsCount() int               { return 0 }
+func (c *fakeConn) GetChannels() []string        { return nil }
+
+func TestFormatLine(t *testing.T) {
+	line := FormatLine(time.Unix(1339518083, 107412000), "127.0.0.1:60866",
+		[][]byte{[]byte("set"), []byte("k"), []byte("a \"b\"\n\x01")})
+	expected := "+1339518083.107412 [0 127.0.0.1:60866] \"set\" \"k\" \"a \\\"b\\\"\\n\\x01\"\r\n"
+	if string(line) != expected {
+		t.Errorf("expected %s, actually %s", expected, line)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	hub := MakeHub()
+	conn := &fakeConn{}
+	hub.Add(conn)
+	hub.Feed(conn, [][]byte{[]byte("ping")})
+	time.Sleep(10 * time.Millisecond)
+	conn.mu.Lock()
+	if len(conn.written) != 2 || string(conn.written[0]) != "+OK\r\n" {
+		t.Errorf("expected OK and 1 line, actually %q", conn.written)
+	}
+	conn.mu.Unlock()
+	hub.Remove(conn)
+
+	// slow monitor should be disconnected
+	slow := &fakeConn{block: make(chan struct{})}
+	hub.Add(slow)
+	for i := 0; i < outputBufferLimit+2; i++ {
+		hub.Feed(conn, [][]byte{[]byte("ping")})
+	}
+	if hub.Len() != 0 {
+		t.Errorf("expected slow monitor removed")
+	}
+	close(slow.block)
+	time.Sleep(10 * time.Millisecond)
+	slow.mu.Lock()
+	if !slow.closed {
+		t.Errorf("expected slow monitor closed")
+	}
+	slow.mu.Unlock()
+}
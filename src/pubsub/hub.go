@@ -18,3 +18,8 @@ func MakeHub() *Hub {
 		subsLocker: lock.Make(16),
 	}
 }
+
+// count of channels which have at least one subscriber
+func (hub *Hub) ChannelCount() int {
+	return hub.subs.Len()
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"my-godis/src/interface/redis"
 	"my-godis/src/lib/logger"
+	"my-godis/src/lib/metrics"
 	"my-godis/src/lib/sync/wait"
 	"my-godis/src/redis/reply"
 	"net"
@@ -34,6 +35,7 @@ type Request struct {
 	reply     redis.Reply
 	heartbeat bool
 	waiting   *wait.Wait
+	sentAt    time.Time
 }
 
 const (
@@ -41,6 +43,13 @@ const (
 	maxWait  = 3 * time.Second
 )
 
+var (
+	peerRTT = metrics.DefaultRegistry.HistogramVec("godis_peer_heartbeat_rtt_seconds",
+		"Round trip time of heartbeat to cluster peers in seconds.", "peer", metrics.DefaultLatencyBuckets)
+	peerErrors = metrics.DefaultRegistry.CounterVec("godis_peer_errors_total",
+		"Number of errors while communicating with cluster peers.", "peer")
+)
+
 func MakeClient(addr string) (*Client, error) {
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
@@ -63,6 +72,7 @@ func (client *Client) Start() {
 	go client.handleWrite()
 	go func() {
 		err := client.handleRead()
+		peerErrors.Inc(client.addr)
 		logger.Warn(err)
 	}()
 	go client.heartbeat()
@@ -133,8 +143,12 @@ loop:
 	client.writing.Done()
 }
 
-// todo: wait with timeout
 func (client *Client) Send(args [][]byte) redis.Reply {
+	return client.SendWithTimeout(args, maxWait)
+}
+
+// SendWithTimeout sends command and waits for its reply at most timeout
+func (client *Client) SendWithTimeout(args [][]byte, timeout time.Duration) redis.Reply {
 	request := &Request{
 		args:      args,
 		heartbeat: false,
@@ -142,8 +156,7 @@ func (client *Client) Send(args [][]byte) redis.Reply {
 	}
 	request.waiting.Add(1)
 	client.sendingReqs <- request
-	timeout := request.waiting.WaitWithTimeout(maxWait)
-	if timeout {
+	if request.waiting.WaitWithTimeout(timeout) {
 		return reply.MakeErrReply("server time out")
 	}
 	return request.reply
@@ -151,6 +164,7 @@ func (client *Client) Send(args [][]byte) redis.Reply {
 
 func (client *Client) doRequest(req *Request) {
 	bytes := reply.MakeMultiBulkReply(req.args).ToBytes()
+	req.sentAt = time.Now()
 	_, err := client.conn.Write(bytes)
 	i := 0
 	for err != nil && i < 3 {
@@ -162,17 +176,30 @@ func (client *Client) doRequest(req *Request) {
 	}
 	if err == nil {
 		client.waitingReqs <- req
+	} else {
+		peerErrors.Inc(client.addr)
 	}
 }
 
 func (client *Client) finishRequest(reply redis.Reply) {
 	request := <-client.waitingReqs
 	request.reply = reply
+	client.observe(request)
 	if request.waiting != nil {
 		request.waiting.Done()
 	}
 }
 
+// record heartbeat rtt and error replies of peer
+func (client *Client) observe(request *Request) {
+	if request.reply != nil && reply.IsErrorReply(request.reply) {
+		peerErrors.Inc(client.addr)
+	}
+	if request.heartbeat {
+		peerRTT.Observe(client.addr, time.Since(request.sentAt).Seconds())
+	}
+}
+
 func (client *Client) handleRead() error {
 	reader := bufio.NewReader(client.conn)
 	downloading := false
@@ -300,6 +327,7 @@ func (client *Client) handleRead() error {
 				} else if msgType == '$' {
 					request.reply = reply.MakeBulkReply(args[0])
 				}
+				client.observe(request)
 
 				if request.waiting != nil {
 					request.waiting.Done()
@@ -47,3 +47,27 @@ func AssertMultiBulkReply(t *testing.T, actual redis.Reply, expected []string) {
 		}
 	}
 }
+
+// AssertStatusReply checks status reply, including OK and PONG
+func AssertStatusReply(t *testing.T, actual redis.Reply, expected string) {
+	if reply.IsErrorReply(actual) || string(actual.ToBytes()) != "+"+expected+reply.CRLF {
+		t.Error(fmt.Sprintf("expected status %s, actually %s", expected, actual.ToBytes()))
+	}
+}
+
+func AssertErrReply(t *testing.T, actual redis.Reply, expected string) {
+	errReply, ok := actual.(reply.ErrorReply)
+	if !ok {
+		t.Error(fmt.Sprintf("expected error reply, actually %s", actual.ToBytes()))
+		return
+	}
+	if errReply.Error() != expected {
+		t.Error(fmt.Sprintf("expected %s, actually %s", expected, errReply.Error()))
+	}
+}
+
+func AssertNullBulk(t *testing.T, actual redis.Reply) {
+	if _, ok := actual.(*reply.NullBulkReply); !ok {
+		t.Error(fmt.Sprintf("expected null bulk reply, actually %s", actual.ToBytes()))
+	}
+}
@@ -40,3 +40,12 @@ var NoBytes = []byte("")
 func (r *NoReply) ToBytes() []byte {
 	return NoBytes
 }
+
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+// NullMultiBulkReply is null array, such as reply of blocking commands on timeout
+type NullMultiBulkReply struct{}
+
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
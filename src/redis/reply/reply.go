@@ -1,12 +1,13 @@
 package reply
 
 import (
+	"bytes"
 	"my-godis/src/interface/redis"
 	"strconv"
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
+	nullBulkReplyBytes = []byte("$-1\r\n")
 	CRLF               = "\r\n"
 )
 
@@ -23,7 +24,7 @@ func MakeBulkReply(arg []byte) *BulkReply {
 }
 
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
+	if r.Arg == nil {
 		return nullBulkReplyBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
@@ -54,6 +55,28 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return []byte(res)
 }
 
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply is an array of replies, for nested replies such as SLOWLOG GET
+type MultiRawReply struct {
+	Replies []redis.Reply
+}
+
+func MakeMultiRawReply(replies []redis.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+func (r *MultiRawReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Replies)) + CRLF)
+	for _, item := range r.Replies {
+		buf.Write(item.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 /* ---- Status Reply ---- */
 
 type StatusReply struct {
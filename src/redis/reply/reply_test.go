@@ -0,0 +1,28 @@
+package reply
+
+import (
+	"my-godis/src/interface/redis"
+	"testing"
+)
+
+func TestToBytes(t *testing.T) {
+	cases := []struct {
+		reply    redis.Reply
+		expected string
+	}{
+		{MakeBulkReply([]byte("a")), "$1\r\na\r\n"},
+		// an empty string is not nil, GET of a key set to "" must not reply nil
+		{MakeBulkReply([]byte{}), "$0\r\n\r\n"},
+		{MakeBulkReply(nil), "$-1\r\n"},
+		{&NullBulkReply{}, "$-1\r\n"},
+		{MakeMultiBulkReply([][]byte{[]byte("a"), {}, nil}), "*3\r\n$1\r\na\r\n$0\r\n\r\n$-1\r\n"},
+		{MakeMultiRawReply([]redis.Reply{MakeIntReply(1), MakeBulkReply(nil)}), "*2\r\n:1\r\n$-1\r\n"},
+		{MakeMultiRawReply([]redis.Reply{MakeMultiBulkReply([][]byte{[]byte("a")}), MakeStatusReply("OK")}), "*2\r\n*1\r\n$1\r\na\r\n+OK\r\n"},
+	}
+	for _, c := range cases {
+		actual := string(c.reply.ToBytes())
+		if actual != c.expected {
+			t.Errorf("expected %q, actually %q", c.expected, actual)
+		}
+	}
+}
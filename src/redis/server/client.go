@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"bufio"
 	"my-godis/src/lib/sync/wait"
 	"net"
 	"sync"
+	sysAtomic "sync/atomic"
 	"time"
 
 	"my-godis/src/lib/sync/atomic"
@@ -12,6 +14,8 @@ import (
 // abstract of active client
 type Client struct {
 	conn net.Conn
+	// reader of conn used by handler, nil if connection is not served by handler
+	reader *bufio.Reader
 
 	// waiting util reply finished
 	waitingReply wait.Wait
@@ -30,8 +34,25 @@ type Client struct {
 
 	// subscribing channels
 	subs map[string]bool
+
+	// unique id of connection, assigned by MakeClient
+	id int64
+	// name set by CLIENT SETNAME
+	name string
+	// last executed command, lowercase
+	lastCmd string
+	// protect name and lastCmd
+	metaMu sync.RWMutex
+	// create time of connection
+	createdAt time.Time
+	// last interaction time in unix nano, accessed atomically
+	lastInteraction int64
+	// client is excluded from eviction, set by CLIENT NO-EVICT
+	noEvict atomic.AtomicBool
 }
 
+var clientIdGen int64 = 0
+
 func (c *Client) Close() error {
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
@@ -39,11 +60,64 @@ func (c *Client) Close() error {
 }
 
 func MakeClient(conn net.Conn) *Client {
+	now := time.Now()
 	return &Client{
-		conn: conn,
+		conn:            conn,
+		id:              sysAtomic.AddInt64(&clientIdGen, 1),
+		createdAt:       now,
+		lastInteraction: now.UnixNano(),
 	}
 }
 
+func (c *Client) Id() int64 {
+	return c.id
+}
+
+func (c *Client) Name() string {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.name
+}
+
+func (c *Client) SetName(name string) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	c.name = name
+}
+
+func (c *Client) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+func (c *Client) LocalAddr() string {
+	return c.conn.LocalAddr().String()
+}
+
+func (c *Client) LastCmd() string {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.lastCmd
+}
+
+// record command and interaction time before executing
+func (c *Client) touch(cmd string) {
+	c.metaMu.Lock()
+	c.lastCmd = cmd
+	c.metaMu.Unlock()
+	sysAtomic.StoreInt64(&c.lastInteraction, time.Now().UnixNano())
+}
+
+// seconds since connection created
+func (c *Client) Age() int64 {
+	return int64(time.Since(c.createdAt) / time.Second)
+}
+
+// seconds since last interaction
+func (c *Client) Idle() int64 {
+	last := sysAtomic.LoadInt64(&c.lastInteraction)
+	return int64(time.Since(time.Unix(0, last)) / time.Second)
+}
+
 func (c *Client) Write(b []byte) error {
 	if b == nil || len(b) == 0 {
 		return nil
@@ -55,6 +129,48 @@ func (c *Client) Write(b []byte) error {
 	return err
 }
 
+/*
+ * WatchClose watches connection in background while handler is blocked in a command, onClose is called if peer
+ * closes the connection. watching ends once next request arrives, as the connection is alive.
+ * stop returns after watching ended, then handler could read the connection again
+ */
+func (c *Client) WatchClose(onClose func()) (stop func()) {
+	if c.reader == nil {
+		return func() {}
+	}
+	var mu sync.Mutex
+	stopped := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			return
+		}
+		// blocked client is not timed out for idle
+		_ = c.conn.SetReadDeadline(time.Time{})
+		mu.Unlock()
+		// peek doesn't consume pipelined requests
+		_, err := c.reader.Peek(1)
+		mu.Lock()
+		closed := err != nil && !stopped
+		mu.Unlock()
+		if closed {
+			onClose()
+		}
+	}()
+	return func() {
+		mu.Lock()
+		stopped = true
+		// interrupt peeking
+		_ = c.conn.SetReadDeadline(time.Now())
+		mu.Unlock()
+		<-done
+		_ = c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
 func (c *Client) SubsChannel(channel string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -76,6 +192,9 @@ func (c *Client) UnSubsChannel(channel string) {
 }
 
 func (c *Client) SubsCount() int {
+	// read by CLIENT LIST of other connections
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.subs == nil {
 		return 0
 	}
@@ -0,0 +1,371 @@
+package handler
+
+/*
+ * CLIENT command family, introspect and control connections of this handler
+ */
+
+import (
+	DBImpl "my-godis/src/db"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// all connections are authenticated as default user since acl is not supported
+const defaultUser = "default"
+
+func (h *Handler) execClient(client *Client, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return &reply.ArgNumErrReply{Cmd: "client"}
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "id":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "client|id"}
+		}
+		return reply.MakeIntReply(client.Id())
+	case "getname":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "client|getname"}
+		}
+		name := client.Name()
+		if name == "" {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply([]byte(name))
+	case "setname":
+		if len(args) != 2 {
+			return &reply.ArgNumErrReply{Cmd: "client|setname"}
+		}
+		name := string(args[1])
+		for _, ch := range name {
+			if ch <= ' ' || ch > '~' {
+				return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+		}
+		client.SetName(name)
+		return &reply.OkReply{}
+	case "list":
+		return h.clientList(args[1:])
+	case "kill":
+		return h.clientKill(client, args[1:])
+	case "pause":
+		return h.clientPause(args[1:])
+	case "unpause":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "client|unpause"}
+		}
+		h.pause.unpause()
+		return &reply.OkReply{}
+	case "no-evict":
+		if len(args) != 2 {
+			return &reply.ArgNumErrReply{Cmd: "client|no-evict"}
+		}
+		switch strings.ToLower(string(args[1])) {
+		case "on":
+			client.noEvict.Set(true)
+		case "off":
+			client.noEvict.Set(false)
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+		return &reply.OkReply{}
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CLIENT HELP.")
+}
+
+// returns connected clients sorted by id
+func (h *Handler) clients() []*Client {
+	clients := make([]*Client, 0)
+	h.activeConn.Range(func(key interface{}, val interface{}) bool {
+		clients = append(clients, key.(*Client))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Id() < clients[j].Id()
+	})
+	return clients
+}
+
+func clientType(client *Client) string {
+	if client.SubsCount() > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// db and psub are listed in format of redis, there is only one database and PSUBSCRIBE is not supported
+func formatClient(client *Client) string {
+	flags := "N"
+	if client.SubsCount() > 0 {
+		flags = "P"
+	}
+	if client.noEvict.Get() {
+		flags += "e"
+	}
+	cmd := client.LastCmd()
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	return "id=" + strconv.FormatInt(client.Id(), 10) +
+		" addr=" + client.RemoteAddr() +
+		" laddr=" + client.LocalAddr() +
+		" name=" + client.Name() +
+		" age=" + strconv.FormatInt(client.Age(), 10) +
+		" idle=" + strconv.FormatInt(client.Idle(), 10) +
+		" flags=" + flags +
+		" db=0" +
+		" sub=" + strconv.Itoa(client.SubsCount()) +
+		" psub=0" +
+		" user=" + defaultUser +
+		" cmd=" + cmd + "\n"
+}
+
+// CLIENT LIST [TYPE normal|pubsub] [ID client-id ...]
+func (h *Handler) clientList(args [][]byte) redis.Reply {
+	typeFilter := ""
+	var idFilter map[int64]bool
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "type" && i+1 < len(args) {
+			typeFilter = strings.ToLower(string(args[i+1]))
+			if typeFilter != "normal" && typeFilter != "pubsub" {
+				return reply.MakeErrReply("ERR Unknown client type '" + typeFilter + "'")
+			}
+			i++
+		} else if arg == "id" && i+1 < len(args) {
+			idFilter = make(map[int64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(string(args[i]), 10, 64)
+				if err != nil || id <= 0 {
+					return reply.MakeErrReply("ERR Invalid client ID")
+				}
+				idFilter[id] = true
+			}
+		} else {
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	var builder strings.Builder
+	for _, client := range h.clients() {
+		if typeFilter != "" && clientType(client) != typeFilter {
+			continue
+		}
+		if idFilter != nil && !idFilter[client.Id()] {
+			continue
+		}
+		builder.WriteString(formatClient(client))
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+type killFilter struct {
+	id     int64
+	addr   string
+	laddr  string
+	user   string
+	typ    string
+	skipMe bool
+}
+
+func (f *killFilter) match(self *Client, client *Client) bool {
+	if f.skipMe && client == self {
+		return false
+	}
+	if f.id > 0 && client.Id() != f.id {
+		return false
+	}
+	if f.addr != "" && client.RemoteAddr() != f.addr {
+		return false
+	}
+	if f.laddr != "" && client.LocalAddr() != f.laddr {
+		return false
+	}
+	if f.user != "" && f.user != defaultUser {
+		return false
+	}
+	if f.typ != "" && clientType(client) != f.typ {
+		return false
+	}
+	return true
+}
+
+/*
+ * CLIENT KILL ip:port
+ * CLIENT KILL [ID client-id] [ADDR ip:port] [LADDR ip:port] [USER username] [TYPE normal|pubsub] [SKIPME yes|no]
+ */
+func (h *Handler) clientKill(self *Client, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return &reply.ArgNumErrReply{Cmd: "client|kill"}
+	}
+	if len(args) == 1 {
+		// old style, kill by address
+		addr := string(args[0])
+		for _, client := range h.clients() {
+			if client.RemoteAddr() == addr {
+				h.killClient(client)
+				return &reply.OkReply{}
+			}
+		}
+		return reply.MakeErrReply("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return &reply.SyntaxErrReply{}
+	}
+	filter := &killFilter{skipMe: true}
+	for i := 0; i < len(args); i += 2 {
+		val := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || id <= 0 {
+				return reply.MakeErrReply("ERR client-id should be greater than 0")
+			}
+			filter.id = id
+		case "addr":
+			filter.addr = val
+		case "laddr":
+			filter.laddr = val
+		case "user":
+			filter.user = val
+		case "type":
+			filter.typ = strings.ToLower(val)
+			if filter.typ != "normal" && filter.typ != "pubsub" {
+				return reply.MakeErrReply("ERR Unknown client type '" + val + "'")
+			}
+		case "skipme":
+			switch strings.ToLower(val) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return &reply.SyntaxErrReply{}
+			}
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+
+	killed := 0
+	for _, client := range h.clients() {
+		if filter.match(self, client) {
+			h.killClient(client)
+			killed++
+		}
+	}
+	return reply.MakeIntReply(int64(killed))
+}
+
+// close connection immediately, read loop in Handle will clean the client
+func (h *Handler) killClient(client *Client) {
+	_ = client.conn.Close()
+}
+
+// CLIENT PAUSE timeout [WRITE|ALL]
+func (h *Handler) clientPause(args [][]byte) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return &reply.ArgNumErrReply{Cmd: "client|pause"}
+	}
+	timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || timeout < 0 {
+		return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	all := true
+	if len(args) == 2 {
+		switch strings.ToLower(string(args[1])) {
+		case "all":
+			all = true
+		case "write":
+			all = false
+		default:
+			return &reply.SyntaxErrReply{}
+		}
+	}
+	h.pause.pause(time.Now().Add(time.Duration(timeout)*time.Millisecond), all)
+	return &reply.OkReply{}
+}
+
+// state of CLIENT PAUSE, commands wait until deadline or CLIENT UNPAUSE
+type pauseState struct {
+	mu       sync.Mutex
+	deadline time.Time
+	all      bool // pause all commands or write commands only
+	// closed while unpaused, replaced by every pause
+	resume chan struct{}
+}
+
+func makePauseState() *pauseState {
+	resume := make(chan struct{})
+	close(resume)
+	return &pauseState{
+		resume: resume,
+	}
+}
+
+func (p *pauseState) pause(deadline time.Time, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.resume:
+		// not paused, start a new pause
+		p.resume = make(chan struct{})
+		p.deadline = deadline
+		p.all = all
+	default:
+		// already paused, extend deadline and widen scope
+		if deadline.After(p.deadline) {
+			p.deadline = deadline
+		}
+		p.all = p.all || all
+	}
+}
+
+func (p *pauseState) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.resume:
+	default:
+		close(p.resume)
+	}
+}
+
+// block current command while paused
+func (p *pauseState) waitIfPaused(cmd string) {
+	for {
+		p.mu.Lock()
+		resume := p.resume
+		wait := time.Until(p.deadline)
+		all := p.all
+		select {
+		case <-resume:
+			p.mu.Unlock()
+			return
+		default:
+		}
+		if wait <= 0 {
+			// pause expired
+			close(p.resume)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		if !all && !DBImpl.IsWriteCommand(cmd) {
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-resume:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
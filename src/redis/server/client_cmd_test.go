@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fields of the only client listed
+func listFields(t *testing.T, c *testConn, args ...string) map[string]string {
+	t.Helper()
+	list := c.do(t, append([]string{"CLIENT", "LIST"}, args...)...)
+	lines := strings.Split(strings.TrimSuffix(list, "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 client, actually %q", list)
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(lines[0], " ") {
+		kv := strings.SplitN(field, "=", 2)
+		fields[kv[0]] = kv[1]
+	}
+	return fields
+}
+
+func TestClientName(t *testing.T) {
+	h := MakeHandler()
+	c := connect(h)
+	if actual := c.do(t, "CLIENT", "GETNAME"); actual != "$-1" {
+		t.Errorf("expected nil, actually %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "SETNAME", "a b"); !strings.HasPrefix(actual, "-ERR Client names cannot contain spaces") {
+		t.Errorf("expected error, actually %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "SETNAME", "worker"); actual != "+OK" {
+		t.Errorf("expected OK, actually %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "GETNAME"); actual != "worker" {
+		t.Errorf("expected worker, actually %s", actual)
+	}
+}
+
+func TestClientList(t *testing.T) {
+	h := MakeHandler()
+	c := connect(h)
+	id := c.do(t, "CLIENT", "ID")
+	c.do(t, "CLIENT", "SETNAME", "lister")
+	c.do(t, "PING")
+	fields := listFields(t, c)
+	expected := map[string]string{
+		"id":    strings.TrimPrefix(id, ":"),
+		"name":  "lister",
+		"flags": "N",
+		"db":    "0",
+		"sub":   "0",
+		"psub":  "0",
+		"user":  "default",
+		"cmd":   "client",
+	}
+	for key, val := range expected {
+		if fields[key] != val {
+			t.Errorf("expected %s=%s, actually %s", key, val, fields[key])
+		}
+	}
+
+	subscriber := connect(h)
+	subscriber.send(t, "SUBSCRIBE", "ch1", "ch2")
+	for i := 0; i < 6; i++ {
+		subscriber.read(t)
+	}
+	fields = listFields(t, c, "TYPE", "pubsub")
+	if fields["flags"] != "P" || fields["sub"] != "2" || fields["cmd"] != "subscribe" {
+		t.Errorf("unexpected subscriber %v", fields)
+	}
+	fields = listFields(t, c, "ID", strings.TrimPrefix(id, ":"))
+	if fields["name"] != "lister" {
+		t.Errorf("unexpected client %v", fields)
+	}
+	if actual := c.do(t, "CLIENT", "LIST", "TYPE", "master"); actual != "-ERR Unknown client type 'master'" {
+		t.Errorf("unexpected reply %s", actual)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	h := MakeHandler()
+	c := connect(h)
+	victim := connect(h)
+	victimId := strings.TrimPrefix(victim.do(t, "CLIENT", "ID"), ":")
+	if actual := c.do(t, "CLIENT", "KILL", "ID", victimId); actual != ":1" {
+		t.Errorf("expected :1, actually %s", actual)
+	}
+	waitUntil(t, func() bool {
+		return len(h.clients()) == 1
+	})
+
+	// skip me by default
+	id := strings.TrimPrefix(c.do(t, "CLIENT", "ID"), ":")
+	if actual := c.do(t, "CLIENT", "KILL", "ID", id); actual != ":0" {
+		t.Errorf("expected :0, actually %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "KILL", "1.2.3.4:5"); actual != "-ERR No such client" {
+		t.Errorf("unexpected reply %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "KILL", "ID", "0"); actual != "-ERR client-id should be greater than 0" {
+		t.Errorf("unexpected reply %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "KILL", "USER", "nobody"); actual != ":0" {
+		t.Errorf("expected :0, actually %s", actual)
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	h := MakeHandler()
+	c := connect(h)
+	other := connect(h)
+	if actual := c.do(t, "CLIENT", "PAUSE", "10000", "WRITE"); actual != "+OK" {
+		t.Fatalf("expected OK, actually %s", actual)
+	}
+	// read commands are served while writes are paused
+	if actual := other.do(t, "GET", "paused"); actual != "$-1" {
+		t.Errorf("expected nil, actually %s", actual)
+	}
+	other.send(t, "SET", "paused", "1")
+	time.Sleep(50 * time.Millisecond)
+	if actual := c.do(t, "GET", "paused"); actual != "$-1" {
+		t.Errorf("expected write paused, actually %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "UNPAUSE"); actual != "+OK" {
+		t.Errorf("expected OK, actually %s", actual)
+	}
+	if actual := other.read(t); actual != "+OK" {
+		t.Errorf("expected OK, actually %s", actual)
+	}
+	if actual := c.do(t, "GET", "paused"); actual != "1" {
+		t.Errorf("expected 1, actually %s", actual)
+	}
+
+	// pause expires
+	c.do(t, "CLIENT", "PAUSE", "50")
+	start := time.Now()
+	other.do(t, "PING")
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected paused for 50ms, actually %s", elapsed)
+	}
+	if actual := c.do(t, "CLIENT", "PAUSE", "-1"); actual != "-ERR timeout is not an integer or out of range" {
+		t.Errorf("unexpected reply %s", actual)
+	}
+}
+
+func TestClientNoEvict(t *testing.T) {
+	c := connect(MakeHandler())
+	if actual := c.do(t, "CLIENT", "NO-EVICT", "ON"); actual != "+OK" {
+		t.Errorf("expected OK, actually %s", actual)
+	}
+	if fields := listFields(t, c); fields["flags"] != "Ne" {
+		t.Errorf("expected flags Ne, actually %s", fields["flags"])
+	}
+	if actual := c.do(t, "CLIENT", "NO-EVICT", "OFF"); actual != "+OK" {
+		t.Errorf("expected OK, actually %s", actual)
+	}
+	if fields := listFields(t, c); fields["flags"] != "N" {
+		t.Errorf("expected flags N, actually %s", fields["flags"])
+	}
+	if actual := c.do(t, "CLIENT", "NO-EVICT", "maybe"); actual != "-Err syntax error" {
+		t.Errorf("unexpected reply %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "NO-EVICT"); actual != "-ERR wrong number of arguments for 'client|no-evict' command" {
+		t.Errorf("unexpected reply %s", actual)
+	}
+	if actual := c.do(t, "CLIENT", "HELLO"); actual != "-ERR unknown subcommand 'HELLO'. Try CLIENT HELP." {
+		t.Errorf("unexpected reply %s", actual)
+	}
+}
@@ -8,37 +8,48 @@ import (
 	"bufio"
 	"context"
 	"io"
+	"my-godis/src/config"
 	DBImpl "my-godis/src/db"
 	"my-godis/src/interface/db"
+	"my-godis/src/interface/redis"
 	"my-godis/src/lib/logger"
 	"my-godis/src/lib/sync/atomic"
 	"my-godis/src/redis/reply"
+	"my-godis/src/stats"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
-	UnknownErrReplyBytes = []byte("-ERR unknown\r\n")
+	UnknownErrReplyBytes    = []byte("-ERR unknown\r\n")
+	maxClientsErrReplyBytes = []byte("-ERR max number of clients reached\r\n")
 )
 
 type Handler struct {
 	activeConn sync.Map // *client -> placeholder
 	db         db.DB
 	closing    atomic.AtomicBool // refusing new client and new request
+
+	// set by CLIENT PAUSE
+	pause *pauseState
 }
 
 func MakeHandler() *Handler {
 	return &Handler{
-		db: DBImpl.MakeDB(),
+		db:    DBImpl.MakeDB(),
+		pause: makePauseState(),
 	}
 }
 
 func (h *Handler) closeClient(client *Client) {
 	_ = client.Close()
 	h.db.AfterClientClose(client)
-	h.activeConn.Delete(client)
+	if _, loaded := h.activeConn.LoadAndDelete(client); loaded {
+		stats.Decr(&stats.ConnectedClients)
+	}
 }
 
 func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
@@ -47,15 +58,26 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 		_ = conn.Close()
 	}
 
+	if maxClients := config.Properties().MaxClients; maxClients > 0 &&
+		stats.Load(&stats.ConnectedClients) >= int64(maxClients) {
+		_, _ = conn.Write(maxClientsErrReplyBytes)
+		_ = conn.Close()
+		return
+	}
+
 	client := MakeClient(conn)
 	h.activeConn.Store(client, 1)
+	stats.Incr(&stats.ConnectedClients)
+	stats.Incr(&stats.TotalConnectionsReceived)
 
 	reader := bufio.NewReader(conn)
+	client.reader = reader
 	var fixedLen int64 = 0
 	var err error
 	var msg []byte
 	for {
 		if fixedLen == 0 {
+			setIdleDeadline(client)
 			msg, err = reader.ReadBytes('\n')
 			if err != nil {
 				if err == io.EOF ||
@@ -125,7 +147,7 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 				}
 
 				// send reply
-				result := h.db.Exec(client, args)
+				result := h.exec(client, args)
 				if result != nil {
 					_ = client.Write(result.ToBytes())
 				} else {
@@ -155,7 +177,7 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 				client.uploading.Set(false) // finish sending progress
 
 				// send reply
-				result := h.db.Exec(client, client.args)
+				result := h.exec(client, client.args)
 				if result != nil {
 					_ = client.Write(result.ToBytes())
 				} else {
@@ -173,6 +195,30 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 	}
 }
 
+// close connection which is idle longer than config timeout, subscribers are never timed out
+func setIdleDeadline(client *Client) {
+	timeout := config.Properties().Timeout
+	if timeout > 0 && client.SubsCount() == 0 {
+		_ = client.conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		_ = client.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// exec handles connection level commands and sends others to db
+func (h *Handler) exec(client *Client, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return &reply.UnknownErrReply{}
+	}
+	cmd := strings.ToLower(string(args[0]))
+	client.touch(cmd)
+	if cmd == "client" {
+		return h.execClient(client, args[1:])
+	}
+	h.pause.waitIfPaused(cmd)
+	return h.db.Exec(client, args)
+}
+
 func (h *Handler) Close() error {
 	logger.Info("handler shuting down...")
 	h.closing.Set(true)
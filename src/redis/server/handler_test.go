@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	DBImpl "my-godis/src/db"
+	"my-godis/src/redis/reply"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// connect serves one end of a pipe by handler, returns the other end
+func connect(h *Handler) *testConn {
+	server, client := net.Pipe()
+	go h.Handle(context.Background(), server)
+	return &testConn{
+		conn:   client,
+		reader: bufio.NewReader(client),
+	}
+}
+
+func (c *testConn) send(t *testing.T, args ...string) {
+	t.Helper()
+	bytes := make([][]byte, len(args))
+	for i, arg := range args {
+		bytes[i] = []byte(arg)
+	}
+	if _, err := c.conn.Write(reply.MakeMultiBulkReply(bytes).ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns a reply of status, error, integer or bulk string without CRLF
+func (c *testConn) read(t *testing.T) string {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line[0] != '$' || line == "$-1" {
+		return line
+	}
+	size, _ := strconv.Atoi(line[1:])
+	buf := make([]byte, size+2)
+	for n := 0; n < len(buf); {
+		m, err := c.reader.Read(buf[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	return string(buf[:size])
+}
+
+func (c *testConn) do(t *testing.T, args ...string) string {
+	t.Helper()
+	c.send(t, args...)
+	return c.read(t)
+}
+
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestBlockedClientClosedByPeer(t *testing.T) {
+	h := MakeHandler()
+	db := h.db.(*DBImpl.DB)
+	c := connect(h)
+	c.send(t, "BZPOPMIN", "zset", "0")
+	waitUntil(t, func() bool {
+		return db.BlockedClients() == 1
+	})
+	_ = c.conn.Close()
+	waitUntil(t, func() bool {
+		return db.BlockedClients() == 0
+	})
+
+	// element is not popped for the client gone
+	other := connect(h)
+	if actual := other.do(t, "ZADD", "zset", "1", "a"); actual != ":1" {
+		t.Errorf("expected :1, actually %s", actual)
+	}
+	if actual := other.do(t, "ZCARD", "zset"); actual != ":1" {
+		t.Errorf("expected :1, actually %s", actual)
+	}
+}
+
+func TestBlockedClientServedAfterWakeup(t *testing.T) {
+	h := MakeHandler()
+	db := h.db.(*DBImpl.DB)
+	c := connect(h)
+	c.send(t, "BZPOPMIN", "zset2", "0")
+	waitUntil(t, func() bool {
+		return db.BlockedClients() == 1
+	})
+	other := connect(h)
+	other.do(t, "ZADD", "zset2", "1", "a")
+	if actual := c.read(t); actual != "*3" {
+		t.Fatalf("expected popped element, actually %s", actual)
+	}
+	c.read(t)
+	c.read(t)
+	c.read(t)
+	// connection is readable after watching stopped
+	if actual := c.do(t, "PING"); actual != "+PONG" {
+		t.Errorf("expected PONG, actually %s", actual)
+	}
+}
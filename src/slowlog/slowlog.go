@@ -0,0 +1,200 @@
+package slowlog
+
+/*
+ * slowlog records commands whose execution time exceeds slowlog-log-slower-than in a bounded ring buffer
+ */
+
+import (
+	"my-godis/src/config"
+	"my-godis/src/interface/redis"
+	"my-godis/src/redis/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxArgc = 32  // max args recorded per entry
+	maxArgs = 128 // max length of a recorded arg
+)
+
+type Entry struct {
+	Id       int64
+	Time     time.Time
+	Duration time.Duration
+	Args     [][]byte
+	Addr     string
+}
+
+type SlowLog struct {
+	mu      sync.Mutex
+	entries []*Entry // ring buffer
+	head    int      // index of the oldest entry
+	size    int
+	nextId  int64
+}
+
+func Make() *SlowLog {
+	return &SlowLog{}
+}
+
+// Default is the slowlog of the server
+var Default = Make()
+
+func maxLen() int {
+	return config.Properties().SlowlogMaxLen
+}
+
+// threshold from config, negative value disables slowlog
+func threshold() time.Duration {
+	return config.Properties().SlowlogLogSlowerThan
+}
+
+func trimArgs(args [][]byte) [][]byte {
+	argc := len(args)
+	if argc > maxArgc {
+		argc = maxArgc
+	}
+	result := make([][]byte, argc)
+	for i := 0; i < argc; i++ {
+		if i == maxArgc-1 && len(args) > maxArgc {
+			result[i] = []byte("... (" + strconv.Itoa(len(args)-maxArgc+1) + " more arguments)")
+			break
+		}
+		arg := args[i]
+		if len(arg) > maxArgs {
+			result[i] = []byte(string(arg[:maxArgs]) + "... (" + strconv.Itoa(len(arg)-maxArgs) + " more bytes)")
+		} else {
+			result[i] = append([]byte{}, arg...)
+		}
+	}
+	return result
+}
+
+// Record adds command into slowlog if duration exceeds threshold
+func (log *SlowLog) Record(args [][]byte, addr string, start time.Time, duration time.Duration) {
+	limit := threshold()
+	if limit < 0 || duration < limit {
+		return
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	capacity := maxLen()
+	if capacity <= 0 {
+		return
+	}
+	if len(log.entries) != capacity {
+		log.resize(capacity)
+	}
+	entry := &Entry{
+		Id:       log.nextId,
+		Time:     start,
+		Duration: duration,
+		Args:     trimArgs(args),
+		Addr:     addr,
+	}
+	log.nextId++
+	if log.size < capacity {
+		log.entries[(log.head+log.size)%capacity] = entry
+		log.size++
+	} else {
+		// overwrite the oldest one
+		log.entries[log.head] = entry
+		log.head = (log.head + 1) % capacity
+	}
+}
+
+// resize ring buffer while slowlog-max-len changed, keeps newest entries
+func (log *SlowLog) resize(capacity int) {
+	entries := log.newest(capacity)
+	log.entries = make([]*Entry, capacity)
+	log.head = 0
+	log.size = len(entries)
+	for i := range entries {
+		// entries is ordered from newest to oldest
+		log.entries[i] = entries[len(entries)-1-i]
+	}
+}
+
+// newest returns at most n entries, ordered from newest to oldest
+func (log *SlowLog) newest(n int) []*Entry {
+	if n < 0 || n > log.size {
+		n = log.size
+	}
+	result := make([]*Entry, n)
+	for i := 0; i < n; i++ {
+		result[i] = log.entries[(log.head+log.size-1-i)%len(log.entries)]
+	}
+	return result
+}
+
+func (log *SlowLog) Get(n int) []*Entry {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.newest(n)
+}
+
+func (log *SlowLog) Len() int {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.size
+}
+
+func (log *SlowLog) Reset() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.entries = nil
+	log.head = 0
+	log.size = 0
+}
+
+func entryToReply(entry *Entry) redis.Reply {
+	return reply.MakeMultiRawReply([]redis.Reply{
+		reply.MakeIntReply(entry.Id),
+		reply.MakeIntReply(entry.Time.Unix()),
+		reply.MakeIntReply(int64(entry.Duration / time.Microsecond)),
+		reply.MakeMultiBulkReply(entry.Args),
+		reply.MakeBulkReply([]byte(entry.Addr)),
+		reply.MakeBulkReply([]byte{}),
+	})
+}
+
+// SLOWLOG GET [count] | LEN | RESET
+func Exec(log *SlowLog, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return &reply.ArgNumErrReply{Cmd: "slowlog"}
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "get":
+		if len(args) > 2 {
+			return &reply.ArgNumErrReply{Cmd: "slowlog|get"}
+		}
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(string(args[1]))
+			if err != nil || n < -1 {
+				return reply.MakeErrReply("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := log.Get(count)
+		replies := make([]redis.Reply, len(entries))
+		for i, entry := range entries {
+			replies[i] = entryToReply(entry)
+		}
+		return reply.MakeMultiRawReply(replies)
+	case "len":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "slowlog|len"}
+		}
+		return reply.MakeIntReply(int64(log.Len()))
+	case "reset":
+		if len(args) != 1 {
+			return &reply.ArgNumErrReply{Cmd: "slowlog|reset"}
+		}
+		log.Reset()
+		return &reply.OkReply{}
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try SLOWLOG HELP.")
+}
@@ -0,0 +1,58 @@
+package slowlog
+
+import (
+	"my-godis/src/config"
+	"my-godis/src/redis/reply/asserts"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func toArgs(cmd ...string) [][]byte {
+	args := make([][]byte, len(cmd))
+	for i, s := range cmd {
+		args[i] = []byte(s)
+	}
+	return args
+}
+
+func TestSlowLog(t *testing.T) {
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.SlowlogLogSlowerThan = time.Millisecond
+		properties.SlowlogMaxLen = 3
+	})
+	log := Make()
+
+	log.Record(toArgs("get", "a"), "127.0.0.1:1", time.Now(), 10*time.Microsecond)
+	if log.Len() != 0 {
+		t.Errorf("expected 0 entries, actually %d", log.Len())
+	}
+	for i := 0; i < 5; i++ {
+		log.Record(toArgs("set", strconv.Itoa(i)), "127.0.0.1:1", time.Now(), 2*time.Millisecond)
+	}
+	asserts.AssertIntReply(t, Exec(log, toArgs("len")), 3)
+
+	entries := log.Get(-1)
+	for i, entry := range entries {
+		expected := strconv.Itoa(4 - i)
+		if string(entry.Args[1]) != expected {
+			t.Errorf("expected %s, actually %s", expected, entry.Args[1])
+		}
+	}
+	if len(log.Get(1)) != 1 {
+		t.Error("expected 1 entry")
+	}
+
+	// shrink
+	config.Update(func(properties *config.PropertyHolder) {
+		properties.SlowlogMaxLen = 2
+	})
+	log.Record(toArgs("set", "5"), "127.0.0.1:1", time.Now(), 2*time.Millisecond)
+	entries = log.Get(-1)
+	if len(entries) != 2 || string(entries[0].Args[1]) != "5" || string(entries[1].Args[1]) != "4" {
+		t.Error("unexpected entries after shrink")
+	}
+
+	Exec(log, toArgs("reset"))
+	asserts.AssertIntReply(t, Exec(log, toArgs("len")), 0)
+}
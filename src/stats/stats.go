@@ -0,0 +1,161 @@
+package stats
+
+/*
+ * server wide counters, shared by handler, db and cluster
+ */
+
+import (
+	"my-godis/src/lib/metrics"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	StartTime = time.Now()
+
+	ConnectedClients         int64
+	TotalConnectionsReceived int64
+
+	TotalCommandsProcessed int64
+	KeyspaceHits           int64
+	KeyspaceMisses         int64
+	ExpiredKeys            int64
+	// keys are never evicted for now, see maxmemory_policy in INFO
+	EvictedKeys int64
+)
+
+func init() {
+	registerCounter := func(name string, help string, counter *int64) {
+		metrics.DefaultRegistry.RegisterCounterFunc(name, help, func() float64 {
+			return float64(Load(counter))
+		})
+	}
+	metrics.DefaultRegistry.RegisterGaugeFunc("godis_connected_clients",
+		"Number of client connections.", func() float64 {
+			return float64(Load(&ConnectedClients))
+		})
+	registerCounter("godis_connections_received_total",
+		"Total number of connections accepted by the server.", &TotalConnectionsReceived)
+	registerCounter("godis_commands_processed_total",
+		"Total number of commands processed by the server.", &TotalCommandsProcessed)
+	registerCounter("godis_keyspace_hits_total", "Number of successful lookups of keys.", &KeyspaceHits)
+	registerCounter("godis_keyspace_misses_total", "Number of failed lookups of keys.", &KeyspaceMisses)
+	registerCounter("godis_expired_keys_total", "Number of key expiration events.", &ExpiredKeys)
+	registerCounter("godis_evicted_keys_total", "Number of evicted keys due to maxmemory limit.", &EvictedKeys)
+}
+
+func Incr(counter *int64) {
+	atomic.AddInt64(counter, 1)
+}
+
+func Decr(counter *int64) {
+	atomic.AddInt64(counter, -1)
+}
+
+func Add(counter *int64, delta int64) {
+	atomic.AddInt64(counter, delta)
+}
+
+func Load(counter *int64) int64 {
+	return atomic.LoadInt64(counter)
+}
+
+/* ---- instantaneous ops per second ---- */
+
+const (
+	sampleInterval = 100 * time.Millisecond
+	sampleCount    = 16
+)
+
+var (
+	samplerOnce   sync.Once
+	samplesMu     sync.Mutex
+	samples       [sampleCount]int64 // ops/sec of recent samples
+	sampleIndex   int
+	lastSampleOps int64
+	lastSampleAt  time.Time
+)
+
+// StartSampler starts sampling TotalCommandsProcessed, it's safe to call it multiple times
+func StartSampler() {
+	samplerOnce.Do(func() {
+		lastSampleAt = time.Now()
+		ticker := time.NewTicker(sampleInterval)
+		go func() {
+			for now := range ticker.C {
+				sample(now)
+				SampleMemory()
+			}
+		}()
+	})
+}
+
+func sample(now time.Time) {
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	ops := Load(&TotalCommandsProcessed)
+	elapsed := now.Sub(lastSampleAt)
+	if elapsed > 0 {
+		samples[sampleIndex] = (ops - lastSampleOps) * int64(time.Second) / int64(elapsed)
+		sampleIndex = (sampleIndex + 1) % sampleCount
+	}
+	lastSampleOps = ops
+	lastSampleAt = now
+}
+
+// average ops per second of recent samples
+func InstantaneousOps() int64 {
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	var sum int64
+	for _, v := range samples {
+		sum += v
+	}
+	return sum / sampleCount
+}
+
+/* ---- memory ---- */
+
+var (
+	usedMemory     int64 // heap bytes allocated at last sample
+	usedMemoryPeak int64 // max of usedMemory
+)
+
+// SampleMemory reads memory stats of runtime and updates used memory and its peak.
+// ReadMemStats stops the world, so it's sampled periodically rather than per command
+func SampleMemory() *runtime.MemStats {
+	mem := &runtime.MemStats{}
+	runtime.ReadMemStats(mem)
+	used := int64(mem.Alloc)
+	atomic.StoreInt64(&usedMemory, used)
+	for {
+		peak := atomic.LoadInt64(&usedMemoryPeak)
+		if used <= peak || atomic.CompareAndSwapInt64(&usedMemoryPeak, peak, used) {
+			break
+		}
+	}
+	return mem
+}
+
+// UsedMemory returns heap bytes allocated at last sample
+func UsedMemory() int64 {
+	return atomic.LoadInt64(&usedMemory)
+}
+
+// UsedMemoryPeak returns the max used memory ever sampled since start or CONFIG RESETSTAT
+func UsedMemoryPeak() int64 {
+	return atomic.LoadInt64(&usedMemoryPeak)
+}
+
+// ResetStat resets counters, for CONFIG RESETSTAT
+func ResetStat() {
+	atomic.StoreInt64(&TotalConnectionsReceived, 0)
+	atomic.StoreInt64(&TotalCommandsProcessed, 0)
+	atomic.StoreInt64(&KeyspaceHits, 0)
+	atomic.StoreInt64(&KeyspaceMisses, 0)
+	atomic.StoreInt64(&ExpiredKeys, 0)
+	atomic.StoreInt64(&EvictedKeys, 0)
+	atomic.StoreInt64(&usedMemoryPeak, UsedMemory())
+}